@@ -0,0 +1,489 @@
+
+package pdf
+
+import (
+    "fmt"
+    "bytes"
+)
+
+/*
+Content builder: page content is generated by calling a method per operator,
+each method writing the operator and its operands in a buffer. Methods using
+a resource (fonts, XObjects and graphics state parameter dictionaries) take
+a reference to the resource object and register it in the builder resource
+dictionary under a new name, or under the name it already has.
+
+A builder made for a page starts with the page resources, so that new names
+do not collide with existing names. Once complete, the content is added to
+the page as a new stream object, after any existing content, and the builder
+resources are merged in the page resources. The content can also be used for
+a form XObject.
+*/
+
+// ContentBuilder generates a content stream
+type ContentBuilder struct {
+    f           *pdfWriter
+    buf         bytes.Buffer
+    resources   Dictionary      // resource dictionaries by category (Font, XObject...)
+}
+
+// NewContentBuilder returns a content builder without any resource
+func NewContentBuilder( ) *ContentBuilder {
+    cb := &ContentBuilder{ resources: NewDictionary( ) }
+    cb.f = newPdfWriter( &cb.buf )
+    return cb
+}
+
+// NewContentBuilder returns a content builder for the page, starting with the
+// page resources. See AddContent.
+func (p *Page) NewContentBuilder( ) ( *ContentBuilder, error ) {
+    res, err := p.resolvedResources( )
+    if err != nil {
+        return nil, err
+    }
+    cb := NewContentBuilder( )
+    cb.resources = res
+    return cb, nil
+}
+
+// return a copy of the page resources, with the resource category
+// dictionaries resolved and copied, so that they can be modified
+func (p *Page) resolvedResources( ) ( Dictionary, error ) {
+    res, err := p.Resources( )
+    if err != nil {
+        return Dictionary{}, err
+    }
+    res = res.clone( )
+    for _, k := range res.Keys() {
+        v, err := p.pf.Resolve( res.data[k] )
+        if err != nil {
+            return Dictionary{}, fmt.Errorf( "Page %d %d Resources /%s: %v", p.obj.id, p.obj.gen, k, err )
+        }
+        if d, ok := v.(Dictionary); ok {
+            res.Set( k, d.clone( ) )
+        }
+    }
+    return res, nil
+}
+
+// return the name of the resource in category, registering it with a new
+// name made of prefix and a number if it is not already registered
+func (cb *ContentBuilder) resourceName( category, prefix string, ref Reference ) Name {
+    names, _ := cb.resources.data[category].(Dictionary)
+    for _, k := range names.Keys() {
+        if names.data[k] == ref {
+            return Name(k)
+        }
+    }
+    names = names.clone( )
+    var name string
+    for i := names.Len() + 1; ; i++ {
+        name = fmt.Sprintf( "%s%d", prefix, i )
+        if _, ok := names.data[name]; ! ok {
+            break
+        }
+    }
+    names.Set( name, ref )
+    cb.resources.Set( category, names )
+    return Name(name)
+}
+
+// Resources returns the resources used by the content (and for a page, the
+// resources the page already had)
+func (cb *ContentBuilder) Resources( ) Dictionary {
+    return cb.resources
+}
+
+// Bytes returns the content generated so far
+func (cb *ContentBuilder) Bytes( ) []byte {
+    cb.f.flush( )               // cannot fail with a bytes.Buffer
+    return cb.buf.Bytes()
+}
+
+// Op writes the operator with its operands, for operators without a specific
+// method. Operands must be direct objects.
+func (cb *ContentBuilder) Op( operator string, operands ...interface{} ) {
+    for _, v := range operands {
+        serializeValue( cb.f, v )
+        cb.f.WriteString( " " )
+    }
+    cb.f.WriteString( operator )
+    cb.f.WriteString( "\n" )
+}
+
+// Append writes the given operations, for example as returned by ParseContent
+func (cb *ContentBuilder) Append( ops ...Operation ) {
+    for _, op := range ops {
+        if image, ok := inlineImage( op ); ok {
+            cb.f.WriteString( "BI\n" )
+            for _, k := range image.extent.Keys() {
+                cb.f.WriteString( "/" + k + " " )
+                serializeValue( cb.f, image.extent.data[k] )
+                cb.f.WriteString( "\n" )
+            }
+            cb.f.WriteString( "ID " )
+            cb.f.Write( image.data )
+            cb.f.WriteString( "\nEI\n" )
+            continue
+        }
+        cb.Op( op.Operator, op.Operands... )
+    }
+}
+
+// return the inline image of a BI operation
+func inlineImage( op Operation ) ( Stream, bool ) {
+    if op.Operator != "BI" || len(op.Operands) != 1 {
+        return Stream{}, false
+    }
+    image, ok := op.Operands[0].(Stream)
+    return image, ok
+}
+
+func numbers( values ...float64 ) []interface{} {
+    operands := make( []interface{}, len(values) )
+    for i, v := range values {
+        operands[i] = Number(v)
+    }
+    return operands
+}
+
+// Save saves the graphics state (q)
+func (cb *ContentBuilder) Save( ) {
+    cb.Op( "q" )
+}
+
+// Restore restores the last saved graphics state (Q)
+func (cb *ContentBuilder) Restore( ) {
+    cb.Op( "Q" )
+}
+
+// Transform modifies the current transformation matrix (cm)
+func (cb *ContentBuilder) Transform( a, b, c, d, e, f float64 ) {
+    cb.Op( "cm", numbers( a, b, c, d, e, f )... )
+}
+
+// Translate moves the coordinate system origin to x, y
+func (cb *ContentBuilder) Translate( x, y float64 ) {
+    cb.Transform( 1, 0, 0, 1, x, y )
+}
+
+// Scale scales the coordinate system by sx horizontally and sy vertically
+func (cb *ContentBuilder) Scale( sx, sy float64 ) {
+    cb.Transform( sx, 0, 0, sy, 0, 0 )
+}
+
+// SetLineWidth sets the line width (w)
+func (cb *ContentBuilder) SetLineWidth( w float64 ) {
+    cb.Op( "w", Number(w) )
+}
+
+// SetLineCap sets the line cap style, 0 to 2 (J)
+func (cb *ContentBuilder) SetLineCap( style int ) {
+    cb.Op( "J", Number(style) )
+}
+
+// SetLineJoin sets the line join style, 0 to 2 (j)
+func (cb *ContentBuilder) SetLineJoin( style int ) {
+    cb.Op( "j", Number(style) )
+}
+
+// SetDash sets the line dash pattern, solid if dashes is empty (d)
+func (cb *ContentBuilder) SetDash( dashes []float64, phase float64 ) {
+    cb.Op( "d", Array{ numbers( dashes... ) }, Number(phase) )
+}
+
+// SetGraphicsState sets the parameters given by the graphics state parameter
+// dictionary gs (gs)
+func (cb *ContentBuilder) SetGraphicsState( gs Reference ) {
+    cb.Op( "gs", cb.resourceName( "ExtGState", "GS", gs ) )
+}
+
+// MoveTo starts a new subpath at x, y (m)
+func (cb *ContentBuilder) MoveTo( x, y float64 ) {
+    cb.Op( "m", numbers( x, y )... )
+}
+
+// LineTo adds a line to x, y to the current subpath (l)
+func (cb *ContentBuilder) LineTo( x, y float64 ) {
+    cb.Op( "l", numbers( x, y )... )
+}
+
+// CurveTo adds a Bezier curve to x3, y3 with control points x1, y1 and x2, y2
+// to the current subpath (c)
+func (cb *ContentBuilder) CurveTo( x1, y1, x2, y2, x3, y3 float64 ) {
+    cb.Op( "c", numbers( x1, y1, x2, y2, x3, y3 )... )
+}
+
+// ClosePath closes the current subpath (h)
+func (cb *ContentBuilder) ClosePath( ) {
+    cb.Op( "h" )
+}
+
+// Rectangle adds a rectangle to the path (re)
+func (cb *ContentBuilder) Rectangle( x, y, width, height float64 ) {
+    cb.Op( "re", numbers( x, y, width, height )... )
+}
+
+// Stroke strokes the path (S)
+func (cb *ContentBuilder) Stroke( ) {
+    cb.Op( "S" )
+}
+
+// CloseAndStroke closes and strokes the path (s)
+func (cb *ContentBuilder) CloseAndStroke( ) {
+    cb.Op( "s" )
+}
+
+// Fill fills the path with the nonzero winding number rule (f)
+func (cb *ContentBuilder) Fill( ) {
+    cb.Op( "f" )
+}
+
+// FillEvenOdd fills the path with the even-odd rule (f*)
+func (cb *ContentBuilder) FillEvenOdd( ) {
+    cb.Op( "f*" )
+}
+
+// FillAndStroke fills, with the nonzero winding number rule, and strokes the
+// path (B)
+func (cb *ContentBuilder) FillAndStroke( ) {
+    cb.Op( "B" )
+}
+
+// EndPath ends the path without filling or stroking it, e.g. after Clip (n)
+func (cb *ContentBuilder) EndPath( ) {
+    cb.Op( "n" )
+}
+
+// Clip intersects the clipping path with the path, with the nonzero winding
+// number rule (W). The path must then be painted or ended.
+func (cb *ContentBuilder) Clip( ) {
+    cb.Op( "W" )
+}
+
+// SetFillGray sets the gray level for filling, from 0 (black) to 1 (g)
+func (cb *ContentBuilder) SetFillGray( gray float64 ) {
+    cb.Op( "g", Number(gray) )
+}
+
+// SetStrokeGray sets the gray level for stroking, from 0 (black) to 1 (G)
+func (cb *ContentBuilder) SetStrokeGray( gray float64 ) {
+    cb.Op( "G", Number(gray) )
+}
+
+// SetFillRGB sets the RGB color for filling, each component from 0 to 1 (rg)
+func (cb *ContentBuilder) SetFillRGB( r, g, b float64 ) {
+    cb.Op( "rg", numbers( r, g, b )... )
+}
+
+// SetStrokeRGB sets the RGB color for stroking, each component from 0 to 1 (RG)
+func (cb *ContentBuilder) SetStrokeRGB( r, g, b float64 ) {
+    cb.Op( "RG", numbers( r, g, b )... )
+}
+
+// SetFillCMYK sets the CMYK color for filling, each component from 0 to 1 (k)
+func (cb *ContentBuilder) SetFillCMYK( c, m, y, k float64 ) {
+    cb.Op( "k", numbers( c, m, y, k )... )
+}
+
+// SetStrokeCMYK sets the CMYK color for stroking, each component from 0 to 1 (K)
+func (cb *ContentBuilder) SetStrokeCMYK( c, m, y, k float64 ) {
+    cb.Op( "K", numbers( c, m, y, k )... )
+}
+
+// BeginText begins a text object (BT)
+func (cb *ContentBuilder) BeginText( ) {
+    cb.Op( "BT" )
+}
+
+// EndText ends a text object (ET)
+func (cb *ContentBuilder) EndText( ) {
+    cb.Op( "ET" )
+}
+
+// SetFont sets the text font, given by a reference to a font dictionary, and
+// the text size (Tf)
+func (cb *ContentBuilder) SetFont( font Reference, size float64 ) {
+    cb.Op( "Tf", cb.resourceName( "Font", "F", font ), Number(size) )
+}
+
+// MoveText moves to the start of the next line, offset by tx, ty from the
+// start of the current line (Td)
+func (cb *ContentBuilder) MoveText( tx, ty float64 ) {
+    cb.Op( "Td", numbers( tx, ty )... )
+}
+
+// SetTextMatrix sets the text matrix and the text line matrix (Tm)
+func (cb *ContentBuilder) SetTextMatrix( a, b, c, d, e, f float64 ) {
+    cb.Op( "Tm", numbers( a, b, c, d, e, f )... )
+}
+
+// SetLeading sets the text leading, used by NextLine (TL)
+func (cb *ContentBuilder) SetLeading( leading float64 ) {
+    cb.Op( "TL", Number(leading) )
+}
+
+// SetCharSpacing sets the character spacing (Tc)
+func (cb *ContentBuilder) SetCharSpacing( spacing float64 ) {
+    cb.Op( "Tc", Number(spacing) )
+}
+
+// SetWordSpacing sets the word spacing (Tw)
+func (cb *ContentBuilder) SetWordSpacing( spacing float64 ) {
+    cb.Op( "Tw", Number(spacing) )
+}
+
+// SetTextRise sets the text rise (Ts)
+func (cb *ContentBuilder) SetTextRise( rise float64 ) {
+    cb.Op( "Ts", Number(rise) )
+}
+
+// SetTextRenderingMode sets the text rendering mode, from 0 (fill) to 7 (Tr)
+func (cb *ContentBuilder) SetTextRenderingMode( mode int ) {
+    cb.Op( "Tr", Number(mode) )
+}
+
+// NextLine moves to the start of the next line (T*)
+func (cb *ContentBuilder) NextLine( ) {
+    cb.Op( "T*" )
+}
+
+// ShowText shows the text, given as character codes in the current font
+// encoding, e.g. WinAnsiEncoding for standard fonts (Tj)
+func (cb *ContentBuilder) ShowText( text string ) {
+    cb.Op( "Tj", String(text) )
+}
+
+// ShowTextAdjusted shows text strings, adjusted by numbers given in thousandths
+// of text space units, which are subtracted from the current position (TJ)
+func (cb *ContentBuilder) ShowTextAdjusted( elements ...interface{} ) {
+    a := NewArray( )
+    for _, e := range elements {
+        switch e := e.(type) {
+        case string:
+            a.Append( String(e) )
+        case float64:
+            a.Append( Number(e) )
+        case int:
+            a.Append( Number(e) )
+        default:
+            a.Append( e )
+        }
+    }
+    cb.Op( "TJ", a )
+}
+
+// DrawXObject paints the XObject (image or form) given by reference (Do)
+func (cb *ContentBuilder) DrawXObject( xobject Reference ) {
+    cb.Op( "Do", cb.resourceName( "XObject", "X", xobject ) )
+}
+
+// DrawImage paints the image XObject in the rectangle of lower left corner
+// x, y with the given width and height
+func (cb *ContentBuilder) DrawImage( image Reference, x, y, width, height float64 ) {
+    cb.Save( )
+    cb.Transform( width, 0, 0, height, x, y )
+    cb.DrawXObject( image )
+    cb.Restore( )
+}
+
+// return the content as a new stream, compressed
+func (cb *ContentBuilder) stream( dic Dictionary ) Stream {
+    dic.Set( "Filter", Name("FlateDecode") )
+    return NewStream( dic, flateEncode( cb.Bytes() ) )
+}
+
+// standard Type 1 fonts, available in all readers
+var standardFonts = map[string]bool{
+    "Times-Roman": true, "Times-Bold": true, "Times-Italic": true, "Times-BoldItalic": true,
+    "Helvetica": true, "Helvetica-Bold": true, "Helvetica-Oblique": true, "Helvetica-BoldOblique": true,
+    "Courier": true, "Courier-Bold": true, "Courier-Oblique": true, "Courier-BoldOblique": true,
+    "Symbol": true, "ZapfDingbats": true,
+}
+
+// NewStandardFont creates a font object for one of the 14 standard Type 1
+// fonts (e.g. Helvetica), with WinAnsiEncoding except for Symbol and
+// ZapfDingbats, which use their own encoding.
+func (pf *PdfFile) NewStandardFont( baseFont string ) ( Reference, error ) {
+    if ! standardFonts[baseFont] {
+        return Reference{}, fmt.Errorf( "%s is not a standard font\n", baseFont )
+    }
+    dic := NewDictionary( )
+    dic.Set( "Type", Name("Font") )
+    dic.Set( "Subtype", Name("Type1") )
+    dic.Set( "BaseFont", Name(baseFont) )
+    if baseFont != "Symbol" && baseFont != "ZapfDingbats" {
+        dic.Set( "Encoding", Name("WinAnsiEncoding") )
+    }
+    obj := pf.NewObject( dic )
+    return Reference{ obj.id, obj.gen }, nil
+}
+
+// NewFormXObject creates a form XObject from the content, with the given
+// bounding box in form space, which can then be drawn with DrawXObject.
+func (pf *PdfFile) NewFormXObject( cb *ContentBuilder, bbox Rectangle ) Reference {
+    dic := NewDictionary( )
+    dic.Set( "Type", Name("XObject") )
+    dic.Set( "Subtype", Name("Form") )
+    dic.Set( "BBox", NewArray( Number(bbox.LLx), Number(bbox.LLy), Number(bbox.URx), Number(bbox.URy) ) )
+    dic.Set( "Resources", cb.Resources() )
+    obj := pf.NewObject( cb.stream( dic ) )
+    return Reference{ obj.id, obj.gen }
+}
+
+// AddContent adds the builder content to the page, as a new content stream
+// following the existing content, and sets the page resources, which become
+// direct objects in the page dictionary. The builder should be made by the
+// page NewContentBuilder, otherwise its resource names must not collide with
+// the page resource names. Since the new content starts in the graphics state
+// left by the existing content, it may be necessary to have the existing
+// content between Save and Restore.
+func (p *Page) AddContent( cb *ContentBuilder ) error {
+    res, err := p.resolvedResources( )
+    if err != nil {
+        return err
+    }
+    for _, category := range cb.resources.Keys() {
+        names, ok := cb.resources.data[category].(Dictionary)
+        if ! ok {
+            res.Set( category, cb.resources.data[category] )
+            continue
+        }
+        existing, _ := res.data[category].(Dictionary)
+        existing = existing.clone( )
+        for _, name := range names.Keys() {
+            if v, ok := existing.data[name]; ok && ! equalValues( v, names.data[name] ) {
+                return fmt.Errorf( "Page %d %d resource /%s /%s is already defined\n",
+                                   p.obj.id, p.obj.gen, category, name )
+            }
+            existing.Set( name, names.data[name] )
+        }
+        res.Set( category, existing )
+    }
+
+    dic := p.Dict()
+    contents, err := p.pf.Resolve( dic.data["Contents"] )
+    if err != nil {
+        return fmt.Errorf( "Page %d %d Contents: %v", p.obj.id, p.obj.gen, err )
+    }
+    switch contents.(type) {
+    case nil, Stream, Array:
+    default:
+        return fmt.Errorf( "Page %d %d Contents is not a stream or an array\n", p.obj.id, p.obj.gen )
+    }
+    obj := p.pf.NewObject( cb.stream( NewDictionary( ) ) )
+    ref := Reference{ obj.id, obj.gen }
+    switch c := contents.(type) {
+    case nil:
+        dic.Set( "Contents", ref )
+    case Stream:
+        dic.Set( "Contents", NewArray( dic.data["Contents"], ref ) )
+    case Array:
+        a := NewArray( c.data... )
+        a.Append( ref )
+        dic.Set( "Contents", a )
+    }
+    dic.Set( "Resources", res )
+    p.obj.SetValue( dic )
+    return nil
+}
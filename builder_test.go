@@ -0,0 +1,160 @@
+package pdf
+
+import (
+    "testing"
+)
+
+// return a new document with one page, whose resources have inline array and
+// dictionary values besides a font reference
+func newPageWithInlineResources( t *testing.T ) ( *PdfFile, *Page, Reference ) {
+    pf, err := NewDocument( &DocumentArgs{ Deterministic: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    font, err := pf.NewStandardFont( "Helvetica" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    fonts := NewDictionary( )
+    fonts.Set( "F1", font )
+    colorSpaces := NewDictionary( )
+    colorSpaces.Set( "CS0", NewArray( Name("ICCBased"), NewReference( 5, 0 ) ) )
+    gs := NewDictionary( )
+    gs.Set( "CA", Number(0.5) )
+    states := NewDictionary( )
+    states.Set( "GS0", gs )
+    res := NewDictionary( )
+    res.Set( "Font", fonts )
+    res.Set( "ColorSpace", colorSpaces )
+    res.Set( "ExtGState", states )
+
+    dict := NewDictionary( )
+    dict.Set( "MediaBox", NewArray( Number(0), Number(0), Number(612), Number(792) ) )
+    dict.Set( "Resources", res )
+    p, err := pf.AppendPage( dict )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return pf, p, font
+}
+
+func TestAddContentInlineResources( t *testing.T ) {
+    pf, p, font := newPageWithInlineResources( t )
+
+    cb, err := p.NewContentBuilder( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    cb.Op( "cs", Name("CS0") )
+    cb.Op( "gs", Name("GS0") )
+    cb.BeginText( )
+    cb.SetFont( font, 12 )
+    cb.ShowText( "Hello" )
+    cb.EndText( )
+    if err = p.AddContent( cb ); err != nil {
+        t.Fatalf( "AddContent with the page resources: %v", err )
+    }
+
+    // same values, but not the same instances
+    other := NewContentBuilder( )
+    other.SetFont( font, 10 )
+    colorSpaces := NewDictionary( )
+    colorSpaces.Set( "CS0", NewArray( Name("ICCBased"), NewReference( 5, 0 ) ) )
+    gs := NewDictionary( )
+    gs.Set( "CA", Number(0.5) )
+    states := NewDictionary( )
+    states.Set( "GS0", gs )
+    other.resources.Set( "ColorSpace", colorSpaces )
+    other.resources.Set( "ExtGState", states )
+    if err = p.AddContent( other ); err != nil {
+        t.Fatalf( "AddContent with identical resources: %v", err )
+    }
+
+    res, err := p.Resources( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, category := range []string{ "Font", "ColorSpace", "ExtGState" } {
+        names, ok := res.data[category].(Dictionary)
+        if ! ok || names.Len() != 1 {
+            t.Errorf( "Page resources /%s: got %v, expected 1 entry", category, res.data[category] )
+        }
+    }
+    if contents, ok := p.Dict( ).data["Contents"].(Array); ! ok || contents.Len() != 2 {
+        t.Errorf( "Page contents: got %v, expected 2 streams", p.Dict( ).data["Contents"] )
+    }
+
+    // different values with the same names
+    changed := NewContentBuilder( )
+    gs = NewDictionary( )
+    gs.Set( "CA", Number(1) )
+    states = NewDictionary( )
+    states.Set( "GS0", gs )
+    changed.resources.Set( "ExtGState", states )
+    if err = p.AddContent( changed ); err == nil {
+        t.Errorf( "AddContent with a different /ExtGState /GS0: no error" )
+    }
+    courier, err := pf.NewStandardFont( "Courier" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    conflict := NewContentBuilder( )
+    conflict.SetFont( courier, 10 )     // named F1 as well
+    if err = p.AddContent( conflict ); err == nil {
+        t.Errorf( "AddContent with a different /Font /F1: no error" )
+    }
+}
+
+func TestEqualValues( t *testing.T ) {
+    d1 := NewDictionary( )
+    d1.Set( "A", NewArray( Number(1), Name("N") ) )
+    d1.Set( "B", String("s") )
+    d2 := NewDictionary( )
+    d2.Set( "B", String("s") )
+    d2.Set( "A", NewArray( Number(1), Name("N") ) )
+    d3 := d2.clone( )
+    d3.Set( "A", NewArray( Number(1), Name("M") ) )
+
+    tests := []struct {
+        a, b    interface{}
+        equal   bool
+    }{
+        { Number(1), Number(1), true },
+        { Number(1), Number(2), false },
+        { String("s"), HexString("s"), false },
+        { NewReference( 3, 0 ), NewReference( 3, 0 ), true },
+        { NewReference( 3, 0 ), NewReference( 3, 1 ), false },
+        { d1, d2, true },
+        { d1, d3, false },
+        { d1, NewArray( ), false },
+        { NewArray( ), d1, false },
+        { Name("A"), NewArray( Name("A") ), false },
+        { NewStream( d1.clone( ), []byte("data") ), NewStream( d2.clone( ), []byte("data") ), true },
+        { NewStream( d1.clone( ), []byte("data") ), NewStream( d1.clone( ), []byte("other") ), false },
+    }
+    for i, test := range tests {
+        if equal := equalValues( test.a, test.b ); equal != test.equal {
+            t.Errorf( "#%d equalValues( %v, %v ): got %v, expected %v", i, test.a, test.b, equal, test.equal )
+        }
+    }
+}
+
+func TestContentBuilderLargeNumbers( t *testing.T ) {
+    cb := NewContentBuilder( )
+    cb.Translate( 1234567.5, 0 )
+    cb.Rectangle( -12345678.9, 0.000125, 98765432.25, 1e21 )
+    cb.Op( "w", Number(10000000.5) )
+    expected := "1 0 0 1 1234567.5 0 cm\n" +
+                "-12345678.9 0.000125 98765432.25 1000000000000000000000 re\n" +
+                "10000000.5 w\n"
+    if s := string(cb.Bytes( )); s != expected {
+        t.Errorf( "Content: got %q, expected %q", s, expected )
+    }
+    ops, err := ParseContent( cb.Bytes( ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkOperations( t, ops, []string{ "cm", "re", "w" }, [][]interface{}{
+        numbers( 1, 0, 0, 1, 1234567.5, 0 ), numbers( -12345678.9, 0.000125, 98765432.25, 1e21 ),
+        numbers( 10000000.5 ) } )
+}
@@ -0,0 +1,538 @@
+
+package pdf
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf16"
+)
+
+/*
+CMaps map character codes in strings to character selectors: CIDs for the
+Encoding CMap of a composite font, or Unicode text for a ToUnicode CMap. A
+CMap is a PostScript program, but its operators have the same syntax as
+content stream operators, e.g.:
+
+    /CMapName /Adobe-Identity-UCS def
+    1 begincodespacerange <0000> <FFFF> endcodespacerange
+    2 beginbfchar <0003> <0020> <0011> <0066006C> endbfchar
+    1 beginbfrange <0024> <0026> <0041> endbfrange
+    1 begincidrange <8140> <817E> 633 endcidrange
+
+Code space ranges give the valid codes and their length in bytes, from 1 to
+4. In a range, each byte must be between the corresponding bytes of the low
+and high codes, so that multi-byte code spaces can be mixed (e.g. 1 byte for
+ASCII and 2 bytes for other characters).
+
+Unicode text is given as UTF-16BE, possibly made of several characters (e.g.
+for ligatures). In a bfrange, the last character of the destination is
+incremented for each code following the low code, or the destination is given
+for each code by an array. In a cidrange, the CID is incremented for each
+code following the low code. Codes that are not mapped to a CID are mapped to
+the notdef CID given by notdefchar or notdefrange, or else to CID 0.
+
+A CMap can use the mappings of another CMap (usecmap operator, or UseCMap
+entry of an embedded CMap stream), its own mappings taking precedence.
+
+Predefined CMaps are known by name (see PDF 32000 9.7.5.2): Identity-H and
+Identity-V map 2 byte codes to the same CIDs, and the Adobe CJK CMaps give
+their code spaces, their character collection and their writing mode. The
+Unicode based CJK CMaps (e.g. UniJIS-UCS2-H or UniGB-UTF16-V) also map codes
+to Unicode, since codes are Unicode values, but CIDs of the other predefined
+CJK CMaps are not known, as they require the Adobe CMap resource files.
+*/
+
+// code space range, with low and high codes of the same length
+type codeSpaceRange struct {
+    low, high   []byte
+}
+
+// range of codes mapped to Unicode
+type unicodeRange struct {
+    low, high   uint32
+    dst         []uint16        // UTF-16 destination of the low code
+    dsts        []interface{}   // or destination of each code
+}
+
+// range of codes mapped to CIDs
+type cidRange struct {
+    low, high   uint32
+    cid         uint32          // CID of the low code
+}
+
+// Unicode encodings of predefined CMap codes
+const (
+    _CODE_NOT_UNICODE = iota
+    _CODE_UCS2
+    _CODE_UTF16
+)
+
+// CMap maps character codes to CIDs and to Unicode text
+type CMap struct {
+    Name        string
+    Registry    string          // character collection (CIDSystemInfo), e.g. Adobe
+    Ordering    string          // e.g. Japan1
+    Supplement  int
+    Vertical    bool            // writing mode (WMode 1)
+
+    codeSpace   []codeSpaceRange
+    chars       map[uint32]string
+    ranges      []unicodeRange
+    cids        map[uint32]uint32
+    cidRanges   []cidRange
+    notdefs     []cidRange
+    identity    bool            // codes are CIDs
+    unicode     int             // predefined CMap codes are Unicode values
+    parent      *CMap           // used CMap, or nil
+}
+
+func newCMap( ) *CMap {
+    return &CMap{ chars: make( map[uint32]string ), cids: make( map[uint32]uint32 ) }
+}
+
+// return the code value of at most 4 bytes
+func codeValue( b []byte ) uint32 {
+    var v uint32
+    for _, c := range b {
+        v = v << 8 | uint32(c)
+    }
+    return v
+}
+
+// return the UTF-16BE text as UTF-16 code units
+func utf16Units( b []byte ) []uint16 {
+    units := make( []uint16, len(b) / 2 )
+    for i := range units {
+        units[i] = uint16(b[2*i]) << 8 | uint16(b[2*i+1])
+    }
+    return units
+}
+
+// return the CMap code or destination string operand
+func cmapString( v interface{} ) ( []byte, bool ) {
+    switch v := v.(type) {
+    case HexString:
+        return []byte(v), true
+    case String:
+        return []byte(v), true
+    }
+    return nil, false
+}
+
+// return the CMap source code operand, of 1 to 4 bytes
+func cmapCode( v interface{} ) ( uint32, bool ) {
+    code, ok := cmapString( v )
+    if ! ok || len(code) == 0 || len(code) > 4 {
+        return 0, false
+    }
+    return codeValue( code ), true
+}
+
+// return the CID operand
+func cmapCID( v interface{} ) ( uint32, bool ) {
+    n, ok := v.(Number)
+    if ! ok || n < 0 || n > 0xffffffff {
+        return 0, false
+    }
+    return uint32(n), true
+}
+
+// add the code to CID ranges given as low high cid triples
+func appendCIDRanges( ranges []cidRange, operands []interface{} ) []cidRange {
+    for i := 0; i + 2 < len(operands); i += 3 {
+        low, ok1 := cmapCode( operands[i] )
+        high, ok2 := cmapCode( operands[i+1] )
+        cid, ok3 := cmapCID( operands[i+2] )
+        if ok1 && ok2 && ok3 && low <= high {
+            ranges = append( ranges, cidRange{ low, high, cid } )
+        }
+    }
+    return ranges
+}
+
+// ParseCMap parses a CMap from the decoded stream data. CMaps used by name
+// must be predefined. Mappings that are not valid are ignored. In case of
+// error, the CMap made of the mappings found before the error is returned.
+func ParseCMap( data []byte ) ( *CMap, error ) {
+    cm := newCMap( )
+    cp := NewContentParser( data )
+    for {
+        op, err := cp.Next( )
+        if err == io.EOF {
+            return cm, nil
+        }
+        if err != nil {
+            return cm, fmt.Errorf( "Invalid CMap: %v", err )
+        }
+        operands := op.Operands
+        switch op.Operator {
+        case "def":
+            if len(operands) == 2 {
+                cm.define( operands[0], operands[1] )
+            }
+        case "usecmap":
+            if len(operands) != 1 {
+                continue
+            }
+            name, ok := operands[0].(Name)
+            if ! ok {
+                continue
+            }
+            if cm.parent, err = PredefinedCMap( string(name) ); err != nil {
+                return cm, err
+            }
+        case "endcodespacerange":
+            for i := 0; i + 1 < len(operands); i += 2 {
+                low, ok1 := cmapString( operands[i] )
+                high, ok2 := cmapString( operands[i+1] )
+                if ok1 && ok2 && len(low) == len(high) && len(low) > 0 && len(low) <= 4 {
+                    cm.codeSpace = append( cm.codeSpace, codeSpaceRange{ low, high } )
+                }
+            }
+        case "endbfchar":
+            for i := 0; i + 1 < len(operands); i += 2 {
+                src, ok := cmapCode( operands[i] )
+                if ! ok {
+                    continue
+                }
+                if dst, ok := cmapString( operands[i+1] ); ok {
+                    cm.chars[src] = string( utf16.Decode( utf16Units( dst ) ) )
+                } else if name, ok := operands[i+1].(Name); ok {
+                    cm.chars[src] = glyphUnicode( string(name) )
+                }
+            }
+        case "endbfrange":
+            for i := 0; i + 2 < len(operands); i += 3 {
+                low, ok1 := cmapCode( operands[i] )
+                high, ok2 := cmapCode( operands[i+1] )
+                if ! ok1 || ! ok2 || high < low {
+                    continue
+                }
+                r := unicodeRange{ low: low, high: high }
+                if dst, ok := cmapString( operands[i+2] ); ok && len(dst) >= 2 {
+                    r.dst = utf16Units( dst )
+                } else if a, ok := operands[i+2].(Array); ok {
+                    r.dsts = a.data
+                } else {
+                    continue
+                }
+                cm.ranges = append( cm.ranges, r )
+            }
+        case "endcidchar", "endnotdefchar":
+            for i := 0; i + 1 < len(operands); i += 2 {
+                src, ok1 := cmapCode( operands[i] )
+                cid, ok2 := cmapCID( operands[i+1] )
+                if ! ok1 || ! ok2 {
+                    continue
+                }
+                if op.Operator == "endcidchar" {
+                    cm.cids[src] = cid
+                } else {
+                    cm.notdefs = append( cm.notdefs, cidRange{ src, src, cid } )
+                }
+            }
+        case "endcidrange":
+            cm.cidRanges = appendCIDRanges( cm.cidRanges, operands )
+        case "endnotdefrange":
+            cm.notdefs = appendCIDRanges( cm.notdefs, operands )
+        }
+    }
+}
+
+// set the CMap attribute defined by key, either directly or in the
+// CIDSystemInfo dictionary
+func (cm *CMap) define( key, value interface{} ) {
+    k, ok := key.(Name)
+    if ! ok {
+        return
+    }
+    switch k {
+    case "CMapName":
+        if name, ok := value.(Name); ok {
+            cm.Name = string(name)
+        }
+    case "WMode":
+        if n, ok := value.(Number); ok {
+            cm.Vertical = n == 1
+        }
+    case "Registry":
+        if s, ok := value.(String); ok {
+            cm.Registry = string(s)
+        }
+    case "Ordering":
+        if s, ok := value.(String); ok {
+            cm.Ordering = string(s)
+        }
+    case "Supplement":
+        if n, ok := value.(Number); ok {
+            cm.Supplement = int(n)
+        }
+    case "CIDSystemInfo":
+        if a, ok := value.(Array); ok && len(a.data) > 0 {  // array of 1 dictionary
+            value = a.data[0]
+        }
+        if d, ok := value.(Dictionary); ok {
+            for _, k := range []string{ "Registry", "Ordering", "Supplement" } {
+                if v, ok := d.data[k]; ok {
+                    cm.define( Name(k), v )
+                }
+            }
+        }
+    }
+}
+
+// LoadCMap returns the CMap given by the stream v, which is either a stream
+// or a reference to a stream object, such as a font ToUnicode or Encoding
+// entry. The CMap used by the stream UseCMap entry, either a name or another
+// stream, is loaded as well. The stream dictionary entries CMapName, WMode
+// and CIDSystemInfo are used if they are not defined by the CMap program.
+func (pf *PdfFile) LoadCMap( v interface{} ) ( *CMap, error ) {
+    return pf.loadCMap( v, 0 )
+}
+
+func (pf *PdfFile) loadCMap( v interface{}, depth int ) ( *CMap, error ) {
+    where := "CMap"
+    if ref, ok := v.(Reference); ok {
+        where = fmt.Sprintf( "CMap %d %d", ref.id, ref.gen )
+    }
+    v, err := pf.Resolve( v )
+    if err != nil {
+        return nil, fmt.Errorf( "%s: %v", where, err )
+    }
+    s, ok := v.(Stream)
+    if ! ok {
+        return nil, fmt.Errorf( "%s is not a stream\n", where )
+    }
+    data, err := s.Decode( )
+    if err != nil {
+        return nil, fmt.Errorf( "%s: %v", where, err )
+    }
+    cm, err := ParseCMap( data )
+    if err != nil {
+        return cm, fmt.Errorf( "%s: %v", where, err )
+    }
+    dic := s.extent
+    for _, k := range []string{ "CMapName", "WMode", "CIDSystemInfo" } {
+        if v, ok := dic.data[k]; ok {
+            v, _ = pf.Resolve( v )
+            switch {
+            case k == "CMapName" && cm.Name != "",
+                 k == "WMode" && cm.Vertical,
+                 k == "CIDSystemInfo" && cm.Registry != "":
+            default:
+                cm.define( Name(k), v )
+            }
+        }
+    }
+    use, ok := dic.data["UseCMap"]
+    if ! ok || cm.parent != nil {
+        return cm, nil
+    }
+    if name, ok := use.(Name); ok {
+        cm.parent, err = PredefinedCMap( string(name) )
+    } else if depth >= 8 {
+        err = fmt.Errorf( "Too many nested UseCMap\n" )
+    } else {
+        cm.parent, err = pf.loadCMap( use, depth + 1 )
+    }
+    if err != nil {
+        return cm, fmt.Errorf( "%s UseCMap: %v", where, err )
+    }
+    return cm, nil
+}
+
+// predefined CJK CMap, without the writing mode suffix
+type predefinedCMap struct {
+    ordering    string      // Adobe character collection
+    codeSpace   string      // low and high hexadecimal codes of each range
+    unicode     int
+}
+
+const (
+    _ASCII_CODES = "00 80 "
+    _EUC_CODES = _ASCII_CODES + "A1A1 FEFE"
+    _RKSJ_CODES = _ASCII_CODES + "8140 9FFC A0 DF E040 FCFC"
+    _GBK_CODES = _ASCII_CODES + "8140 FEFE"
+    _UCS2_CODES = "0000 FFFF"
+    _UTF16_CODES = "0000 D7FF D800DC00 DBFFDFFF E000 FFFF"
+)
+
+// predefined CJK CMaps by name, without -H or -V
+var predefinedCMaps = map[string]predefinedCMap{
+    "GB-EUC":           { "GB1", _EUC_CODES, _CODE_NOT_UNICODE },
+    "GBpc-EUC":         { "GB1", _ASCII_CODES + "A1A1 FEFE FD FF", _CODE_NOT_UNICODE },
+    "GBK-EUC":          { "GB1", _GBK_CODES, _CODE_NOT_UNICODE },
+    "GBKp-EUC":         { "GB1", _GBK_CODES, _CODE_NOT_UNICODE },
+    "GBK2K":            { "GB1", _GBK_CODES + " 81308130 FE39FE39", _CODE_NOT_UNICODE },
+    "UniGB-UCS2":       { "GB1", _UCS2_CODES, _CODE_UCS2 },
+    "UniGB-UTF16":      { "GB1", _UTF16_CODES, _CODE_UTF16 },
+    "B5pc":             { "CNS1", _ASCII_CODES + "A140 FEFE FD FF", _CODE_NOT_UNICODE },
+    "HKscs-B5":         { "CNS1", _ASCII_CODES + "8840 FEFE", _CODE_NOT_UNICODE },
+    "ETen-B5":          { "CNS1", _ASCII_CODES + "A140 FEFE", _CODE_NOT_UNICODE },
+    "ETenms-B5":        { "CNS1", _ASCII_CODES + "A140 FEFE", _CODE_NOT_UNICODE },
+    "CNS-EUC":          { "CNS1", _EUC_CODES + " 8EA1A1A1 8EB0FEFE", _CODE_NOT_UNICODE },
+    "UniCNS-UCS2":      { "CNS1", _UCS2_CODES, _CODE_UCS2 },
+    "UniCNS-UTF16":     { "CNS1", _UTF16_CODES, _CODE_UTF16 },
+    "83pv-RKSJ":        { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "90ms-RKSJ":        { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "90msp-RKSJ":       { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "90pv-RKSJ":        { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "Add-RKSJ":         { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "EUC":              { "Japan1", _EUC_CODES + " 8EA0 8EDF", _CODE_NOT_UNICODE },
+    "Ext-RKSJ":         { "Japan1", _RKSJ_CODES, _CODE_NOT_UNICODE },
+    "":                 { "Japan1", "2121 7E7E", _CODE_NOT_UNICODE },   // H and V
+    "UniJIS-UCS2":      { "Japan1", _UCS2_CODES, _CODE_UCS2 },
+    "UniJIS-UCS2-HW":   { "Japan1", _UCS2_CODES, _CODE_UCS2 },
+    "UniJIS-UTF16":     { "Japan1", _UTF16_CODES, _CODE_UTF16 },
+    "KSC-EUC":          { "Korea1", _EUC_CODES, _CODE_NOT_UNICODE },
+    "KSCms-UHC":        { "Korea1", _ASCII_CODES + "8141 FEFE", _CODE_NOT_UNICODE },
+    "KSCms-UHC-HW":     { "Korea1", _ASCII_CODES + "8141 FEFE", _CODE_NOT_UNICODE },
+    "KSCpc-EUC":        { "Korea1", _ASCII_CODES + "A1A1 FDFE", _CODE_NOT_UNICODE },
+    "UniKS-UCS2":       { "Korea1", _UCS2_CODES, _CODE_UCS2 },
+    "UniKS-UTF16":      { "Korea1", _UTF16_CODES, _CODE_UTF16 },
+}
+
+// PredefinedCMap returns the predefined CMap name, e.g. Identity-H or
+// UniJIS-UCS2-V
+func PredefinedCMap( name string ) ( *CMap, error ) {
+    cm := newCMap( )
+    cm.Name, cm.Registry = name, "Adobe"
+    base := strings.TrimSuffix( strings.TrimSuffix( name, "-H" ), "-V" )
+    cm.Vertical = strings.HasSuffix( name, "V" )
+    if name == "H" || name == "V" {
+        base = ""
+    } else if base == name {
+        return nil, fmt.Errorf( "Unknown predefined CMap %s\n", name )
+    }
+    var codeSpace string
+    if base == "Identity" {
+        cm.Ordering, cm.identity = "Identity", true
+        codeSpace = _UCS2_CODES
+    } else {
+        p, ok := predefinedCMaps[base]
+        if ! ok {
+            return nil, fmt.Errorf( "Unknown predefined CMap %s\n", name )
+        }
+        cm.Ordering, cm.unicode = p.ordering, p.unicode
+        codeSpace = p.codeSpace
+    }
+    codes := strings.Fields( codeSpace )
+    for i := 0; i + 1 < len(codes); i += 2 {
+        low, _ := strconv.ParseUint( codes[i], 16, 32 )
+        high, _ := strconv.ParseUint( codes[i+1], 16, 32 )
+        n := len(codes[i]) / 2
+        r := codeSpaceRange{ make( []byte, n ), make( []byte, n ) }
+        for j := 0; j < n; j++ {
+            shift := uint( 8 * (n - 1 - j) )
+            r.low[j], r.high[j] = byte(low >> shift), byte(high >> shift)
+        }
+        cm.codeSpace = append( cm.codeSpace, r )
+    }
+    return cm, nil
+}
+
+// NextCode returns the next character code in s and its length in bytes,
+// according to the code space ranges (0 bytes at the end of s). If no range
+// matches, the code length is the shortest length in the code space, or 1
+// byte if there is no code space.
+func (cm *CMap) NextCode( s []byte ) ( uint32, int ) {
+    if len(s) == 0 {
+        return 0, 0
+    }
+    codeSpace := cm.codeSpace
+    for p := cm.parent; len(codeSpace) == 0 && p != nil; p = p.parent {
+        codeSpace = p.codeSpace
+    }
+    shortest := 0
+    for n := 1; n <= 4 && n <= len(s); n++ {
+        for _, r := range codeSpace {
+            if len(r.low) != n {
+                continue
+            }
+            if shortest == 0 {
+                shortest = n
+            }
+            i := 0
+            for ; i < n; i++ {
+                if s[i] < r.low[i] || s[i] > r.high[i] {
+                    break
+                }
+            }
+            if i == n {
+                return codeValue( s[:n] ), n
+            }
+        }
+    }
+    if shortest == 0 || shortest > len(s) {
+        shortest = 1
+    }
+    return codeValue( s[:shortest] ), shortest
+}
+
+// Unicode returns the Unicode text of the code, and whether it is mapped
+func (cm *CMap) Unicode( code uint32 ) ( string, bool ) {
+    if s, ok := cm.chars[code]; ok {
+        return s, true
+    }
+    for _, r := range cm.ranges {
+        if code < r.low || code > r.high {
+            continue
+        }
+        offset := code - r.low
+        if r.dsts != nil {
+            if int(offset) < len(r.dsts) {
+                if dst, ok := cmapString( r.dsts[offset] ); ok {
+                    return string( utf16.Decode( utf16Units( dst ) ) ), true
+                }
+            }
+            continue
+        }
+        units := make( []uint16, len(r.dst) )
+        copy( units, r.dst )
+        units[len(units)-1] += uint16(offset)
+        return string( utf16.Decode( units ) ), true
+    }
+    switch {
+    case cm.unicode == _CODE_NOT_UNICODE:
+    case code < 0xd800 || (code >= 0xe000 && code <= 0xffff):
+        return string(rune(code)), true
+    case cm.unicode == _CODE_UTF16 && code > 0xffff:
+        if r := utf16.DecodeRune( rune(code >> 16), rune(code & 0xffff) ); r != unicode.ReplacementChar {
+            return string(r), true
+        }
+    }
+    if cm.parent != nil {
+        return cm.parent.Unicode( code )
+    }
+    return "", false
+}
+
+// CID returns the CID of the code, and whether it is mapped. A code that is
+// not mapped has the notdef CID, 0 by default.
+func (cm *CMap) CID( code uint32 ) ( uint32, bool ) {
+    if cm.identity {
+        return code, true
+    }
+    if cid, ok := cm.cids[code]; ok {
+        return cid, true
+    }
+    for _, r := range cm.cidRanges {
+        if code >= r.low && code <= r.high {
+            return r.cid + code - r.low, true
+        }
+    }
+    if cm.parent != nil {
+        if cid, ok := cm.parent.CID( code ); ok {
+            return cid, true
+        }
+    }
+    for _, r := range cm.notdefs {
+        if code >= r.low && code <= r.high {
+            return r.cid, false
+        }
+    }
+    return 0, false
+}
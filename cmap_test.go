@@ -0,0 +1,257 @@
+package pdf
+
+import (
+    "testing"
+)
+
+const testCMap = `%!PS-Adobe-3.0 Resource-CMap
+/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+/CIDSystemInfo 3 dict dup begin
+  /Registry (Adobe) def
+  /Ordering (Japan1) def
+  /Supplement 6 def
+end def
+/CMapName /Test-V def
+/WMode 1 def
+2 begincodespacerange <00> <80> <8140> <FCFC> endcodespacerange
+2 begincidchar <41> 34 <8145> 700 endcidchar
+1 begincidrange <8140> <817E> 633 endcidrange
+1 beginnotdefrange <00> <1F> 231 endnotdefrange
+3 beginbfchar <41> <0041> <42> /B.alt <43> <0066006C> endbfchar
+2 beginbfrange <8140> <8142> <3000> <8150> <8151> [ <D83DDE00> <00E9> ] endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+func TestParseCMap( t *testing.T ) {
+    cm, err := ParseCMap( []byte(testCMap) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if cm.Name != "Test-V" || cm.Registry != "Adobe" || cm.Ordering != "Japan1" ||
+       cm.Supplement != 6 || ! cm.Vertical {
+        t.Errorf( "CMap: got %s %s-%s-%d vertical %v", cm.Name, cm.Registry, cm.Ordering,
+                  cm.Supplement, cm.Vertical )
+    }
+
+    cids := []struct {
+        code    uint32
+        cid     uint32
+        mapped  bool
+    }{
+        { 0x41, 34, true },
+        { 0x8140, 633, true },
+        { 0x817e, 695, true },
+        { 0x8145, 700, true },      // cidchar takes precedence
+        { 0x05, 231, false },       // notdef range
+        { 0x42, 0, false },
+        { 0x817f, 0, false },
+    }
+    for _, test := range cids {
+        if cid, mapped := cm.CID( test.code ); cid != test.cid || mapped != test.mapped {
+            t.Errorf( "CID( %x ): got %d %v, expected %d %v", test.code, cid, mapped, test.cid, test.mapped )
+        }
+    }
+
+    texts := []struct {
+        code    uint32
+        text    string
+        mapped  bool
+    }{
+        { 0x41, "A", true },
+        { 0x42, "B", true },        // glyph name
+        { 0x43, "fl", true },       // several characters
+        { 0x8140, "　", true },
+        { 0x8142, "。", true },
+        { 0x8150, "\U0001F600", true },  // surrogate pair
+        { 0x8151, "é", true },
+        { 0x8143, "", false },
+        { 0x44, "", false },
+    }
+    for _, test := range texts {
+        if text, mapped := cm.Unicode( test.code ); text != test.text || mapped != test.mapped {
+            t.Errorf( "Unicode( %x ): got %q %v, expected %q %v", test.code, text, mapped, test.text, test.mapped )
+        }
+    }
+}
+
+func TestCMapNextCode( t *testing.T ) {
+    cm, err := ParseCMap( []byte(testCMap) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    s := []byte("A\x81\x42\x05\xfd\x81")
+    expected := []struct {
+        code    uint32
+        n       int
+    }{
+        { 0x41, 1 }, { 0x8142, 2 }, { 0x05, 1 },
+        { 0xfd, 1 },                // not in any range: shortest length
+        { 0x81, 1 },                // truncated 2 byte code
+    }
+    for i, e := range expected {
+        code, n := cm.NextCode( s )
+        if code != e.code || n != e.n {
+            t.Errorf( "Code #%d: got %x (%d bytes), expected %x (%d bytes)", i, code, n, e.code, e.n )
+        }
+        s = s[n:]
+    }
+    if _, n := cm.NextCode( s ); n != 0 {
+        t.Errorf( "NextCode at the end: got %d bytes, expected 0", n )
+    }
+    if code, n := newCMap( ).NextCode( []byte("\x12\x34") ); code != 0x12 || n != 1 {
+        t.Errorf( "NextCode without code space: got %x (%d bytes), expected 12 (1 byte)", code, n )
+    }
+}
+
+func TestParseCMapErrors( t *testing.T ) {
+    if _, err := ParseCMap( []byte("/Unknown-H usecmap") ); err == nil {
+        t.Errorf( "usecmap of an unknown CMap: no error" )
+    }
+    cm, err := ParseCMap( []byte("1 begincidchar <20> 1 endcidchar 1 begincidrange <30> [ 5") )
+    if err == nil {
+        t.Errorf( "Truncated CMap: no error" )
+    }
+    if cid, ok := cm.CID( 0x20 ); ! ok || cid != 1 {    // mappings before the error
+        t.Errorf( "Partial CMap CID( 20 ): got %d %v, expected 1 true", cid, ok )
+    }
+    // invalid mappings are ignored
+    cm, err = ParseCMap( []byte("1 begincodespacerange <00> <FFFF> endcodespacerange " +
+                                "1 begincidrange <40> <30> 1 endcidrange 1 beginbfrange <40> <30> <0041> endbfrange") )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if len(cm.codeSpace) != 0 || len(cm.cidRanges) != 0 || len(cm.ranges) != 0 {
+        t.Errorf( "Invalid ranges: got %v %v %v, expected none", cm.codeSpace, cm.cidRanges, cm.ranges )
+    }
+}
+
+func TestPredefinedCMap( t *testing.T ) {
+    tests := []struct {
+        name        string
+        ordering    string
+        vertical    bool
+    }{
+        { "Identity-H", "Identity", false }, { "Identity-V", "Identity", true },
+        { "H", "Japan1", false }, { "V", "Japan1", true },
+        { "90ms-RKSJ-H", "Japan1", false }, { "KSCms-UHC-HW-V", "Korea1", true },
+        { "UniGB-UTF16-H", "GB1", false },
+    }
+    for _, test := range tests {
+        cm, err := PredefinedCMap( test.name )
+        if err != nil {
+            t.Errorf( "%s: %v", test.name, err )
+            continue
+        }
+        if cm.Name != test.name || cm.Registry != "Adobe" || cm.Ordering != test.ordering ||
+           cm.Vertical != test.vertical {
+            t.Errorf( "%s: got %s %s-%s vertical %v", test.name, cm.Name, cm.Registry, cm.Ordering, cm.Vertical )
+        }
+    }
+    for _, name := range []string{ "Identity", "Unknown-H", "90ms-RKSJ", "" } {
+        if _, err := PredefinedCMap( name ); err == nil {
+            t.Errorf( "%q: no error", name )
+        }
+    }
+
+    identity, _ := PredefinedCMap( "Identity-H" )
+    if code, n := identity.NextCode( []byte("\x12\x34") ); code != 0x1234 || n != 2 {
+        t.Errorf( "Identity-H NextCode: got %x (%d bytes), expected 1234 (2 bytes)", code, n )
+    }
+    if cid, ok := identity.CID( 0x1234 ); ! ok || cid != 0x1234 {
+        t.Errorf( "Identity-H CID: got %x %v, expected 1234 true", cid, ok )
+    }
+    if _, ok := identity.Unicode( 0x41 ); ok {
+        t.Errorf( "Identity-H Unicode: mapped, expected not mapped" )
+    }
+
+    // codes are UTF-16 units, with 4 byte surrogate pairs
+    utf16, _ := PredefinedCMap( "UniJIS-UTF16-H" )
+    s := []byte("\x30\x42\xd8\x3d\xde\x00")
+    for _, expected := range []string{ "あ", "\U0001F600" } {
+        code, n := utf16.NextCode( s )
+        if text, ok := utf16.Unicode( code ); ! ok || text != expected {
+            t.Errorf( "UniJIS-UTF16-H code %x: got %q %v, expected %q", code, text, ok, expected )
+        }
+        s = s[n:]
+    }
+    if _, ok := utf16.CID( 0x3042 ); ok {      // requires the Adobe CMap resources
+        t.Errorf( "UniJIS-UTF16-H CID: mapped, expected not mapped" )
+    }
+}
+
+func TestLoadCMapUseCMap( t *testing.T ) {
+    pf, err := NewDocument( nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    base := pf.NewObject( NewStream( NewDictionary( ),
+                          []byte("1 begincodespacerange <00> <FF> endcodespacerange 1 begincidrange <20> <7E> 1 endcidrange") ) )
+    dic := NewDictionary( )
+    dic.Set( "Type", Name("CMap") )
+    dic.Set( "CMapName", Name("Embedded") )
+    dic.Set( "WMode", Number(1) )
+    dic.Set( "UseCMap", NewReference( base.ID( ), base.Gen( ) ) )
+    enc := pf.NewObject( NewStream( dic, []byte("1 begincidchar <41> 500 endcidchar") ) )
+
+    cm, err := pf.LoadCMap( NewReference( enc.ID( ), enc.Gen( ) ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if cm.Name != "Embedded" || ! cm.Vertical {
+        t.Errorf( "CMap: got %s vertical %v, expected the stream entries", cm.Name, cm.Vertical )
+    }
+    for _, test := range []struct{ code, cid uint32 }{ { 0x41, 500 }, { 0x42, 35 } } {
+        if cid, ok := cm.CID( test.code ); ! ok || cid != test.cid {
+            t.Errorf( "CID( %x ): got %d %v, expected %d", test.code, cid, ok, test.cid )
+        }
+    }
+    if code, n := cm.NextCode( []byte("\x42") ); code != 0x42 || n != 1 {   // used code space
+        t.Errorf( "NextCode: got %x (%d bytes), expected 42 (1 byte)", code, n )
+    }
+    if _, err = pf.LoadCMap( NewReference( 99, 0 ) ); err == nil {
+        t.Errorf( "LoadCMap of an undefined object: no error" )
+    }
+}
+
+func TestCompositeFontWidth( t *testing.T ) {
+    pf, err := NewDocument( nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    enc := pf.NewObject( NewStream( NewDictionary( ),
+                         []byte("1 begincodespacerange <00> <FF> endcodespacerange 1 begincidrange <20> <7E> 1 endcidrange") ) )
+    cidFont := NewDictionary( )
+    cidFont.Set( "Type", Name("Font") )
+    cidFont.Set( "Subtype", Name("CIDFontType2") )
+    cidFont.Set( "DW", Number(800) )
+    cidFont.Set( "W", NewArray( Number(0), NewArray( Number(300), Number(500) ) ) )  // CIDs 0 and 1
+    cid := pf.NewObject( cidFont )
+    font := NewDictionary( )
+    font.Set( "Type", Name("Font") )
+    font.Set( "Subtype", Name("Type0") )
+    font.Set( "BaseFont", Name("Test") )
+    font.Set( "Encoding", NewReference( enc.ID( ), enc.Gen( ) ) )
+    font.Set( "DescendantFonts", NewArray( NewReference( cid.ID( ), cid.Gen( ) ) ) )
+
+    f, err := pf.loadFont( font )
+    if err != nil {
+        t.Fatal( err )
+    }
+    tests := []struct {
+        code    uint32
+        width   float64
+    }{
+        { 0x20, 0.5 },              // CID 1
+        { 0x21, 0.8 },              // CID 2, default width
+        { 0x10, 0.8 },              // not mapped: default width, not the CID 0 width
+    }
+    for _, test := range tests {
+        if w := f.width( test.code ); w != test.width {
+            t.Errorf( "Width of code %x: got %g, expected %g", test.code, w, test.width )
+        }
+    }
+}
@@ -0,0 +1,294 @@
+
+package pdf
+
+import (
+    "fmt"
+    "io"
+    "bytes"
+)
+
+/*
+Content streams: page contents, form XObjects, Type 3 glyphs and appearance
+streams are sequences of operations, each made of zero or more operands
+followed by an operator, e.g. "/F1 12 Tf" or "0 0 612 792 re". Operands are
+objects with the same syntax as in the file body, except that there is no
+indirect reference. Operators are the remaining tokens (keywords such as BT,
+Tj, cm, re, Do, T*, ' or ").
+
+Inline images are the only exception to this syntax: BI is followed by the
+image dictionary entries, then by ID, a single white space, the binary image
+data and EI. An inline image is returned as a single operation BI with a
+single Stream operand, made of the image dictionary (with keys as given,
+possibly abbreviated) and of the image data, still encoded.
+
+The image data length is given by the L or Length entry if any. Otherwise
+it is computed for unfiltered images in a device color space, or else the
+data ends before the first EI keyword that is preceded by a white space and
+followed by a white space or the end of content.
+*/
+
+// Operation is a content stream operation
+type Operation struct {
+    Operator    string
+    Operands    []interface{}   // Bool, Number, String, HexString, Name, Array, Dictionary or Null
+}
+
+// ContentParser returns the operations from decoded content stream data
+type ContentParser struct {
+    fi          *fileInput
+    data        []byte
+}
+
+// NewContentParser returns a parser for the decoded content stream data
+func NewContentParser( data []byte ) *ContentParser {
+    fi := newMemoryInput( data, nil, false )
+    fi.nextToken()
+    return &ContentParser{ fi, data }
+}
+
+// true if token is an operator instead of the start of an operand
+func isOperator( token string ) bool {
+    switch token {
+    case "true", "false", "null":
+        return false
+    }
+    switch c := token[0]; {
+    case c >= '0' && c <= '9', c == '+', c == '-', c == '.':
+        return false
+    case c == '(', c == '<', c == '>', c == '/', c == '[', c == ']', c == '{', c == '}', c == ')':
+        return false
+    }
+    return true
+}
+
+// Next returns the next operation, or io.EOF at the end of the content
+func (cp *ContentParser) Next( ) ( *Operation, error ) {
+    fi := cp.fi
+    operands := make( []interface{}, 0, 6 )
+    for {
+        if fi.token == "" {
+            if len(operands) > 0 {
+                return nil, fi.parseErrorf( "End of content after operands without operator\n" )
+            }
+            return nil, io.EOF
+        }
+        if isOperator( fi.token ) {
+            break
+        }
+        if fi.token == "R" {
+            return nil, fi.parseErrorf( "Unexpected indirect reference in content\n" )
+        }
+        v, err := getObjectDef( fi, -1 )
+        if err != nil {
+            return nil, err
+        }
+        if _, ok := v.(Reference); ok {
+            return nil, fi.parseErrorf( "Unexpected indirect reference in content\n" )
+        }
+        operands = append( operands, v )
+    }
+    op := &Operation{ Operator: fi.token, Operands: operands }
+    switch fi.token {
+    case "BI":
+        if len(operands) > 0 {
+            return nil, fi.parseErrorf( "Unexpected operands before inline image\n" )
+        }
+        image, err := getInlineImage( fi, cp.data )
+        if err != nil {
+            return nil, err
+        }
+        op.Operands = append( op.Operands, image )
+    case "ID", "EI":
+        return nil, fi.parseErrorf( "Unexpected %s operator outside inline image\n", fi.token )
+    default:
+        fi.nextToken()
+    }
+    return op, nil
+}
+
+// ParseContent returns all operations in the decoded content stream data
+func ParseContent( data []byte ) ( []Operation, error ) {
+    cp := NewContentParser( data )
+    ops := make( []Operation, 0, 64 )
+    for {
+        op, err := cp.Next( )
+        if err == io.EOF {
+            return ops, nil
+        }
+        if err != nil {
+            return ops, err
+        }
+        ops = append( ops, *op )
+    }
+}
+
+// number of color components in inline image color spaces, by name
+var inlineColorComponents = map[Name]int{
+    "G": 1, "DeviceGray": 1, "RGB": 3, "DeviceRGB": 3, "CMYK": 4, "DeviceCMYK": 4,
+}
+
+// return the inline image data length if it can be known from the image
+// dictionary, or -1
+func inlineImageLength( dic Dictionary ) int {
+    for _, k := range []string{ "L", "Length" } {
+        if n, ok := dic.data[k].(Number); ok && n >= 0 {
+            return int(n)
+        }
+    }
+    for _, k := range []string{ "F", "Filter" } {
+        if _, ok := dic.data[k]; ok {
+            return -1
+        }
+    }
+    var w, h, bpc int
+    for _, p := range []struct{ keys []string; v *int }{
+                { []string{ "W", "Width" }, &w }, { []string{ "H", "Height" }, &h },
+                { []string{ "BPC", "BitsPerComponent" }, &bpc } } {
+        for _, k := range p.keys {
+            if n, ok := dic.data[k].(Number); ok {
+                *p.v = int(n)
+            }
+        }
+    }
+    components := 1             // image masks have 1 bit per pixel
+    if im, _ := dic.data["IM"].(Bool); im {
+        bpc = 1
+    } else if im, _ := dic.data["ImageMask"].(Bool); im {
+        bpc = 1
+    } else {
+        cs, ok := dic.data["CS"].(Name)
+        if ! ok {
+            cs, ok = dic.data["ColorSpace"].(Name)
+        }
+        if components, ok = inlineColorComponents[cs]; ! ok {
+            return -1           // e.g. indexed or named resource
+        }
+    }
+    if w <= 0 || h <= 0 || bpc <= 0 {
+        return -1
+    }
+    return (w * components * bpc + 7) / 8 * h   // rows are byte aligned
+}
+
+func isWhiteSpace( c byte ) bool {
+    switch c {
+    case 0x00, '\t', '\n', '\f', '\r', ' ':
+        return true
+    }
+    return false
+}
+
+// parse an inline image in content, from the BI operator to the EI operator
+// included
+func getInlineImage( fi *fileInput, content []byte ) ( Stream, error ) {
+    dic := NewDictionary( )
+    fi.nextToken()              // skip BI
+    for fi.token != "ID" {
+        if fi.token == "" || fi.token[0] != '/' {
+            return Stream{}, fi.parseErrorf( "Inline image entry is not a name: %s\n", fi.token )
+        }
+        key, err := getName( fi )
+        if err != nil {
+            return Stream{}, fmt.Errorf( "Inline image key is invalid: %v", err )
+        }
+        v, err := getObjectDef( fi, -1 )
+        if err != nil {
+            return Stream{}, fmt.Errorf( "Inline image value is invalid: %v", err )
+        }
+        dic.Set( string(key), v )
+    }
+
+    // data follows ID and a single white space
+    start := int(fi.getFilePos()) + 1
+    if start > len(content) {
+        return Stream{}, fi.parseErrorf( "End of content in inline image\n" )
+    }
+    var end, next int           // end of data and position after EI
+    if l := inlineImageLength( dic ); l >= 0 && start + l <= len(content) {
+        end = start + l
+        next = bytes.Index( content[end:], []byte("EI") )
+        if next == -1 {
+            return Stream{}, fi.parseErrorf( "Inline image without EI\n" )
+        }
+        next += end + 2
+    } else {
+        for i := start; ; i++ {
+            j := bytes.Index( content[i:], []byte("EI") )
+            if j == -1 {
+                return Stream{}, fi.parseErrorf( "Inline image without EI\n" )
+            }
+            i += j
+            if isWhiteSpace( content[i-1] ) && (i + 2 == len(content) || isSeparator( content[i+2] )) {
+                end, next = i - 1, i + 2
+                if end < start {    // no data
+                    end = start
+                }
+                break
+            }
+        }
+    }
+    data := make( []byte, end - start )
+    copy( data, content[start:end] )
+    if next == len(content) {   // nothing to fill, EI ends the content
+        fi.token = ""
+        return Stream{ dic, data }, nil
+    }
+    if err := fi.fillBuffer( int64(len(content) - next), int64(next) ); err != nil {
+        return Stream{}, err
+    }
+    fi.nextToken()
+    return Stream{ dic, data }, nil
+}
+
+// ContentData returns the decoded page content. If the page Contents entry is
+// an array of streams, they are concatenated, separated by a white space. A
+// page without Contents is empty.
+func (p *Page) ContentData( ) ( []byte, error ) {
+    v, err := p.pf.Resolve( p.Dict().data["Contents"] )
+    if err != nil {
+        return nil, fmt.Errorf( "Page %d %d Contents: %v", p.obj.id, p.obj.gen, err )
+    }
+    var streams []interface{}
+    switch v := v.(type) {
+    case nil:
+    case Stream:
+        streams = []interface{}{ v }
+    case Array:
+        streams = v.data
+    default:
+        return nil, fmt.Errorf( "Page %d %d Contents is not a stream or an array\n", p.obj.id, p.obj.gen )
+    }
+    var content bytes.Buffer
+    for i, e := range streams {
+        s, err := p.pf.Resolve( e )
+        if err != nil {
+            return nil, fmt.Errorf( "Page %d %d Contents: %v", p.obj.id, p.obj.gen, err )
+        }
+        stream, ok := s.(Stream)
+        if ! ok {
+            return nil, fmt.Errorf( "Page %d %d Contents is not a stream\n", p.obj.id, p.obj.gen )
+        }
+        data, err := stream.Decode( )
+        if err != nil {
+            return nil, fmt.Errorf( "Page %d %d Contents: %v", p.obj.id, p.obj.gen, err )
+        }
+        if i > 0 {
+            content.WriteByte( '\n' )
+        }
+        content.Write( data )
+    }
+    return content.Bytes(), nil
+}
+
+// Content returns the page content operations
+func (p *Page) Content( ) ( []Operation, error ) {
+    data, err := p.ContentData( )
+    if err != nil {
+        return nil, err
+    }
+    ops, err := ParseContent( data )
+    if err != nil {
+        return nil, fmt.Errorf( "Page %d %d Contents: %v", p.obj.id, p.obj.gen, err )
+    }
+    return ops, nil
+}
@@ -0,0 +1,229 @@
+package pdf
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestNextTokenDelimiters( t *testing.T ) {
+    tests := []struct {
+        data    string
+        tokens  []string
+    }{
+        { "/A/B<</C 1>>", []string{ "/A", "/B", "<<", "/C", "1", ">>" } },
+        { "<<>>", []string{ "<<", ">>" } },
+        { "<>", []string{ "<", ">" } },
+        { "BT<48>Tj", []string{ "BT", "<", "48", ">", "Tj" } },
+        { "q<</X 1>>", []string{ "q", "<<", "/X", "1", ">>" } },
+        { "ET>>", []string{ "ET", ">>" } },
+        { "<48><49>", []string{ "<", "48", ">", "<", "49", ">" } },
+        { "[1 2]TJ", []string{ "[", "1", "2", "]", "TJ" } },
+    }
+    for _, test := range tests {
+        fi := newMemoryInput( []byte(test.data), nil, false )
+        tokens := make( []string, 0, len(test.tokens) )
+        for fi.nextToken( ); fi.token != ""; fi.nextToken( ) {
+            tokens = append( tokens, fi.token )
+        }
+        if len(tokens) != len(test.tokens) {
+            t.Errorf( "%q: got tokens %q, expected %q", test.data, tokens, test.tokens )
+            continue
+        }
+        for i, token := range tokens {
+            if token != test.tokens[i] {
+                t.Errorf( "%q: got tokens %q, expected %q", test.data, tokens, test.tokens )
+                break
+            }
+        }
+    }
+}
+
+// check that ops has the expected operators, and the operands of each
+// operation if operands is not nil
+func checkOperations( t *testing.T, ops []Operation, operators []string, operands [][]interface{} ) {
+    t.Helper( )
+    if len(ops) != len(operators) {
+        t.Fatalf( "Operations: got %v, expected operators %q", ops, operators )
+    }
+    for i, op := range ops {
+        if op.Operator != operators[i] {
+            t.Errorf( "Operation #%d: got %s, expected %s", i, op.Operator, operators[i] )
+        }
+        if operands == nil {
+            continue
+        }
+        if len(op.Operands) != len(operands[i]) {
+            t.Errorf( "Operation #%d %s: got operands %v, expected %v", i, op.Operator, op.Operands, operands[i] )
+            continue
+        }
+        for j, v := range op.Operands {
+            if ! equalValues( v, operands[i][j] ) {
+                t.Errorf( "Operation #%d %s operand %d: got %v, expected %v", i, op.Operator, j, v, operands[i][j] )
+            }
+        }
+    }
+}
+
+func TestParseContent( t *testing.T ) {
+    data := "q 1 0 0 1 72 720 cm\nBT/F1 12 Tf(Hello \\(world\\))Tj<48 49>Tj T*\n" +
+            "[(A)-120(B)]TJ 2 3 (quote)\" ET\n" +
+            "/GS0 gs 0 0 612 792 re f /Im0 Do /OC<</MCID 3>>BDC EMC true null d0 Q"
+    ops, err := ParseContent( []byte(data) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    props := NewDictionary( )
+    props.Set( "MCID", Number(3) )
+    checkOperations( t, ops,
+        []string{ "q", "cm", "BT", "Tf", "Tj", "Tj", "T*", "TJ", "\"", "ET",
+                  "gs", "re", "f", "Do", "BDC", "EMC", "d0", "Q" },
+        [][]interface{}{
+            { }, { Number(1), Number(0), Number(0), Number(1), Number(72), Number(720) }, { },
+            { Name("F1"), Number(12) }, { String("Hello (world)") }, { HexString("HI") }, { },
+            { NewArray( String("A"), Number(-120), String("B") ) },
+            { Number(2), Number(3), String("quote") }, { },
+            { Name("GS0") }, { Number(0), Number(0), Number(612), Number(792) }, { },
+            { Name("Im0") }, { Name("OC"), props }, { }, { Bool(true), Null{} }, { },
+        } )
+}
+
+func TestParseContentEndsWithIntegers( t *testing.T ) {
+    // looking ahead for an indirect reference reaches the end of content
+    for _, data := range []string{ "1 2 3 4 re", "q 1 2 3 rg", "0 0 612 792 re\n" } {
+        ops, err := ParseContent( []byte(data) )
+        if err != nil {
+            t.Errorf( "%q: %v", data, err )
+            continue
+        }
+        last := ops[len(ops)-1]
+        if len(last.Operands) != 3 && len(last.Operands) != 4 {
+            t.Errorf( "%q: got %v, expected the operands of the last operation", data, ops )
+        }
+    }
+}
+
+func TestParseContentEmpty( t *testing.T ) {
+    for _, data := range []string{ "", " \n\t", "% only a comment\n" } {
+        ops, err := ParseContent( []byte(data) )
+        if err != nil || len(ops) != 0 {
+            t.Errorf( "%q: got %v %v, expected no operation", data, ops, err )
+        }
+    }
+}
+
+func TestParseContentErrors( t *testing.T ) {
+    for _, data := range []string{
+        "0 0 612 792",              // operands without operator
+        "/Im0 5 0 R Do",            // indirect reference
+        "q ID Q",                   // ID outside inline image
+        "q EI Q",
+        "1 BI /W 1 /H 1 ID x EI",   // operands before BI
+        "BI /W 1 /H 1 ID xxx",      // no EI
+        "BI 1 2 ID x EI",           // key is not a name
+        "[ 1 2 TJ",                 // unterminated array
+    } {
+        if ops, err := ParseContent( []byte(data) ); err == nil {
+            t.Errorf( "%q: got %v, expected an error", data, ops )
+        }
+    }
+}
+
+// return the inline image in the single BI operation of ops
+func checkInlineImage( t *testing.T, ops []Operation, index int ) Stream {
+    t.Helper( )
+    if len(ops) <= index || ops[index].Operator != "BI" || len(ops[index].Operands) != 1 {
+        t.Fatalf( "Operations: got %v, expected BI at %d with a single operand", ops, index )
+    }
+    image, ok := ops[index].Operands[0].(Stream)
+    if ! ok {
+        t.Fatalf( "BI operand: got %T, expected a Stream", ops[index].Operands[0] )
+    }
+    return image
+}
+
+func TestParseInlineImage( t *testing.T ) {
+    binary := []byte{ 'E', 'I', ' ', 0x00, 0xff, '\n' }    // EI within the data
+    tests := []struct {
+        name    string
+        dic     string
+        data    []byte
+    }{
+        // 3 x 2 RGB, 8 bits: length computed from the dictionary
+        { "computed", "/W 3 /H 2 /CS /RGB /BPC 8", append( bytes.Repeat( []byte{ 0x20 }, 12 ), binary... ) },
+        // 9 x 2 mask, 1 bit: rows are byte aligned
+        { "mask", "/Width 9 /Height 2 /ImageMask true", []byte{ 'E', 'I', 'E', 'I' } },
+        // filtered, with explicit length
+        { "length", "/W 4 /H 4 /CS /G /BPC 8 /F /AHx /L 6", binary },
+        // filtered, ending before the first EI preceded and followed by a white space
+        { "scan", "/W 4 /H 4 /CS /G /BPC 8 /F [ /AHx ]", []byte("0a1bEI 2c>") },
+    }
+    for _, test := range tests {
+        content := []byte( "q BI " + test.dic + " ID " )
+        content = append( content, test.data... )
+        content = append( content, []byte("\nEI Q")... )
+        ops, err := ParseContent( content )
+        if err != nil {
+            t.Errorf( "%s: %v", test.name, err )
+            continue
+        }
+        checkOperations( t, ops, []string{ "q", "BI", "Q" }, nil )
+        image := checkInlineImage( t, ops, 1 )
+        if ! bytes.Equal( image.data, test.data ) {
+            t.Errorf( "%s: got data %q, expected %q", test.name, image.data, test.data )
+        }
+    }
+
+    ops, err := ParseContent( []byte("BI /W 2 /H 1 /CS /Cs1 /BPC 8 ID \x01\x02 EI") )
+    if err != nil {
+        t.Fatal( err )
+    }
+    image := checkInlineImage( t, ops, 0 )
+    if cs, _ := image.extent.Get( "CS" ); cs != Name("Cs1") {   // abbreviations are kept
+        t.Errorf( "Inline image /CS: got %v, expected /Cs1", cs )
+    }
+    if ! bytes.Equal( image.data, []byte{ 1, 2 } ) {
+        t.Errorf( "Inline image data: got %q, expected 01 02", image.data )
+    }
+}
+
+func TestContentParserNext( t *testing.T ) {
+    cp := NewContentParser( []byte("BT /F1 9 Tf ET") )
+    for _, expected := range []string{ "BT", "Tf", "ET" } {
+        op, err := cp.Next( )
+        if err != nil || op.Operator != expected {
+            t.Fatalf( "Next: got %v %v, expected %s", op, err, expected )
+        }
+    }
+    for i := 0; i < 2; i++ {
+        if op, err := cp.Next( ); err == nil || op != nil {
+            t.Errorf( "Next at the end: got %v %v, expected io.EOF", op, err )
+        }
+    }
+}
+
+func TestPageContent( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    p, err := pf.Page( 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    second := pf.NewObject( NewStream( NewDictionary( ), []byte("1 g") ) )
+    contents := NewArray( p.Dict( ).data["Contents"], NewReference( second.ID( ), second.Gen( ) ) )
+    dict := p.Dict( ).clone( )
+    dict.Set( "Contents", contents )
+    p.obj.SetValue( dict )
+
+    ops, err := p.Content( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkOperations( t, ops, []string{ "re", "f", "g" }, [][]interface{}{
+        { Number(0), Number(0), Number(10), Number(10) }, { }, { Number(1) } } )
+
+    dict = dict.clone( )
+    dict.Delete( "Contents" )
+    p.obj.SetValue( dict )
+    if ops, err = p.Content( ); err != nil || len(ops) != 0 {
+        t.Errorf( "Page without Contents: got %v %v, expected no operation", ops, err )
+    }
+}
@@ -0,0 +1,160 @@
+
+package pdf
+
+import (
+    "fmt"
+    "strings"
+)
+
+/*
+Diagnostics are collected while parsing and checking a document, instead of
+being printed. Each diagnostic has a severity, the file offset and object it
+refers to when known, a stable code that can be used to count or filter them,
+a readable message and whether the problem was fixed (only in fix mode).
+
+Informational diagnostics (progress of parsing or checking) are collected
+only in verbose mode. Fatal errors are returned as errors, as before, and are
+not in the diagnostic list, except when checking streams, since checking
+continues with the next stream. When parsing fails, the error is a ParseError
+giving the diagnostics collected until then.
+*/
+
+// Severity of a diagnostic
+type Severity int
+
+const (
+    SEVERITY_INFO Severity = iota       // information about parsing or checking
+    SEVERITY_WARNING                    // invalid or unusual construct, fixed or ignored
+    SEVERITY_ERROR                      // invalid construct that could not be fixed
+)
+
+var severityNames = [...]string{ "info", "warning", "error" }
+
+func (s Severity) String( ) string {
+    if s < SEVERITY_INFO || s > SEVERITY_ERROR {
+        return fmt.Sprintf( "severity(%d)", int(s) )
+    }
+    return severityNames[s]
+}
+
+// MarshalText allows encoding a severity by name, e.g. in JSON
+func (s Severity) MarshalText( ) ( []byte, error ) {
+    return []byte( s.String() ), nil
+}
+
+// UnmarshalText allows decoding a severity by name, e.g. from JSON
+func (s *Severity) UnmarshalText( text []byte ) error {
+    for i, n := range severityNames {
+        if n == string(text) {
+            *s = Severity(i)
+            return nil
+        }
+    }
+    return fmt.Errorf( "Unknown severity %s\n", text )
+}
+
+// Diagnostic codes
+const (
+    DIAG_PROGRESS           = "progress"            // parsing or checking step
+    DIAG_HEADER             = "header"              // file header
+    DIAG_STARTXREF          = "startxref"           // startxref value at the end of file
+    DIAG_XREF               = "xref"                // XREF table or stream entries
+    DIAG_STREAM_LENGTH      = "stream-length"       // stream Length does not match data
+    DIAG_OBJECT_STREAM      = "object-stream"       // object stream content
+    DIAG_REBUILD            = "rebuild"             // XREF reconstruction
+    DIAG_DAMAGED_OBJECT     = "damaged-object"      // object definition that cannot be parsed
+    DIAG_TRAILER            = "trailer"             // trailer dictionary
+    DIAG_STREAM_DATA        = "stream-data"         // stream data does not match its filters
+    DIAG_UNSUPPORTED_FILTER = "unsupported-filter"  // stream data that cannot be checked
+)
+
+// Diagnostic describes a problem or an information found in a document
+type Diagnostic struct {
+    Severity    Severity    `json:"severity"`
+    Offset      int64       `json:"offset"`     // file offset, -1 if unknown
+    Object      int64       `json:"object"`     // object ID, 0 if not in an object
+    Code        string      `json:"code"`       // one of the DIAG_ codes
+    Message     string      `json:"message"`
+    Fixed       bool        `json:"fixed"`      // true if the problem was fixed
+}
+
+func (d Diagnostic) String( ) string {
+    var sb strings.Builder
+    sb.WriteString( d.Severity.String() )
+    if d.Offset >= 0 {
+        fmt.Fprintf( &sb, " at offset 0x%x", d.Offset )
+    }
+    if d.Object != 0 {
+        fmt.Fprintf( &sb, " in object %d", d.Object )
+    }
+    fmt.Fprintf( &sb, " [%s]: %s", d.Code, d.Message )
+    if d.Fixed {
+        sb.WriteString( " (fixed)" )
+    }
+    return sb.String()
+}
+
+// ParseError is returned when a document cannot be parsed, with the
+// diagnostics collected before parsing stopped
+type ParseError struct {
+    Err         error
+    Diagnostics []Diagnostic
+}
+
+func (e *ParseError) Error( ) string {
+    return e.Err.Error()
+}
+
+// Unwrap returns the parsing error, e.g. ErrIncorrectPassword
+func (e *ParseError) Unwrap( ) error {
+    return e.Err
+}
+
+// diagnostics collects diagnostics. All methods accept a nil receiver, in
+// which case nothing is collected.
+type diagnostics struct {
+    list        []Diagnostic
+    verbose     bool        // collect informational diagnostics too
+    object      int64       // current object ID, 0 if none
+    start       int64       // current object offset, -1 if unknown
+}
+
+func newDiagnostics( verbose bool ) *diagnostics {
+    return &diagnostics{ verbose: verbose, start: -1 }
+}
+
+// set the current object, for the following diagnostics
+func (d *diagnostics) setObject( id, start int64 ) {
+    if d != nil {
+        d.object = id
+        d.start = start
+    }
+}
+
+// add a diagnostic at the given file offset
+func (d *diagnostics) addAt( offset int64, severity Severity, code string, fixed bool,
+                             format string, a ...interface{} ) {
+    if d == nil || (severity == SEVERITY_INFO && ! d.verbose) {
+        return
+    }
+    msg := strings.TrimSpace( fmt.Sprintf( format, a... ) )
+    d.list = append( d.list, Diagnostic{ severity, offset, d.object, code, msg, fixed } )
+}
+
+// add a diagnostic at the current object offset
+func (d *diagnostics) add( severity Severity, code string, fixed bool, format string, a ...interface{} ) {
+    if d != nil {
+        d.addAt( d.start, severity, code, fixed, format, a... )
+    }
+}
+
+// CountDiagnostics returns the number of diagnostics with the given severity
+func CountDiagnostics( list []Diagnostic, severity Severity ) int {
+    n := 0
+    for _, d := range list {
+        if d.Severity == severity {
+            n++
+        }
+    }
+    return n
+}
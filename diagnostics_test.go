@@ -0,0 +1,169 @@
+package pdf
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "testing"
+)
+
+func TestSeverityText( t *testing.T ) {
+    for s, name := range map[Severity]string{ SEVERITY_INFO: "info", SEVERITY_WARNING: "warning",
+                                              SEVERITY_ERROR: "error", Severity(7): "severity(7)" } {
+        text, err := s.MarshalText( )
+        if err != nil || string(text) != name || s.String( ) != name {
+            t.Errorf( "Severity %d: got %q %v, expected %q", int(s), text, err, name )
+        }
+        var u Severity
+        err = u.UnmarshalText( text )
+        if s > SEVERITY_ERROR {
+            if err == nil {
+                t.Errorf( "Severity %q: no error", text )
+            }
+        } else if err != nil || u != s {
+            t.Errorf( "Severity %q: got %v %v, expected %v", text, u, err, s )
+        }
+    }
+}
+
+func TestDiagnosticJSON( t *testing.T ) {
+    d := Diagnostic{ SEVERITY_WARNING, 16, 4, DIAG_XREF, "Entry ignored", true }
+    data, err := json.Marshal( d )
+    if err != nil {
+        t.Fatal( err )
+    }
+    expected := `{"severity":"warning","offset":16,"object":4,"code":"xref","message":"Entry ignored","fixed":true}`
+    if string(data) != expected {
+        t.Errorf( "Got %s, expected %s", data, expected )
+    }
+    var u Diagnostic
+    if err = json.Unmarshal( data, &u ); err != nil || u != d {
+        t.Errorf( "Unmarshal: got %+v %v, expected %+v", u, err, d )
+    }
+    if err = json.Unmarshal( bytes.Replace( data, []byte("warning"), []byte("fatal"), 1 ), &u ); err == nil {
+        t.Errorf( "Unknown severity: no error" )
+    }
+
+    if s := d.String( ); s != "warning at offset 0x10 in object 4 [xref]: Entry ignored (fixed)" {
+        t.Errorf( "String: got %q", s )
+    }
+    d = Diagnostic{ SEVERITY_INFO, -1, 0, DIAG_PROGRESS, "Body starts", false }
+    if s := d.String( ); s != "info [progress]: Body starts" {
+        t.Errorf( "String: got %q", s )
+    }
+}
+
+func TestCountDiagnostics( t *testing.T ) {
+    for _, verbose := range []bool{ false, true } {
+        dc := newDiagnostics( verbose )
+        dc.setObject( 3, 100 )
+        dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Step\n" )
+        dc.add( SEVERITY_WARNING, DIAG_XREF, true, "Warning %d\n", 1 )
+        dc.addAt( 200, SEVERITY_WARNING, DIAG_XREF, false, "Warning %d\n", 2 )
+        dc.add( SEVERITY_ERROR, DIAG_STREAM_DATA, false, "Error\n" )
+
+        // informational diagnostics are collected only in verbose mode
+        infos := 0
+        if verbose {
+            infos = 1
+        }
+        for s, n := range map[Severity]int{ SEVERITY_INFO: infos, SEVERITY_WARNING: 2, SEVERITY_ERROR: 1 } {
+            if c := CountDiagnostics( dc.list, s ); c != n {
+                t.Errorf( "Verbose %t: got %d %v diagnostics, expected %d", verbose, c, s, n )
+            }
+        }
+        if len(dc.list) != 3 + infos {
+            t.Errorf( "Verbose %t: got %d diagnostics, expected %d", verbose, len(dc.list), 3 + infos )
+        }
+        w := dc.list[infos]
+        if w.Offset != 100 || w.Object != 3 || w.Message != "Warning 1" || ! w.Fixed {
+            t.Errorf( "Verbose %t: got %+v", verbose, w )
+        }
+        if w = dc.list[infos+1]; w.Offset != 200 || w.Object != 3 {
+            t.Errorf( "Verbose %t: got %+v", verbose, w )
+        }
+    }
+    if n := CountDiagnostics( nil, SEVERITY_ERROR ); n != 0 {
+        t.Errorf( "Empty list: got %d", n )
+    }
+    var dc *diagnostics                 // nothing is collected
+    dc.setObject( 1, 0 )
+    dc.add( SEVERITY_ERROR, DIAG_STREAM_DATA, false, "Error\n" )
+}
+
+// return the test document without its XREF table and trailer
+func makeNoTrailerFile( ) []byte {
+    data := makeTestFile( testSinglePageObjects, "/Root 1 0 R" )
+    return data[:bytes.Index( data, []byte("xref") )]
+}
+
+func TestDiagnosticCodes( t *testing.T ) {
+    single := makeTestFile( testSinglePageObjects, "/Root 1 0 R" )
+    unused := makeTestFile( append( testSinglePageObjects[:3:3], "(Unused)" ), "/Root 1 0 R" )
+    entry := []byte( fmt.Sprintf( "%010d 00000 n", bytes.Index( unused, []byte("4 0 obj") ) ) )
+    unused = bytes.Replace( unused, entry, []byte("0000000000 00001 f"), 1 )
+
+    tests := []struct {
+        code        string
+        data        []byte
+        args        *ParseArgs
+        severity    Severity
+        fixed       bool
+    }{
+        { DIAG_PROGRESS, single, &ParseArgs{ Verbose: true }, SEVERITY_INFO, false },
+        { DIAG_HEADER, single, nil, SEVERITY_WARNING, false },
+        { DIAG_STARTXREF, setStartxref( single, int64(len(single)) + 100 ), &ParseArgs{ Fix: true },
+          SEVERITY_WARNING, true },
+        { DIAG_XREF, unused, nil, SEVERITY_WARNING, true },
+        { DIAG_STREAM_LENGTH, makeIndirectLengthFile( "9 0 R", "0 0 m" ), &ParseArgs{ Fix: true },
+          SEVERITY_WARNING, true },
+        { DIAG_OBJECT_STREAM, makeObjectStreamFile( makeTestObjectStream( "2 0 9 43 ", "/N 2 /First 9" ),
+                                                    testObjStmEntries ), &ParseArgs{ Fix: true },
+          SEVERITY_WARNING, true },
+        { DIAG_REBUILD, setStartxref( single, 20 ), &ParseArgs{ Fix: true }, SEVERITY_WARNING, true },
+        { DIAG_DAMAGED_OBJECT, makeIndirectLengthFile( "9 0 R", testStreamData ), &ParseArgs{ Fix: true },
+          SEVERITY_WARNING, true },
+        { DIAG_TRAILER, makeNoTrailerFile( ), &ParseArgs{ Fix: true }, SEVERITY_WARNING, true },
+    }
+    for _, test := range tests {
+        pf, err := ParseBytes( test.data, test.args )
+        if err != nil {
+            t.Errorf( "%s: %v", test.code, err )
+            continue
+        }
+        found := false
+        for _, d := range pf.Diagnostics {
+            if d.Code == test.code && d.Severity == test.severity && d.Fixed == test.fixed {
+                found = true
+                break
+            }
+        }
+        if ! found {
+            t.Errorf( "%s: no %v diagnostic (fixed %t) in %v", test.code, test.severity, test.fixed, pf.Diagnostics )
+        }
+    }
+
+    // stream checks
+    streams := []struct {
+        code        string
+        stream      Stream
+        fix         bool
+        severity    Severity
+        fixed       bool
+    }{
+        { DIAG_STREAM_DATA, newTestStream( "ASCIIHexDecode", "", []byte( "4x>" ) ), false, SEVERITY_ERROR, false },
+        { DIAG_STREAM_DATA, newTestStream( "FlateDecode", "", flateEncode( testFlateData( 4000 ) )[:1000] ),
+          true, SEVERITY_WARNING, true },
+        { DIAG_UNSUPPORTED_FILTER, newTestStream( "JBIG2Decode", "", []byte( "data" ) ), false,
+          SEVERITY_WARNING, false },
+    }
+    for _, test := range streams {
+        pf := newTestDocument( t, 0 )
+        obj := pf.NewObject( test.stream )
+        diags, _ := pf.Check( &StreamArgs{ Fix: test.fix } )
+        if len(diags) != 1 || diags[0].Code != test.code || diags[0].Severity != test.severity ||
+           diags[0].Fixed != test.fixed || diags[0].Object != obj.ID( ) {
+            t.Errorf( "%s: got %v, expected one %v in object %d", test.code, diags, test.severity, obj.ID( ) )
+        }
+    }
+}
@@ -3,50 +3,316 @@ package pdf
 
 import (
     "fmt"
-
+    "time"
 )
 
 const (
     DEFAULT_PAGE_NUMBER = 2
+    DEFAULT_DOCUMENT_VERSION = 7
 )
 
 
-func newDocument( version int ) *PdfFile {
-    pf := new(PdfFile)
+// DocumentArgs gives the optional parameters for NewDocument
+type DocumentArgs struct {
+    Version     int         // PDF version 1.Version, from 0 to 7 (7 by default)
+
+    // Info dictionary entries, only if not empty. An Info dictionary is
+    // created with a CreationDate if at least one entry is given (see Deterministic).
+    Title, Author, Subject, Keywords, Creator, Producer    string
+    CreationDate    time.Time   // time.Now() by default
+
+    Deterministic   bool        // no CreationDate unless given, for reproducible output
+}
+
+// format a date as a PDF date string D:YYYYMMDDHHmmSSOHH'mm'
+func formatDate( t time.Time ) String {
+    s := t.Format( "D:20060102150405" )
+    _, offset := t.Zone()
+    if offset == 0 {
+        return String( s + "Z" )
+    }
+    sign := byte('+')
+    if offset < 0 {
+        sign = '-'
+        offset = -offset
+    }
+    return String( fmt.Sprintf( "%s%c%02d'%02d'", s, sign, offset / 3600, (offset % 3600) / 60 ) )
+}
 
+// NewDocument returns a new document without any page, made of a catalog, an
+// empty page tree and an optional Info dictionary. Pages can then be added
+// with AppendPage or InsertPage. If args is nil, default values are used. The
+// file identifiers are generated when the document is first written.
+func NewDocument( args *DocumentArgs ) ( *PdfFile, error ) {
+    if args == nil {
+        args = &DocumentArgs{ Version: DEFAULT_DOCUMENT_VERSION }
+    }
+    version := args.Version
+    if version == 0 {
+        version = DEFAULT_DOCUMENT_VERSION
+    }
+    if version < 0 || version > 7 {
+        return nil, fmt.Errorf( "Invalid PDF version 1.%d\n", version )
+    }
+    pf := new(PdfFile)
     pf.Version = fmt.Sprintf( "1.%d", version )
-    pf.Header = fmt.Sprintf( "%%PDF-1.%d\n%%%s", version, string([]byte{0xf6, 0xe4, 0xfc, 0xdf}) )
+    pf.Header = fmt.Sprintf( "%%PDF-1.%d", version )
 
     pf.Objects = make( []*PdfObject, 0, 16 )
     pf.ObjById = make( map[int64]*PdfObject, 16 )
+    pf.Trailer = NewDictionary( )
 
-    var pA PdfArray
-    pA.data = make( []interface{}, 0, DEFAULT_PAGE_NUMBER )
+    rDict := NewDictionary( )
+    rDict.Set( "Type", Name("Catalog") )
+    rDict.Set( "Pages", Reference{ id: 2, gen: 0 } )
+    pf.newIndirectObject( 1, 0, rDict )
+    pf.Catalog = Reference{ id: 1, gen: 0 }
 
-    pDict := make( map[string]interface{}, DEFAULT_DICTIONARY_SIZE )
-    pDict["Type"] = pdfName("/Pages")
-    pDict["Count"] = pdfNumber(0)
-    pDict["Kids"] = pA
-    pf.newIndirectObject( 1, 0, pDict )
+    pDict := NewDictionary( )
+    pDict.Set( "Type", Name("Pages") )
+    pDict.Set( "Kids", NewArray( ) )
+    pDict.Set( "Count", Number(0) )
+    pf.newIndirectObject( 2, 0, pDict )
 
-    rDict := make( map[string]interface{}, DEFAULT_DICTIONARY_SIZE )
-    rDict["Type"] = pdfName("/Catalog")
-    rDict["Pages"] = pdfReference{ id: 1, gen: 0 }
-    pf.newIndirectObject( 2, 0, rDict )
+    pf.Size = 3     // including the head of free object list
+    pf.Trailer.Set( "Size", Number(pf.Size) )
+    pf.Trailer.Set( "Root", pf.Catalog )
 
-    pf.Trailer.keys = make( []string, 0, DEFAULT_DICTIONARY_SIZE )
-    pf.Trailer.keys = append( pf.Trailer.keys, "Pages", "Catalog" )
+    iDict := NewDictionary( )
+    for _, e := range []struct{ key, value string }{
+                { "Title", args.Title }, { "Author", args.Author },
+                { "Subject", args.Subject }, { "Keywords", args.Keywords },
+                { "Creator", args.Creator }, { "Producer", args.Producer } } {
+        if e.value != "" {
+            iDict.Set( e.key, String(e.value) )
+        }
+    }
+    if iDict.Len() > 0 {
+        date := args.CreationDate
+        if date.IsZero() && ! args.Deterministic {
+            date = time.Now()
+        }
+        if ! date.IsZero() {
+            iDict.Set( "CreationDate", formatDate( date ) )
+        }
+        pf.Info = Reference{ id: pf.newObjectId( ), gen: 0 }
+        pf.newIndirectObject( pf.Info.id, 0, iDict )
+        pf.Trailer.Set( "Info", pf.Info )
+    }
+    return pf, nil
+}
 
-    pf.Trailer.data = make( map[string]interface{}, DEFAULT_DICTIONARY_SIZE )
-    pf.Trailer.data["Root"] = pdfReference{ id: 2, gen: 0 }
+// return a new object id, following the highest id in use
+func (pf *PdfFile) newObjectId( ) int64 {
+    id := pf.Size
+    if id < 1 {
+        id = 1
+    }
+    for ; ; id++ {      // in case Size was wrong in the file
+        if _, ok := pf.ObjById[id]; ! ok {
+            break
+        }
+    }
+    pf.Size = id + 1
+    pf.Trailer.Set( "Size", Number(pf.Size) )
+    return id
+}
 
-    pf.Size = 3     // including the head of free object list
-    pf.Trailer.data["Size"] = pf.Size
+// remove an indirect object from the document. Only the objects that are in
+// the file are freed by the next incremental update.
+func (pf *PdfFile) deleteObject( obj *PdfObject ) {
+    if ! obj.created {
+        pf.deleted = append( pf.deleted, Reference{ obj.id, obj.gen } )
+    }
+    delete( pf.ObjById, obj.id )
+    for i, o := range pf.Objects {
+        if o == obj {
+            pf.Objects = append( pf.Objects[:i], pf.Objects[i+1:]... )
+            break
+        }
+    }
+}
+
+// return the index of the page object in its parent Kids array
+func getKidIndex( parent, page *PdfObject ) ( Dictionary, Array, int ) {
+    dic := parent.value.(Dictionary)
+    kids := dic.data["Kids"].(Array)
+    for i, kid := range kids.data {
+        if ref, ok := kid.(Reference); ok && ref.id == page.id {
+            return dic, kids, i
+        }
+    }
+    return dic, kids, -1
+}
+
+// add delta to the Count of all Pages nodes in ancestors. Indirect counts are
+// replaced by direct numbers. Nothing is modified if a Count is not a number.
+func (pf *PdfFile) updatePageCounts( ancestors []*PdfObject, delta int ) error {
+    counts := make( []float64, len(ancestors) )
+    for i, node := range ancestors {
+        count, ok := pf.numberValue( node.value.(Dictionary).data["Count"] )
+        if ! ok {
+            return fmt.Errorf( "Pages node %d %d Count is not a number\n", node.id, node.gen )
+        }
+        counts[i] = count
+    }
+    for i, node := range ancestors {
+        dic := node.value.(Dictionary)
+        dic.Set( "Count", Number(counts[i] + float64(delta)) )
+        node.SetValue( dic )
+    }
+    return nil
+}
+
+// link the page object in the page tree, so that it becomes the page at index
+// at. If at is the number of pages, the page is added after the last page.
+func (pf *PdfFile)addPage( page *PdfObject, at int ) error {
+    pages, err := pf.Pages( )
+    if err != nil {
+        return err
+    }
+    if at < 0 || at > len(pages) {
+        return fmt.Errorf( "Page index %d out of range [0 %d]\n", at, len(pages) )
+    }
+
+    var ancestors []*PdfObject
+    var index int
+    if len(pages) == 0 {    // new page in the root node
+        root, err := pf.getPageTreeRoot( )
+        if err != nil {
+            return err
+        }
+        ancestors = []*PdfObject{ root }
+        index = len(root.value.(Dictionary).data["Kids"].(Array).data)
+    } else {                // new page before the current page or after the last one
+        next := at
+        if at == len(pages) {
+            next = at - 1
+        }
+        ancestors = pages[next].ancestors
+        _, _, index = getKidIndex( ancestors[len(ancestors)-1], pages[next].obj )
+        if at == len(pages) {
+            index ++
+        }
+    }
+
+    if err = pf.updatePageCounts( ancestors, 1 ); err != nil {
+        return err
+    }
+    parent := ancestors[len(ancestors)-1]
+    dic := parent.value.(Dictionary)
+    kids := dic.data["Kids"].(Array)
+    kids.data = append( kids.data, nil )
+    copy( kids.data[index+1:], kids.data[index:] )
+    kids.data[index] = Reference{ page.id, page.gen }
+    dic.Set( "Kids", kids )
+    parent.SetValue( dic )
+
+    pageDic := page.value.(Dictionary)
+    pageDic.Set( "Parent", Reference{ parent.id, parent.gen } )
+    page.SetValue( pageDic )
+    return nil
+}
 
-    return pf
+// unlink the page from the page tree, without deleting the page object
+func (pf *PdfFile) removePage( p *Page ) error {
+    if err := pf.updatePageCounts( p.ancestors, -1 ); err != nil {
+        return err
+    }
+    parent := p.ancestors[len(p.ancestors)-1]
+    dic, kids, index := getKidIndex( parent, p.obj )
+    kids.data = append( kids.data[:index], kids.data[index+1:]... )
+    dic.Set( "Kids", kids )
+    parent.SetValue( dic )
+    return nil
+}
+
+// copy in the page dictionary the inheritable attributes that are defined in
+// ancestor nodes only, so that they are preserved when the page is moved.
+func (p *Page) setInheritedAttributes( ) {
+    dic := p.Dict()
+    for _, key := range inheritableKeys {
+        if _, ok := dic.data[key]; ok {
+            continue
+        }
+        for i := len(p.ancestors) - 1; i >= 0; i-- {
+            if v, ok := p.ancestors[i].value.(Dictionary).data[key]; ok {
+                dic.Set( key, v )
+                break
+            }
+        }
+    }
+    p.obj.SetValue( dic )
+}
+
+// InsertPage creates a new page object from the page dictionary dict and
+// inserts it in the page tree, so that it becomes the page at index at,
+// starting from 0. If at is the number of pages, the page is appended after
+// the last page. The dictionary Type and Parent entries are set as needed.
+// Attributes not given in dict are inherited from the parent Pages nodes.
+func (pf *PdfFile) InsertPage( at int, dict Dictionary ) ( *Page, error ) {
+    n, err := pf.NumPages( )
+    if err != nil {
+        return nil, err
+    }
+    if at < 0 || at > n {
+        return nil, fmt.Errorf( "Page index %d out of range [0 %d]\n", at, n )
+    }
+    dict = dict.clone( )    // in case dict is used for several pages
+    dict.Set( "Type", Name("Page") )
+    page := pf.newIndirectObject( pf.newObjectId( ), 0, dict )
+    if err = pf.addPage( page, at ); err != nil {
+        pf.deleteObject( page )
+        return nil, err
+    }
+    return pf.Page( at )
+}
+
+// AppendPage creates a new page object from the page dictionary dict and adds
+// it after the last page. See InsertPage.
+func (pf *PdfFile) AppendPage( dict Dictionary ) ( *Page, error ) {
+    n, err := pf.NumPages( )
+    if err != nil {
+        return nil, err
+    }
+    return pf.InsertPage( n, dict )
 }
 
-func (pf *PdfFile)addPage( ) error {
+// DeletePage removes the page at index at from the page tree and deletes the
+// page object. Objects referred to by the page (e.g. contents) are kept, since
+// they might be shared with other pages.
+func (pf *PdfFile) DeletePage( at int ) error {
+    p, err := pf.Page( at )
+    if err != nil {
+        return err
+    }
+    if err = pf.removePage( p ); err != nil {
+        return err
+    }
+    pf.deleteObject( p.obj )
     return nil
 }
 
+// MovePage moves the page at index from, so that it becomes the page at
+// index to. The page keeps the attributes it was inheriting before the move.
+func (pf *PdfFile) MovePage( from, to int ) error {
+    p, err := pf.Page( from )
+    if err != nil {
+        return err
+    }
+    n, err := pf.NumPages( )
+    if err != nil {
+        return err
+    }
+    if to < 0 || to >= n {
+        return fmt.Errorf( "Page index %d out of range [0 %d]\n", to, n - 1 )
+    }
+    if from == to {
+        return nil
+    }
+    p.setInheritedAttributes( )
+    if err = pf.removePage( p ); err != nil {
+        return err
+    }
+    return pf.addPage( p.obj, to )
+}
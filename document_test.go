@@ -0,0 +1,177 @@
+package pdf
+
+import (
+    "testing"
+)
+
+// check that the Count of each Pages node is the number of pages below it,
+// and return the page object IDs in order
+func checkPageCounts( t *testing.T, pf *PdfFile ) []int64 {
+    t.Helper( )
+    var ids []int64
+    var count func( node *PdfObject ) int
+    count = func( node *PdfObject ) int {
+        dic := node.Value( ).(Dictionary)
+        if getPageNodeType( dic ) == "Page" {
+            ids = append( ids, node.ID( ) )
+            return 1
+        }
+        n := 0
+        for _, kid := range dic.data["Kids"].(Array).data {
+            n += count( pf.ObjById[kid.(Reference).id] )
+        }
+        if c, ok := dic.data["Count"].(Number); ! ok || int(c) != n {
+            t.Errorf( "Pages node %d: got Count %v, expected %d", node.ID( ), dic.data["Count"], n )
+        }
+        return n
+    }
+    root, err := pf.getPageTreeRoot( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    count( root )
+    return ids
+}
+
+func checkPageIds( t *testing.T, what string, ids, expected []int64 ) {
+    t.Helper( )
+    if len(ids) != len(expected) {
+        t.Errorf( "%s: got pages %v, expected %v", what, ids, expected )
+        return
+    }
+    for i, id := range ids {
+        if id != expected[i] {
+            t.Errorf( "%s: got pages %v, expected %v", what, ids, expected )
+            return
+        }
+    }
+}
+
+// return a new page dictionary
+func newTestPage( ) Dictionary {
+    dict := NewDictionary( )
+    dict.Set( "MediaBox", NewArray( Number(0), Number(0), Number(100), Number(100) ) )
+    return dict
+}
+
+func TestInsertPage( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    first, err := pf.InsertPage( 0, newTestPage( ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if parent := first.Dict( ).data["Parent"]; parent != NewReference( 3, 0 ) {
+        t.Errorf( "First page Parent: got %v, expected 3 0 R", parent )
+    }
+    if r, err := first.Rotate( ); err != nil || r != 270 {   // inherited from its new parent
+        t.Errorf( "First page Rotate: got %d %v, expected 270", r, err )
+    }
+    middle, err := pf.InsertPage( 3, newTestPage( ) )     // before page 6, in the root node
+    if err != nil {
+        t.Fatal( err )
+    }
+    last, err := pf.AppendPage( newTestPage( ) )          // below node 7, with an indirect Count
+    if err != nil {
+        t.Fatal( err )
+    }
+    if parent := last.Dict( ).data["Parent"]; parent != NewReference( 7, 0 ) {
+        t.Errorf( "Last page Parent: got %v, expected 7 0 R", parent )
+    }
+    ids := checkPageCounts( t, pf )
+    checkPageIds( t, "InsertPage", ids, []int64{ first.obj.id, 4, 5, middle.obj.id, 6, 8, last.obj.id } )
+    if v := pf.ObjById[9].Value( ); v != Number(1) {
+        t.Errorf( "Indirect Count object: got %v, expected 1 unchanged", v )
+    }
+
+    for _, at := range []int{ -1, 8 } {
+        if _, err = pf.InsertPage( at, newTestPage( ) ); err == nil {
+            t.Errorf( "InsertPage( %d ): no error", at )
+        }
+    }
+    written, err := ParseBytes( writeTestDocument( t, pf, nil ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if n, err := written.NumPages( ); err != nil || n != 7 {
+        t.Errorf( "Written NumPages: got %d %v, expected 7", n, err )
+    }
+    checkPageCounts( t, written )
+}
+
+func TestDeletePage( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    for _, at := range []int{ 3, 1, 0 } {   // pages 8, 5 and 4
+        if err := pf.DeletePage( at ); err != nil {
+            t.Fatalf( "DeletePage( %d ): %v", at, err )
+        }
+    }
+    checkPageIds( t, "DeletePage", checkPageCounts( t, pf ), []int64{ 6 } )
+    for _, id := range []int64{ 4, 5, 8 } {
+        if _, ok := pf.ObjById[id]; ok {
+            t.Errorf( "Deleted page %d is defined", id )
+        }
+    }
+    if err := pf.DeletePage( 1 ); err == nil {
+        t.Errorf( "DeletePage( 1 ): no error" )
+    }
+}
+
+func TestMovePage( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    if err := pf.MovePage( 0, 3 ); err != nil {     // page 4, from node 3 to node 7
+        t.Fatal( err )
+    }
+    checkPageIds( t, "MovePage", checkPageCounts( t, pf ), []int64{ 5, 6, 8, 4 } )
+    p, err := pf.Page( 3 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if parent := p.Dict( ).data["Parent"]; parent != NewReference( 7, 0 ) {
+        t.Errorf( "Moved page Parent: got %v, expected 7 0 R", parent )
+    }
+    // attributes inherited before the move are kept
+    if r, err := p.Rotate( ); err != nil || r != 270 {
+        t.Errorf( "Moved page Rotate: got %d %v, expected 270", r, err )
+    }
+    if r, err := p.CropBox( ); err != nil || r != (Rectangle{ 0, 100, 500, 792 }) {
+        t.Errorf( "Moved page CropBox: got %v %v", r, err )
+    }
+    if _, ok := p.Dict( ).data["Resources"]; ! ok {
+        t.Errorf( "Moved page has no Resources" )
+    }
+
+    if err = pf.MovePage( 3, 1 ); err != nil {      // back into node 3
+        t.Fatal( err )
+    }
+    checkPageIds( t, "MovePage", checkPageCounts( t, pf ), []int64{ 5, 4, 6, 8 } )
+    if err = pf.MovePage( 2, 2 ); err != nil {
+        t.Errorf( "MovePage to the same index: %v", err )
+    }
+    for _, m := range [][2]int{ { 4, 0 }, { 0, 4 }, { 0, -1 } } {
+        if err = pf.MovePage( m[0], m[1] ); err == nil {
+            t.Errorf( "MovePage( %d, %d ): no error", m[0], m[1] )
+        }
+    }
+}
+
+func TestPageCountNotNumber( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    pf.ObjById[9].SetValue( Name("None") )      // indirect Count of node 7
+    if _, err := pf.AppendPage( newTestPage( ) ); err == nil {
+        t.Errorf( "AppendPage below an invalid Count: no error" )
+    }
+    if err := pf.DeletePage( 3 ); err == nil {
+        t.Errorf( "DeletePage below an invalid Count: no error" )
+    }
+    // the page tree is unchanged
+    root := pf.ObjById[2].Value( ).(Dictionary)
+    if root.data["Count"] != Number(4) {
+        t.Errorf( "Root Count: got %v, expected 4", root.data["Count"] )
+    }
+    if kids := pf.ObjById[7].Value( ).(Dictionary).data["Kids"].(Array); kids.Len() != 1 {
+        t.Errorf( "Node 7 Kids: got %v, expected 1 page", kids )
+    }
+    if n, err := pf.NumPages( ); err != nil || n != 4 {
+        t.Errorf( "NumPages: got %d %v, expected 4", n, err )
+    }
+}
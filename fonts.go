@@ -0,0 +1,386 @@
+
+package pdf
+
+import (
+    "strings"
+)
+
+/*
+Fonts used to show text: a string shown by a text operator is a sequence of
+character codes, of 1 byte for simple fonts (Type1, MMType1, TrueType and
+Type3) or of 1 to 4 bytes for composite fonts (Type0), as given by the code
+space of the font CMap (Identity-H and Identity-V use 2 bytes).
+
+The text of a code is given by the font ToUnicode CMap if any, otherwise by
+the encoding of a simple font or by a Unicode based CMap of a composite font. A code that cannot be mapped to Unicode is
+given as the replacement character U+FFFD.
+
+The width of a code, in thousandths of text space units for all fonts except
+Type 3 fonts (whose FontMatrix gives the glyph space), is given by the font
+Widths array for a simple font, or by the W array of the descendant CIDFont
+of a composite font. The standard 14 fonts may not have Widths, in which case
+their widths are approximated. Vertical fonts (e.g. Identity-V) move down by
+the same distance after each glyph.
+
+The glyph height is given by the font descriptor Ascent and Descent, or by
+the font bounding box, or else approximated.
+*/
+
+// font used to show text
+type textFont struct {
+    name        string              // BaseFont, without subset tag
+    composite   bool
+    cmap        *CMap               // composite font CMap, nil for 2 byte codes mapped to the same CIDs
+    toUnicode   *CMap               // nil if not given
+    encoding    [256]string         // simple font text by code
+    widths      map[uint32]float64  // width by code or CID, in glyph space
+    missing     float64             // width of other codes
+    scale       float64             // glyph space to text space
+    vertical    bool
+    advance     float64             // vertical advance in glyph space (negative)
+    ascent      float64             // glyph height above the baseline, in glyph space
+    descent     float64             // glyph depth below the baseline (negative)
+}
+
+// approximate widths of ASCII codes 32 to 126 in standard fonts
+var helveticaWidths = [95]float64{
+    278, 278, 355, 556, 556, 889, 667, 222, 333, 333, 389, 584, 278, 333, 278, 278,
+    556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+    1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+    667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+    222, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+    556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var timesWidths = [95]float64{
+    250, 333, 408, 500, 500, 833, 778, 333, 333, 333, 500, 564, 250, 333, 250, 278,
+    500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 278, 278, 564, 564, 564, 444,
+    921, 722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, 722, 722,
+    556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, 333, 278, 333, 469, 500,
+    333, 444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, 500, 500,
+    500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, 480, 200, 480, 541,
+}
+
+// font used when the font resource cannot be found
+func defaultTextFont( ) *textFont {
+    f := &textFont{ scale: 0.001, missing: 500, ascent: 800, descent: -200 }
+    f.setEncoding( &winAnsiEncoding )
+    return f
+}
+
+// return the number value of v, possibly an indirect reference
+func (pf *PdfFile) numberValue( v interface{} ) ( float64, bool ) {
+    v, err := pf.Resolve( v )
+    if err != nil {
+        return 0, false
+    }
+    n, ok := v.(Number)
+    return float64(n), ok
+}
+
+// return the dictionary value of v, possibly an indirect reference
+func (pf *PdfFile) dictionaryValue( v interface{} ) ( Dictionary, bool ) {
+    v, err := pf.Resolve( v )
+    if err != nil {
+        return Dictionary{}, false
+    }
+    d, ok := v.(Dictionary)
+    return d, ok
+}
+
+// return the array value of v, possibly an indirect reference
+func (pf *PdfFile) arrayValue( v interface{} ) ( Array, bool ) {
+    v, err := pf.Resolve( v )
+    if err != nil {
+        return Array{}, false
+    }
+    a, ok := v.(Array)
+    return a, ok
+}
+
+// load the font dictionary given by v. Font entries that are not valid are
+// ignored, since the text can still be shown.
+func (pf *PdfFile) loadFont( v interface{} ) ( *textFont, error ) {
+    v, err := pf.Resolve( v )
+    if err != nil {
+        return nil, err
+    }
+    dic, ok := v.(Dictionary)
+    if ! ok {
+        return defaultTextFont( ), nil
+    }
+    f := &textFont{ widths: make( map[uint32]float64 ), scale: 0.001 }
+    if base, err := pf.Resolve( dic.data["BaseFont"] ); err == nil {
+        if base, ok := base.(Name); ok {
+            f.name = string(base)
+            if len(f.name) > 7 && f.name[6] == '+' {    // subset tag
+                f.name = f.name[7:]
+            }
+        }
+    }
+    if v, ok := dic.data["ToUnicode"]; ok {
+        f.toUnicode, _ = pf.LoadCMap( v )     // possibly partial
+    }
+    if subtype, _ := pf.Resolve( dic.data["Subtype"] ); subtype == Name("Type0") {
+        pf.loadCompositeFont( f, dic )
+    } else {
+        pf.loadSimpleFont( f, dic, subtype == Name("Type3") )
+    }
+    return f, nil
+}
+
+// set the simple font encoding from a base encoding, or from the codes
+// themselves if base is nil
+func (f *textFont) setEncoding( base *[256]rune ) {
+    for c := 0; c < 256; c++ {
+        if base != nil {
+            if r := base[c]; r != 0 {
+                f.encoding[c] = string(r)
+            } else {
+                f.encoding[c] = ""
+            }
+        } else if c >= 0x20 && (c < 0x7f || c >= 0xa0) {
+            f.encoding[c] = string(rune(c))
+        }
+    }
+}
+
+func (pf *PdfFile) loadSimpleFont( f *textFont, dic Dictionary, type3 bool ) {
+    if type3 {
+        if m, ok := pf.arrayValue( dic.data["FontMatrix"] ); ok && len(m.data) == 6 {
+            if a, ok := pf.numberValue( m.data[0] ); ok && a != 0 {
+                f.scale = a
+            }
+        }
+    }
+    descriptor, _ := pf.dictionaryValue( dic.data["FontDescriptor"] )
+    f.missing, _ = pf.numberValue( descriptor.data["MissingWidth"] )
+    bbox := descriptor.data["FontBBox"]
+    if type3 {
+        bbox = dic.data["FontBBox"]
+    }
+    pf.setFontHeight( f, descriptor, bbox )
+
+    if widths, ok := pf.arrayValue( dic.data["Widths"] ); ok {
+        first, _ := pf.numberValue( dic.data["FirstChar"] )
+        for i, w := range widths.data {
+            if w, ok := pf.numberValue( w ); ok {
+                f.widths[uint32(int(first) + i)] = w
+            }
+        }
+    } else {
+        f.setStandardWidths( )
+    }
+
+    flags, _ := pf.numberValue( descriptor.data["Flags"] )
+    symbolic := int(flags) & 4 != 0 && int(flags) & 32 == 0
+    if f.name == "Symbol" || f.name == "ZapfDingbats" {
+        symbolic = true
+    }
+    var base *[256]rune
+    if ! symbolic {
+        base = &standardEncoding
+    }
+    encoding, _ := pf.Resolve( dic.data["Encoding"] )
+    switch e := encoding.(type) {
+    case Name:
+        if b := baseEncoding( e ); b != nil {
+            base = b
+        }
+        f.setEncoding( base )
+    case Dictionary:
+        if name, ok := e.data["BaseEncoding"].(Name); ok {
+            if b := baseEncoding( name ); b != nil {
+                base = b
+            }
+        }
+        f.setEncoding( base )
+        differences, _ := pf.arrayValue( e.data["Differences"] )
+        code := 0
+        for _, v := range differences.data {
+            switch v := v.(type) {
+            case Number:
+                code = int(v)
+            case Name:
+                if code >= 0 && code < 256 {
+                    f.encoding[code] = glyphUnicode( string(v) )
+                }
+                code++
+            }
+        }
+    default:
+        f.setEncoding( base )
+    }
+}
+
+// set approximate widths for a standard font without Widths
+func (f *textFont) setStandardWidths( ) {
+    var widths *[95]float64
+    switch {
+    case strings.HasPrefix( f.name, "Courier" ):
+        f.missing = 600
+        return
+    case strings.HasPrefix( f.name, "Helvetica" ), strings.HasPrefix( f.name, "Arial" ):
+        widths = &helveticaWidths
+    case strings.HasPrefix( f.name, "Times" ):
+        widths = &timesWidths
+    default:
+        if f.missing == 0 {
+            f.missing = 500
+        }
+        return
+    }
+    for i, w := range widths {
+        f.widths[uint32(i + 32)] = w
+    }
+    f.missing = widths[0]
+}
+
+// approximate ascent and descent of standard fonts, by name prefix
+var standardHeights = []struct{ prefix string; ascent, descent float64 }{
+    { "Courier", 629, -157 }, { "Helvetica", 718, -207 }, { "Arial", 718, -207 },
+    { "Times", 683, -217 }, { "Symbol", 1010, -293 }, { "ZapfDingbats", 820, -143 },
+}
+
+// set the font ascent and descent from the font descriptor, or from the font
+// bounding box bbox
+func (pf *PdfFile) setFontHeight( f *textFont, descriptor Dictionary, bbox interface{} ) {
+    ascent, _ := pf.numberValue( descriptor.data["Ascent"] )
+    descent, _ := pf.numberValue( descriptor.data["Descent"] )
+    if ascent > descent {
+        f.ascent, f.descent = ascent, descent
+        return
+    }
+    if bbox, ok := pf.arrayValue( bbox ); ok && len(bbox.data) == 4 {
+        bottom, ok1 := pf.numberValue( bbox.data[1] )
+        top, ok2 := pf.numberValue( bbox.data[3] )
+        if ok1 && ok2 && top != bottom {
+            if top < bottom {
+                top, bottom = bottom, top
+            }
+            f.ascent, f.descent = top, bottom
+            return
+        }
+    }
+    f.ascent, f.descent = 800, -200
+    for _, h := range standardHeights {
+        if strings.HasPrefix( f.name, h.prefix ) {
+            f.ascent, f.descent = h.ascent, h.descent
+            break
+        }
+    }
+}
+
+func (pf *PdfFile) loadCompositeFont( f *textFont, dic Dictionary ) {
+    f.composite = true
+    f.missing = 1000
+    f.advance = -1000
+    encoding, _ := pf.Resolve( dic.data["Encoding"] )
+    switch e := encoding.(type) {
+    case Name:
+        f.cmap, _ = PredefinedCMap( string(e) )
+    case Stream:
+        f.cmap, _ = pf.LoadCMap( dic.data["Encoding"] )
+    }
+    if f.cmap != nil {
+        f.vertical = f.cmap.Vertical
+    }
+
+    descendants, _ := pf.arrayValue( dic.data["DescendantFonts"] )
+    if len(descendants.data) == 0 {
+        f.ascent, f.descent = 800, -200
+        return
+    }
+    cidFont, _ := pf.dictionaryValue( descendants.data[0] )
+    descriptor, _ := pf.dictionaryValue( cidFont.data["FontDescriptor"] )
+    pf.setFontHeight( f, descriptor, descriptor.data["FontBBox"] )
+    if dw, ok := pf.numberValue( cidFont.data["DW"] ); ok {
+        f.missing = dw
+    }
+    if dw2, ok := pf.arrayValue( cidFont.data["DW2"] ); ok && len(dw2.data) == 2 {
+        if w1, ok := pf.numberValue( dw2.data[1] ); ok {
+            f.advance = w1
+        }
+    }
+    // W is made of "c [w1 w2 ...]" and "cfirst clast w" elements
+    w, _ := pf.arrayValue( cidFont.data["W"] )
+    for i := 0; i + 1 < len(w.data); {
+        first, ok := pf.numberValue( w.data[i] )
+        if ! ok {
+            break
+        }
+        if a, ok := pf.arrayValue( w.data[i+1] ); ok {
+            for j, v := range a.data {
+                if v, ok := pf.numberValue( v ); ok {
+                    f.widths[uint32(int(first) + j)] = v
+                }
+            }
+            i += 2
+            continue
+        }
+        if i + 2 >= len(w.data) {
+            break
+        }
+        last, ok1 := pf.numberValue( w.data[i+1] )
+        v, ok2 := pf.numberValue( w.data[i+2] )
+        if ok1 && ok2 && last >= first && last - first < 0x10000 {
+            for c := int(first); c <= int(last); c++ {
+                f.widths[uint32(c)] = v
+            }
+        }
+        i += 3
+    }
+}
+
+// return the next character code in s and its length in bytes, 0 at the end
+// of s
+func (f *textFont) nextCode( s []byte ) ( uint32, int ) {
+    switch {
+    case len(s) == 0:
+        return 0, 0
+    case ! f.composite:
+        return uint32(s[0]), 1
+    case f.cmap != nil:
+        return f.cmap.NextCode( s )
+    case len(s) == 1:
+        return uint32(s[0]), 1
+    }
+    return uint32(s[0]) << 8 | uint32(s[1]), 2
+}
+
+// return the text of the character code
+func (f *textFont) text( code uint32 ) string {
+    if f.toUnicode != nil {
+        if s, ok := f.toUnicode.Unicode( code ); ok {
+            return s
+        }
+    }
+    if ! f.composite && code < 256 && f.encoding[code] != "" {
+        return f.encoding[code]
+    }
+    if f.cmap != nil {
+        if s, ok := f.cmap.Unicode( code ); ok {
+            return s
+        }
+    }
+    return "\ufffd"
+}
+
+// return the character code displacement in text space, for a font size of 1:
+// horizontal, or vertical (negative) for a vertical font
+func (f *textFont) width( code uint32 ) float64 {
+    if f.vertical {
+        return f.advance * f.scale
+    }
+    if f.cmap != nil {
+        cid, ok := f.cmap.CID( code )
+        if ! ok {           // unmapped code: notdef CID, with the default width
+            return f.missing * f.scale
+        }
+        code = cid
+    }
+    if w, ok := f.widths[code]; ok {
+        return w * f.scale
+    }
+    return f.missing * f.scale
+}
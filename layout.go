@@ -0,0 +1,120 @@
+
+package pdf
+
+import (
+    "math"
+    "strings"
+)
+
+/*
+Positioned text: the glyphs shown on a page, as found by the text interpreter
+(see text.go), are given with their font, size, color and bounding box, either
+one by one or gathered in words.
+
+A glyph box spans the glyph displacement along the baseline and the font
+height (descent to ascent) across it, in text space. It is given in user space
+(the default page coordinate system, in points, as used by the media box) and
+in device space (the page as displayed, with the origin at the top left
+corner of the crop box, y going down and the page rotation applied). Since
+text can be rotated or skewed, a box is the smallest rectangle enclosing the
+transformed glyph box.
+
+Words are made of glyphs following each other on the same baseline, in
+content order, separated by white space or by a distance larger than a
+fraction of the font size (see TextArgs.WordSpacing). The font, size and color
+of a word are those of its first glyph.
+*/
+
+// TextRun is a glyph or a word shown on a page
+type TextRun struct {
+    Text        string
+    Font        string      // font name (BaseFont), without subset tag
+    FontSize    float64     // font size in user space
+    Color       Color       // fill color, or stroke color for stroked text
+    RenderMode  int         // text rendering mode (Tr), 3 and 7 are invisible
+    Box         Rectangle   // in user space
+    DeviceBox   Rectangle   // in device space
+}
+
+// return the smallest rectangle enclosing r and s
+func (r Rectangle) union( s Rectangle ) Rectangle {
+    return Rectangle{ math.Min( r.LLx, s.LLx ), math.Min( r.LLy, s.LLy ),
+                      math.Max( r.URx, s.URx ), math.Max( r.URy, s.URy ) }
+}
+
+// return the glyph box transformed by the text rendering matrix and m
+func glyphBox( g *shownGlyph, m matrix ) Rectangle {
+    f := g.font
+    llx, lly, urx, ury := 0.0, f.descent * f.scale, g.width, f.ascent * f.scale
+    if f.vertical {
+        llx, lly, urx, ury = -0.5, g.width, 0.5, 0
+    }
+    trm := g.trm.multiply( m )
+    box := Rectangle{ math.Inf( 1 ), math.Inf( 1 ), math.Inf( -1 ), math.Inf( -1 ) }
+    for _, c := range [4][2]float64{ { llx, lly }, { urx, lly }, { urx, ury }, { llx, ury } } {
+        x, y := trm.apply( c[0], c[1] )
+        box = box.union( Rectangle{ x, y, x, y } )
+    }
+    return box
+}
+
+// return the glyph as a text run
+func glyphRun( g *shownGlyph, display matrix ) TextRun {
+    x0, y0 := g.trm.apply( 0, 0 )
+    x1, y1 := g.trm.apply( 0, 1 )
+    color := g.gs.fill
+    if g.gs.render == 1 || g.gs.render == 5 {
+        color = g.gs.stroke
+    }
+    return TextRun{ Text: g.text, Font: g.font.name, FontSize: math.Hypot( x1 - x0, y1 - y0 ),
+                    Color: color, RenderMode: g.gs.render,
+                    Box: glyphBox( g, identityMatrix ), DeviceBox: glyphBox( g, display ) }
+}
+
+// Glyphs returns the glyphs shown on the page, in content order
+func (p *Page) Glyphs( ) ( []TextRun, error ) {
+    display, err := p.displayMatrix( )
+    if err != nil {
+        return nil, err
+    }
+    glyphs := make( []TextRun, 0, 256 )
+    err = p.showGlyphs( func( g *shownGlyph ) {
+        glyphs = append( glyphs, glyphRun( g, display ) )
+    } )
+    return glyphs, err
+}
+
+// Words returns the words shown on the page, in content order. Only the
+// WordSpacing of args is used, if args is not nil.
+func (p *Page) Words( args *TextArgs ) ( []TextRun, error ) {
+    spacing := DEFAULT_WORD_SPACING
+    if args != nil && args.WordSpacing > 0 {
+        spacing = args.WordSpacing
+    }
+    display, err := p.displayMatrix( )
+    if err != nil {
+        return nil, err
+    }
+    words := make( []TextRun, 0, 64 )
+    var run *textRun        // current word, in display space
+    var word *TextRun
+    err = p.showGlyphs( func( g *shownGlyph ) {
+        gp, visible := placeGlyph( g, display )
+        if strings.TrimSpace( g.text ) == "" || ! visible {
+            run = nil       // end of word
+            return
+        }
+        gr := glyphRun( g, display )
+        if along, ok := run.follows( &gp ); ok && along <= spacing * gp.size {
+            word.Text += gr.Text
+            word.Box = word.Box.union( gr.Box )
+            word.DeviceBox = word.DeviceBox.union( gr.DeviceBox )
+            run.x1, run.y1 = gp.x1, gp.y1
+            return
+        }
+        run = &textRun{ glyphPlacement: gp }
+        words = append( words, gr )
+        word = &words[len(words)-1]
+    } )
+    return words, err
+}
@@ -0,0 +1,164 @@
+package pdf
+
+import (
+    "math"
+    "reflect"
+    "testing"
+)
+
+func TestSetFontHeight( t *testing.T ) {
+    pf, err := NewDocument( nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    ascent := pf.NewObject( Number(900) )
+    descriptor := NewDictionary( )
+    descriptor.Set( "Ascent", NewReference( ascent.ID( ), ascent.Gen( ) ) )
+    descriptor.Set( "Descent", Number(-100) )
+    bbox := NewArray( Number(0), Number(-150), Number(1000), Number(850) )
+    tests := []struct {
+        what                string
+        name                string
+        descriptor          Dictionary
+        bbox                interface{}
+        ascent, descent     float64
+    }{
+        { "Descriptor", "Helvetica", descriptor, bbox, 900, -100 },
+        { "FontBBox", "Helvetica", NewDictionary( ), bbox, 850, -150 },
+        { "Swapped FontBBox", "Helvetica", NewDictionary( ), NewArray( Number(0), Number(850), Number(1000), Number(-150) ),
+          850, -150 },
+        { "Flat FontBBox", "Times-Roman", NewDictionary( ), NewArray( Number(0), Number(0), Number(1000), Number(0) ),
+          683, -217 },
+        { "Standard font", "Courier-Bold", NewDictionary( ), nil, 629, -157 },
+        { "Unknown font", "Foo", NewDictionary( ), nil, 800, -200 },
+    }
+    for _, test := range tests {
+        f := &textFont{ name: test.name }
+        pf.setFontHeight( f, test.descriptor, test.bbox )
+        if f.ascent != test.ascent || f.descent != test.descent {
+            t.Errorf( "%s: got %g %g, expected %g %g", test.what, f.ascent, f.descent, test.ascent, test.descent )
+        }
+    }
+}
+
+func checkBox( t *testing.T, what string, box, expected Rectangle ) {
+    for i, v := range []float64{ box.LLx - expected.LLx, box.LLy - expected.LLy,
+                                 box.URx - expected.URx, box.URy - expected.URy } {
+        if math.Abs( v ) > 1e-6 {
+            t.Errorf( "%s: got %v, expected %v (coordinate %d)", what, box, expected, i )
+            return
+        }
+    }
+}
+
+func TestGlyphColors( t *testing.T ) {
+    p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        cb.SetFillRGB( 1, 0, 0 )
+        cb.SetStrokeRGB( 0, 0, 1 )
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 72, 700 )
+        for _, mode := range []int{ 0, 1, 2, 5 } {
+            cb.SetTextRenderingMode( mode )
+            cb.ShowText( "a" )
+        }
+        cb.EndText( )
+    } )
+    glyphs, err := p.Glyphs( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    red, blue := Color{ "DeviceRGB", []float64{ 1, 0, 0 } }, Color{ "DeviceRGB", []float64{ 0, 0, 1 } }
+    expected := []Color{ red, blue, red, blue }
+    if len(glyphs) != len(expected) {
+        t.Fatalf( "Got %d glyphs, expected %d", len(glyphs), len(expected) )
+    }
+    for i, g := range glyphs {
+        if ! reflect.DeepEqual( g.Color, expected[i] ) {
+            t.Errorf( "Render mode %d: got color %v, expected %v", g.RenderMode, g.Color, expected[i] )
+        }
+    }
+    // "a" is 6.672 wide, Helvetica goes from -207 to 718
+    g := glyphs[1]
+    if g.Text != "a" || g.Font != "Helvetica" || g.FontSize != 12 {
+        t.Errorf( "Got glyph %q %s %g", g.Text, g.Font, g.FontSize )
+    }
+    checkBox( t, "Glyph box", g.Box, Rectangle{ 78.672, 697.516, 85.344, 708.616 } )
+    checkBox( t, "Glyph device box", g.DeviceBox, Rectangle{ 78.672, 83.384, 85.344, 94.484 } )
+}
+
+func TestVerticalGlyphs( t *testing.T ) {
+    p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        cid := NewDictionary( )
+        cid.Set( "Type", Name("Font") )
+        cid.Set( "Subtype", Name("CIDFontType2") )
+        cid.Set( "BaseFont", Name("ABCDEF+Vertical") )
+        cidObj := pf.NewObject( cid )
+        dict := NewDictionary( )
+        dict.Set( "Type", Name("Font") )
+        dict.Set( "Subtype", Name("Type0") )
+        dict.Set( "BaseFont", Name("ABCDEF+Vertical") )
+        dict.Set( "Encoding", Name("Identity-V") )
+        dict.Set( "DescendantFonts", NewArray( NewReference( cidObj.ID( ), cidObj.Gen( ) ) ) )
+        obj := pf.NewObject( dict )
+        cb.BeginText( )
+        cb.SetFont( NewReference( obj.ID( ), obj.Gen( ) ), 10 )
+        cb.MoveText( 100, 700 )
+        cb.Op( "Tj", String( "\x00\x41\x00\x42" ) )
+        cb.EndText( )
+    } )
+    glyphs, err := p.Glyphs( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if len(glyphs) != 2 {
+        t.Fatalf( "Got %d glyphs, expected 2", len(glyphs) )
+    }
+    // vertical glyphs are 1 wide, centered on the origin, and go down by the
+    // default vertical advance
+    if glyphs[0].Font != "Vertical" || glyphs[0].FontSize != 10 {
+        t.Errorf( "Got font %s %g", glyphs[0].Font, glyphs[0].FontSize )
+    }
+    checkBox( t, "First glyph box", glyphs[0].Box, Rectangle{ 95, 690, 105, 700 } )
+    checkBox( t, "Second glyph box", glyphs[1].Box, Rectangle{ 95, 680, 105, 690 } )
+    checkBox( t, "Second glyph device box", glyphs[1].DeviceBox, Rectangle{ 95, 102, 105, 112 } )
+}
+
+func TestWords( t *testing.T ) {
+    p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 72, 700 )
+        cb.ShowTextAdjusted( "ab", -300, "cd", -100, "ef" )
+        cb.ShowText( " gh" )
+        cb.EndText( )
+    } )
+    // the gap between "ab" and "cd" is 0.3 font size
+    tests := []struct {
+        args        *TextArgs
+        words       []string
+    }{
+        { nil, []string{ "ab", "cdef", "gh" } },
+        { &TextArgs{ WordSpacing: 0.25 }, []string{ "ab", "cdef", "gh" } },
+        { &TextArgs{ WordSpacing: 0.5 }, []string{ "abcdef", "gh" } },
+        { &TextArgs{ WordSpacing: 0.05 }, []string{ "ab", "cd", "ef", "gh" } },
+    }
+    for _, test := range tests {
+        words, err := p.Words( test.args )
+        if err != nil {
+            t.Fatal( err )
+        }
+        text := make( []string, len(words) )
+        for i, w := range words {
+            text[i] = w.Text
+        }
+        if ! reflect.DeepEqual( text, test.words ) {
+            t.Errorf( "Words %+v: got %q, expected %q", test.args, text, test.words )
+        }
+    }
+
+    // a word box encloses its glyph boxes
+    words, _ := p.Words( nil )
+    checkBox( t, "Word box", words[0].Box, Rectangle{ 72, 697.516, 85.344, 708.616 } )
+    checkBox( t, "Word device box", words[0].DeviceBox, Rectangle{ 72, 83.384, 85.344, 94.484 } )
+}
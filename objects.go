@@ -3,14 +3,227 @@ package pdf
 
 import (
     "fmt"
-
+    "sort"
+    "bytes"
 )
 
+// NewDictionary returns an empty dictionary, ready to use
+func NewDictionary( ) Dictionary {
+    return Dictionary{ make( []string, 0, DEFAULT_DICTIONARY_SIZE ),
+                       make( map[string]interface{}, DEFAULT_DICTIONARY_SIZE ) }
+}
+
+// Len returns the number of entries in the dictionary
+func (d Dictionary) Len( ) int {
+    return len(d.data)
+}
+
+// Get returns the value associated with key, and whether key was found
+func (d Dictionary) Get( key string ) ( interface{}, bool ) {
+    v, ok := d.data[key]
+    return v, ok
+}
+
+// Keys returns the dictionary keys, in file order for a parsed dictionary or
+// in insertion order for a new dictionary. Keys that were added through a
+// copy of the dictionary follow, in alphabetical order.
+func (d Dictionary) Keys( ) []string {
+    keys := make( []string, 0, len(d.data) )
+    seen := make( map[string]bool, len(d.data) )
+    for _, k := range d.keys {
+        if _, ok := d.data[k]; ok && ! seen[k] {
+            keys = append( keys, k )
+            seen[k] = true
+        }
+    }
+    if len(keys) < len(d.data) {
+        extra := make( []string, 0, len(d.data) - len(keys) )
+        for k := range d.data {
+            if ! seen[k] {
+                extra = append( extra, k )
+            }
+        }
+        sort.Strings( extra )
+        keys = append( keys, extra... )
+    }
+    return keys
+}
+
+// Set associates value with key, replacing any previous value
+func (d *Dictionary) Set( key string, value interface{} ) {
+    if d.data == nil {
+        *d = NewDictionary( )
+    }
+    if _, ok := d.data[key]; ! ok {
+        d.keys = append( d.keys, key )
+    }
+    d.data[key] = value
+}
+
+// Delete removes key and its value from the dictionary
+func (d *Dictionary) Delete( key string ) {
+    delete( d.data, key )
+    for i, k := range d.keys {
+        if k == key {
+            d.keys = append( d.keys[:i:i], d.keys[i+1:]... )
+            break
+        }
+    }
+}
+
+// return a shallow copy of the dictionary
+func (d Dictionary) clone( ) Dictionary {
+    c := Dictionary{ make( []string, 0, len(d.data) ), make( map[string]interface{}, len(d.data) ) }
+    for _, k := range d.Keys() {
+        c.Set( k, d.data[k] )
+    }
+    return c
+}
+
+// NewArray returns an array made of the given values
+func NewArray( values ...interface{} ) Array {
+    data := make( []interface{}, len(values) )
+    copy( data, values )
+    return Array{ data }
+}
+
+// Len returns the number of elements in the array
+func (a Array) Len( ) int {
+    return len(a.data)
+}
+
+// Index returns the array element at index i (from 0 to Len()-1)
+func (a Array) Index( i int ) interface{} {
+    return a.data[i]
+}
+
+// Values returns the array elements
+func (a Array) Values( ) []interface{} {
+    return a.data
+}
+
+// Append adds values at the end of the array
+func (a *Array) Append( values ...interface{} ) {
+    a.data = append( a.data, values... )
+}
+
+// NewStream returns a stream made of the dictionary dict and of the already
+// encoded data. The dictionary Length entry is set to the data length.
+func NewStream( dict Dictionary, data []byte ) Stream {
+    dict.Set( "Length", Number(len(data)) )
+    return Stream{ dict, data }
+}
+
+// Dict returns the stream dictionary
+func (s Stream) Dict( ) Dictionary {
+    return s.extent
+}
+
+// Data returns the stream data, as stored in the file (i.e. not decoded)
+func (s Stream) Data( ) []byte {
+    return s.data
+}
+
+// Decode returns the stream data, decoded by all the stream filters
+func (s Stream) Decode( ) ( []byte, error ) {
+    return decodeStream( &s )
+}
+
+// return true if both direct values are identical, comparing the entries of
+// dictionaries and the elements of arrays (their order does not matter for
+// dictionaries). References are equal if they refer to the same object.
+func equalValues( a, b interface{} ) bool {
+    switch a := a.(type) {
+    case Dictionary:
+        d, ok := b.(Dictionary)
+        if ! ok || len(a.data) != len(d.data) {
+            return false
+        }
+        for k, v := range a.data {
+            if w, ok := d.data[k]; ! ok || ! equalValues( v, w ) {
+                return false
+            }
+        }
+        return true
+    case Array:
+        r, ok := b.(Array)
+        if ! ok || len(a.data) != len(r.data) {
+            return false
+        }
+        for i, v := range a.data {
+            if ! equalValues( v, r.data[i] ) {
+                return false
+            }
+        }
+        return true
+    case Stream:
+        s, ok := b.(Stream)
+        return ok && equalValues( a.extent, s.extent ) && bytes.Equal( a.data, s.data )
+    }
+    switch b.(type) {
+    case Dictionary, Array, Stream:
+        return false
+    }
+    return a == b
+}
+
+// NewReference returns a reference to the indirect object id with generation gen
+func NewReference( id, gen int64 ) Reference {
+    return Reference{ id, gen }
+}
+
+// ID returns the referred object ID
+func (r Reference) ID( ) int64 {
+    return r.id
+}
+
+// Gen returns the referred object generation
+func (r Reference) Gen( ) int64 {
+    return r.gen
+}
+
+// ID returns the indirect object ID
+func (obj *PdfObject) ID( ) int64 {
+    return obj.id
+}
+
+// Gen returns the indirect object generation
+func (obj *PdfObject) Gen( ) int64 {
+    return obj.gen
+}
+
+// Value returns the object value: Bool, Number, String, HexString, Name,
+// Dictionary, Stream, Array, Null or Reference
+func (obj *PdfObject) Value( ) interface{} {
+    return obj.value
+}
+
+// SetValue replaces the object value and marks the object as modified. It
+// must also be called after modifying the entries of a dictionary, stream
+// or array value in place, so that the object is part of the next
+// incremental update.
+func (obj *PdfObject) SetValue( value interface{} ) {
+    obj.value = value
+    obj.modified = true
+}
+
+// Modified returns true if the object was created or modified since the
+// document was parsed or since the last incremental update
+func (obj *PdfObject) Modified( ) bool {
+    return obj.modified
+}
+
+// NewObject creates a new indirect object with the given value, using the
+// next available object ID.
+func (pf *PdfFile) NewObject( value interface{} ) *PdfObject {
+    return pf.newIndirectObject( pf.newObjectId( ), 0, value )
+}
+
 func (pf *PdfFile) PrintFileIds( ) {
     if pf.Id == nil {
         fmt.Printf( "no file IDs\n" )
     } else {
-        fmt.Printf( "PDF file IDs: 0x%x, 0x%x\n", pf.Id.data[0].(pdfHexString), pf.Id.data[1].(pdfHexString) )
+        fmt.Printf( "PDF file IDs: 0x%x, 0x%x\n", pf.Id.data[0].(HexString), pf.Id.data[1].(HexString) )
     }
 }
 
@@ -53,7 +266,7 @@ func (pf *PdfFile) PrintCatalog( ) {
 func (pf *pdf.PdfFile)printEncryptionData(  ) {
     if ed := pf.Encrypt; ed != nil {
         fmt.Printf( "Encrypt object ID %d gen %d\n", ed.id, ed.generation )
-        dic := ed.value.(pdf.pdfDictionary)
+        dic := ed.value.(pdf.Dictionary)
         for k, v := range dic.val {
             if k == "O" || k == "U" {
                 fmt.Printf( "%s=%x\n", k, v )
@@ -67,7 +280,7 @@ func (pf *pdf.PdfFile)printEncryptionData(  ) {
 }
 */
 
-func (pf *PdfFile) printReference( ref *pdfReference, objName string ) {
+func (pf *PdfFile) printReference( ref *Reference, objName string ) {
     if ref.id == 0 {
         fmt.Printf( "No %s defined\n", objName )
         return
@@ -91,42 +304,42 @@ func (obj *PdfObject) print( ) {
     printValue( obj.value, "  " )
 }
 
-func printMapValue( val map[string]interface{}, header, indent string ) {
+func printMapValue( val Dictionary, header, indent string ) {
     fmt.Printf( header )
-    for k, v := range( val ) {
+    for _, k := range val.Keys() {     // in file or insertion order
         fmt.Printf( "%s /%s: ", indent, k )
-        printValue( v, indent + "  " )
+        printValue( val.data[k], indent + "  " )
     }
 }
 
 func printValue( val interface{}, indent string ) {
     switch val := val.(type) {
-    case pdfBool:
+    case Bool:
         fmt.Printf( " %t\n", val )
-    case pdfNumber:
+    case Number:
         fmt.Printf( " %g\n", val )
-    case pdfString:
+    case String:
         fmt.Printf( " %s\n", val )
-    case pdfHexString:
+    case HexString:
         fmt.Printf( " 0x%x\n", val )
-    case pdfName:
+    case Name:
         fmt.Printf( " /%s\n", val )
-    case pdfDictionary:
+    case Dictionary:
 //        fmt.Printf( " Dictionary:\n" )
-        printMapValue( val.data, " Dictionary:\n", indent )
-    case pdfStream:
+        printMapValue( val, " Dictionary:\n", indent )
+    case Stream:
         fmt.Printf( " Stream extent:" )
         printValue( val.extent, indent + "  " )
         fmt.Printf( "%s Stream length: %d\n", indent, len( val.data ) )
-    case PdfArray:
+    case Array:
         fmt.Printf(" Array:\n" )
         for i, v := range( val.data ) {
             fmt.Printf("%s   %d: ", indent, i )
             printValue( v, indent + "  " )
         }
-    case pdfNull:
+    case Null:
         fmt.Printf( " Null" )
-    case pdfReference:
+    case Reference:
         fmt.Printf( " Indirect object reference: id %d generation %d\n",
                     val.id, val.gen )
     }
@@ -147,6 +360,8 @@ func (pf *PdfFile) newIndirectObject( id, gen int64, content interface{} ) *PdfO
     nio.id = id
     nio.gen = gen
     nio.value = content
+    nio.modified = true
+    nio.created = true
 
     pf.Objects = append(pf.Objects, nio )
     pf.ObjById[id] = nio
@@ -0,0 +1,119 @@
+package pdf
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestDictionary( t *testing.T ) {
+    var d Dictionary                    // zero value is usable
+    d.Set( "Type", Name("Page") )
+    d.Set( "Count", Number(1) )
+    d.Set( "Kids", NewArray( ) )
+    d.Set( "Type", Name("Pages") )      // replaced, order unchanged
+    if d.Len( ) != 3 {
+        t.Errorf( "Len: got %d, expected 3", d.Len( ) )
+    }
+    if keys := d.Keys( ); ! reflect.DeepEqual( keys, []string{ "Type", "Count", "Kids" } ) {
+        t.Errorf( "Keys: got %v", keys )
+    }
+    if v, ok := d.Get( "Type" ); ! ok || v != Name("Pages") {
+        t.Errorf( "Get Type: got %v %t", v, ok )
+    }
+    if v, ok := d.Get( "Parent" ); ok || v != nil {
+        t.Errorf( "Get Parent: got %v %t", v, ok )
+    }
+
+    d.Delete( "Count" )
+    d.Delete( "Missing" )
+    if keys := d.Keys( ); ! reflect.DeepEqual( keys, []string{ "Type", "Kids" } ) {
+        t.Errorf( "Keys after Delete: got %v", keys )
+    }
+    d.Set( "Count", Number(0) )         // added again at the end
+    if keys := d.Keys( ); ! reflect.DeepEqual( keys, []string{ "Type", "Kids", "Count" } ) {
+        t.Errorf( "Keys after Set: got %v", keys )
+    }
+
+    // a copy shares the entries, but keys added through the copy come last
+    c := d
+    c.Set( "Z", Null{} )
+    c.Set( "A", Bool(true) )
+    if keys := d.Keys( ); ! reflect.DeepEqual( keys, []string{ "Type", "Kids", "Count", "A", "Z" } ) {
+        t.Errorf( "Keys after Set in a copy: got %v", keys )
+    }
+    clone := d.clone( )
+    clone.Delete( "A" )
+    if _, ok := d.Get( "A" ); ! ok {
+        t.Errorf( "Delete in a clone removed the original entry" )
+    }
+}
+
+func TestArray( t *testing.T ) {
+    values := []interface{}{ Number(1), Name("Two") }
+    a := NewArray( values... )
+    values[0] = Number(0)               // NewArray copies values
+    if a.Len( ) != 2 || a.Index( 0 ) != Number(1) || a.Index( 1 ) != Name("Two") {
+        t.Errorf( "NewArray: got %v", a.Values( ) )
+    }
+    a.Append( String("3"), Null{} )
+    if ! reflect.DeepEqual( a.Values( ), []interface{}{ Number(1), Name("Two"), String("3"), Null{} } ) {
+        t.Errorf( "Append: got %v", a.Values( ) )
+    }
+    var empty Array
+    empty.Append( Bool(false) )
+    if empty.Len( ) != 1 {
+        t.Errorf( "Append to zero array: got %d elements", empty.Len( ) )
+    }
+}
+
+func TestStreamValue( t *testing.T ) {
+    dict := NewDictionary( )
+    dict.Set( "Length", Number(100) )
+    s := NewStream( dict, []byte( "data" ) )
+    if l, _ := s.Dict( ).Get( "Length" ); l != Number(4) {
+        t.Errorf( "NewStream Length: got %v, expected 4", l )
+    }
+    if string(s.Data( )) != "data" {
+        t.Errorf( "Data: got %q", s.Data( ) )
+    }
+    if data, err := s.Decode( ); err != nil || string(data) != "data" {
+        t.Errorf( "Decode: got %q %v", data, err )
+    }
+}
+
+func TestParsedValues( t *testing.T ) {
+    objs := append( testSinglePageObjects[:3:3],
+                    "<< /B true /N -1.5 /S (a\\)b) /H <41 42> /Na /A#20B /Ar [ 1 [ ] << >> ] /Nu null /R 3 0 R >>" )
+    pf, err := ParseBytes( makeTestFile( objs, "/Root 1 0 R" ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    obj := pf.ObjById[4]
+    if obj.ID( ) != 4 || obj.Gen( ) != 0 || obj.Modified( ) {
+        t.Errorf( "Object: got %d %d modified %t", obj.ID( ), obj.Gen( ), obj.Modified( ) )
+    }
+    d, ok := obj.Value( ).(Dictionary)
+    if ! ok {
+        t.Fatalf( "Value: got %T, expected Dictionary", obj.Value( ) )
+    }
+    expected := map[string]interface{}{
+        "B": Bool(true), "N": Number(-1.5), "S": String("a)b"), "H": HexString("AB"), "Na": Name("A#20B"),   // names are not decoded
+        "Ar": NewArray( Number(1), NewArray( ), NewDictionary( ) ), "Nu": Null{}, "R": NewReference( 3, 0 ),
+    }
+    if keys := d.Keys( ); ! reflect.DeepEqual( keys, []string{ "B", "N", "S", "H", "Na", "Ar", "Nu", "R" } ) {
+        t.Errorf( "Keys: got %v", keys )
+    }
+    for k, e := range expected {
+        if v, _ := d.Get( k ); ! equalValues( v, e ) {
+            t.Errorf( "/%s: got %#v, expected %#v", k, v, e )
+        }
+    }
+    if r, _ := d.Get( "R" ); r.(Reference).ID( ) != 3 || r.(Reference).Gen( ) != 0 {
+        t.Errorf( "Reference: got %v", r )
+    }
+
+    obj.SetValue( Number(4) )
+    if ! obj.Modified( ) || obj.Value( ) != Number(4) {
+        t.Errorf( "SetValue: got %v modified %t", obj.Value( ), obj.Modified( ) )
+    }
+}
@@ -0,0 +1,319 @@
+
+package pdf
+
+import (
+    "fmt"
+    "math"
+)
+
+/*
+Page tree: the catalog Pages entry refers to the root Pages node. Each Pages
+node has an array of Kids, which are either Pages nodes or Page leaves. The
+document pages are the leaves in the order given by a depth first traversal.
+
+Some page attributes (Resources, MediaBox, CropBox and Rotate) can be defined
+in any ancestor Pages node and are inherited by all pages below that node,
+unless redefined on the way.
+*/
+
+// Page is a page of the document, found in the page tree
+type Page struct {
+    pf          *PdfFile
+    obj         *PdfObject      // Page object
+    ancestors   []*PdfObject    // Pages nodes from the root to the page parent
+}
+
+// Rectangle is a normalized PDF rectangle: lower left and upper right corners
+type Rectangle struct {
+    LLx, LLy    float64
+    URx, URy    float64
+}
+
+// Width returns the rectangle width
+func (r Rectangle) Width( ) float64 {
+    return r.URx - r.LLx
+}
+
+// Height returns the rectangle height
+func (r Rectangle) Height( ) float64 {
+    return r.URy - r.LLy
+}
+
+// inheritable page attributes, in the order they are copied into a page
+var inheritableKeys = []string{ "Resources", "MediaBox", "CropBox", "Rotate" }
+var inheritable = map[string]bool{ "Resources": true, "MediaBox": true,
+                                   "CropBox": true, "Rotate": true }
+
+// return the root Pages node of the page tree
+func (pf *PdfFile) getPageTreeRoot( ) ( *PdfObject, error ) {
+    v, err := pf.Resolve( pf.Catalog )
+    if err != nil {
+        return nil, fmt.Errorf( "Root catalog: %v", err )
+    }
+    catalog, ok := v.(Dictionary)
+    if ! ok {
+        return nil, fmt.Errorf( "Root catalog is not a dictionary\n" )
+    }
+    pages, ok := catalog.data["Pages"].(Reference)
+    if ! ok {
+        return nil, fmt.Errorf( "Root catalog does not refer to a page tree\n" )
+    }
+    root, ok := pf.ObjById[pages.id]
+    if ! ok || root.value == nil {
+        return nil, fmt.Errorf( "Root catalog Pages: %w", &ReferenceError{ pages, ErrDanglingReference } )
+    }
+    if root.gen != pages.gen {
+        return nil, fmt.Errorf( "Root catalog Pages: %w", &ReferenceError{ pages, ErrGenerationMismatch } )
+    }
+    return root, nil
+}
+
+// return the node type, either "Pages" or "Page", inferred from Kids if needed
+func getPageNodeType( dic Dictionary ) Name {
+    if t, ok := dic.data["Type"].(Name); ok {
+        return t
+    }
+    if _, ok := dic.data["Kids"]; ok {
+        return Name("Pages")
+    }
+    return Name("Page")
+}
+
+// call f for each page in document order, until f returns an error or
+// errStopWalk, which stops walking the tree without error.
+var errStopWalk = fmt.Errorf( "stop walking the page tree" )
+
+func (pf *PdfFile) walkPages( f func( p *Page ) error ) error {
+    root, err := pf.getPageTreeRoot( )
+    if err != nil {
+        return err
+    }
+    visited := make( map[int64]bool )
+
+    var walk func( node *PdfObject, ancestors []*PdfObject ) error
+    walk = func( node *PdfObject, ancestors []*PdfObject ) error {
+        if visited[node.id] {
+            return fmt.Errorf( "Page tree node %d %d appears more than once\n", node.id, node.gen )
+        }
+        visited[node.id] = true
+        dic, ok := node.value.(Dictionary)
+        if ! ok {
+            return fmt.Errorf( "Page tree node %d %d is not a dictionary\n", node.id, node.gen )
+        }
+        switch getPageNodeType( dic ) {
+        case "Page":
+            if len(ancestors) == 0 {
+                return fmt.Errorf( "Page tree root %d %d is a page\n", node.id, node.gen )
+            }
+            return f( &Page{ pf, node, ancestors } )
+        case "Pages":
+            kids, ok := dic.data["Kids"].(Array)
+            if ! ok {
+                return fmt.Errorf( "Pages node %d %d does not have Kids\n", node.id, node.gen )
+            }
+            path := append( ancestors[:len(ancestors):len(ancestors)], node )
+            for _, kid := range kids.data {
+                ref, ok := kid.(Reference)
+                if ! ok {
+                    return fmt.Errorf( "Pages node %d %d has a kid which is not a reference\n",
+                                       node.id, node.gen )
+                }
+                kidObj, ok := pf.ObjById[ref.id]
+                if ! ok || kidObj.value == nil {
+                    return &ReferenceError{ ref, ErrDanglingReference }
+                }
+                if kidObj.gen != ref.gen {
+                    return &ReferenceError{ ref, ErrGenerationMismatch }
+                }
+                if err := walk( kidObj, path ); err != nil {
+                    return err
+                }
+            }
+            return nil
+        default:
+            return fmt.Errorf( "Page tree node %d %d has an invalid type\n", node.id, node.gen )
+        }
+    }
+    err = walk( root, nil )
+    if err == errStopWalk {
+        return nil
+    }
+    return err
+}
+
+// Pages returns all document pages, in document order
+func (pf *PdfFile) Pages( ) ( []*Page, error ) {
+    pages := make( []*Page, 0, DEFAULT_PAGE_NUMBER )
+    err := pf.walkPages( func( p *Page ) error {
+        pages = append( pages, p )
+        return nil
+    } )
+    if err != nil {
+        return nil, err
+    }
+    return pages, nil
+}
+
+// NumPages returns the number of pages in the document
+func (pf *PdfFile) NumPages( ) ( int, error ) {
+    n := 0
+    err := pf.walkPages( func( p *Page ) error {
+        n++
+        return nil
+    } )
+    return n, err
+}
+
+// Page returns the page at index i, starting from 0 for the first page
+func (pf *PdfFile) Page( i int ) ( *Page, error ) {
+    var page *Page
+    n := 0
+    err := pf.walkPages( func( p *Page ) error {
+        if n == i {
+            page = p
+            return errStopWalk
+        }
+        n++
+        return nil
+    } )
+    if err != nil {
+        return nil, err
+    }
+    if page == nil {
+        return nil, fmt.Errorf( "Page %d does not exist (%d pages)\n", i, n )
+    }
+    return page, nil
+}
+
+// Object returns the page object
+func (p *Page) Object( ) *PdfObject {
+    return p.obj
+}
+
+// Dict returns the page dictionary, without inherited attributes
+func (p *Page) Dict( ) Dictionary {
+    return p.obj.value.(Dictionary)
+}
+
+// Inherited returns the resolved value of key in the page dictionary, or
+// in the closest ancestor Pages node if key is an inheritable attribute
+// (Resources, MediaBox, CropBox or Rotate) that is not in the page itself.
+// It returns nil and no error if key cannot be found.
+func (p *Page) Inherited( key string ) ( interface{}, error ) {
+    if v, ok := p.Dict().data[key]; ok {
+        return p.pf.Resolve( v )
+    }
+    if ! inheritable[key] {
+        return nil, nil
+    }
+    for i := len(p.ancestors) - 1; i >= 0; i-- {
+        if dic, ok := p.ancestors[i].value.(Dictionary); ok {
+            if v, ok := dic.data[key]; ok {
+                return p.pf.Resolve( v )
+            }
+        }
+    }
+    return nil, nil
+}
+
+// Resources returns the page resource dictionary, possibly inherited. An
+// empty dictionary is returned if no resource is defined.
+func (p *Page) Resources( ) ( Dictionary, error ) {
+    v, err := p.Inherited( "Resources" )
+    if err != nil {
+        return Dictionary{}, fmt.Errorf( "Page %d %d Resources: %v", p.obj.id, p.obj.gen, err )
+    }
+    if v == nil {
+        return NewDictionary(), nil
+    }
+    res, ok := v.(Dictionary)
+    if ! ok {
+        return Dictionary{}, fmt.Errorf( "Page %d %d Resources is not a dictionary\n", p.obj.id, p.obj.gen )
+    }
+    return res, nil
+}
+
+func (pf *PdfFile) getRectangle( v interface{} ) ( Rectangle, error ) {
+    a, ok := v.(Array)
+    if ! ok || len(a.data) != 4 {
+        return Rectangle{}, fmt.Errorf( "Rectangle is not an array of 4 numbers\n" )
+    }
+    var c [4]float64
+    for i, e := range a.data {
+        r, err := pf.Resolve( e )
+        if err != nil {
+            return Rectangle{}, err
+        }
+        n, ok := r.(Number)
+        if ! ok {
+            return Rectangle{}, fmt.Errorf( "Rectangle is not an array of 4 numbers\n" )
+        }
+        c[i] = float64(n)
+    }
+    // any 2 diagonally opposite corners can be given
+    rect := Rectangle{ c[0], c[1], c[2], c[3] }
+    if rect.LLx > rect.URx { rect.LLx, rect.URx = rect.URx, rect.LLx }
+    if rect.LLy > rect.URy { rect.LLy, rect.URy = rect.URy, rect.LLy }
+    return rect, nil
+}
+
+// MediaBox returns the page media box, possibly inherited
+func (p *Page) MediaBox( ) ( Rectangle, error ) {
+    v, err := p.Inherited( "MediaBox" )
+    if err == nil && v == nil {
+        err = fmt.Errorf( "missing\n" )
+    }
+    if err == nil {
+        var r Rectangle
+        if r, err = p.pf.getRectangle( v ); err == nil {
+            return r, nil
+        }
+    }
+    return Rectangle{}, fmt.Errorf( "Page %d %d MediaBox: %v", p.obj.id, p.obj.gen, err )
+}
+
+// CropBox returns the page crop box, possibly inherited. By default, it is
+// the media box, otherwise it is the crop box clipped by the media box.
+func (p *Page) CropBox( ) ( Rectangle, error ) {
+    media, err := p.MediaBox( )
+    if err != nil {
+        return media, err
+    }
+    v, err := p.Inherited( "CropBox" )
+    if err != nil {
+        return Rectangle{}, fmt.Errorf( "Page %d %d CropBox: %v", p.obj.id, p.obj.gen, err )
+    }
+    if v == nil {
+        return media, nil
+    }
+    crop, err := p.pf.getRectangle( v )
+    if err != nil {
+        return Rectangle{}, fmt.Errorf( "Page %d %d CropBox: %v", p.obj.id, p.obj.gen, err )
+    }
+    if crop.LLx < media.LLx { crop.LLx = media.LLx }
+    if crop.LLy < media.LLy { crop.LLy = media.LLy }
+    if crop.URx > media.URx { crop.URx = media.URx }
+    if crop.URy > media.URy { crop.URy = media.URy }
+    return crop, nil
+}
+
+// Rotate returns the page rotation in degrees clockwise, possibly inherited,
+// normalized to 0, 90, 180 or 270.
+func (p *Page) Rotate( ) ( int, error ) {
+    v, err := p.Inherited( "Rotate" )
+    if err != nil {
+        return 0, fmt.Errorf( "Page %d %d Rotate: %v", p.obj.id, p.obj.gen, err )
+    }
+    if v == nil {
+        return 0, nil
+    }
+    n, ok := v.(Number)
+    if ! ok || float64(n) != math.Trunc( float64(n) ) || int(n) % 90 != 0 {
+        return 0, fmt.Errorf( "Page %d %d Rotate is not a multiple of 90: %v\n", p.obj.id, p.obj.gen, v )
+    }
+    r := int(n) % 360
+    if r < 0 {
+        r += 360
+    }
+    return r, nil
+}
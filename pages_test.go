@@ -0,0 +1,174 @@
+package pdf
+
+import (
+    "bytes"
+    "fmt"
+    "testing"
+)
+
+// return a PDF file made of the given objects, numbered from 1, with a XREF
+// table and the given trailer entries
+func makeTestFile( objs []string, trailer string ) []byte {
+    var b bytes.Buffer
+    b.WriteString( "%PDF-1.4\n" )
+    offsets := make( []int, len(objs) )
+    for i, o := range objs {
+        offsets[i] = b.Len()
+        fmt.Fprintf( &b, "%d 0 obj\n%s\nendobj\n", i + 1, o )
+    }
+    xref := b.Len()
+    fmt.Fprintf( &b, "xref\n0 %d\n0000000000 65535 f\r\n", len(objs) + 1 )
+    for _, offset := range offsets {
+        fmt.Fprintf( &b, "%010d 00000 n\r\n", offset )
+    }
+    fmt.Fprintf( &b, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objs) + 1, trailer, xref )
+    return b.Bytes()
+}
+
+// a page tree with 2 levels of Pages nodes and inherited attributes
+var testPageTree = []string{
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    "<< /Type /Pages /Kids [ 3 0 R 6 0 R 7 0 R ] /Count 4 /MediaBox [ 0 0 612 792 ] /Rotate 90 " +
+       "/Resources << /Font << /F1 10 0 R >> >> >>",
+    "<< /Type /Pages /Parent 2 0 R /Kids [ 4 0 R 5 0 R ] /Count 2 /Rotate -90 /CropBox [ 500 900 -10 100 ] >>",
+    "<< /Type /Page /Parent 3 0 R >>",
+    "<< /Type /Page /Parent 3 0 R /Rotate 180 /MediaBox [ 0 0 300 300 ] /Resources << >> >>",
+    "<< /Type /Page /Parent 2 0 R /Rotate -450 >>",
+    "<< /Type /Pages /Parent 2 0 R /Kids [ 8 0 R ] /Count 9 0 R >>",
+    "<< /Type /Page /Parent 7 0 R /Rotate 9 0 R >>",
+    "1",
+    "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+}
+
+func parseTestPageTree( t *testing.T ) *PdfFile {
+    pf, err := ParseBytes( makeTestFile( testPageTree, "/Root 1 0 R" ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return pf
+}
+
+func TestPageTreeAttributes( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    pages, err := pf.Pages( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    tests := []struct {
+        id              int64
+        rotate          int
+        media, crop     Rectangle
+        fonts           int
+    }{
+        { 4, 270, Rectangle{ 0, 0, 612, 792 }, Rectangle{ 0, 100, 500, 792 }, 1 },
+        { 5, 180, Rectangle{ 0, 0, 300, 300 }, Rectangle{ 0, 100, 300, 300 }, 0 },
+        { 6, 270, Rectangle{ 0, 0, 612, 792 }, Rectangle{ 0, 0, 612, 792 }, 1 },
+        { 8, -1, Rectangle{ 0, 0, 612, 792 }, Rectangle{ 0, 0, 612, 792 }, 1 },   // Rotate 1
+    }
+    if len(pages) != len(tests) {
+        t.Fatalf( "Pages: got %d, expected %d", len(pages), len(tests) )
+    }
+    for i, test := range tests {
+        p := pages[i]
+        if p.Object( ).ID( ) != test.id {
+            t.Errorf( "Page %d: got object %d, expected %d", i, p.Object( ).ID( ), test.id )
+        }
+        r, err := p.Rotate( )
+        if test.rotate == -1 && err == nil {
+            t.Errorf( "Page %d Rotate: got %d, expected an error", i, r )
+        } else if test.rotate != -1 && (err != nil || r != test.rotate) {
+            t.Errorf( "Page %d Rotate: got %d %v, expected %d", i, r, err, test.rotate )
+        }
+        if r, err := p.MediaBox( ); err != nil || r != test.media {
+            t.Errorf( "Page %d MediaBox: got %v %v, expected %v", i, r, err, test.media )
+        }
+        if r, err := p.CropBox( ); err != nil || r != test.crop {
+            t.Errorf( "Page %d CropBox: got %v %v, expected %v", i, r, err, test.crop )
+        }
+        res, err := p.Resources( )
+        if fonts, _ := res.data["Font"].(Dictionary); err != nil || fonts.Len() != test.fonts {
+            t.Errorf( "Page %d Resources: got %v %v, expected %d fonts", i, res, err, test.fonts )
+        }
+    }
+
+    p := pages[0]
+    for _, key := range []string{ "Count", "Kids", "Contents" } {   // not inheritable
+        if v, err := p.Inherited( key ); v != nil || err != nil {
+            t.Errorf( "Inherited( %s ): got %v %v, expected nil", key, v, err )
+        }
+    }
+    if v, err := p.Inherited( "Type" ); v != Name("Page") || err != nil {
+        t.Errorf( "Inherited( Type ): got %v %v, expected /Page", v, err )
+    }
+    if v, err := pages[3].Inherited( "Rotate" ); v != Number(1) || err != nil {   // resolved
+        t.Errorf( "Inherited( Rotate ): got %v %v, expected 1", v, err )
+    }
+}
+
+func TestPageRotate( t *testing.T ) {
+    tests := []struct {
+        rotate  interface{}
+        r       int
+        valid   bool
+    }{
+        { Number(0), 0, true }, { Number(90), 90, true }, { Number(-90), 270, true },
+        { Number(720), 0, true }, { Number(-630), 90, true }, { Number(270.0), 270, true },
+        { Number(90.5), 0, false }, { Number(45.0001), 0, false }, { Number(45), 0, false },
+        { Number(-180.25), 0, false }, { Name("90"), 0, false },
+    }
+    pf := parseTestPageTree( t )
+    p, err := pf.Page( 3 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, test := range tests {
+        dict := p.Dict( ).clone( )
+        dict.Set( "Rotate", test.rotate )
+        p.obj.SetValue( dict )
+        r, err := p.Rotate( )
+        if test.valid && (err != nil || r != test.r) {
+            t.Errorf( "Rotate %v: got %d %v, expected %d", test.rotate, r, err, test.r )
+        } else if ! test.valid && err == nil {
+            t.Errorf( "Rotate %v: got %d, expected an error", test.rotate, r )
+        }
+    }
+
+    // without any Rotate in the page and its ancestors
+    dict := p.Dict( ).clone( )
+    dict.Delete( "Rotate" )
+    p.obj.SetValue( dict )
+    root := pf.ObjById[2]
+    rootDict := root.Value( ).(Dictionary).clone( )
+    rootDict.Delete( "Rotate" )
+    root.SetValue( rootDict )
+    if r, err := p.Rotate( ); err != nil || r != 0 {
+        t.Errorf( "No Rotate: got %d %v, expected 0", r, err )
+    }
+}
+
+func TestPageBoxErrors( t *testing.T ) {
+    pf := parseTestPageTree( t )
+    p, err := pf.Page( 1 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, box := range []interface{}{ NewArray( Number(0), Number(0), Number(1) ),
+                                       NewArray( Number(0), Number(0), Number(1), Name("A") ), Number(1) } {
+        dict := p.Dict( ).clone( )
+        dict.Set( "MediaBox", box )
+        p.obj.SetValue( dict )
+        if _, err = p.MediaBox( ); err == nil {
+            t.Errorf( "MediaBox %v: no error", box )
+        }
+        if _, err = p.CropBox( ); err == nil {
+            t.Errorf( "CropBox with MediaBox %v: no error", box )
+        }
+    }
+    dict := p.Dict( ).clone( )
+    dict.Delete( "MediaBox" )
+    dict.Set( "CropBox", Name("None") )
+    p.obj.SetValue( dict )
+    if _, err = p.CropBox( ); err == nil {
+        t.Errorf( "CropBox /None: no error" )
+    }
+}
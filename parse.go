@@ -6,7 +6,9 @@ import (
     "os"
     "io"
     "bytes"
+    "errors"
 
+    "math"
     "sort"
     "strconv"
     "strings"
@@ -18,6 +20,7 @@ const (
     DEFAULT_DICTIONARY_SIZE = 8         // by default a small size
     _DEFAULT_STREAM_SIZE = 16
     _STARTXREF_SIZE = 512               // may need to be much larger
+    _LENGTH_OBJECT_SIZE = 256           // max size of an indirect stream length definition
 )
 
 type PdfFile struct {
@@ -32,18 +35,34 @@ type PdfFile struct {
     ObjById     map[int64]*PdfObject     // object by ID, with start & stop offsets
 
     // from trailer
-    Trailer     pdfDictionary            // unmodified, followed by the extracted values:
+    Trailer     Dictionary               // unmodified, followed by the extracted values:
     Size        int64                    // always available
-    Catalog     pdfReference             // always available, also know as Root
-    Encrypt     pdfReference             // reference ID = 0 if not available
-    Info        pdfReference             // reference ID = 0 if not available
-    Id          *PdfArray                // nil if not available
+    Catalog     Reference                // always available, also know as Root
+    Encrypt     Reference                // reference ID = 0 if not available
+    Info        Reference                // reference ID = 0 if not available
+    Id          *Array                   // nil if not available
+
+    Rebuilt     *RebuildReport           // nil if XREF sections were used
+    Revisions   []*Revision              // from the original document, nil if XREF was rebuilt
+
+    Diagnostics []Diagnostic             // collected while parsing and checking
+
+    // for incremental updates
+    inputSize   int64                    // size of the parsed input, 0 if none
+    inputEOL    bool                     // parsed input ends with an end of line
+    deleted     []Reference              // objects deleted since parsing or last update
+
+    security    *securityHandler         // nil if the parsed document was not encrypted
 }
 
 type PdfObject   struct {                 // sortable by start offset
     id, gen     int64
     start, stop int64
+    stream      int64                    // containing object stream ID, 0 if not compressed
+    index       int64                    // index in containing object stream
     value       interface{}
+    modified    bool                     // new or modified since parsing or last update
+    created     bool                     // new since parsing or last update, not in the file
 }
 
 type objBoundaries [](*PdfObject)
@@ -59,35 +78,46 @@ func (ob objBoundaries)Swap(i, j int) {
     ob[i], ob[j] = ob[j], ob[i]
 }
 
-// pdf type mapping to go types
-type pdfBool        bool
-type pdfNumber      float64
-type pdfString      string
-type pdfHexString   string
-type pdfName        string
+/*
+pdf type mapping to go types. An object value is one of Bool, Number,
+String, HexString, Name, Dictionary, Stream, Array, Null or Reference.
+
+String and HexString are both byte strings, without any escape sequence
+or hexadecimal encoding: they differ only in the way they are serialized.
+*/
+type Bool        bool
+type Number      float64
+type String      string
+type HexString   string
+type Name        string
 
-type pdfDictionary  struct {
+type Dictionary  struct {
     keys       []string     // (file) ordered list of map keys
     data        map[string]interface{}
 }
 
-type pdfStream      struct {
-    extent      pdfDictionary
+type Stream      struct {
+    extent      Dictionary
     data        []byte
 }
 
-type PdfArray       struct {
+type Array       struct {
     data        []interface{}
 }
 
-type pdfNull        struct { }
+// Deprecated: PdfArray is the previous name of Array
+type PdfArray = Array
+
+type Null        struct { }
 
-type pdfReference   struct {
+type Reference   struct {
     id, gen     int64
 }
 
 type fileInput struct {
-    fd          *os.File
+    r           io.ReaderAt
+    pos         int64   // next read position in r
+
 
     size        int64   // total file size
     bStart      int64   // current buffer file offset
@@ -107,8 +137,17 @@ type fileInput struct {
     savedOffset int     // -1 indicate no saved token
     savedPos    int64   // valid only if saved token is not ""
 
-    verbose     bool    // print warnings and other information while parsing
+    diag        *diagnostics    // collected while parsing
+    freed       map[int64]int64 // free entries of newer XREF sections (ID: generation)
+    lengthOf    func( ref Reference ) ( int64, bool )   // indirect stream length, once objects are located
+    deferLength bool    // lengths in object streams are not loaded yet
+    pending     bool    // current stream length is in an object stream
+    deferred    []*PdfObject    // objects to load after those in object streams
+    inMemory    bool    // input is not the file itself (e.g. object stream)
     fix         bool    // try to recover from wrong PDF syntax
+
+    userPassword    string  // for encrypted documents
+    ownerPassword   string
 }
 
 func (fi *fileInput) parseErrorf( format string, a ...interface{} ) error {
@@ -116,9 +155,34 @@ func (fi *fileInput) parseErrorf( format string, a ...interface{} ) error {
     return fmt.Errorf( f, a... )
 }
 
-func (fi *fileInput) printParseInfo( format string, a ...interface{} ) {
-    fmt.Fprintf( os.Stderr, "Around offset 0x%x: ", fi.bStart + int64(fi.offset) )
-    fmt.Fprintf( os.Stderr, format, a... )
+// collect a diagnostic at the current input position (or at the current object
+// position for a memory input, since memory offsets are not file offsets)
+func (fi *fileInput) report( severity Severity, code string, fixed bool, format string, a ...interface{} ) {
+    if fi.inMemory {
+        fi.diag.add( severity, code, fixed, format, a... )
+    } else {
+        fi.diag.addAt( fi.bStart + int64(fi.offset), severity, code, fixed, format, a... )
+    }
+}
+
+// set the next read position in the input
+func (fi *fileInput) seek( pos int64 ) error {
+    if pos < 0 || pos > fi.size {
+        return fmt.Errorf( "invalid position %d in input of size %d", pos, fi.size )
+    }
+    fi.pos = pos
+    return nil
+}
+
+// read from the current read position, as much as possible up to len(b).
+// Like for io.Reader, io.EOF is returned only if nothing could be read.
+func (fi *fileInput) read( b []byte ) ( int, error ) {
+    n, err := fi.r.ReadAt( b, fi.pos )
+    fi.pos += int64(n)
+    if n > 0 && err == io.EOF {
+        err = nil
+    }
+    return n, err
 }
 
 func (fi *fileInput)refill() error {
@@ -139,7 +203,7 @@ func (fi *fileInput)refill() error {
     var err error
     if end > int64(remaining) {                 // something to read
         tmpBuf := fi.buffer[remaining:end]      // keep unread fi.buffer data ptr
-        n, err = fi.fd.Read( tmpBuf )
+        n, err = fi.read( tmpBuf )
         if err != nil {
             if err != io.EOF { return err }
         } // n == 0 if io.EOF
@@ -158,7 +222,7 @@ func (fi *fileInput)fillBuffer( size, fl int64 ) error {
 
     if size == 0 { return nil }
 
-    _, err := fi.fd.Seek( fl, os.SEEK_SET )
+    err := fi.seek( fl )
     if err != nil { return err }
     fi.bStart = fl                // buffer start position in file
     fi.stopAt = fl + size         // set limits next refill if needed
@@ -167,7 +231,7 @@ func (fi *fileInput)fillBuffer( size, fl int64 ) error {
         size = INPUT_BUFFER_SIZE
     }
     tmpBuf := fi.buffer[0:size]
-    n, err := fi.fd.Read( tmpBuf )
+    n, err := fi.read( tmpBuf )
     if err != nil && err != io.EOF { return err }
 
     fi.buffer = fi.buffer[0:n]  // use buffer from 0 to n read bytes
@@ -222,10 +286,9 @@ func (fi *fileInput)readUpToEndstream( ) ( int64, error ) {
             end += fi.offset
             fi.bytes = append( fi.bytes, fi.buffer[fi.offset:end]... )
             fi.offset = end + len("endstream")
-            if fi.verbose {
-                fi.printParseInfo( "Found 'endstream' after %d bytes\n",
-                                   fi.getFilePos() - start - int64(len("endstream")) )
-            }
+            fi.report( SEVERITY_INFO, DIAG_STREAM_LENGTH, false,
+                       "Found 'endstream' after %d bytes\n",
+                        fi.getFilePos() - start - int64(len("endstream")) )
             return int64( len( fi.bytes ) ), nil
         }
         fi.bytes = append( fi.bytes, fi.buffer[fi.offset:]... ) 
@@ -353,24 +416,25 @@ func (fi *fileInput)nextToken( ) {
                 }
 
             case '<', '>' :
-                if sb.Len() > 1 {       // something before '<' or '>' is a first token
-                    fi.token = sb.String()
-                    fi.offset = i       // do not consume following '<' or '>'
+                if sb.Len() == 1 && ( previous == '<' || previous == '>' ) {
+                    if previous == b {  // 1 identical previous char
+                        fi.token = sb.String() + string(b)  // token is '<<' or '>>'
+                        fi.offset = i + 1   // consume second '<' or '>'
+                    } else {            // e.g. '<>', empty hex string
+                        fi.token = sb.String()
+                        fi.offset = i
+                    }
                     return
                 }
-                if previous == '<' {    // 1 previous char
-                    fi.token = "<<"     // token is '<<'
-                    fi.offset = i + 1   // consume second '<'
-                    return
-                } else if previous == '>' {
-                    fi.token = ">>"     // token is '>>'
-                    fi.offset = i + 1   // consume second '>'
+                if sb.Len() > 0 {       // something before '<' or '>' is a first token
+                    fi.token = sb.String()
+                    fi.offset = i       // do not consume following '<' or '>'
                     return
                 }                       // else 0 previous char keep going at least one round
 
             case '/':
                 if sb.Len() > 0 {       // not starting a new token
-                    fi.token = string(b)
+                    fi.token = sb.String()
                     fi.offset = i       // not consumed
                     return
                 }                       // else must be the start of a name
@@ -397,17 +461,18 @@ func (fi *fileInput)nextToken( ) {
         // refill takes care of moving data from fi.offset to buffer end
         // to the head of the new buffer before filling the rest of the
         // buffer with new data.
-        if err := fi.refill( ); err != nil {
-            fi.offset = i
+        err := fi.refill( )
+        if fi.savedOffset != -1 {   // update saved offset after refill, even at
+//fmt.Printf( "Fixing saved offset from 0x%x to 0X0\n", fi.savedOffset )
+            fi.savedOffset = 0      // the end, to point to the beginning of new token
+        }
+        if err != nil {
+            fi.offset = len( fi.buffer ) // refill moved the token to the buffer start
             fi.token = sb.String()  // premature end of token
             return
         }
         i = sb.Len()                // resume token where we stopped
         end = len( fi.buffer )
-        if fi.savedOffset != -1 {   // update saved offset after refill
-//fmt.Printf( "Fixing saved offset from 0x%x to 0X0\n", fi.savedOffset )
-            fi.savedOffset = 0      // to point to the beginning of new token
-        }
     }
  }
 
@@ -459,37 +524,49 @@ func (fi *fileInput)ungetByte( ) {
     fi.offset --
 }
 
-// consumes from 0 byte to 3 bytes
+// decode an escape sequence in a literal string: consumes from 0 byte to 3 bytes
 func writeEscapeSeq( fi *fileInput, sb *strings.Builder ) {
 
     switch c := fi.getByte( ); c {
-    case 'n', 'r', 't', 'b', 'f', '(', ')', '\\', '\n', '\r':
-        (*sb).WriteByte( '\\' )
+    case 'n':
+        (*sb).WriteByte( '\n' )
+    case 'r':
+        (*sb).WriteByte( '\r' )
+    case 't':
+        (*sb).WriteByte( '\t' )
+    case 'b':
+        (*sb).WriteByte( '\b' )
+    case 'f':
+        (*sb).WriteByte( '\f' )
+    case '(', ')', '\\':
         (*sb).WriteByte( c )
+    case '\r':      // line continuation, \r\n is a single EOL
+        if fi.getByte( ) != '\n' {
+            fi.ungetByte()
+        }
+    case '\n':      // line continuation
 
     default:    // octal number
         if c < 0x30 || c > 0x37 {
             fi.ungetByte()  // ignore '\' not followed by valid characters
             return
         }
-        (*sb).WriteByte( '\\' )
-        (*sb).WriteByte( c )
-        c = fi.getByte( )
-        if c < 0x30 || c > 0x37 {
-            fi.ungetByte()
-            return
-        }
-        (*sb).WriteByte( c )
-        c = fi.getByte()
-        if c < 0x30 || c > 0x37 {
-            fi.ungetByte()
-            return
+        v := c - 0x30
+        for i := 0; i < 2; i++ {
+            c = fi.getByte( )
+            if c < 0x30 || c > 0x37 {
+                fi.ungetByte()
+                break
+            }
+            v = v << 3 + c - 0x30   // high-order overflow is ignored
         }
-        (*sb).WriteByte( c )
+        (*sb).WriteByte( v )
     }
 }
 
-func getLiteralString( fi *fileInput ) ( pdfString, error ) {
+// the returned string is made of the actual bytes, after decoding escape
+// sequences and replacing end of lines with a single LF
+func getLiteralString( fi *fileInput ) ( String, error ) {
     var sb strings.Builder
 //    fmt.Printf("getLiteralString\n" )
     openCount := 1
@@ -508,7 +585,7 @@ func getLiteralString( fi *fileInput ) ( pdfString, error ) {
 //                    fmt.Printf( "End of literal string, next=0x%x\n", i+1 )
                     fi.offset = i + 1
                     fi.nextToken()
-                    return pdfString( sb.String() ), nil
+                    return String( sb.String() ), nil
                 }
                 sb.WriteByte( c )
             case '\\':
@@ -518,13 +595,19 @@ func getLiteralString( fi *fileInput ) ( pdfString, error ) {
                 end = len(fi.buffer)   // buffer & offset may have changed
                 i = fi.offset -1       // i++ at the end of the loop
 //                fmt.Printf( "Exiting escape sequence with end %x, i %x\n", end, i )
+            case '\r':
+                sb.WriteByte( '\n' )
+                if i + 1 < end && fi.buffer[i+1] == '\n' {
+                    i++
+                }
             default:
                 sb.WriteByte( c )
             }
         }
+        fi.offset = end
         err := fi.refill()
         if err != nil {
-            return pdfString(""), fi.parseErrorf( "End of file within a literal string (%s)\n", sb.String() )
+            return String(""), fi.parseErrorf( "End of file within a literal string (%s)\n", sb.String() )
         }
     }
 }
@@ -547,11 +630,8 @@ func checkExpectedEndStream( fi *fileInput, l, stop int64 ) int64 {
         localBuffer = fi.buffer[expectedEndOffset:expectedEndOffset+15]
     } else {    // use a small local buffer and read 256 bytes
         localBuffer = make( []byte, 256 )
-        pos, _ := fi.fd.Seek( 0, os.SEEK_CUR )
-        fi.fd.Seek( expectedEndPos, os.SEEK_SET )
-        n, err := fi.fd.Read( localBuffer )
-        fi.fd.Seek( pos, os.SEEK_SET )
-        if err != nil { return -1 }
+        n, _ := fi.r.ReadAt( localBuffer, expectedEndPos )
+        if n == 0 { return -1 }
         localBuffer = localBuffer[:n]
     }
     end = bytes.Index( localBuffer, []byte("endstream") )
@@ -586,9 +666,8 @@ func getStreamBytes( fi *fileInput, l, stop int64 ) ( []byte, error ) {
         if ! fi.fix {
             return nil, fi.parseErrorf( "No 'endstream' at stream end (length %d)\n", l )
         }
-        if fi.verbose {
-            fi.printParseInfo( "No 'endstream' at stream end (length %d): searching in stream data and beyond\n", l )
-        }
+        fi.report( SEVERITY_WARNING, DIAG_STREAM_LENGTH, true,
+                   "No 'endstream' at stream end (length %d): searching in stream data and beyond\n", l )
         var err error
         actual, err = fi.readUpToEndstream( )
         if err != nil {
@@ -599,9 +678,8 @@ func getStreamBytes( fi *fileInput, l, stop int64 ) ( []byte, error ) {
             if ! fi.fix {
                 return nil, fi.parseErrorf( "Stream length %d does not match actual length: %d\n", l, actual )
             }
-            if fi.verbose {
-                fi.printParseInfo( "Stream length %d does not match actual length: %d\n", l, actual )
-            }
+            fi.report( SEVERITY_WARNING, DIAG_STREAM_LENGTH, true,
+                       "Stream length %d does not match actual length: %d\n", l, actual )
         }
         if l = fi.readNBytes( actual ); l < actual {    // something is wrong
             panic( fmt.Sprintf( "Stream object with wrong length %d: remaining %d\n", actual, l ) )
@@ -616,6 +694,60 @@ func getStreamBytes( fi *fileInput, l, stop int64 ) ( []byte, error ) {
     return fi.bytes, nil
 }
 
+// return the indirect stream length defined by the object ref at offset start
+// in the input, without disturbing the current input position
+func parseLengthAt( fi *fileInput, ref Reference, start int64 ) ( int64, bool ) {
+    data := make( []byte, _LENGTH_OBJECT_SIZE )
+    n, _ := fi.r.ReadAt( data, start )
+    li := newMemoryInput( data[:n], nil, false )
+    li.nextToken()
+    id, gen, err := getIndirectObjectDef( li )
+    if err != nil || id != ref.id || gen != ref.gen {
+        return 0, false
+    }
+    v, err := getObjectDef( li, -1 )
+    if err != nil || li.token != "endobj" {
+        return 0, false
+    }
+    l, ok := v.(Number)
+    if ! ok || l < 0 || float64(l) != math.Trunc( float64(l) ) {
+        return 0, false
+    }
+    return int64(l), true
+}
+
+// return the stream length given by the Length value n, either a number or an
+// indirect reference resolved once objects are located in the input
+func getStreamLength( fi *fileInput, n interface{} ) ( int64, bool ) {
+    switch l := n.(type) {
+    case Number:
+        return int64(l), true
+    case Reference:
+        if fi.lengthOf != nil {
+            return fi.lengthOf( l )
+        }
+    }
+    return 0, false
+}
+
+// used when the stream length is not known (e.g. an indirect reference that
+// cannot be resolved): the data ends at the first endstream keyword, which may
+// be in binary data.
+func getUnknownLengthStreamBytes( fi *fileInput ) ( []byte, error ) {
+    if skip, err := fi.skipCurrentEOL( true ); ! skip || err != nil {
+        return nil, fi.parseErrorf( "Stream keyword without following EOL\n" )
+    }
+    l, err := fi.readUpToEndstream( )
+    if err != nil {
+        return nil, err
+    }
+    // the EOL preceding endstream is not part of the data
+    if l > 0 && fi.bytes[l-1] == '\n' { l-- }
+    if l > 0 && fi.bytes[l-1] == '\r' { l-- }
+    fi.nextToken()  // get ready for the next token
+    return fi.bytes[:l], nil
+}
+
 func getDictionaryOrStream( fi *fileInput, stop int64 ) ( interface{}, error ) {
 //    fmt.Printf( "getDictionaryOrStream, offset: 0x%x, stop: 0x%0x\n", fi.offset, stop )
     k := make( []string, 0, DEFAULT_DICTIONARY_SIZE )
@@ -630,18 +762,38 @@ func getDictionaryOrStream( fi *fileInput, stop int64 ) ( interface{}, error ) {
                 n, ok := m["Length"]; if ! ok {
                     return nil, fi.parseErrorf(  "Stream object without Length in dictionary: %v\n", m )
                 }
-                l := n.(pdfNumber)
-                stream, err := getStreamBytes( fi, int64(l), stop )
+                l, ok := getStreamLength( fi, n )
+                if ! ok {
+                    desc := fmt.Sprintf( "%v", n )
+                    if ref, ok := n.(Reference); ok {
+                        desc = fmt.Sprintf( "%d %d R", ref.id, ref.gen )
+                    }
+                    if fi.pending {     // the object is loaded again later
+                        return nil, fi.parseErrorf( "Stream Length %s is in an object stream\n", desc )
+                    }
+                    if ! fi.fix {
+                        return nil, fi.parseErrorf( "Stream Length %s cannot be resolved\n", desc )
+                    }
+                    fi.report( SEVERITY_WARNING, DIAG_STREAM_LENGTH, true,
+                               "Stream Length %s cannot be resolved: searching for endstream\n", desc )
+                    stream, err := getUnknownLengthStreamBytes( fi )
+                    if err != nil {
+                        return nil, fmt.Errorf(  "Stream object error: %v", err )
+                    }
+                    return Stream{ extent: Dictionary{ k, m }, data: stream }, nil
+                }
+                stream, err := getStreamBytes( fi, l, stop )
                 if err != nil {
                     return nil, fmt.Errorf(  "Stream object error: %v", err )
                 }
                 if len(stream) != int(l) { // only possible if fi.fix is true, otherwise an error was returned
-                    fi.printParseInfo( "Setting stream extent to length %d (previously %d)\n", len(stream), int(l))
-                    m["Length"] = pdfNumber(len(stream))    // note that actual stream checking might change the length
+                    fi.report( SEVERITY_WARNING, DIAG_STREAM_LENGTH, true,
+                               "Setting stream extent to length %d (previously %d)\n", len(stream), int(l))
+                    m["Length"] = Number(len(stream))    // note that actual stream checking might change the length
                 }
-                return pdfStream{ extent: pdfDictionary{ k, m }, data: stream }, nil
+                return Stream{ extent: Dictionary{ k, m }, data: stream }, nil
             }
-            return pdfDictionary{ k, m }, nil
+            return Dictionary{ k, m }, nil
         }
         // expect a name first
         if fi.token[0] != '/' {
@@ -660,7 +812,7 @@ func getDictionaryOrStream( fi *fileInput, stop int64 ) ( interface{}, error ) {
     }
 }
 
-func getHexString( fi *fileInput ) ( pdfHexString, error ) {
+func getHexString( fi *fileInput ) ( HexString, error ) {
     var hsb strings.Builder
     var nibble byte
     // <xx..xx>
@@ -682,12 +834,12 @@ mainLoop:
                 if n & 1 == 0 { // first nibble
                     nibble = makeNibbleFromHexChar( c )
                     if nibble == 0xff {
-                        return pdfHexString(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", c )
+                        return HexString(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", c )
                     }
                 } else {        // second nibble
                     lower := makeNibbleFromHexChar( c )
                     if nibble == 0xff {
-                        return pdfHexString(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", c )
+                        return HexString(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", c )
                     }
                     hsb.WriteByte( nibble << 4 + lower )
                 }
@@ -697,11 +849,11 @@ mainLoop:
         fi.offset = end
         err := fi.refill()
         if err != nil {
-            return pdfHexString(""), fi.parseErrorf( "End of file within a hex string (%s)\n", hsb.String() )
+            return HexString(""), fi.parseErrorf( "End of file within a hex string (%s)\n", hsb.String() )
         }
     }
     fi.nextToken()
-    return pdfHexString( hsb.String() ), nil
+    return HexString( hsb.String() ), nil
 }
 
 func makeNibbleFromHexChar( c byte ) byte {
@@ -718,7 +870,7 @@ func makeNibbleFromHexChar( c byte ) byte {
     return c - 0x41 + 10    // A, B, C, D, E, F => 10, 11, 12, 13, 14, 15
 }
 
-func getName( fi *fileInput ) ( pdfName, error ) {
+func getName( fi *fileInput ) ( Name, error ) {
     var name strings.Builder
     token := fi.token
 //    fmt.Printf( "name token: '%s'\n", token )
@@ -726,18 +878,18 @@ func getName( fi *fileInput ) ( pdfName, error ) {
         switch token[i] {
         case '#':  // check for correctness but do not decode encoded name
             if i + 2 >= len(token) {
-                return pdfName(""), fi.parseErrorf( "Incomplete # escape in name\n" )
+                return Name(""), fi.parseErrorf( "Incomplete # escape in name\n" )
             }
             name.WriteByte( '#' )
             c := makeNibbleFromHexChar( token[i+1] )
             if c == 0xff {
-                return pdfName(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", token[i+1] )
+                return Name(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", token[i+1] )
             }
             name.WriteByte( token[i+1] )
             c <<= 4
             c += makeNibbleFromHexChar( token[i+2] )
             if c == 0xff {
-                return pdfName(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", token[i+2] )
+                return Name(""), fi.parseErrorf( "Not an hexadecimal digit: 0x%x\n", token[i+2] )
             }
             name.WriteByte( token[i+2] )
             i += 3
@@ -747,41 +899,41 @@ func getName( fi *fileInput ) ( pdfName, error ) {
         }
     }
     fi.nextToken()
-    return pdfName( name.String() ), nil
+    return Name( name.String() ), nil
 }
 
-func getArray( fi *fileInput, stop int64 ) ( PdfArray, error ) {
+func getArray( fi *fileInput, stop int64 ) ( Array, error ) {
     array := make( []interface{}, 0, 4 )   // expect mostly small arrays
     fi.nextToken()
     for {
         if fi.token == "]" {
             fi.nextToken()
 //            fmt.Printf( "Array: %v\n", array )
-            return PdfArray { array }, nil
+            return Array { array }, nil
         }
 //        fmt.Printf( "Array: token '%s'\n", fi.token )
         val, err := getObjectDef( fi, stop )
         if err != nil {
-            return PdfArray{nil}, fi.parseErrorf( "Array element is invalid: %v", err )
+            return Array{nil}, fi.parseErrorf( "Array element is invalid: %v", err )
         }
         array = append( array, val )
         if stop != -1 && stop <= fi.getFilePos() {
-            return PdfArray{nil}, fi.parseErrorf( "Array reached the end of object before ending (0x%x)\n", stop )
+            return Array{nil}, fi.parseErrorf( "Array reached the end of object before ending (0x%x)\n", stop )
         }
     }
 }
 
-func getNumber( fi *fileInput ) ( pdfNumber, error ) {
+func getNumber( fi *fileInput ) ( Number, error ) {
     rn, err := strconv.ParseFloat( fi.token, 64 )
     if err != nil {
-        return pdfNumber(0), fi.parseErrorf( "Invalid floating point number %s\n", fi.token )
+        return Number(0), fi.parseErrorf( "Invalid floating point number %s\n", fi.token )
     }
     fi.nextToken()
-    return pdfNumber( rn ), nil
+    return Number( rn ), nil
 }
 
 func getPositiveInteger( token string ) (int64, bool) {
-    if ( token[0] < '0' || token[0] > '9' ) && token[0] != '+' {
+    if token == "" || (( token[0] < '0' || token[0] > '9' ) && token[0] != '+') {
 //        fmt.Printf( "getPositiveInteger: illegal char 0x%x (%s)\n", token[0], token )
         return 0, false
     }
@@ -818,12 +970,12 @@ func getNumberOrObjRef( fi *fileInput ) ( interface{}, error ) {
             fi.nextToken()
 //            fmt.Printf( "getNumberOrObjRef returns indirect reference %d %d with next token='%s'\n",
 //                         id, g, fi.token )
-            return pdfReference{ id, g }, nil
+            return Reference{ id, g }, nil
         }
         fi.restoreCurrentToken()
     }
 //    fmt.Printf( "getNumberOrObjRef returns single integer number %d with next token='%s'\n", id, fi.token )
-    return pdfNumber( float64( id ) ), nil
+    return Number( float64( id ) ), nil
 }
 
 func getIndirectObjectDef( fi *fileInput ) ( int64, int64, error ) {
@@ -854,13 +1006,16 @@ func getObjectDef( fi *fileInput, stop int64 ) ( interface{}, error ) {
         return nil, fi.parseErrorf( "Reached the end of parent object before ending (0x%x)\n", stop )
     }
 //    fmt.Printf( "getObjectDef: token: '%s'\n", fi.token )
+    if fi.token == "" {
+        return nil, fi.parseErrorf( "Unexpected end of data in object definition\n" )
+    }
     switch fi.token[0] {
     case 't':           // boolean
         err = checkObjType( fi, "true" )
-        result = pdfBool( true )
+        result = Bool( true )
     case 'f':           // boolean
         err = checkObjType( fi, "false" )
-        result = pdfBool( false )
+        result = Bool( false )
     case '(':           // string
         result, err = getLiteralString( fi )
     case '<':           // hex string or dictionary
@@ -879,7 +1034,7 @@ func getObjectDef( fi *fileInput, stop int64 ) ( interface{}, error ) {
         }
     case 'n':           // null
         err = checkObjType( fi, "null" )
-        result = pdfNull{ }
+        result = Null{ }
     case '-', '.' :     // negative number, either integer or real, or positive real number
         result, err = getNumber( fi )
     case '+':           // may be start of a positive number or integer in an object reference?
@@ -897,35 +1052,35 @@ func getObjectDef( fi *fileInput, stop int64 ) ( interface{}, error ) {
 
 func printPdfObj( obj interface{}, indent string ) {
     switch obj := obj.(type) {
-    case pdfBool:
+    case Bool:
         fmt.Printf("%s Bool %t\n", indent, obj )
-    case pdfNumber:
+    case Number:
         fmt.Printf("%s Number %g\n", indent, obj )
-    case pdfString:
+    case String:
         fmt.Printf("%s String %s\n", indent, obj )
-    case pdfHexString:
+    case HexString:
         fmt.Printf("%s Hex string 0x%x\n", indent, obj )
-    case pdfName:
+    case Name:
         fmt.Printf("%s Name %s\n", indent, obj )
-    case pdfDictionary:
+    case Dictionary:
         fmt.Printf("%s Dictionary:\n", indent )
         for k, v := range( obj.data ) {
             fmt.Printf( "%s   %s: ", indent, k )
             printPdfObj( v, indent + "  " )
         }
-    case pdfStream:
+    case Stream:
         fmt.Printf( "%s Stream extent:\n", indent )
         printPdfObj( obj.extent, indent + "  " )
         fmt.Printf( "%s Stream length: %d\n", indent, len( obj.data ) )
-    case PdfArray:
+    case Array:
         fmt.Printf("%s Array:\n", indent)
         for i, v := range( obj.data ) {
             fmt.Printf("%s   %d: ", indent, i )
             printPdfObj( v, indent + "  " )
         }
-    case pdfNull:
+    case Null:
         fmt.Printf( "%s Null", indent )
-    case pdfReference:
+    case Reference:
         fmt.Printf( "%s Indirect object reference: id %d generation %d\n",
                     indent, obj.id, obj.gen )
     }
@@ -937,9 +1092,7 @@ func (pf *PdfFile) parseObjects( fi *fileInput, objStart, objEnd int64 ) error {
         fi.fillBuffer( objEnd - objStart, objStart )
     }
 //    fmt.Printf( "parseObjects: objStart: 0x%x, offset 0x%x, objEnd: 0x%x\n", fi.bStart, fi.offset, fi.stopAt )
-    if fi.verbose {
-        fi.printParseInfo( "Body starts\n" )
-    }
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false, "Body starts\n" )
 
     for {
         fi.nextToken()
@@ -963,6 +1116,7 @@ func (pf *PdfFile) parseObjects( fi *fileInput, objStart, objEnd int64 ) error {
 //        fmt.Printf( "ObjById: %d %d, start 0x%x, end 0x%x\n",
 //                    objDef.id, objDef.gen, objDef.start, objDef.stop )
             if objDef.start == -1 { objDef.start = offset }
+            fi.diag.setObject( id, offset )
             obj, err := getObjectDef( fi, objDef.stop )
             if err != nil {
                 return fmt.Errorf( "Indirect Object %d %d has an invalid definition: %v", id, gen, err )
@@ -975,10 +1129,21 @@ func (pf *PdfFile) parseObjects( fi *fileInput, objStart, objEnd int64 ) error {
             if objDef.stop == -1 { objDef.stop = fi.tokFilePos }
             pf.Objects = append( pf.Objects, objDef ) // keep pointer to objects in file order
 
-        } else {
+        } else if objDef != nil && objDef.start == offset && objDef.stop != -1 {
             fi.skipInBufferTo( objDef.stop ) // skip object
+        } else {                            // not in XREF table: parse it, but ignore it
+            fi.diag.setObject( id, offset )
+            if _, err := getObjectDef( fi, -1 ); err != nil {
+                return fmt.Errorf( "Indirect Object %d %d has an invalid definition: %v", id, gen, err )
+            }
+            if fi.token != "endobj" {
+                return fi.parseErrorf( "Indirect Object %d %d does not end with 'endobj': %s\n", id, gen, fi.token )
+            }
+            fi.report( SEVERITY_WARNING, DIAG_XREF, true,
+                       "Indirect Object %d %d is not in the XREF table, ignored\n", id, gen )
         }
     }
+    fi.diag.setObject( 0, -1 )
     return nil
 }
 
@@ -986,10 +1151,9 @@ func (pf *PdfFile) parseObjects( fi *fileInput, objStart, objEnd int64 ) error {
 //     fmt.Printf( "obj ID %d, gen %d, offset 0x%x ends @0x%x\n", ob.id, ob.gen, ob.start, ob.stop )
 // }
 
-func (pf *PdfFile) parseXrefSubsection( fi *fileInput, maxObjPos, start, number int64 ) error {
-    if fi.verbose {
-        fi.printParseInfo( "XEF subsection [%d:%d] (included)\n", start, start + number -1 )
-    }
+func (pf *PdfFile) parseXrefSubsection( fi *fileInput, rev *Revision, maxObjPos, start, number int64 ) error {
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+               "XEF subsection [%d:%d] (included)\n", start, start + number -1 )
 
     // start & eventually stop offset of each object after sorting by start offset
     boundaries := make( objBoundaries, number )
@@ -1042,6 +1206,7 @@ func (pf *PdfFile) parseXrefSubsection( fi *fileInput, maxObjPos, start, number
 //                return fi.parseErrorf( "invalid end of line 0x%x%x\n", fi.bytes[18], fi.bytes[19] )
 //            }
 //        }
+        rev.entries = append( rev.entries, xrefEntry{ id: start+i, gen: gen, start: offset, inUse: inUse } )
         if inUse {  // free objects are just ignored
             if badOffset != -1 || offset > maxObjPos {
                 if ! fi.fix {
@@ -1049,10 +1214,9 @@ func (pf *PdfFile) parseXrefSubsection( fi *fileInput, maxObjPos, start, number
                                            offset, maxObjPos )
                 }
                 if badOffset == -1 {
-                    if fi.verbose {
-                        fi.printParseInfo( "XREF object offset 0x%x beyond object range (max 0x%x)\n",
-                                           offset, maxObjPos )
-                    }
+                    fi.report( SEVERITY_WARNING, DIAG_XREF, true,
+                               "XREF object offset 0x%x beyond object range (max 0x%x)\n",
+                                offset, maxObjPos )
                     badOffset = nInUse // one bad offset invalids all previous and following offsets
                 } 
                 offset = -1 // invalid start offset
@@ -1078,6 +1242,9 @@ func (pf *PdfFile) parseXrefSubsection( fi *fileInput, maxObjPos, start, number
 
     for i := int64(0); i < nInUse; i++ {
         id := boundaries[i].id
+        if _, ok := fi.freed[id]; ok {  // freed in a newer section
+            continue
+        }
         if _, ok := pf.ObjById[id]; ! ok { // do not replace previously found objboundaries (they were updated)
             pf.ObjById[id] = boundaries[i]
         }
@@ -1085,81 +1252,60 @@ func (pf *PdfFile) parseXrefSubsection( fi *fileInput, maxObjPos, start, number
     return nil
 }
 
-func (pf *PdfFile) parseXrefTable( fi *fileInput, xrefStart, xrefEnd int64 ) error {
-
-    // estimate an upper bound for the xref table size. This includes the
-    // trailer, which takes perhaps 2 or 3 XREF entries (40 to 60 bytes)
-    size := (xrefEnd - xrefStart) / XREF_ENTRY_SIZE
-    pf.ObjById = make( map[int64]*PdfObject, size )
-    fi.fillBuffer( xrefEnd - xrefStart, xrefStart ) // now use the full buffer
+// assumes the buffer is filled from xrefStart and the first token is ready
+func (pf *PdfFile) parseXrefTable( fi *fileInput, rev *Revision ) error {
 
 //    fmt.Printf( "parseXrefTable file start: 0x%x, offset: 0x%x, end: 0x%x\n", fi.bStart, fi.offset, fi.stopAt )
-    fi.nextToken()
     // cross-reference table is made of multiple xref sections
     // each xref section is made of 1 or more subsections
     // subsection: 1 line with 2 integers: start space number eol
     for {
         if fi.token != "xref" { return nil }
         fi.nextToken( )
-        start, ok := getPositiveInteger( fi.token )
-        if ! ok {
-            return fi.parseErrorf( "Incorrect xref section: start %s\n", fi.token )
-        }
-        fi.nextToken( )
-        number, ok := getPositiveInteger( fi.token )
-        if ! ok {
-            return fi.parseErrorf( "Incorrect xref section: number %s\n", fi.token )
-        }
-        fi.skipSpaces( false )     // skip spaces and comments
-        if err := pf.parseXrefSubsection( fi, xrefStart, start, number ); err != nil {
-            return fmt.Errorf( "Incorrect xref section: %v", err )
+        for {   // until the next token is not a subsection start
+            start, ok := getPositiveInteger( fi.token )
+            if ! ok {
+                return fi.parseErrorf( "Incorrect xref section: start %s\n", fi.token )
+            }
+            fi.nextToken( )
+            number, ok := getPositiveInteger( fi.token )
+            if ! ok {
+                return fi.parseErrorf( "Incorrect xref section: number %s\n", fi.token )
+            }
+            fi.skipSpaces( false )     // skip spaces and comments
+            if err := pf.parseXrefSubsection( fi, rev, rev.XrefOffset, start, number ); err != nil {
+                return fmt.Errorf( "Incorrect xref section: %v", err )
+            }
+            fi.nextToken()
+            if fi.token == "" || fi.token[0] < '0' || fi.token[0] > '9' { break }
         }
-        fi.nextToken()
     }
 }
 
-func (pf *PdfFile) getRefFromTrailer( dic pdfDictionary, key string ) *pdfReference {
+func (pf *PdfFile) getRefFromTrailer( dic Dictionary, key string ) *Reference {
     if v, ok := dic.data[ key ]; ok {
-        if ref, ok := v.(pdfReference); ok {
+        if ref, ok := v.(Reference); ok {
             return &ref
         }
     }
     return nil
 }
 
-func (pf *PdfFile) parseTrailer( fi *fileInput ) ( int64, error ) {
-//    fmt.Printf( "trailer file start: 0x%x, offset: 0x%x, end: 0x%x\n", fi.bStart, fi.offset, fi.stopAt )
-    if fi.verbose {
-        fi.printParseInfo( "Trailer starts\n" )
-    }
-
-    if fi.token != "trailer" {
-        return 0, fi.parseErrorf( "No trailer found: %s\n", fi.token )
-    }
-    fi.nextToken( )
-    if fi.token != "<<" {
-        return 0, fi.parseErrorf( "Trailer does not have a dictionary: %s\n", fi.token )
-    }
-    dos, err := getDictionaryOrStream( fi, fi.stopAt )
-    if err != nil {
-        return 0, fmt.Errorf( "Trailer dictionary is invalid: %v", err )
-    }
-    dic, ok := dos.(pdfDictionary)
-    if ! ok {
-        return 0, fi.parseErrorf( "Trailer does not have a dictionary (unexpected stream)\n" )
-    }
+// extract the values from a trailer dictionary, or from the equivalent
+// entries of a cross-reference stream dictionary
+func (pf *PdfFile) setTrailer( fi *fileInput, dic Dictionary ) error {
     pf.Trailer = dic
 //    printPdfObj( dic, "  " )
 
     if v, ok := dic.data["Size"]; ok {
-        pf.Size = int64(v.(pdfNumber))
+        pf.Size = int64(v.(Number))
     } else {
-        return 0, fi.parseErrorf( "Trailer dictionary does not provide XREF size\n" )
+        return fi.parseErrorf( "Trailer dictionary does not provide XREF size\n" )
     }
     if v := pf.getRefFromTrailer( dic, "Root" ); v != nil {
         pf.Catalog = *v
     } else {
-        return 0, fi.parseErrorf( "Trailer dictionary does not provide root catalog\n" )
+        return fi.parseErrorf( "Trailer dictionary does not provide root catalog\n" )
     }
 
     if v := pf.getRefFromTrailer( dic, "Encrypt" ); v != nil {
@@ -1171,19 +1317,44 @@ func (pf *PdfFile) parseTrailer( fi *fileInput ) ( int64, error ) {
     }
 
     if pa, ok := dic.data[ "ID" ]; ok {
-        if v, ok := pa.(PdfArray); ok {
+        if v, ok := pa.(Array); ok {
             pf.Id = &v
             a := v.data
-//            var Id0, Id1 pdfHexString
-//            Id0, ok = a[0].(pdfHexString)
-            _, ok = a[0].(pdfHexString)
-            if ! ok { return 0, fi.parseErrorf( "ID #0 is not an hexString: %v\n", a[0] ) }
-//            Id1, ok = a[1].(pdfHexString)
-            _, ok = a[1].(pdfHexString)
-            if ! ok { return 0, fi.parseErrorf( "ID #1 is not an hexString: %v\n", a[1] ) }
+//            var Id0, Id1 HexString
+//            Id0, ok = a[0].(HexString)
+            _, ok = a[0].(HexString)
+            if ! ok { return fi.parseErrorf( "ID #0 is not an hexString: %v\n", a[0] ) }
+//            Id1, ok = a[1].(HexString)
+            _, ok = a[1].(HexString)
+            if ! ok { return fi.parseErrorf( "ID #1 is not an hexString: %v\n", a[1] ) }
 //            fmt.Printf( "IDs: <%x> <%x>\n", Id0, Id1 )
         }
     }
+    return nil
+}
+
+func (pf *PdfFile) parseTrailer( fi *fileInput ) ( int64, error ) {
+//    fmt.Printf( "trailer file start: 0x%x, offset: 0x%x, end: 0x%x\n", fi.bStart, fi.offset, fi.stopAt )
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false, "Trailer starts\n" )
+
+    if fi.token != "trailer" {
+        return 0, fi.parseErrorf( "No trailer found: %s\n", fi.token )
+    }
+    fi.nextToken( )
+    if fi.token != "<<" {
+        return 0, fi.parseErrorf( "Trailer does not have a dictionary: %s\n", fi.token )
+    }
+    dos, err := getDictionaryOrStream( fi, fi.stopAt )
+    if err != nil {
+        return 0, fmt.Errorf( "Trailer dictionary is invalid: %v", err )
+    }
+    dic, ok := dos.(Dictionary)
+    if ! ok {
+        return 0, fi.parseErrorf( "Trailer does not have a dictionary (unexpected stream)\n" )
+    }
+    if err = pf.setTrailer( fi, dic ); err != nil {
+        return 0, err
+    }
 
     if fi.token != "startxref" {
         return 0, fi.parseErrorf( "Trailer does not have a start xref: %s\n", fi.token )
@@ -1201,27 +1372,511 @@ func (pf *PdfFile) parseTrailer( fi *fileInput ) ( int64, error ) {
         return 0, fi.parseErrorf( "Trailer does not have a correct EOF\n" )
     }
     if pXref, ok := dic.data["Prev"]; ok {
-        if v, ok := pXref.(pdfNumber); ok {
+        if v, ok := pXref.(Number); ok {
             return int64(v), nil
         }
     }
     return 0, nil    // not a possible XREF offset
 }
 
+// keys copied from a cross-reference stream dictionary into the trailer
+var xrefStreamTrailerKeys = map[string]bool{ "Size": true, "Prev": true, "Root": true,
+                                             "Encrypt": true, "Info": true, "ID": true }
+
+// big-endian field of w bytes, or def if the field is not present (w == 0)
+func getXrefField( data []byte, w int, def int64 ) int64 {
+    if w == 0 {
+        return def
+    }
+    var v int64
+    for _, b := range data[:w] {
+        v = v << 8 | int64(b)
+    }
+    return v
+}
+
+// assumes the buffer is filled from xrefStart and the first token is ready.
+// If isTrailer is true the stream dictionary also provides the trailer values
+func (pf *PdfFile) parseXrefStream( fi *fileInput, rev *Revision, xrefStart int64, isTrailer bool ) ( int64, error ) {
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false, "XREF stream starts\n" )
+    id, gen, err := getIndirectObjectDef( fi )
+    if err != nil {
+        return 0, fmt.Errorf( "XREF stream object ID or generation: %v", err )
+    }
+    obj, err := getObjectDef( fi, -1 )
+    if err != nil {
+        return 0, fmt.Errorf( "XREF stream object %d %d has an invalid definition: %v", id, gen, err )
+    }
+    stream, ok := obj.(Stream)
+    if ! ok {
+        return 0, fi.parseErrorf( "XREF object %d %d is not a stream\n", id, gen )
+    }
+    dic := stream.extent
+    if t, ok := dic.data["Type"].(Name); ! ok || t != "XRef" {
+        return 0, fi.parseErrorf( "Stream object %d %d is not a XREF stream\n", id, gen )
+    }
+
+    size := int64(getIntParameter( dic.data, "Size", -1 ))
+    if size < 0 {
+        return 0, fi.parseErrorf( "XREF stream dictionary does not provide XREF size\n" )
+    }
+    var w [3]int
+    wa, ok := dic.data["W"].(Array)
+    if ! ok || len(wa.data) != 3 {
+        return 0, fi.parseErrorf( "XREF stream dictionary does not provide valid field widths\n" )
+    }
+    for i, v := range wa.data {
+        n, ok := v.(Number)
+        if ! ok || n < 0 || n > 8 {
+            return 0, fi.parseErrorf( "XREF stream field width #%d is invalid: %v\n", i, v )
+        }
+        w[i] = int(n)
+    }
+    index := []interface{}{ Number(0), Number(size) }   // default [ 0 Size ]
+    if ia, ok := dic.data["Index"].(Array); ok {
+        if len(ia.data) & 1 == 1 {
+            return 0, fi.parseErrorf( "XREF stream index has an odd number of values\n" )
+        }
+        index = ia.data
+    }
+
+    data, err := decodeStream( &stream )
+    if err != nil {
+        return 0, fi.parseErrorf( "XREF stream %d %d cannot be decoded: %v", id, gen, err )
+    }
+
+    entrySize := w[0] + w[1] + w[2]
+    pos := 0
+    for i := 0; i < len(index); i += 2 {
+        start, ok1 := index[i].(Number)
+        number, ok2 := index[i+1].(Number)
+        if ! ok1 || ! ok2 {
+            return 0, fi.parseErrorf( "XREF stream index is invalid: %v %v\n", index[i], index[i+1] )
+        }
+        fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+                   "XREF stream subsection [%d:%d] (included)\n",
+                    int64(start), int64(start) + int64(number) - 1 )
+        for objId := int64(start); objId < int64(start) + int64(number); objId++ {
+            if pos + entrySize > len(data) {
+                if ! fi.fix {
+                    return 0, fi.parseErrorf( "XREF stream %d %d data is too short\n", id, gen )
+                }
+                fi.report( SEVERITY_WARNING, DIAG_XREF, true,
+                           "XREF stream %d %d data is too short, ignoring remaining entries\n", id, gen )
+                break
+            }
+            entry := data[pos:pos+entrySize]
+            pos += entrySize
+
+            t := getXrefField( entry, w[0], 1 )     // type 1 by default
+            f2 := getXrefField( entry[w[0]:], w[1], 0 )
+            f3 := getXrefField( entry[w[0]+w[1]:], w[2], 0 )
+            switch {
+            case t == 1 && f2 != xrefStart:
+                rev.entries = append( rev.entries, xrefEntry{ id: objId, gen: f3, start: f2, inUse: true } )
+            case t == 2:
+                rev.entries = append( rev.entries, xrefEntry{ id: objId, stream: f2, index: f3, inUse: true } )
+            case t == 0:
+                rev.entries = append( rev.entries, xrefEntry{ id: objId, gen: f3 } )
+            }
+            if _, ok := pf.ObjById[objId]; ok {     // do not replace newer definitions
+                continue
+            }
+            if _, ok := fi.freed[objId]; ok {       // freed in a newer section
+                continue
+            }
+            switch t {
+            case 1:                                 // in use, f2 is offset, f3 is generation
+                if f2 == xrefStart {                // the XREF stream itself
+                    continue
+                }
+                if f2 >= fi.size {
+                    if ! fi.fix {
+                        return 0, fi.parseErrorf( "Incorrect XREF object %d offset 0x%x (beyond end of file 0x%x)\n",
+                                                  objId, f2, fi.size )
+                    }
+                    fi.report( SEVERITY_WARNING, DIAG_XREF, true,
+                               "XREF object %d offset 0x%x beyond end of file, ignored\n", objId, f2 )
+                    continue
+                }
+                pf.ObjById[objId] = &PdfObject{ id: objId, gen: f3, start: f2, stop: -1 }
+            case 2:                                 // compressed, f2 is object stream ID, f3 is index
+                pf.ObjById[objId] = &PdfObject{ id: objId, start: -1, stop: -1, stream: f2, index: f3 }
+            }                                       // free (0) or unknown entry types are ignored
+        }
+    }
+
+    if isTrailer {
+        trailer := Dictionary{ make( []string, 0, DEFAULT_DICTIONARY_SIZE ),
+                                  make( map[string]interface{}, DEFAULT_DICTIONARY_SIZE ) }
+        for _, k := range dic.keys {
+            if xrefStreamTrailerKeys[k] {
+                trailer.keys = append( trailer.keys, k )
+                trailer.data[k] = dic.data[k]
+            }
+        }
+        if err = pf.setTrailer( fi, trailer ); err != nil {
+            return 0, err
+        }
+    }
+    if prev, ok := dic.data["Prev"].(Number); ok {
+        return int64(prev), nil
+    }
+    return 0, nil
+}
+
+// parse the cross-reference section starting at rev.XrefOffset, either a
+// table followed by a trailer or a cross-reference stream, and update rev
+// accordingly. It returns the offset of the previous section (0 if none).
+func (pf *PdfFile) parseXrefSection( fi *fileInput, rev *Revision, xrefEnd int64 ) ( int64, error ) {
+    xrefStart := rev.XrefOffset
+    fi.fillBuffer( xrefEnd - xrefStart, xrefStart ) // now use the full buffer
+    fi.nextToken()
+    if fi.token != "xref" {
+        rev.XrefStream = true
+        prev, err := pf.parseXrefStream( fi, rev, xrefStart, true )
+        if err != nil {
+            return 0, fmt.Errorf( "PDF Parser: invalid XREF stream: %v", err )
+        }
+        rev.Trailer = pf.Trailer
+        rev.End = findRevisionEnd( fi, fi.getFilePos(), xrefEnd )
+        pf.hideFreedEntries( fi, rev )
+        return prev, nil
+    }
+    if err := pf.parseXrefTable( fi, rev ); err != nil {
+        return 0, fmt.Errorf( "PDF Parser: invalid XREF table: %v", err )
+    }
+    prev, err := pf.parseTrailer( fi )
+    if err != nil {
+        return 0, fmt.Errorf( "PDF Parser: invalid trailer: %v", err )
+    }
+    rev.Trailer = pf.Trailer
+    rev.End = findRevisionEnd( fi, fi.getFilePos(), xrefEnd )
+    // hybrid file: the trailer refers to an additional XREF stream whose
+    // entries come after the table entries, but before the previous section
+    xrefStm, ok := pf.Trailer.data["XRefStm"].(Number)
+    if ! ok {
+        pf.hideFreedEntries( fi, rev )
+        return prev, nil
+    }
+    rev.XrefStream = true
+    stmStart := int64(xrefStm)
+    if stmStart <= 0 || stmStart >= fi.size {
+        return 0, fi.parseErrorf( "Invalid XRefStm offset 0x%x\n", stmStart )
+    }
+    fi.fillBuffer( fi.size - stmStart, stmStart )
+    fi.nextToken()
+    if _, err = pf.parseXrefStream( fi, rev, stmStart, false ); err != nil {
+        return 0, fmt.Errorf( "PDF Parser: invalid XRefStm stream: %v", err )
+    }
+    pf.hideFreedEntries( fi, rev )
+    return prev, nil
+}
+
+// Once a section is parsed, its free entries hide the entries with the same
+// IDs in older sections. Within a section, a free entry does not hide an entry
+// in use: in hybrid files, the objects compressed in object streams are given
+// as free in the table and in use in the XRefStm stream.
+func (pf *PdfFile) hideFreedEntries( fi *fileInput, rev *Revision ) {
+    for _, e := range rev.entries {
+        if e.inUse || e.id == 0 {      // object 0 is always the free list head
+            continue
+        }
+        if _, ok := pf.ObjById[e.id]; ok {
+            continue
+        }
+        if _, ok := fi.freed[e.id]; ! ok {
+            fi.freed[e.id] = e.gen
+        }
+    }
+}
+
+// parse the object located at obj.start, as given by the cross-reference section
+func (pf *PdfFile) parseObjectAt( fi *fileInput, obj *PdfObject ) error {
+    fi.diag.setObject( obj.id, obj.start )
+    end := obj.stop
+    if end == -1 {
+        end = fi.size
+    }
+    if err := fi.fillBuffer( end - obj.start, obj.start ); err != nil {
+        return fmt.Errorf( "Indirect Object %d %d cannot be read: %v", obj.id, obj.gen, err )
+    }
+    fi.nextToken()
+    id, gen, err := getIndirectObjectDef( fi )
+    if err != nil {
+        return fmt.Errorf( "Indirect Object ID or generation: %v", err )
+    }
+    if id != obj.id || gen != obj.gen {
+        return fi.parseErrorf( "Indirect Object %d %d found instead of object %d %d\n",
+                               id, gen, obj.id, obj.gen )
+    }
+    value, err := getObjectDef( fi, obj.stop )
+    if err != nil {
+        return fmt.Errorf( "Indirect Object %d %d has an invalid definition: %v", id, gen, err )
+    }
+    if fi.token != "endobj" {
+        return fi.parseErrorf( "Indirect Object %d %d does not end with 'endobj': %s\n", id, gen, fi.token )
+    }
+    obj.value = value
+    if obj.stop == -1 { obj.stop = fi.tokFilePos }
+    pf.Objects = append( pf.Objects, obj ) // keep pointer to objects in file order
+    return nil
+}
+
+// return the stream length given by the indirect object ref, as located by
+// the XREF sections. If ref is in an object stream that is not loaded yet,
+// the length is pending.
+func (pf *PdfFile) indirectLength( fi *fileInput, ref Reference ) ( int64, bool ) {
+    obj, ok := pf.ObjById[ref.id]
+    if ! ok || obj.gen != ref.gen {
+        return 0, false
+    }
+    if l, ok := obj.value.(Number); ok {    // already parsed
+        return int64(l), l >= 0 && float64(l) == math.Trunc( float64(l) )
+    }
+    if obj.stream != 0 {
+        fi.pending = fi.deferLength
+        return 0, false
+    }
+    if obj.start < 0 {
+        return 0, false
+    }
+    return parseLengthAt( fi, ref, obj.start )
+}
+
+// Instead of parsing bodies in sequence, objects are loaded directly from
+// their cross-reference offsets. This is required with cross-reference
+// streams, which can be anywhere in the file, and with incremental updates
+// since the same object may be defined in multiple bodies.
+func (pf *PdfFile) parseObjectsAt( fi *fileInput ) error {
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false, "Loading objects from XREF offsets\n" )
+    located := make( objBoundaries, 0, len(pf.ObjById) )
+    for _, obj := range pf.ObjById {
+        if obj.start >= 0 {     // compressed objects or unknown offsets are skipped
+            located = append( located, obj )
+        } else if obj.stream == 0 {
+            fi.report( SEVERITY_WARNING, DIAG_XREF, true,
+                       "Indirect Object %d %d offset is unknown, ignored\n", obj.id, obj.gen )
+        }
+    }
+    sort.Sort( located )        // sort by incrementing start offset
+
+    fi.deferred = nil
+    for i, obj := range located {
+        obj.stop = -1
+        if i < len(located) - 1 {
+            obj.stop = located[i+1].start
+        }
+        if err := pf.parseObjectAt( fi, obj ); err != nil {
+            if fi.pending {     // stream length is in an object stream
+                fi.pending = false
+                fi.deferred = append( fi.deferred, obj )
+                continue
+            }
+            return err
+        }
+    }
+    fi.diag.setObject( 0, -1 )
+    return nil
+}
+
+// Objects whose stream Length is compressed in an object stream are loaded
+// after compressed objects, and then decrypted if needed.
+func (pf *PdfFile) parseDeferredObjects( fi *fileInput ) error {
+    fi.deferLength = false
+    for _, obj := range fi.deferred {
+        fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+                   "Loading object %d %d after its compressed stream Length\n", obj.id, obj.gen )
+        if err := pf.parseObjectAt( fi, obj ); err != nil {
+            return err
+        }
+        if pf.security != nil {
+            v, err := pf.security.decryptValue( obj.value, obj.id, obj.gen )
+            if err != nil {
+                return fmt.Errorf( "Cannot decrypt object %d %d: %v", obj.id, obj.gen, err )
+            }
+            obj.value = v
+        }
+    }
+    fi.diag.setObject( 0, -1 )
+    fi.deferred = nil
+    return nil
+}
+
+// parse the pairs of integers (object number and offset) in the object
+// stream header, given in the first First bytes of the decoded data.
+func parseObjectStreamHeader( fi *fileInput, n int ) ( []int64, []int64, error ) {
+    ids := make( []int64, n )
+    offsets := make( []int64, n )
+    fi.nextToken()
+    for i := 0; i < n; i++ {
+        var ok bool
+        if fi.token == "" {
+            return nil, nil, fi.parseErrorf( "Object stream header is too short (%d objects)\n", i )
+        }
+        if ids[i], ok = getPositiveInteger( fi.token ); ! ok {
+            return nil, nil, fi.parseErrorf( "Invalid object number %s in object stream header\n", fi.token )
+        }
+        fi.nextToken()
+        if fi.token == "" {
+            return nil, nil, fi.parseErrorf( "Object stream header is too short (%d objects)\n", i )
+        }
+        if offsets[i], ok = getPositiveInteger( fi.token ); ! ok {
+            return nil, nil, fi.parseErrorf( "Invalid object offset %s in object stream header\n", fi.token )
+        }
+        if i > 0 && offsets[i] < offsets[i-1] {
+            return nil, nil, fi.parseErrorf( "Object offsets are not increasing in object stream header\n" )
+        }
+        fi.nextToken()
+    }
+    return ids, offsets, nil
+}
+
+// decode the object stream sid and parse its header. It returns the decoded
+// data, the offset of the first object in data, the object numbers and their
+// offsets relative to first.
+func (pf *PdfFile) getObjectStream( fi *fileInput, sid int64 ) ( []byte, int, []int64, []int64, error ) {
+    container, ok := pf.ObjById[sid]
+    if ! ok || container.value == nil {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d does not exist\n", sid )
+    }
+    stream, ok := container.value.(Stream)
+    if ! ok {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d is not a stream\n", sid )
+    }
+    dic := stream.extent.data
+    if t, ok := dic["Type"].(Name); ! ok || t != "ObjStm" {
+        return nil, 0, nil, nil, fmt.Errorf( "Stream object %d is not an object stream\n", sid )
+    }
+    n := getIntParameter( dic, "N", -1 )
+    first := getIntParameter( dic, "First", -1 )
+    if n < 0 || first < 0 {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d does not provide valid N or First\n", sid )
+    }
+    data, err := decodeStream( &stream )
+    if err != nil {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d cannot be decoded: %v", sid, err )
+    }
+    if first > len(data) {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d First %d is beyond the data end (%d)\n",
+                                             sid, first, len(data) )
+    }
+    ids, offsets, err := parseObjectStreamHeader( newMemoryInput( data[:first], fi.diag, fi.fix ), n )
+    if err != nil {
+        return nil, 0, nil, nil, fmt.Errorf( "Object stream %d: %v", sid, err )
+    }
+    return data, first, ids, offsets, nil
+}
+
+// load all objects compressed in the object stream sid, as indicated by the
+// XREF stream entries in objs.
+func (pf *PdfFile) parseObjectStream( fi *fileInput, sid int64, objs []*PdfObject ) error {
+    data, first, ids, offsets, err := pf.getObjectStream( fi, sid )
+    if err != nil {
+        return err
+    }
+    n := len(ids)
+
+    for _, obj := range objs {
+        if obj.index >= int64(n) {
+            return fmt.Errorf( "Object %d index %d is beyond object stream %d size %d\n",
+                               obj.id, obj.index, sid, n )
+        }
+        if ids[obj.index] != obj.id {
+            if ! fi.fix {
+                return fmt.Errorf( "Object stream %d index %d has object %d instead of %d\n",
+                                   sid, obj.index, ids[obj.index], obj.id )
+            }
+            fi.report( SEVERITY_WARNING, DIAG_OBJECT_STREAM, true,
+                       "Object stream %d index %d has object %d instead of %d, ignored\n",
+                        sid, obj.index, ids[obj.index], obj.id )
+            continue
+        }
+        start := int64(first) + offsets[obj.index]
+        end := int64(len(data))
+        if obj.index < int64(n) - 1 {
+            end = int64(first) + offsets[obj.index+1]
+        }
+        if start > end || end > int64(len(data)) {
+            return fmt.Errorf( "Object %d is beyond object stream %d data\n", obj.id, sid )
+        }
+        fi.diag.setObject( obj.id, -1 )
+        oi := newMemoryInput( data[start:end], fi.diag, fi.fix )
+        oi.nextToken()
+        if oi.token == "" {
+            return fmt.Errorf( "Object %d in object stream %d is empty\n", obj.id, sid )
+        }
+        value, err := getObjectDef( oi, -1 )
+        if err != nil {
+            return fmt.Errorf( "Object %d in object stream %d has an invalid definition: %v", obj.id, sid, err )
+        }
+        obj.value = value
+        pf.Objects = append( pf.Objects, obj )
+    }
+    return nil
+}
+
+// Objects compressed in object streams are loaded after all uncompressed
+// objects, including the object streams. Once their objects are extracted,
+// the object streams themselves are removed from the file objects.
+func (pf *PdfFile) parseCompressedObjects( fi *fileInput ) error {
+    byStream := make( map[int64][]*PdfObject )
+    sids := make( []int64, 0 )
+    for _, obj := range pf.ObjById {
+        if obj.stream == 0 {
+            continue
+        }
+        if _, ok := byStream[obj.stream]; ! ok {
+            sids = append( sids, obj.stream )
+        }
+        byStream[obj.stream] = append( byStream[obj.stream], obj )
+    }
+    sort.Slice( sids, func( i, j int ) bool { return sids[i] < sids[j] } )
+
+    for _, sid := range sids {
+        objs := byStream[sid]
+        sort.Slice( objs, func( i, j int ) bool { return objs[i].index < objs[j].index } )
+        fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+                   "Loading %d objects from object stream %d\n", len(objs), sid )
+        err := pf.parseObjectStream( fi, sid, objs )
+        fi.diag.setObject( 0, -1 )
+        if err != nil {
+            if ! fi.fix {
+                return err
+            }
+            fi.report( SEVERITY_WARNING, DIAG_OBJECT_STREAM, true, "Ignoring invalid object stream: %v", err )
+            continue
+        }
+        container := pf.ObjById[sid]
+        delete( pf.ObjById, sid )
+        for i, obj := range pf.Objects {
+            if obj == container {
+                pf.Objects = append( pf.Objects[:i], pf.Objects[i+1:]... )
+                break
+            }
+        }
+    }
+    return nil
+}
+
 func huntForXref( fi *fileInput ) ( int64, error ) {
     fi.offset = 0
-    start, err := fi.fd.Seek( -_STARTXREF_SIZE, os.SEEK_END )
-    if err != nil {
-        return -1, fmt.Errorf("Empty file: %v", err )
+    last := int64(_STARTXREF_SIZE)
+    if last > fi.size {         // small file
+        last = fi.size
+    }
+    start := fi.size - last
+    if err := fi.seek( start ); err != nil || last == 0 {
+        return -1, fmt.Errorf("Empty file\n" )
     }
     fi.bStart = start      // to allow reporting the proper error location
-    _, err = fi.fd.Read( fi.buffer )
+    n, err := fi.read( fi.buffer )
     if err != nil {
         return -1, fi.parseErrorf("Unable to read file: %v", err )
     }
     // search for 'startxref'
     token := []byte("startxref")
-    offset := bytes.LastIndex( fi.buffer, token )
+    offset := bytes.LastIndex( fi.buffer[:n], token )
     if -1 == offset {
         return -1, fi.parseErrorf("No 'startxref' in file\n" )
     }
@@ -1260,28 +1915,29 @@ func huntForXref( fi *fileInput ) ( int64, error ) {
         if ! fi.fix {
             return -1, fi.parseErrorf("Startxref value is beyond end of file (0x%x)\n", startXref )
         }
-        if fi.verbose {
-            fi.printParseInfo( "Startxref value is beyond end of file (0x%x) searching for XREF\n", startXref )
-        }
-        back := int64(_STARTXREF_SIZE) // go back the last segment
+        fi.report( SEVERITY_WARNING, DIAG_STARTXREF, true,
+                   "Startxref value is beyond end of file (0x%x) searching for XREF\n", startXref )
         for {
             startXref = int64(bytes.LastIndex( fi.buffer[:endSearch], []byte("xref") ))
             if startXref != -1 {
                 startXref += fi.bStart
-                if fi.verbose {
-                    fmt.Fprintf( os.Stderr, "XREF found at offset 0x%x\n", startXref )
-                }
+                fi.report( SEVERITY_INFO, DIAG_STARTXREF, false, "XREF found at offset 0x%x\n", startXref )
                 break
             }
 //            fmt.Printf( "Hunting for XREF: not in last buffer\n" )
-            endSearch = int64(_STARTXREF_SIZE) + 5   // one segment + enough for [x]ref
-            back += int64(_STARTXREF_SIZE) // back one more segment
-            start, err := fi.fd.Seek( - back, os.SEEK_END )
-            if err != nil {
+            if fi.bStart == 0 {
+                return -1, fmt.Errorf( "No XREF section\n" )
+            }
+            start := fi.bStart - _STARTXREF_SIZE  // back one more segment, not
+            if start < 0 {                      // before the beginning of file
+                start = 0
+            }
+            endSearch = fi.bStart - start + 5   // segment + enough for [x]ref
+            if err := fi.seek( start ); err != nil {
                 return -1, fmt.Errorf( "No XREF section: %v", err )
             }
             fi.bStart = start      // to allow reporting the proper error location
-            _, err = fi.fd.Read( fi.buffer[:endSearch] )
+            _, err = fi.read( fi.buffer[:endSearch] )
             if err != nil {
                 return -1, fi.parseErrorf("Unable to read file: %v", err )
             }
@@ -1297,8 +1953,7 @@ func (pf *PdfFile) parseHeader( fi *fileInput ) error {
     if err != nil {
         return err
     }
-    minor := pf.Header[7] - 0x30
-    pf.Version = fmt.Sprintf( "1.%d", minor )
+    pf.Version = pf.Header[5:8]
     return nil
 }
 
@@ -1325,11 +1980,11 @@ func (fi *fileInput)readFirstLine( ) ( string, error ) {
         return "", fi.parseErrorf( "Empty file: %v", err )
     }
     end := len(fi.buffer)
-   // expects %PDF-1.?\n
+   // expects %PDF-1.?\n or %PDF-2.0\n
     if end < 9 || '%' != fi.buffer[0] ||
        'P' != fi.buffer[1] || 'D' != fi.buffer[2] ||
        'F' != fi.buffer[3] || '-' != fi.buffer[4] ||
-       '1' != fi.buffer[5] || '.' != fi.buffer[6] {
+       ('1' != fi.buffer[5] && '2' != fi.buffer[5]) || '.' != fi.buffer[6] {
         return "", fi.parseErrorf( "Not a PDF document\n" )
     }
     fi.offset = 8
@@ -1352,16 +2007,14 @@ func (fi *fileInput)readFirstLine( ) ( string, error ) {
     }
     if fi.buffer[ i ] != '%' || fi.buffer[ i+1 ] < 0x80 || fi.buffer[ i+2 ] < 0x80 ||
        fi.buffer[ i+3 ] < 0x80 || fi.buffer[ i+4 ] < 0x80 {
-        if fi.verbose {
-            fmt.Fprintf( os.Stderr, "Warning: recommended binary comment is missing\n" )
-        }
+        fi.report( SEVERITY_WARNING, DIAG_HEADER, false, "Recommended binary comment is missing\n" )
     } else {
         fi.offset = skipToEOL( fi.buffer, i + 5 )
     }
     return string(fi.buffer[:8]), nil
 }
 
-func (fi *fileInput) parse( ) ( *PdfFile, error ) {
+func (fi *fileInput) parse( rebuild bool ) ( *PdfFile, error ) {
 
     pf := new( PdfFile )
     if err := pf.parseHeader( fi ); err != nil {
@@ -1382,94 +2035,223 @@ func (fi *fileInput) parse( ) ( *PdfFile, error ) {
         |<----- regular block ----->|<------last block ------>|
     xrefstart                   blockend = previous xrefstart
 
-   The initial body is processed separately. Objects in updated bodies
-   have a higher generation number and replace the previous definitions
-   that have a lower generation number (all initial objects start at
-   generation 0). Since parsing starts always from the latest update, when
-   adding objects, if an object exists already in the accumulated objects,
-   it is simply ignored.
+   The XREF sections are processed first, from the latest update to the
+   initial one. Since parsing starts always from the latest update, when
+   adding XREF entries, if an object exists already in the accumulated
+   entries, it is simply ignored.
 
    A trailer has a dictionary and is followed by a xrefstart pointing at
    the XREF associated with the trailer and an EOF comment. If the trailer
    is not the initial one, it has also a value in the directory giving
    the offset of the previous xrefstart (prev).
 
+   Since PDF 1.5, a XREF section may also be a XREF stream object, whose
+   dictionary replaces the trailer dictionary. Its binary entries can
+   refer to objects compressed in object streams. A hybrid file has XREF
+   tables, whose trailers refer to an additional XREF stream (XRefStm).
+
    The last updated xrefstart is searched directly from the end of the
-   file (huntForXref), and blocks are then processed until the initial
+   file (huntForXref), and sections are then processed until the initial
    one is reached.
+
+   If the file has a single body followed by a XREF table, the body is
+   parsed in sequence. Otherwise objects are loaded from their XREF offsets.
+
+   If the XREF sections cannot be used and fix is requested, or if rebuild
+   is requested, the XREF is rebuilt by scanning the whole file for objects
+   (see rebuildXref).
 */
     mainObjStart := int64(fi.offset)
 //    fmt.Printf( "First object offset : %d\n", mainObjStart )
 
+    var err error
+    if ! rebuild {
+        if err = pf.parseFromXref( fi, mainObjStart ); err == nil {
+            return pf, nil
+        }
+        if ! fi.fix || errors.Is( err, ErrIncorrectPassword ) {
+            return nil, err
+        }
+        fi.report( SEVERITY_WARNING, DIAG_REBUILD, true, "Rebuilding XREF after error: %v", err )
+        pf = &PdfFile{ Version: pf.Version, Header: pf.Header }
+    }
+    if err = pf.rebuildXref( fi, err ); err != nil {
+        return nil, fmt.Errorf( "PDF Parser: cannot rebuild XREF: %v", err )
+    }
+    return pf, nil
+}
+
+// parse the file body from the XREF sections
+func (pf *PdfFile) parseFromXref( fi *fileInput, mainObjStart int64 ) error {
     xrefStart, err := huntForXref( fi ) // latest updated XREF pointer
     if err != nil {
-        return nil, fmt.Errorf( "PDF Parser: cannot find XREF: %v", err )
+        return fmt.Errorf( "PDF Parser: cannot find XREF: %v", err )
     }
 //    fmt.Printf( "XREF starts @ 0x%x\n", xrefStart )
 
     pf.ObjById = make( map[int64]*PdfObject )
+    fi.freed = make( map[int64]int64 )
 
     blockEnd := fi.size                 // latest updated block end
-    var objEnd int64
-    for i := 0; ; i++ {                 // from last block (0) to first
-        objEnd = xrefStart              // xref follows an object body
-        if err = pf.parseXrefTable( fi, xrefStart, blockEnd ); err != nil {
-            return nil, fmt.Errorf( "PDF Parser: invalid XREF table: %v", err )
-        }
-        blockEnd = xrefStart            // ready for previous update  block
-        xrefStart, err = pf.parseTrailer( fi )
+    sections := make( map[int64]bool )  // to detect loops in XREF sections
+    hasStreams := false                 // true if any XREF stream was found
+    revisions := make( []*Revision, 0, 1 )
+    for {                               // from last block to first
+        sections[xrefStart] = true
+        rev := &Revision{ XrefOffset: xrefStart }
+        prev, err := pf.parseXrefSection( fi, rev, blockEnd )
         if err != nil {
-            return nil, fmt.Errorf( "PDF Parser: invalid trailer: %v", err )
+            return err
         }
-        if xrefStart == 0 { break }     // no more updates, process main body
-
-        if i > 0 {                      // not the last, process following body
-            err = pf.parseObjects( fi, 0, 0 ) // keep moving in the same buffer
-            if err != nil {
-                return nil, fmt.Errorf( "PDF Parser: invalid body: %v", err )
-            }
+        revisions = append( revisions, rev )
+        if rev.XrefStream { hasStreams = true }
+        if prev == 0 { break }          // no more updates, process main body
+        if sections[prev] {
+            return fmt.Errorf( "PDF Parser: XREF section at 0x%x is already processed\n", prev )
         }
+        blockEnd = xrefStart            // ready for previous update  block
+        xrefStart = prev
+    }
+//    fmt.Printf( "End object offset : 0x%x\n", xrefStart )
+    pf.setRevisions( revisions )
+    // the latest trailer is the document trailer, without XREF links
+    trailer := revisions[0].Trailer.clone()
+    trailer.Delete( "Prev" )
+    trailer.Delete( "XRefStm" )
+    pf.Encrypt, pf.Info, pf.Id = Reference{}, Reference{}, nil
+    if err = pf.setTrailer( fi, trailer ); err != nil {
+        return err
+    }
+
+    // stream lengths can be resolved now that objects are located
+    fi.lengthOf = func( ref Reference ) ( int64, bool ) { return pf.indirectLength( fi, ref ) }
+    fi.deferLength = hasStreams
+    if hasStreams || len(sections) > 1 {
+        err = pf.parseObjectsAt( fi )
+    } else {                            // single body followed by XREF table
+        err = pf.parseObjects( fi, mainObjStart, xrefStart )
     }
-//    fmt.Printf( "End object offset : 0x%x\n", objEnd )
-    err = pf.parseObjects( fi, mainObjStart, objEnd )
     if err != nil {
-        return nil, fmt.Errorf( "PDF Parser: invalid body: %v", err )
+        return fmt.Errorf( "PDF Parser: invalid body: %v", err )
     }
-    return pf, nil
+    if err = pf.decrypt( fi, pf.Trailer ); err != nil {
+        return fmt.Errorf( "PDF Parser: %w", err )
+    }
+    if hasStreams {
+        if err = pf.parseCompressedObjects( fi ); err != nil {
+            return fmt.Errorf( "PDF Parser: invalid body: %v", err )
+        }
+        if err = pf.parseDeferredObjects( fi ); err != nil {
+            return fmt.Errorf( "PDF Parser: invalid body: %v", err )
+        }
+    }
+    return nil
 }
 
-func newFileInput( path string, verbose, fix bool ) ( *fileInput, error ) {
-    fd, err := os.Open( path )
-    if err != nil {
-		return nil, fmt.Errorf( "Unable to open file %s: %v", path, err )
-	}
-
+func newFileInput( r io.ReaderAt, size int64, args *ParseArgs ) *fileInput {
     var fi fileInput
-    fi.fd = fd
-
-    fs, _ := fd.Stat()                  // Get total file size
-    fi.size = fs.Size()
-
+    fi.r = r
+    fi.size = size
     fi.buffer = make( []byte, 512, INPUT_BUFFER_SIZE )
-    fi.verbose = verbose
+    fi.diag = newDiagnostics( args.Verbose )
+    fi.fix = args.Fix
+    fi.userPassword = args.UserPassword
+    fi.ownerPassword = args.OwnerPassword
+    return &fi
+}
+
+// create a fileInput reading from data in memory instead of a file. The
+// whole data is in the buffer from the beginning, so that it is never
+// refilled, but it can still be read directly when checking stream lengths.
+func newMemoryInput( data []byte, diag *diagnostics, fix bool ) *fileInput {
+    var fi fileInput
+    fi.r = bytes.NewReader( data )
+    fi.buffer = make( []byte, len(data) )  // refill may modify the buffer
+    copy( fi.buffer, data )
+    fi.size = int64(len(data))
+    fi.pos = fi.size
+    fi.stopAt = fi.size
+    fi.savedOffset = -1
+    fi.diag = diag
+    fi.inMemory = true
     fi.fix = fix
-    return &fi, nil
+    return &fi
 }
 
 type ParseArgs struct {
-    Path    string      // original file to process (must be given)
-    Verbose bool        // verbose parsing (false by default)
+    Path    string      // original file to process (must be given to Parse)
+    Verbose bool        // collect informational diagnostics too (false by default)
     Fix     bool        // fix during parsing (stop with error by default)
+    Rebuild bool        // rebuild XREF from objects found in file (use XREF by default)
+    Revisions int       // number of revisions to parse, from the original document (all by default)
+
+    // for encrypted documents, the owner password is tried first if given,
+    // then the user password (empty by default)
+    UserPassword    string
+    OwnerPassword   string
 }
 
+// Parse parses the file given by args.Path
 func Parse( args *ParseArgs ) ( *PdfFile, error ) {
 
-    fi, err := newFileInput( args.Path, args.Verbose, args.Fix )
-    if err != nil { return nil, err }
-    defer fi.fd.Close()
+    fd, err := os.Open( args.Path )
+    if err != nil {
+		return nil, fmt.Errorf( "Unable to open file %s: %v", args.Path, err )
+	}
+    defer fd.Close()
 
-    pdf, err := fi.parse( )
+    fs, err := fd.Stat()                // Get total file size
     if err != nil { return nil, err }
 
+    return ParseReader( fd, fs.Size(), args )
+}
+
+// ParseReader parses size bytes read from r. The Path in args, if any, is
+// ignored. If args is nil, default arguments are used.
+//
+// If args.Revisions is given, the document is parsed as it was after that
+// number of revisions, ignoring later incremental updates: 1 gives the
+// original document. The revisions are then limited to those parsed.
+func ParseReader( r io.ReaderAt, size int64, args *ParseArgs ) ( *PdfFile, error ) {
+    if args == nil {
+        args = &ParseArgs{ }
+    }
+    if args.Revisions < 0 || (args.Revisions > 0 && args.Rebuild) {
+        return nil, &ParseError{ fmt.Errorf( "PDF Parser: invalid number of revisions %d", args.Revisions ), nil }
+    }
+    fi := newFileInput( r, size, args )
+
+    pdf, err := fi.parse( args.Rebuild )
+    if err != nil {
+        return nil, &ParseError{ err, fi.diag.list }
+    }
+
+    if n := args.Revisions; n > 0 && n != len(pdf.Revisions) {
+        if n > len(pdf.Revisions) {
+            err = fmt.Errorf( "PDF Parser: cannot parse %d revisions, document has %d", n, len(pdf.Revisions) )
+            return nil, &ParseError{ err, fi.diag.list }
+        }
+        end := pdf.Revisions[n-1].End
+        fi = newFileInput( io.NewSectionReader( r, 0, end ), end, args )
+        if pdf, err = fi.parse( false ); err != nil {
+            return nil, &ParseError{ err, fi.diag.list }
+        }
+        if len(pdf.Revisions) != n {
+            err = fmt.Errorf( "PDF Parser: revision %d cannot be parsed separately", n )
+            return nil, &ParseError{ err, fi.diag.list }
+        }
+    }
+
+    pdf.Diagnostics = fi.diag.list
+    pdf.inputSize = fi.size
+    last := make( []byte, 1 )
+    if _, err = fi.r.ReadAt( last, fi.size - 1 ); err == nil {
+        pdf.inputEOL = last[0] == '\n' || last[0] == '\r'
+    }
     return pdf, nil
 }
+
+// ParseBytes parses a whole PDF document given in data. See ParseReader.
+func ParseBytes( data []byte, args *ParseArgs ) ( *PdfFile, error ) {
+    return ParseReader( bytes.NewReader( data ), int64(len(data)), args )
+}
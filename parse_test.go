@@ -0,0 +1,576 @@
+package pdf
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// return the number of diagnostics with the given code and severity
+func countCode( diags []Diagnostic, code string, severity Severity ) int {
+    n := 0
+    for _, d := range diags {
+        if d.Code == code && d.Severity == severity {
+            n++
+        }
+    }
+    return n
+}
+
+// a minimal document, with an empty page tree
+var testMinimalObjects = []string{
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    "<< /Type /Pages /Kids [ ] /Count 0 >>",
+}
+
+func TestHuntForXrefSmallFile( t *testing.T ) {
+    data := makeTestFile( testMinimalObjects, "/Root 1 0 R" )
+    if len(data) >= _STARTXREF_SIZE {
+        t.Fatalf( "Test file has %d bytes, expected less than %d", len(data), _STARTXREF_SIZE )
+    }
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if n, err := pf.NumPages( ); err != nil || n != 0 {
+        t.Errorf( "NumPages: got %d %v, expected 0", n, err )
+    }
+    for _, data := range [][]byte{ nil, []byte("%PDF-1.4\n"), []byte("%PDF-1.4\nstartxref\n") } {
+        if _, err = ParseBytes( data, nil ); err == nil {
+            t.Errorf( "%q: no error", data )
+        }
+    }
+}
+
+// return data with the startxref value replaced by offset
+func setStartxref( data []byte, offset int64 ) []byte {
+    i := bytes.LastIndex( data, []byte("startxref") )
+    return append( data[:i:i], []byte( fmt.Sprintf( "startxref\n%d\n%%%%EOF\n", offset ) )... )
+}
+
+func TestHuntForXrefBeyondEOF( t *testing.T ) {
+    small := makeTestFile( testMinimalObjects, "/Root 1 0 R" )
+    // a trailer longer than the search window, so that the XREF table is
+    // found only in the previous segment
+    padding := "/Padding (" + strings.Repeat( "x", 2 * _STARTXREF_SIZE ) + ")"
+    large := makeTestFile( testMinimalObjects, "/Root 1 0 R " + padding )
+
+    for _, data := range [][]byte{ small, large } {
+        data = setStartxref( data, int64(len(data)) + 100 )
+        if _, err := ParseBytes( data, nil ); err == nil {
+            t.Errorf( "%d bytes: startxref beyond EOF without fix: no error", len(data) )
+        }
+        pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+        if err != nil {
+            t.Errorf( "%d bytes: %v", len(data), err )
+            continue
+        }
+        if n := countCode( pf.Diagnostics, DIAG_STARTXREF, SEVERITY_WARNING ); n != 1 {
+            t.Errorf( "%d bytes: got %d startxref warnings, expected 1", len(data), n )
+        }
+        if pf.Rebuilt != nil {
+            t.Errorf( "%d bytes: XREF was rebuilt instead of found", len(data) )
+        }
+        if n, err := pf.NumPages( ); err != nil || n != 0 {
+            t.Errorf( "%d bytes NumPages: got %d %v, expected 0", len(data), n, err )
+        }
+    }
+}
+
+// a page whose content stream has an indirect Length, and binary data that
+// contains the endstream keyword
+const testStreamData = "0 0 m\n(endstream) Tj\x00\xffendstream\r\n1 1 l"
+
+func makeIndirectLengthFile( length, data string ) []byte {
+    objs := []string{
+        "<< /Type /Catalog /Pages 2 0 R >>",
+        "<< /Type /Pages /Kids [ 3 0 R ] /Count 1 >>",
+        "<< /Type /Page /Parent 2 0 R /MediaBox [ 0 0 100 100 ] /Contents 4 0 R >>",
+        "<< /Length " + length + " >>\nstream\n" + data + "\nendstream",
+        fmt.Sprintf( "%d", len(data) ),
+    }
+    return makeTestFile( objs, "/Root 1 0 R" )
+}
+
+func checkStreamData( t *testing.T, pf *PdfFile, id int64, data string ) {
+    obj, ok := pf.ObjById[id]
+    if ! ok {
+        t.Fatalf( "Object %d is missing", id )
+    }
+    s, ok := obj.Value().(Stream)
+    if ! ok {
+        t.Fatalf( "Object %d is not a stream: %v", id, obj.Value() )
+    }
+    if string(s.data) != data {
+        t.Errorf( "Object %d stream data: got %q, expected %q", id, s.data, data )
+    }
+}
+
+func TestIndirectStreamLength( t *testing.T ) {
+    pf, err := ParseBytes( makeIndirectLengthFile( "5 0 R", testStreamData ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkStreamData( t, pf, 4, testStreamData )
+    if n := countCode( pf.Diagnostics, DIAG_STREAM_LENGTH, SEVERITY_WARNING ); n != 0 {
+        t.Errorf( "Got %d stream length warnings, expected none", n )
+    }
+    rebuilt, err := ParseBytes( makeIndirectLengthFile( "5 0 R", testStreamData ), &ParseArgs{ Rebuild: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkStreamData( t, rebuilt, 4, testStreamData )
+
+    // the length is in an object stream, loaded after the content stream
+    for _, args := range []*WriteArgs{ { XrefStream: true }, { ObjectStreams: true } } {
+        var b bytes.Buffer
+        if _, err = pf.Write( &b, args ); err != nil {
+            t.Fatal( err )
+        }
+        written, err := ParseBytes( b.Bytes(), nil )
+        if err != nil {
+            t.Fatalf( "%+v: %v", *args, err )
+        }
+        checkStreamData( t, written, 4, testStreamData )
+        if args.ObjectStreams && written.ObjById[5].stream == 0 {
+            t.Errorf( "Length object is not in an object stream" )
+        }
+    }
+}
+
+func TestUnresolvedStreamLength( t *testing.T ) {
+    for _, length := range []string{ "9 0 R", "5 1 R", "3 0 R" } {
+        data := makeIndirectLengthFile( length, "0 0 m 1 1 l S" )
+        if _, err := ParseBytes( data, nil ); err == nil {
+            t.Errorf( "Length %s: no error without Fix", length )
+        }
+        pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+        if err != nil {
+            t.Fatalf( "Length %s: %v", length, err )
+        }
+        // the data ends at the endstream keyword
+        checkStreamData( t, pf, 4, "0 0 m 1 1 l S" )
+        if n := countCode( pf.Diagnostics, DIAG_STREAM_LENGTH, SEVERITY_WARNING ); n != 1 {
+            t.Errorf( "Length %s: got %d stream length warnings, expected 1", length, n )
+        }
+    }
+}
+
+// append the object id with the given definition, and return its offset
+func appendTestObject( b *bytes.Buffer, id int, def string ) int64 {
+    offset := int64(b.Len())
+    fmt.Fprintf( b, "%d 0 obj\n%s\nendobj\n", id, def )
+    return offset
+}
+
+// an entry of a hand-built XREF stream: type, field 2 and field 3
+type testXrefEntry [3]int64
+
+// return the XREF stream data for entries, with the field widths w
+func makeXrefStreamData( w [3]int, entries []testXrefEntry ) []byte {
+    var b bytes.Buffer
+    for _, e := range entries {
+        for i, n := range w {
+            for j := n - 1; j >= 0; j-- {
+                b.WriteByte( byte(e[i] >> uint(8 * j)) )
+            }
+        }
+    }
+    return b.Bytes()
+}
+
+// return data made of rows of rowSize bytes, each prefixed by the PNG
+// filter type Up (difference with the previous row)
+func encodePNGUp( data []byte, rowSize int ) []byte {
+    var b bytes.Buffer
+    prior := make( []byte, rowSize )
+    for i := 0; i + rowSize <= len(data); i += rowSize {
+        b.WriteByte( 2 )
+        for j := 0; j < rowSize; j++ {
+            b.WriteByte( data[i+j] - prior[j] )
+        }
+        prior = data[i:i+rowSize]
+    }
+    return b.Bytes()
+}
+
+// append the XREF stream object id with the given dictionary entries and
+// data, followed by startxref if it is the last XREF section
+func appendTestXrefStream( b *bytes.Buffer, id int, dict string, data []byte, last bool ) int64 {
+    offset := appendTestObject( b, id, fmt.Sprintf( "<< /Type /XRef /Length %d %s >>\nstream\n%s\nendstream",
+                                                    len(data), dict, data ) )
+    if last {
+        fmt.Fprintf( b, "startxref\n%d\n%%%%EOF\n", offset )
+    }
+    return offset
+}
+
+// a document with a single page
+var testSinglePageObjects = []string{
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    "<< /Type /Pages /Kids [ 3 0 R ] /Count 1 >>",
+    "<< /Type /Page /Parent 2 0 R /MediaBox [ 0 0 100 100 ] >>",
+}
+
+// return a document made of testSinglePageObjects followed by a XREF stream
+// with field widths w, and a list of entries for objects 0 to 4, from which
+// indexed selects the entries given in the stream.
+func makeXrefStreamFile( w [3]int, dict string, indexed func( []testXrefEntry ) []testXrefEntry,
+                         encode func( []byte ) []byte ) []byte {
+    var b bytes.Buffer
+    b.WriteString( "%PDF-1.5\n" )
+    entries := []testXrefEntry{ { 0, 0, 65535 } }
+    for i, def := range testSinglePageObjects {
+        entries = append( entries, testXrefEntry{ 1, appendTestObject( &b, i + 1, def ), 0 } )
+    }
+    entries = append( entries, testXrefEntry{ 1, int64(b.Len()), 0 } )  // XREF stream itself
+    data := makeXrefStreamData( w, indexed( entries ) )
+    if encode != nil {
+        data = encode( data )
+    }
+    dict = fmt.Sprintf( "/Size 5 /Root 1 0 R /W [ %d %d %d ] %s", w[0], w[1], w[2], dict )
+    appendTestXrefStream( &b, 4, dict, data, true )
+    return b.Bytes()
+}
+
+func allEntries( entries []testXrefEntry ) []testXrefEntry {
+    return entries
+}
+
+func checkSinglePage( t *testing.T, pf *PdfFile, name string ) {
+    if n, err := pf.NumPages( ); err != nil || n != 1 {
+        t.Errorf( "%s: NumPages got %d %v, expected 1", name, n, err )
+    }
+    for id := int64(1); id <= 3; id++ {
+        if obj, ok := pf.ObjById[id]; ! ok || obj.Value() == nil {
+            t.Errorf( "%s: object %d is not loaded", name, id )
+        }
+    }
+}
+
+func TestXrefStream( t *testing.T ) {
+    tests := []struct {
+        name    string
+        w       [3]int
+        dict    string
+        indexed func( []testXrefEntry ) []testXrefEntry
+        encode  func( []byte ) []byte
+    }{
+        { "default index", [3]int{ 1, 2, 2 }, "", allEntries, nil },
+        { "default type and generation", [3]int{ 0, 3, 0 }, "/Index [ 1 3 ]",
+          func( e []testXrefEntry ) []testXrefEntry { return e[1:4] }, nil },
+        { "subsections", [3]int{ 1, 4, 1 }, "/Index [ 0 2 2 3 ]", allEntries, nil },
+        { "flate and predictor", [3]int{ 1, 4, 1 },
+          "/Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 6 >>", allEntries,
+          func( data []byte ) []byte { return flateEncode( encodePNGUp( data, 6 ) ) } },
+    }
+    for _, test := range tests {
+        data := makeXrefStreamFile( test.w, test.dict, test.indexed, test.encode )
+        pf, err := ParseBytes( data, nil )
+        if err != nil {
+            t.Errorf( "%s: %v", test.name, err )
+            continue
+        }
+        checkSinglePage( t, pf, test.name )
+        if len(pf.ObjById) != 3 {
+            t.Errorf( "%s: got %d objects, expected 3", test.name, len(pf.ObjById) )
+        }
+        if ! pf.Revisions[0].XrefStream {
+            t.Errorf( "%s: revision does not use a XREF stream", test.name )
+        }
+        if keys := pf.Trailer.Keys(); len(keys) != 2 || keys[0] != "Size" || keys[1] != "Root" {
+            t.Errorf( "%s: trailer keys %v, expected [Size Root]", test.name, keys )
+        }
+    }
+}
+
+func TestXrefStreamErrors( t *testing.T ) {
+    tests := []struct {
+        name    string
+        w       [3]int
+        dict    string
+    }{
+        { "field width", [3]int{ 1, 9, 1 }, "" },
+        { "index length", [3]int{ 1, 2, 2 }, "/Index [ 0 ]" },
+        { "index values", [3]int{ 1, 2, 2 }, "/Index [ 0 /Five ]" },
+    }
+    for _, test := range tests {
+        data := makeXrefStreamFile( test.w, test.dict, allEntries, nil )
+        if _, err := ParseBytes( data, nil ); err == nil {
+            t.Errorf( "%s: no error", test.name )
+        }
+    }
+    // W must have 3 entries
+    data := makeXrefStreamFile( [3]int{ 1, 2, 2 }, "", allEntries, nil )
+    data = bytes.Replace( data, []byte("/W [ 1 2 2 ]"), []byte("/W [ 1 2 ] "), 1 )
+    if _, err := ParseBytes( data, nil ); err == nil {
+        t.Errorf( "W length: no error" )
+    }
+
+    // missing entries are ignored only with Fix
+    data = makeXrefStreamFile( [3]int{ 1, 2, 2 }, "/Index [ 0 7 ]", allEntries, nil )
+    if _, err := ParseBytes( data, nil ); err == nil {
+        t.Errorf( "Short data: no error" )
+    }
+    pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+    if err != nil {
+        t.Fatalf( "Short data with Fix: %v", err )
+    }
+    checkSinglePage( t, pf, "Short data with Fix" )
+    if n := countCode( pf.Diagnostics, DIAG_XREF, SEVERITY_WARNING ); n != 1 {
+        t.Errorf( "Short data with Fix: got %d XREF warnings, expected 1", n )
+    }
+}
+
+// append an update to data, with the given objects and free entries, and a
+// XREF stream object id referring to the previous XREF section at prev
+func appendXrefStreamUpdate( data []byte, prev int64, id int, objs map[int]string, freed map[int]int64 ) []byte {
+    b := bytes.NewBuffer( data )
+    entries := make( map[int]testXrefEntry )
+    for oid, def := range objs {
+        entries[oid] = testXrefEntry{ 1, appendTestObject( b, oid, def ), 0 }
+    }
+    for oid, gen := range freed {
+        entries[oid] = testXrefEntry{ 0, 0, gen }
+    }
+    entries[id] = testXrefEntry{ 1, int64(b.Len()), 0 }
+
+    // one subsection per entry, in increasing object number order
+    var index bytes.Buffer
+    list := make( []testXrefEntry, 0, len(entries) )
+    for oid := 0; oid <= id; oid++ {
+        if e, ok := entries[oid]; ok {
+            fmt.Fprintf( &index, "%d 1 ", oid )
+            list = append( list, e )
+        }
+    }
+    dict := fmt.Sprintf( "/Size %d /Root 1 0 R /W [ 1 4 2 ] /Index [ %s] /Prev %d", id + 1, index.String(), prev )
+    appendTestXrefStream( b, id, dict, makeXrefStreamData( [3]int{ 1, 4, 2 }, list ), true )
+    return b.Bytes()
+}
+
+// return the offset given by the last startxref in data
+func getStartxref( data []byte ) int64 {
+    var offset int64
+    i := bytes.LastIndex( data, []byte("startxref") )
+    fmt.Sscan( string(data[i+len("startxref"):]), &offset )
+    return offset
+}
+
+func TestXrefStreamPrev( t *testing.T ) {
+    data := makeXrefStreamFile( [3]int{ 1, 2, 2 }, "", allEntries, nil )
+    data = appendXrefStreamUpdate( data, getStartxref( data ), 6,
+                                   map[int]string{ 1: "<< /Type /Catalog /Pages 2 0 R /Extra 5 0 R >>",
+                                                   5: "(Extra)" }, nil )
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "update" )
+    if len(pf.Revisions) != 2 || ! pf.Revisions[0].XrefStream || ! pf.Revisions[1].XrefStream {
+        t.Fatalf( "Got %d revisions, expected 2 with XREF streams", len(pf.Revisions) )
+    }
+    catalog, err := pf.Resolve( pf.Catalog )
+    if err != nil {
+        t.Fatal( err )
+    }
+    extra, ok := catalog.(Dictionary).Get( "Extra" )
+    if ! ok {
+        t.Fatalf( "Catalog was not updated: %v", catalog )
+    }
+    if v, err := pf.Resolve( extra ); err != nil || v != String("Extra") {
+        t.Errorf( "Catalog Extra: got %v %v, expected (Extra)", v, err )
+    }
+
+    // a loop in Prev links is an error
+    looped := appendXrefStreamUpdate( data, getStartxref( data ), 7, nil, nil )
+    looped = appendXrefStreamUpdate( looped, getStartxref( looped ), 8, nil, nil )
+    i := bytes.LastIndex( looped, []byte("/Prev ") )
+    copy( looped[i:], fmt.Sprintf( "/Prev %d", getStartxref( looped ) ) )
+    if _, err = ParseBytes( looped, nil ); err == nil {
+        t.Errorf( "Prev loop: no error" )
+    }
+}
+
+// a free entry in a newer section hides the object defined in older sections
+func TestFreedEntries( t *testing.T ) {
+    data := makeXrefStreamFile( [3]int{ 1, 2, 2 }, "", allEntries, nil )
+    data = appendXrefStreamUpdate( data, getStartxref( data ), 6, map[int]string{ 5: "(Extra)" }, nil )
+    freed := appendXrefStreamUpdate( data, getStartxref( data ), 7, nil, map[int]int64{ 5: 1 } )
+    pf, err := ParseBytes( freed, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "freed" )
+    if _, ok := pf.ObjById[5]; ok {
+        t.Errorf( "Freed object 5 is defined" )
+    }
+
+    // same with a XREF table in the last update
+    b := bytes.NewBuffer( data[:len(data):len(data)] )
+    xref := b.Len()
+    fmt.Fprintf( b, "xref\n0 1\n0000000000 65535 f\r\n5 1\n0000000000 00001 f\r\n" )
+    fmt.Fprintf( b, "trailer\n<< /Size 7 /Root 1 0 R /Prev %d >>\nstartxref\n%d\n%%%%EOF\n",
+                 getStartxref( data ), xref )
+    if pf, err = ParseBytes( b.Bytes(), nil ); err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "freed in table" )
+    if _, ok := pf.ObjById[5]; ok {
+        t.Errorf( "Freed object 5 is defined (XREF table)" )
+    }
+}
+
+// objects in the body that are not in the XREF table are ignored
+func TestObjectNotInXrefTable( t *testing.T ) {
+    objs := append( testSinglePageObjects[:3:3], "(Unused)" )
+    data := makeTestFile( objs, "/Root 1 0 R" )
+    entry := []byte( fmt.Sprintf( "%010d 00000 n", bytes.Index( data, []byte("4 0 obj") ) ) )
+    data = bytes.Replace( data, entry, []byte("0000000000 00001 f"), 1 )
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "unused" )
+    if _, ok := pf.ObjById[4]; ok {
+        t.Errorf( "Object 4 is defined" )
+    }
+    if n := countCode( pf.Diagnostics, DIAG_XREF, SEVERITY_WARNING ); n != 1 {
+        t.Errorf( "Got %d XREF warnings, expected 1", n )
+    }
+}
+
+// return the definition of an object stream with objects 2 and 3 of
+// testSinglePageObjects, with the given header and dictionary entries. If
+// header is empty, the correct header is used.
+func makeTestObjectStream( header, dict string ) string {
+    o2, o3 := testSinglePageObjects[1], testSinglePageObjects[2]
+    if header == "" {
+        header = fmt.Sprintf( "2 0 3 %d ", len(o2) + 1 )
+    }
+    data := header + o2 + " " + o3
+    if dict == "" {
+        dict = fmt.Sprintf( "/N 2 /First %d", len(header) )
+    }
+    return fmt.Sprintf( "<< /Type /ObjStm /Length %d %s >>\nstream\n%s\nendstream", len(data), dict, data )
+}
+
+// A hybrid file has a XREF table for readers that do not know about object
+// streams, and a XRefStm stream giving the compressed objects, which are
+// free in the table.
+func TestHybridXref( t *testing.T ) {
+    var b bytes.Buffer
+    b.WriteString( "%PDF-1.5\n" )
+    off1 := appendTestObject( &b, 1, testSinglePageObjects[0] )
+    off4 := appendTestObject( &b, 4, makeTestObjectStream( "", "" ) )
+    data := makeXrefStreamData( [3]int{ 1, 2, 1 }, []testXrefEntry{ { 2, 4, 0 }, { 2, 4, 1 } } )
+    off5 := appendTestXrefStream( &b, 5, "/Size 6 /W [ 1 2 1 ] /Index [ 2 2 ]", data, false )
+    xref := b.Len()
+    fmt.Fprintf( &b, "xref\n0 6\n0000000000 65535 f\r\n%010d 00000 n\r\n", off1 )
+    fmt.Fprintf( &b, "0000000000 00000 f\r\n0000000000 00000 f\r\n%010d 00000 n\r\n%010d 00000 n\r\n", off4, off5 )
+    fmt.Fprintf( &b, "trailer\n<< /Size 6 /Root 1 0 R /XRefStm %d >>\nstartxref\n%d\n%%%%EOF\n", off5, xref )
+
+    pf, err := ParseBytes( b.Bytes(), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "hybrid" )
+    if pf.ObjById[2].stream != 4 || pf.ObjById[3].stream != 4 {
+        t.Errorf( "Objects 2 and 3 are not compressed in object stream 4" )
+    }
+    if len(pf.Revisions) != 1 || ! pf.Revisions[0].XrefStream {
+        t.Errorf( "Got %d revisions, expected 1 with a XREF stream", len(pf.Revisions) )
+    }
+    if _, ok := pf.Trailer.Get( "XRefStm" ); ok {
+        t.Errorf( "Document trailer has XRefStm" )
+    }
+
+    // without the XRefStm stream, compressed objects are free
+    hidden := bytes.Replace( b.Bytes(), []byte( fmt.Sprintf( "/XRefStm %d", off5 ) ),
+                             []byte( fmt.Sprintf( "/Dummy %d", off5 ) ), 1 )
+    if pf, err = ParseBytes( hidden, nil ); err != nil {
+        t.Fatal( err )
+    }
+    if _, ok := pf.ObjById[2]; ok {
+        t.Errorf( "Object 2 is defined without XRefStm" )
+    }
+}
+
+// return a document where objects 2 and 3 are in object stream 4, defined by
+// objStm, and given by entries 2 and 3 of a XREF stream.
+func makeObjectStreamFile( objStm string, entries []testXrefEntry ) []byte {
+    var b bytes.Buffer
+    b.WriteString( "%PDF-1.5\n" )
+    off1 := appendTestObject( &b, 1, testSinglePageObjects[0] )
+    off4 := appendTestObject( &b, 4, objStm )
+    all := []testXrefEntry{ { 0, 0, 65535 }, { 1, off1, 0 } }
+    all = append( all, entries... )
+    all = append( all, testXrefEntry{ 1, off4, 0 }, testXrefEntry{ 1, int64(b.Len()), 0 } )
+    data := makeXrefStreamData( [3]int{ 1, 2, 1 }, all )
+    appendTestXrefStream( &b, 5, "/Size 6 /Root 1 0 R /W [ 1 2 1 ]", data, true )
+    return b.Bytes()
+}
+
+var testObjStmEntries = []testXrefEntry{ { 2, 4, 0 }, { 2, 4, 1 } }
+
+func TestObjectStream( t *testing.T ) {
+    pf, err := ParseBytes( makeObjectStreamFile( makeTestObjectStream( "", "" ), testObjStmEntries ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "object stream" )
+    if _, ok := pf.ObjById[4]; ok {
+        t.Errorf( "Object stream 4 is still defined" )
+    }
+    for _, obj := range pf.Objects {
+        if obj.id == 4 {
+            t.Errorf( "Object stream 4 is still in objects" )
+        }
+    }
+    // objects in an object stream have generation 0, and the stream can be
+    // given in any order
+    reversed := []testXrefEntry{ { 2, 4, 1 }, { 2, 4, 0 } }
+    header := fmt.Sprintf( "3 0 2 %d ", len(testSinglePageObjects[2]) + 1 )
+    o2, o3 := testSinglePageObjects[1], testSinglePageObjects[2]
+    objStm := fmt.Sprintf( "<< /Type /ObjStm /N 2 /First %d /Length %d >>\nstream\n%s%s %s\nendstream",
+                           len(header), len(header) + len(o3) + 1 + len(o2), header, o3, o2 )
+    if pf, err = ParseBytes( makeObjectStreamFile( objStm, reversed ), nil ); err != nil {
+        t.Fatal( err )
+    }
+    checkSinglePage( t, pf, "reversed object stream" )
+}
+
+func TestObjectStreamErrors( t *testing.T ) {
+    first := len( fmt.Sprintf( "2 0 3 %d ", len(testSinglePageObjects[1]) + 1 ) )
+    tests := []struct {
+        name    string
+        objStm  string
+        entries []testXrefEntry
+    }{
+        { "no N", makeTestObjectStream( "", fmt.Sprintf( "/First %d", first ) ), testObjStmEntries },
+        { "negative N", makeTestObjectStream( "", fmt.Sprintf( "/N -1 /First %d", first ) ), testObjStmEntries },
+        { "N too large", makeTestObjectStream( "", fmt.Sprintf( "/N 3 /First %d", first ) ), testObjStmEntries },
+        { "no First", makeTestObjectStream( "", "/N 2" ), testObjStmEntries },
+        { "First beyond data", makeTestObjectStream( "", "/N 2 /First 1000" ), testObjStmEntries },
+        { "header offsets", makeTestObjectStream( "2 40 3 0 ", "/N 2 /First 9" ), testObjStmEntries },
+        { "header object number", makeTestObjectStream( "2 0 9 43 ", "/N 2 /First 9" ), testObjStmEntries },
+        { "index beyond N", makeTestObjectStream( "", "" ), []testXrefEntry{ { 2, 4, 0 }, { 2, 4, 2 } } },
+        { "not an object stream", "<< /Length 3 >>\nstream\n2 0\nendstream", testObjStmEntries },
+        { "missing object stream", makeTestObjectStream( "", "" ), []testXrefEntry{ { 2, 9, 0 }, { 2, 9, 1 } } },
+    }
+    for _, test := range tests {
+        data := makeObjectStreamFile( test.objStm, test.entries )
+        if _, err := ParseBytes( data, nil ); err == nil {
+            t.Errorf( "%s: no error", test.name )
+        }
+        pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+        if err != nil {
+            t.Errorf( "%s with Fix: %v", test.name, err )
+            continue
+        }
+        if n := countCode( pf.Diagnostics, DIAG_OBJECT_STREAM, SEVERITY_WARNING ); n == 0 {
+            t.Errorf( "%s with Fix: no object stream warning", test.name )
+        }
+    }
+}
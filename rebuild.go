@@ -0,0 +1,318 @@
+
+package pdf
+
+import (
+    "fmt"
+    "bytes"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+/*
+XREF reconstruction: when the XREF sections are missing or unusable (e.g.
+truncated file or wrong offsets), the whole file is scanned for indirect
+object definitions "id gen obj". Each definition is parsed up to its endobj
+and the scan resumes after it, so that definitions appearing inside stream
+data are not mistaken for objects. If the same object is defined more than
+once, the last definition in the file wins, as it would in an incremental
+update.
+
+Objects compressed in object streams are recovered from the object stream
+headers, unless a direct definition follows the object stream in the file.
+
+The trailer is the last trailer dictionary or XREF stream dictionary in the
+file that refers to an existing root catalog. If none can be found, a trailer
+is synthesized with the last catalog found in the file.
+*/
+
+// RebuildReport describes what was recovered when the XREF was rebuilt
+type RebuildReport struct {
+    Reason          string      // why the XREF sections were not used, empty if rebuild was requested
+    Objects         int         // number of objects recovered, including compressed objects
+    Compressed      int         // number of objects recovered from object streams
+    Superseded      int         // number of object definitions replaced by a later definition
+    Damaged         []int64     // offsets of object definitions that could not be parsed
+    TrailerFound    bool        // false if the trailer was synthesized
+    TrailerOffset   int64       // offset of the trailer or XREF stream used, -1 if synthesized
+}
+
+const _SCAN_OVERLAP = 64    // enough for a complete "id gen obj" sequence
+
+var objectDefPattern = regexp.MustCompile( `(\d{1,10})[\x00\t\n\f\r ]+(\d{1,5})[\x00\t\n\f\r ]+obj` )
+
+type objectDef struct {
+    id, gen, start  int64
+}
+
+// scan the whole file for object definitions and trailers, returning them in
+// file order.
+func scanObjectDefs( fi *fileInput ) ( []objectDef, []int64, error ) {
+    defs := make( []objectDef, 0, 64 )
+    trailers := make( []int64, 0, 4 )
+    chunk := make( []byte, INPUT_BUFFER_SIZE )
+    for pos := int64(0); pos < fi.size; {
+        n, err := fi.r.ReadAt( chunk, pos )
+        if n == 0 {
+            return nil, nil, fmt.Errorf( "Unable to read file at offset 0x%x: %v", pos, err )
+        }
+        data := chunk[:n]
+        limit := n              // matches starting after limit are found in the next chunk
+        if pos + int64(n) < fi.size {
+            limit = n - _SCAN_OVERLAP
+        }
+        for _, m := range objectDefPattern.FindAllSubmatchIndex( data, -1 ) {
+            if m[0] >= limit {
+                break
+            }
+            if m[0] > 0 && ! isSeparator( data[m[0]-1] ) {
+                continue        // part of a bigger token
+            }
+            if m[1] < n && ! isSeparator( data[m[1]] ) {
+                continue        // e.g. "objx"
+            }
+            id, _ := getPositiveInteger( string(data[m[2]:m[3]]) )
+            gen, _ := getPositiveInteger( string(data[m[4]:m[5]]) )
+            defs = append( defs, objectDef{ id, gen, pos + int64(m[0]) } )
+        }
+        for i := 0; ; {
+            t := bytes.Index( data[i:limit], []byte("trailer") )
+            if t == -1 {
+                break
+            }
+            t += i
+            if (t == 0 || isSeparator( data[t-1] )) && (t + 7 == n || isSeparator( data[t+7] )) {
+                trailers = append( trailers, pos + int64(t) )
+            }
+            i = t + 7
+        }
+        if limit == n {
+            break
+        }
+        pos += int64(limit)
+    }
+    return defs, trailers, nil
+}
+
+// whitespace or delimiter, as defined by PDF specifications
+func isSeparator( c byte ) bool {
+    switch c {
+    case 0x00, '\t', '\n', '\f', '\r', ' ', '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+        return true
+    }
+    return false
+}
+
+// return the dictionary following the trailer keyword at offset
+func parseTrailerAt( fi *fileInput, offset int64 ) ( Dictionary, error ) {
+    if err := fi.fillBuffer( fi.size - offset, offset ); err != nil {
+        return Dictionary{}, err
+    }
+    fi.nextToken()      // trailer
+    fi.nextToken()
+    v, err := getObjectDef( fi, -1 )
+    if err != nil {
+        return Dictionary{}, err
+    }
+    dic, ok := v.(Dictionary)
+    if ! ok {
+        return Dictionary{}, fi.parseErrorf( "Trailer is not a dictionary\n" )
+    }
+    return dic, nil
+}
+
+// return the type of a dictionary or stream value, or "" if none
+func getValueType( v interface{} ) Name {
+    var dic Dictionary
+    switch v := v.(type) {
+    case Dictionary:
+        dic = v
+    case Stream:
+        dic = v.extent
+    default:
+        return ""
+    }
+    t, _ := dic.data["Type"].(Name)
+    return t
+}
+
+// rebuild ObjById, Objects and Trailer from the objects found in the file.
+// reason is the error that prevented using the XREF sections, if any.
+func (pf *PdfFile) rebuildXref( fi *fileInput, reason error ) error {
+    report := &RebuildReport{ TrailerOffset: -1 }
+    if reason != nil {
+        report.Reason = strings.TrimSpace( reason.Error() )
+    }
+    defs, trailers, err := scanObjectDefs( fi )
+    if err != nil {
+        return err
+    }
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+               "Rebuilding XREF from %d object definitions\n", len(defs) )
+
+    // stream lengths are resolved from the latest definitions
+    latest := make( map[int64]objectDef, len(defs) )
+    for _, def := range defs {
+        latest[def.id] = def
+    }
+    fi.deferLength, fi.pending = false, false
+    fi.lengthOf = func( ref Reference ) ( int64, bool ) {
+        def, ok := latest[ref.id]
+        if ! ok || def.gen != ref.gen {
+            return 0, false
+        }
+        return parseLengthAt( fi, ref, def.start )
+    }
+
+    pf.ObjById = make( map[int64]*PdfObject, len(defs) )
+    pf.Objects = nil
+    var lastStop int64
+    for _, def := range defs {
+        if def.start < lastStop {   // inside the previous object (e.g. in stream data)
+            continue
+        }
+        obj := &PdfObject{ id: def.id, gen: def.gen, start: def.start, stop: -1 }
+        if err := pf.parseObjectAt( fi, obj ); err != nil {
+            fi.report( SEVERITY_WARNING, DIAG_DAMAGED_OBJECT, true,
+                       "Damaged object %d %d at offset 0x%x: %v", def.id, def.gen, def.start, err )
+            report.Damaged = append( report.Damaged, def.start )
+            continue
+        }
+        lastStop = obj.stop
+        if _, ok := pf.ObjById[obj.id]; ok {
+            report.Superseded ++
+        }
+        pf.ObjById[obj.id] = obj
+    }
+    fi.diag.setObject( 0, -1 )
+
+    // XREF streams are not kept, but they are possible trailers
+    type trailerDef struct {
+        start   int64
+        dic     Dictionary
+    }
+    candidates := make( []trailerDef, 0, len(trailers) )
+    for _, obj := range pf.ObjById {
+        if getValueType( obj.value ) == "XRef" {
+            candidates = append( candidates, trailerDef{ obj.start, obj.value.(Stream).extent } )
+            delete( pf.ObjById, obj.id )
+        }
+    }
+    for _, t := range trailers {
+        dic, err := parseTrailerAt( fi, t )
+        if err != nil {
+            fi.report( SEVERITY_WARNING, DIAG_TRAILER, true,
+                       "Ignoring damaged trailer at offset 0x%x: %v", t, err )
+            continue
+        }
+        candidates = append( candidates, trailerDef{ t, dic } )
+    }
+    sort.Slice( candidates, func( i, j int ) bool { return candidates[i].start > candidates[j].start } )
+
+    // object streams are encrypted, like other streams
+    for _, c := range candidates {
+        if _, ok := c.dic.data["Encrypt"]; ok {
+            if err := pf.decrypt( fi, c.dic ); err != nil {
+                return err
+            }
+            break
+        }
+    }
+
+    // compressed objects, unless defined directly after their object stream
+    containers := make( []*PdfObject, 0 )
+    for _, obj := range pf.ObjById {
+        if getValueType( obj.value ) == "ObjStm" {
+            containers = append( containers, obj )
+        }
+    }
+    sort.Sort( objBoundaries( containers ) )
+    for _, container := range containers {
+        _, _, ids, _, err := pf.getObjectStream( fi, container.id )
+        if err != nil {
+            fi.report( SEVERITY_WARNING, DIAG_OBJECT_STREAM, true,
+                       "Ignoring damaged object stream %d: %v", container.id, err )
+            continue
+        }
+        for i, id := range ids {
+            if prev, ok := pf.ObjById[id]; ok {
+                if prev.stream == 0 && prev.start > container.start {
+                    continue
+                }
+                report.Superseded ++
+            }
+            pf.ObjById[id] = &PdfObject{ id: id, start: -1, stop: -1,
+                                         stream: container.id, index: int64(i) }
+        }
+    }
+
+    located := make( objBoundaries, 0, len(pf.ObjById) )
+    for _, obj := range pf.ObjById {
+        if obj.stream == 0 {
+            located = append( located, obj )
+        }
+    }
+    sort.Sort( located )
+    pf.Objects = []*PdfObject( located )
+    if len(containers) > 0 {
+        if err := pf.parseCompressedObjects( fi ); err != nil {
+            return err
+        }
+    }
+    for _, obj := range pf.ObjById {
+        if obj.stream != 0 {
+            if obj.value == nil {       // not found in its object stream
+                delete( pf.ObjById, obj.id )
+            } else {
+                report.Compressed ++
+            }
+        }
+    }
+    report.Objects = len(pf.ObjById)
+
+    var size int64
+    for id := range pf.ObjById {
+        if id >= size {
+            size = id + 1
+        }
+    }
+
+    // use the last trailer referring to an existing catalog
+    var trailer Dictionary
+    for _, c := range candidates {
+        if root, ok := c.dic.data["Root"].(Reference); ok {
+            if obj, ok := pf.ObjById[root.id]; ok && getValueType( obj.value ) == "Catalog" {
+                trailer = NewDictionary( )
+                for _, k := range c.dic.Keys() {
+                    if xrefStreamTrailerKeys[k] && k != "Prev" {
+                        trailer.Set( k, c.dic.data[k] )
+                    }
+                }
+                report.TrailerFound = true
+                report.TrailerOffset = c.start
+                break
+            }
+        }
+    }
+    if ! report.TrailerFound {
+        var catalog *PdfObject
+        for _, obj := range pf.Objects {
+            if getValueType( obj.value ) == "Catalog" {
+                catalog = obj       // last one in file
+            }
+        }
+        if catalog == nil {
+            return fmt.Errorf( "No root catalog found in file\n" )
+        }
+        trailer = NewDictionary( )
+        trailer.Set( "Root", Reference{ catalog.id, catalog.gen } )
+        fi.report( SEVERITY_WARNING, DIAG_TRAILER, true,
+                   "Synthesized trailer with root catalog %d %d\n", catalog.id, catalog.gen )
+    }
+    trailer.Set( "Size", Number(size) )
+    if err := pf.setTrailer( fi, trailer ); err != nil {
+        return err
+    }
+    pf.Rebuilt = report
+    return nil
+}
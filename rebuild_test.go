@@ -0,0 +1,203 @@
+package pdf
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// return a new document with n pages, each with a small content stream
+func newTestDocument( t *testing.T, n int ) *PdfFile {
+    pf, err := NewDocument( &DocumentArgs{ Title: "Test", Deterministic: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for i := 0; i < n; i++ {
+        content := NewStream( NewDictionary( ), []byte( fmt.Sprintf( "0 0 %d %d re f", 10 * (i+1), 10 * (i+1) ) ) )
+        dict := NewDictionary( )
+        dict.Set( "MediaBox", NewArray( Number(0), Number(0), Number(612), Number(792) ) )
+        obj := pf.NewObject( content )
+        dict.Set( "Contents", NewReference( obj.ID( ), obj.Gen( ) ) )
+        if _, err = pf.AppendPage( dict ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    return pf
+}
+
+// return the document written with args
+func writeTestDocument( t *testing.T, pf *PdfFile, args *WriteArgs ) []byte {
+    var b bytes.Buffer
+    if _, err := pf.Write( &b, args ); err != nil {
+        t.Fatal( err )
+    }
+    return b.Bytes()
+}
+
+// check that the parsed document has n pages and the expected title
+func checkTestDocument( t *testing.T, pf *PdfFile, n int, title string ) {
+    t.Helper( )
+    if np, err := pf.NumPages( ); err != nil || np != n {
+        t.Errorf( "NumPages: got %d %v, expected %d", np, err, n )
+    }
+    info, err := pf.Resolve( pf.Info )
+    if err != nil {
+        t.Errorf( "Info: %v", err )
+        return
+    }
+    dic, _ := info.(Dictionary)
+    if v, _ := dic.Get( "Title" ); v != String(title) {
+        t.Errorf( "Info Title: got %v, expected %s", v, title )
+    }
+}
+
+func TestScanObjectDefs( t *testing.T ) {
+    data := "%PDF-1.4\n1 0 obj\n<< >>\nendobj\n" +
+            "12 3 obj\n[ 1 2 ]\nendobj\n" +
+            "x4 0 obj 5 0 objx\n" +               // not definitions
+            "6\r\n0\tobj\n(trailer)\nendobj\n" +
+            "trailer\n<< /Size 13 >>\n"
+    fi := newMemoryInput( []byte(data), nil, false )
+    defs, trailers, err := scanObjectDefs( fi )
+    if err != nil {
+        t.Fatal( err )
+    }
+    expected := []objectDef{ { 1, 0, 9 }, { 12, 3, 30 }, { 6, 0, int64(strings.Index( data, "6\r\n" )) } }
+    if len(defs) != len(expected) {
+        t.Fatalf( "scanObjectDefs: got %v, expected %v", defs, expected )
+    }
+    for i, def := range defs {
+        if def != expected[i] {
+            t.Errorf( "Definition #%d: got %v, expected %v", i, def, expected[i] )
+        }
+    }
+    // the trailer string in object 6 is found as well, it is ignored later
+    // since it is not followed by a dictionary
+    last := int64(strings.LastIndex( data, "trailer" ))
+    if len(trailers) != 2 || trailers[1] != last {
+        t.Errorf( "Trailers: got %v, expected 2 with the last at %d", trailers, last )
+    }
+}
+
+func TestScanObjectDefsAcrossChunks( t *testing.T ) {
+    // a definition overlapping the end of the first chunk, and another one
+    // starting in the overlap, both found in the second chunk
+    data := make( []byte, INPUT_BUFFER_SIZE + 128 )
+    for i := range data {
+        data[i] = ' '
+    }
+    first := INPUT_BUFFER_SIZE - 6
+    copy( data[first:], "7 0 obj" )
+    second := INPUT_BUFFER_SIZE - _SCAN_OVERLAP + 10
+    copy( data[second:], "8 0 obj" )
+    fi := newMemoryInput( data, nil, false )
+    defs, _, err := scanObjectDefs( fi )
+    if err != nil {
+        t.Fatal( err )
+    }
+    expected := []objectDef{ { 8, 0, int64(second) }, { 7, 0, int64(first) } }  // in file order
+    if len(defs) != 2 || defs[0] != expected[0] || defs[1] != expected[1] {
+        t.Errorf( "scanObjectDefs: got %v, expected %v", defs, expected )
+    }
+}
+
+func TestRebuildRequested( t *testing.T ) {
+    data := writeTestDocument( t, newTestDocument( t, 3 ), nil )
+    pf, err := ParseBytes( data, &ParseArgs{ Rebuild: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    r := pf.Rebuilt
+    if r == nil {
+        t.Fatal( "Rebuilt: got nil, expected a report" )
+    }
+    if r.Reason != "" || ! r.TrailerFound || r.Objects != len(pf.ObjById) || len(r.Damaged) != 0 {
+        t.Errorf( "Rebuilt: got %+v", *r )
+    }
+    if pf.NumRevisions( ) != 0 {
+        t.Errorf( "NumRevisions: got %d, expected 0", pf.NumRevisions( ) )
+    }
+    checkTestDocument( t, pf, 3, "Test" )
+}
+
+func TestRebuildTruncatedXref( t *testing.T ) {
+    data := writeTestDocument( t, newTestDocument( t, 2 ), nil )
+    data = data[:bytes.LastIndex( data, []byte("\nxref\n") ) + 1]   // without XREF table and trailer
+
+    if _, err := ParseBytes( data, nil ); err == nil {
+        t.Fatal( "Parse without fix: no error" )
+    }
+    pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    r := pf.Rebuilt
+    if r == nil || r.Reason == "" || r.TrailerFound || r.TrailerOffset != -1 {
+        t.Fatalf( "Rebuilt: got %+v, expected a synthesized trailer", r )
+    }
+    if _, ok := pf.Trailer.Get( "Root" ); ! ok {
+        t.Errorf( "Trailer: no Root in %v", pf.Trailer )
+    }
+    if np, err := pf.NumPages( ); err != nil || np != 2 {
+        t.Errorf( "NumPages: got %d %v, expected 2", np, err )
+    }
+    if n := CountDiagnostics( pf.Diagnostics, SEVERITY_WARNING ); n == 0 {
+        t.Errorf( "Diagnostics: no warning in %v", pf.Diagnostics )
+    }
+}
+
+func TestRebuildObjectStreams( t *testing.T ) {
+    data := writeTestDocument( t, newTestDocument( t, 2 ), &WriteArgs{ ObjectStreams: true } )
+    // wrong startxref offset
+    i := bytes.LastIndex( data, []byte("startxref") )
+    data = append( data[:i:i], []byte("startxref\n999999\n%%EOF\n")... )
+
+    pf, err := ParseBytes( data, &ParseArgs{ Fix: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    r := pf.Rebuilt
+    if r == nil || r.Compressed == 0 || ! r.TrailerFound {
+        t.Fatalf( "Rebuilt: got %+v, expected compressed objects and the XREF stream trailer", r )
+    }
+    for _, obj := range pf.ObjById {
+        if getValueType( obj.value ) == "XRef" {
+            t.Errorf( "XREF stream %d kept as an object", obj.id )
+        }
+    }
+    checkTestDocument( t, pf, 2, "Test" )
+}
+
+func TestRebuildSupersededAndDamaged( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    data := writeTestDocument( t, pf, nil )
+    parsed, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    info := parsed.ObjById[parsed.Info.id]
+    dic := info.Value( ).(Dictionary).clone( )
+    dic.Set( "Title", String("Updated") )
+    info.SetValue( dic )
+    var update bytes.Buffer
+    if _, err = parsed.WriteIncrementalTo( &update ); err != nil {
+        t.Fatal( err )
+    }
+    // a damaged definition, followed by the update
+    data = append( data, []byte("99 0 obj\n<< /Broken [ 1 2\nendobj\n")... )
+    data = append( data, update.Bytes()... )
+
+    pf, err = ParseBytes( data, &ParseArgs{ Rebuild: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    r := pf.Rebuilt
+    if r.Superseded != 1 || len(r.Damaged) != 1 {
+        t.Errorf( "Rebuilt: got %+v, expected 1 superseded and 1 damaged object", *r )
+    }
+    if _, ok := pf.ObjById[99]; ok {
+        t.Errorf( "Damaged object 99 was recovered" )
+    }
+    checkTestDocument( t, pf, 1, "Updated" )
+}
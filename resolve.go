@@ -0,0 +1,133 @@
+
+package pdf
+
+import (
+    "fmt"
+    "errors"
+)
+
+// Reasons for failing to resolve a reference, wrapped in a ReferenceError
+var (
+    ErrDanglingReference    = errors.New( "reference to a non-existing object" )
+    ErrGenerationMismatch   = errors.New( "reference generation does not match object generation" )
+    ErrReferenceCycle       = errors.New( "reference cycle" )
+)
+
+// ReferenceError is returned when a reference cannot be resolved. Err is one
+// of ErrDanglingReference, ErrGenerationMismatch or ErrReferenceCycle, so
+// that errors.Is can be used on the returned error.
+type ReferenceError struct {
+    Ref         Reference   // reference that failed
+    Err         error       // reason
+}
+
+func (e *ReferenceError) Error( ) string {
+    return fmt.Sprintf( "Reference %d %d: %v", e.Ref.id, e.Ref.gen, e.Err )
+}
+
+func (e *ReferenceError) Unwrap( ) error {
+    return e.Err
+}
+
+// Resolve returns the value of the object referred to by v, if v is a
+// Reference, following a chain of references if needed, or v itself if it
+// is not a Reference. Note that the PDF specification allows treating a
+// dangling reference as a Null object, which can be done by testing the
+// error with errors.Is( err, ErrDanglingReference ).
+func (pf *PdfFile) Resolve( v interface{} ) ( interface{}, error ) {
+    var visited map[int64]bool      // only for chains of references
+    for {
+        ref, ok := v.(Reference)
+        if ! ok {
+            return v, nil
+        }
+        if visited == nil {
+            visited = make( map[int64]bool )
+        }
+        if visited[ref.id] {
+            return nil, &ReferenceError{ ref, ErrReferenceCycle }
+        }
+        visited[ref.id] = true
+
+        obj, ok := pf.ObjById[ref.id]
+        if ! ok || obj.value == nil {
+            return nil, &ReferenceError{ ref, ErrDanglingReference }
+        }
+        if obj.gen != ref.gen {
+            return nil, &ReferenceError{ ref, ErrGenerationMismatch }
+        }
+        v = obj.value
+    }
+}
+
+/*
+ResolveAll returns the value v after replacing recursively all references it
+contains, in dictionaries, streams or arrays, with the value of the objects
+they refer to. References back to an object that is being resolved (e.g. the
+Parent entry in a page) are kept unresolved, since they would create a loop.
+
+If inPlace is true, the dictionaries and arrays that are part of v itself are
+modified in place. The referred objects are never modified: their values are
+copied before being inserted in v. If inPlace is false, v is not modified and
+the returned value is a resolved copy.
+*/
+func (pf *PdfFile) ResolveAll( v interface{}, inPlace bool ) ( interface{}, error ) {
+    return pf.resolveTree( v, make( map[int64]bool ), inPlace )
+}
+
+func (pf *PdfFile) resolveTree( v interface{}, path map[int64]bool, inPlace bool ) ( interface{}, error ) {
+    switch v := v.(type) {
+    case Reference:
+        if path[v.id] {     // back reference, keep it as is
+            return v, nil
+        }
+        target, err := pf.Resolve( v )
+        if err != nil {
+            return nil, err
+        }
+        path[v.id] = true
+        res, err := pf.resolveTree( target, path, false )
+        delete( path, v.id )
+        return res, err
+
+    case Dictionary:
+        return pf.resolveDictionary( v, path, inPlace )
+
+    case Stream:
+        dic, err := pf.resolveDictionary( v.extent, path, inPlace )
+        if err != nil {
+            return nil, err
+        }
+        return Stream{ dic, v.data }, nil
+
+    case Array:
+        res := v
+        if ! inPlace {
+            res = Array{ make( []interface{}, len(v.data) ) }
+        }
+        for i, e := range v.data {
+            r, err := pf.resolveTree( e, path, inPlace )
+            if err != nil {
+                return nil, err
+            }
+            res.data[i] = r
+        }
+        return res, nil
+    }
+    return v, nil
+}
+
+func (pf *PdfFile) resolveDictionary( d Dictionary, path map[int64]bool, inPlace bool ) ( Dictionary, error ) {
+    res := d
+    if ! inPlace {
+        res = Dictionary{ make( []string, 0, len(d.data) ), make( map[string]interface{}, len(d.data) ) }
+    }
+    for _, k := range d.Keys() {
+        r, err := pf.resolveTree( d.data[k], path, inPlace )
+        if err != nil {
+            return res, fmt.Errorf( "/%s: %w", k, err )
+        }
+        res.Set( k, r )
+    }
+    return res, nil
+}
@@ -0,0 +1,121 @@
+package pdf
+
+import (
+    "errors"
+    "testing"
+)
+
+// a document with chains of references, a reference cycle and a dangling
+// reference
+var testResolveObjects = []string{
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    "<< /Type /Pages /Kids [ 3 0 R ] /Count 1 >>",
+    "<< /Type /Page /Parent 2 0 R /MediaBox [ 0 0 100 100 ] >>",
+    "5 0 R",
+    "6 0 R",
+    "(end)",
+    "8 0 R",
+    "7 0 R",
+    "<< /A 4 0 R /B [ 6 0 R 10 0 R ] >>",
+    "<< /Dangling 99 0 R >>",
+    "<< /A 4 0 R /B [ 6 0 R ] /Self 11 0 R /Page 3 0 R >>",
+}
+
+func parseTestResolve( t *testing.T ) *PdfFile {
+    pf, err := ParseBytes( makeTestFile( testResolveObjects, "/Root 1 0 R" ), nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return pf
+}
+
+func TestResolve( t *testing.T ) {
+    pf := parseTestResolve( t )
+    if v, err := pf.Resolve( Number(1) ); err != nil || v != Number(1) {
+        t.Errorf( "Direct value: got %v %v", v, err )
+    }
+    if v, err := pf.Resolve( NewReference( 4, 0 ) ); err != nil || v != String("end") {
+        t.Errorf( "Chain of references: got %v %v", v, err )
+    }
+
+    tests := []struct {
+        ref     Reference
+        failed  Reference
+        err     error
+    }{
+        { NewReference( 99, 0 ), NewReference( 99, 0 ), ErrDanglingReference },
+        { NewReference( 6, 1 ), NewReference( 6, 1 ), ErrGenerationMismatch },
+        { NewReference( 7, 0 ), NewReference( 7, 0 ), ErrReferenceCycle },
+        { NewReference( 8, 0 ), NewReference( 8, 0 ), ErrReferenceCycle },
+    }
+    for _, test := range tests {
+        v, err := pf.Resolve( test.ref )
+        if ! errors.Is( err, test.err ) {
+            t.Errorf( "Reference %d %d: got %v %v, expected %v", test.ref.id, test.ref.gen, v, err, test.err )
+            continue
+        }
+        var re *ReferenceError
+        if ! errors.As( err, &re ) || re.Ref != test.failed {
+            t.Errorf( "Reference %d %d: got error %v, expected a ReferenceError for %v", test.ref.id,
+                      test.ref.gen, err, test.failed )
+        }
+    }
+
+    // a chain ending with a dangling reference fails on the last reference
+    pf.ObjById[6].SetValue( NewReference( 99, 0 ) )
+    _, err := pf.Resolve( NewReference( 4, 0 ) )
+    var re *ReferenceError
+    if ! errors.As( err, &re ) || re.Ref != NewReference( 99, 0 ) || ! errors.Is( err, ErrDanglingReference ) {
+        t.Errorf( "Chain to a dangling reference: got %v", err )
+    }
+}
+
+func TestResolveAll( t *testing.T ) {
+    pf := parseTestResolve( t )
+    if _, err := pf.ResolveAll( NewReference( 9, 0 ), false ); ! errors.Is( err, ErrDanglingReference ) {
+        t.Errorf( "Dangling reference in an array: got %v", err )
+    }
+    if _, err := pf.ResolveAll( NewArray( NewReference( 7, 0 ) ), false ); ! errors.Is( err, ErrReferenceCycle ) {
+        t.Errorf( "Reference cycle: got %v", err )
+    }
+
+    v, err := pf.ResolveAll( NewReference( 11, 0 ), false )
+    if err != nil {
+        t.Fatal( err )
+    }
+    d := v.(Dictionary)
+    if a, _ := d.Get( "A" ); a != String("end") {
+        t.Errorf( "/A: got %v", a )
+    }
+    if b, _ := d.Get( "B" ); ! equalValues( b, NewArray( String("end") ) ) {
+        t.Errorf( "/B: got %v", b )
+    }
+    if self, _ := d.Get( "Self" ); self != NewReference( 11, 0 ) {
+        t.Errorf( "/Self: got %v, expected the back reference", self )
+    }
+    page, _ := d.Get( "Page" )
+    parent, _ := page.(Dictionary).Get( "Parent" )
+    kids, _ := parent.(Dictionary).Get( "Kids" )
+    if kids.(Array).Index( 0 ) != NewReference( 3, 0 ) {
+        t.Errorf( "Page /Parent /Kids: got %v, expected the back reference", kids )
+    }
+    // referred objects are not modified
+    if parent, _ := pf.ObjById[3].Value( ).(Dictionary).Get( "Parent" ); parent != NewReference( 2, 0 ) {
+        t.Errorf( "Page object was modified: /Parent %v", parent )
+    }
+    if a, _ := pf.ObjById[11].Value( ).(Dictionary).Get( "A" ); a != NewReference( 4, 0 ) {
+        t.Errorf( "Object 11 was modified: /A %v", a )
+    }
+
+    // in place, only the given value is modified
+    value := pf.ObjById[11].Value( )
+    if _, err = pf.ResolveAll( value, true ); err != nil {
+        t.Fatal( err )
+    }
+    if a, _ := value.(Dictionary).Get( "A" ); a != String("end") {
+        t.Errorf( "In place /A: got %v", a )
+    }
+    if parent, _ := pf.ObjById[3].Value( ).(Dictionary).Get( "Parent" ); parent != NewReference( 2, 0 ) {
+        t.Errorf( "Page object was modified in place: /Parent %v", parent )
+    }
+}
@@ -0,0 +1,302 @@
+
+package pdf
+
+import (
+    "fmt"
+    "os"
+    "io"
+    "sort"
+    "bytes"
+)
+
+/*
+Incremental updates: each update appends a body, a XREF section and a trailer
+to the previous file content, up to a new %%EOF marker. A revision is the
+file as it was after an update, the original document being the first
+revision. Revisions are kept in file order, from the original document to the
+latest update, when the document is parsed from its XREF sections (they are
+not available if the XREF was rebuilt).
+
+The objects changed by an update are found by comparing its XREF entries with
+the entries of all previous revisions: an entry giving the same location as
+before is not a change.
+
+An incremental update can be appended to the parsed file, leaving the previous
+revisions unchanged (e.g. to keep digital signatures valid). It is made of the
+objects created, modified (see PdfObject.SetValue) or deleted since the file
+was parsed, followed by a XREF table and a trailer whose Prev entry gives the
+offset of the previous XREF section.
+*/
+
+// Revision describes the document as it was after an incremental update
+type Revision struct {
+    Start, End  int64       // byte range [Start, End) in file, up to and including %%EOF
+    XrefOffset  int64       // XREF section offset, as given by startxref
+    XrefStream  bool        // true if the XREF section is a stream or a hybrid section
+    Trailer     Dictionary  // trailer, or XREF stream dictionary entries used as trailer
+    Added       []Reference // objects defined for the first time in this revision
+    Modified    []Reference // objects redefined in this revision
+    Deleted     []Reference // objects freed in this revision
+    entries     []xrefEntry // as found in the XREF section
+}
+
+// XREF entry, either in use or free
+type xrefEntry struct {
+    id, gen     int64
+    start       int64       // file offset, if not compressed
+    stream      int64       // containing object stream ID, 0 if not compressed
+    index       int64       // index in containing object stream
+    inUse       bool
+}
+
+// return the end of the revision whose XREF section ends at from, that is
+// the offset following the %%EOF marker and its end of line. If no marker
+// is found before limit, from is returned.
+func findRevisionEnd( fi *fileInput, from, limit int64 ) int64 {
+    if limit - from > _STARTXREF_SIZE {
+        limit = from + _STARTXREF_SIZE
+    }
+    if limit <= from {
+        return from
+    }
+    data := make( []byte, limit - from )
+    n, _ := fi.r.ReadAt( data, from )
+    data = data[:n]
+    i := bytes.Index( data, []byte("%%EOF") )
+    if i == -1 {
+        return from
+    }
+    i += 5
+    if i < n && data[i] == '\r' { i++ }
+    if i < n && data[i] == '\n' { i++ }
+    return from + int64(i)
+}
+
+// return the section entries with a single entry per object, in order. As
+// when loading objects, the first entry in use wins, which matters for hybrid
+// sections where the table may give compressed objects as free.
+func sectionEntries( entries []xrefEntry ) []xrefEntry {
+    index := make( map[int64]int, len(entries) )
+    res := make( []xrefEntry, 0, len(entries) )
+    for _, e := range entries {
+        i, ok := index[e.id]
+        if ! ok {
+            index[e.id] = len(res)
+            res = append( res, e )
+        } else if e.inUse && ! res[i].inUse {
+            res[i] = e
+        }
+    }
+    return res
+}
+
+// set the revisions, once they are all known (given from the latest to the
+// original one).
+func (pf *PdfFile) setRevisions( revisions []*Revision ) {
+    pf.Revisions = make( []*Revision, len(revisions) )
+    for i, rev := range revisions {
+        pf.Revisions[len(revisions)-1-i] = rev
+    }
+    pf.setRevisionChanges( )
+}
+
+// set the revision byte ranges and the objects each revision changed. It
+// returns the XREF entries of the objects in use after the last revision.
+func (pf *PdfFile) setRevisionChanges( ) map[int64]xrefEntry {
+    current := make( map[int64]xrefEntry )
+    var start int64
+    for _, rev := range pf.Revisions {
+        rev.Start = start
+        start = rev.End
+        rev.Added, rev.Modified, rev.Deleted = nil, nil, nil
+        for _, e := range sectionEntries( rev.entries ) {
+            prev, defined := current[e.id]
+            if ! e.inUse {
+                if defined {
+                    rev.Deleted = append( rev.Deleted, Reference{ e.id, prev.gen } )
+                    delete( current, e.id )
+                }
+                continue
+            }
+            if ! defined {
+                rev.Added = append( rev.Added, Reference{ e.id, e.gen } )
+            } else if prev != e {
+                rev.Modified = append( rev.Modified, Reference{ e.id, e.gen } )
+            }
+            current[e.id] = e
+        }
+    }
+    return current
+}
+
+// NumRevisions returns the number of revisions in the document: 1 if it was
+// never updated, 0 if the revisions are unknown (XREF was rebuilt).
+func (pf *PdfFile) NumRevisions( ) int {
+    return len(pf.Revisions)
+}
+
+// WriteIncremental writes to w an incremental update of the parsed document,
+// made of the objects created, modified or deleted since the document was
+// parsed or since the last update. The update must be appended to the parsed
+// input, unchanged, since it refers to the original objects and XREF section
+// by their file offsets. Nothing is written if nothing has changed. Once
+// written, the update is the latest document revision.
+//
+// The update is encrypted like the parsed document, if it was encrypted. Only
+// args.Deterministic is used, if args is not nil: the new file identifier is
+// then computed from the document objects, and AES IVs are derived from the
+// encryption key and identifiers instead of being random (see Write).
+//
+// It returns the number of bytes written and the first error, if any.
+func (pdf *PdfFile) WriteIncremental( w io.Writer, args *WriteArgs ) ( int64, error ) {
+    return pdf.writeIncremental( w, args, "" )
+}
+
+// WriteIncrementalTo writes to w an incremental update of the parsed
+// document. See WriteIncremental.
+func (pdf *PdfFile) WriteIncrementalTo( w io.Writer ) ( int64, error ) {
+    return pdf.WriteIncremental( w, nil )
+}
+
+// write an incremental update to w, which is the file path if known
+func (pdf *PdfFile) writeIncremental( w io.Writer, args *WriteArgs, path string ) ( int64, error ) {
+    if args == nil {
+        args = &WriteArgs{ }
+    }
+    if len(pdf.Revisions) == 0 {
+        return 0, fmt.Errorf( "Incremental update requires the XREF sections of a parsed document\n" )
+    }
+    current := pdf.setRevisionChanges( )
+
+    objs := make( []*PdfObject, 0 )
+    for _, obj := range pdf.ObjById {
+        if obj.modified && obj.value != nil {
+            objs = append( objs, obj )
+        }
+    }
+    sort.Slice( objs, func( i, j int ) bool { return objs[i].id < objs[j].id } )
+    free := make( []xrefEntry, 0, len(pdf.deleted) )
+    for _, ref := range pdf.deleted {
+        if _, ok := current[ref.id]; ok {   // otherwise not in previous revisions
+            free = append( free, xrefEntry{ id: ref.id, gen: ref.gen + 1 } )
+        }
+    }
+    if len(objs) == 0 && len(free) == 0 {
+        return 0, nil
+    }
+
+    ids := pdf.outputFileId( path, args.Deterministic )
+    f := newPdfWriter( w )
+    f.security = pdf.security       // the update is encrypted like the document
+    if pdf.security != nil && args.Deterministic {
+        sh := *pdf.security         // IVs derived from the key and identifiers
+        sh.random = newDeterministicReader( sh.key, fileIdBytes( ids.data[0] ), fileIdBytes( ids.data[1] ) )
+        f.security = &sh
+    }
+    f.pos = pdf.inputSize
+    if ! pdf.inputEOL {
+        f.WriteString( "\n" )
+    }
+    entries := make( []xrefEntry, len(objs), len(objs) + len(free) )
+    starts := make( []int64, len(objs) )
+    for i, obj := range objs {
+        starts[i] = f.pos
+        entries[i] = xrefEntry{ id: obj.id, gen: obj.gen, start: f.pos, inUse: true }
+        f.writeObject( obj.id, obj.gen, obj.value )
+    }
+    // free entries are linked by their offset field
+    sort.Slice( free, func( i, j int ) bool { return free[i].id < free[j].id } )
+    for i := 0; i < len(free) - 1; i++ {
+        free[i].start = free[i+1].id
+    }
+    entries = append( entries, free... )
+    sort.Slice( entries, func( i, j int ) bool { return entries[i].id < entries[j].id } )
+
+    xrefPos := f.pos
+    f.WriteString( "xref\n" )
+    for i := 0; i < len(entries); {    // one subsection per range of consecutive IDs
+        j := i + 1
+        for j < len(entries) && entries[j].id == entries[j-1].id + 1 {
+            j++
+        }
+        fmt.Fprintf( f, "%d %d\n", entries[i].id, j - i )
+        for _, e := range entries[i:j] {
+            if e.inUse {
+                fmt.Fprintf( f, "%010d %05d n\r\n", e.start, e.gen )
+            } else {
+                fmt.Fprintf( f, "%010d %05d f\r\n", e.start, e.gen )
+            }
+        }
+        i = j
+    }
+
+    size := pdf.Size
+    for _, e := range entries {
+        if e.id >= size {
+            size = e.id + 1
+        }
+    }
+    trailer := pdf.Trailer.clone( )
+    trailer.Delete( "XRefStm" )
+    trailer.Set( "Size", Number(size) )
+    trailer.Set( "Root", pdf.Catalog )
+    if pdf.Info.id != 0 {
+        trailer.Set( "Info", pdf.Info )
+    }
+    if pdf.Encrypt.id != 0 {
+        trailer.Set( "Encrypt", pdf.Encrypt )
+    }
+    trailer.Set( "ID", ids )
+    trailer.Set( "Prev", Number(pdf.Revisions[len(pdf.Revisions)-1].XrefOffset) )
+    f.WriteString( "trailer\n" )
+    serializeDictionary( f, trailer )
+    fmt.Fprintf( f, "\nstartxref\n%d\n%%%%EOF\n", xrefPos )
+    if err := f.flush( ); err != nil {
+        return f.out.n, fmt.Errorf( "Error writing incremental update: %v", err )
+    }
+
+    // the update is now the latest revision
+    for i, obj := range objs {
+        obj.start, obj.stop = starts[i], -1
+        obj.stream, obj.index = 0, 0
+        obj.modified, obj.created = false, false
+    }
+    pdf.deleted = nil
+    pdf.Size = size
+    pdf.Trailer.Set( "Size", Number(size) )
+    pdf.Id = &ids
+    pdf.Trailer.Set( "ID", ids )
+    pdf.inputSize += f.out.n
+    pdf.inputEOL = true
+    pdf.Revisions = append( pdf.Revisions, &Revision{ End: pdf.inputSize, XrefOffset: xrefPos,
+                                                      XrefStream: false, Trailer: trailer, entries: entries } )
+    pdf.setRevisionChanges( )
+    return f.out.n, nil
+}
+
+// WriteIncrementalFile appends an incremental update to the file name, which
+// must be the parsed file, unchanged. See WriteIncremental.
+func (pdf *PdfFile) WriteIncrementalFile( name string, args *WriteArgs ) error {
+    f, err := os.OpenFile( name, os.O_WRONLY | os.O_APPEND, 0 )
+    if err != nil {
+        return err
+    }
+    fs, err := f.Stat( )
+    if err == nil && fs.Size( ) != pdf.inputSize {
+        err = fmt.Errorf( "File %s size %d does not match the parsed document size %d\n",
+                          name, fs.Size( ), pdf.inputSize )
+    }
+    if err == nil {
+        _, err = pdf.writeIncremental( f, args, name )
+    }
+    if cErr := f.Close( ); err == nil {
+        err = cErr
+    }
+    return err
+}
+
+// SerializeIncremental appends an incremental update to the file name, which
+// must be the parsed file, unchanged. See WriteIncrementalFile.
+func (pdf *PdfFile) SerializeIncremental( name string ) error {
+    return pdf.WriteIncrementalFile( name, nil )
+}
@@ -0,0 +1,245 @@
+package pdf
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "testing"
+)
+
+// return data followed by the incremental update made by update on the
+// document parsed from data
+func updateTestDocument( t *testing.T, data []byte, update func( pf *PdfFile ) ) []byte {
+    t.Helper( )
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    update( pf )
+    var b bytes.Buffer
+    if _, err = pf.WriteIncrementalTo( &b ); err != nil {
+        t.Fatal( err )
+    }
+    return append( append( make( []byte, 0, len(data) + b.Len() ), data... ), b.Bytes()... )
+}
+
+// set the document title, in a modified Info dictionary
+func setTestTitle( t *testing.T, pf *PdfFile, title string ) {
+    t.Helper( )
+    info, ok := pf.ObjById[pf.Info.id]
+    if ! ok {
+        t.Fatalf( "Info %d is not defined", pf.Info.id )
+    }
+    dic := info.Value( ).(Dictionary).clone( )
+    dic.Set( "Title", String(title) )
+    info.SetValue( dic )
+}
+
+// return a document with 2 pages and 3 revisions: the second changes the
+// title and adds an object, the third changes the title again and deletes
+// the first page
+func newTestRevisions( t *testing.T ) []byte {
+    data := writeTestDocument( t, newTestDocument( t, 2 ), nil )
+    data = updateTestDocument( t, data, func( pf *PdfFile ) {
+        setTestTitle( t, pf, "Second" )
+        pf.NewObject( String("Added") )
+    } )
+    return updateTestDocument( t, data, func( pf *PdfFile ) {
+        setTestTitle( t, pf, "Third" )
+        if err := pf.DeletePage( 0 ); err != nil {
+            t.Fatal( err )
+        }
+    } )
+}
+
+func TestParseRevisionsErrors( t *testing.T ) {
+    data := newTestRevisions( t )
+    for _, args := range []*ParseArgs{ { Revisions: -1 }, { Revisions: 1, Rebuild: true }, { Revisions: 4 } } {
+        _, err := ParseBytes( data, args )
+        var pe *ParseError
+        if ! errors.As( err, &pe ) {
+            t.Errorf( "Revisions %d rebuild %v: got %v, expected a ParseError", args.Revisions, args.Rebuild, err )
+            continue
+        }
+        if msg := pe.Error( ); msg == "" || msg[len(msg)-1] == '\n' {
+            t.Errorf( "Revisions %d: got message %q", args.Revisions, msg )
+        }
+    }
+    for n, title := range []string{ "Test", "Second", "Third" } {
+        pf, err := ParseBytes( data, &ParseArgs{ Revisions: n + 1 } )
+        if err != nil {
+            t.Fatalf( "Revisions %d: %v", n + 1, err )
+        }
+        checkTestDocument( t, pf, 2 - n / 2, title )
+    }
+}
+
+// check that refs are the expected object IDs, with the expected generation
+func checkReferences( t *testing.T, what string, refs []Reference, ids []int64, gen int64 ) {
+    t.Helper( )
+    if len(refs) != len(ids) {
+        t.Errorf( "%s: got %v, expected IDs %v", what, refs, ids )
+        return
+    }
+    for i, ref := range refs {
+        if ref.id != ids[i] || ref.gen != gen {
+            t.Errorf( "%s: got %v, expected IDs %v generation %d", what, refs, ids, gen )
+            return
+        }
+    }
+}
+
+func TestRevisionChanges( t *testing.T ) {
+    data := newTestRevisions( t )
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if pf.NumRevisions( ) != 3 {
+        t.Fatalf( "NumRevisions: got %d, expected 3", pf.NumRevisions( ) )
+    }
+    second, err := ParseBytes( data, &ParseArgs{ Revisions: 2 } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    first, err := second.Page( 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    info, pages := pf.Info.id, first.Dict( ).data["Parent"].(Reference).id
+    changed := []int64{ info, pages }
+    if pages < info {
+        changed = []int64{ pages, info }
+    }
+    all := make( []int64, 0, second.Size - 2 )    // without the object added next
+    for id := int64(1); id < second.Size - 1; id++ {
+        all = append( all, id )
+    }
+    expected := []struct {
+        added, modified, deleted    []int64
+    }{
+        { all, nil, nil },
+        { []int64{ second.Size - 1 }, []int64{ info }, nil },
+        { nil, changed, []int64{ first.obj.id } },
+    }
+    for i, rev := range pf.Revisions {
+        checkReferences( t, fmt.Sprintf( "Revision %d Added", i ), rev.Added, expected[i].added, 0 )
+        checkReferences( t, fmt.Sprintf( "Revision %d Modified", i ), rev.Modified, expected[i].modified, 0 )
+        checkReferences( t, fmt.Sprintf( "Revision %d Deleted", i ), rev.Deleted, expected[i].deleted, 0 )
+    }
+
+    var start int64
+    for i, rev := range pf.Revisions {
+        if rev.Start != start || rev.End <= rev.Start || rev.XrefOffset < rev.Start || rev.XrefOffset >= rev.End {
+            t.Errorf( "Revision %d: range [%d %d) XREF at %d, expected to start at %d",
+                      i, rev.Start, rev.End, rev.XrefOffset, start )
+        }
+        start = rev.End
+        if ! bytes.HasSuffix( data[:rev.End], []byte("%%EOF\n") ) {
+            t.Errorf( "Revision %d does not end with %%%%EOF", i )
+        }
+        prev, ok := rev.Trailer.Get( "Prev" )
+        if i == 0 && ok {
+            t.Errorf( "Revision 0: unexpected Prev %v", prev )
+        } else if i > 0 && prev != Number(pf.Revisions[i-1].XrefOffset) {
+            t.Errorf( "Revision %d: got Prev %v, expected %d", i, prev, pf.Revisions[i-1].XrefOffset )
+        }
+    }
+    if start != int64(len(data)) {
+        t.Errorf( "Last revision ends at %d, expected %d", start, len(data) )
+    }
+
+    // each revision reopened separately has the same changes
+    for n := 1; n <= 3; n++ {
+        rpf, err := ParseBytes( data, &ParseArgs{ Revisions: n } )
+        if err != nil {
+            t.Fatalf( "Revisions %d: %v", n, err )
+        }
+        if rpf.NumRevisions( ) != n || rpf.Revisions[n-1].End != pf.Revisions[n-1].End {
+            t.Errorf( "Revisions %d: got %d revisions", n, rpf.NumRevisions( ) )
+        }
+        for i, rev := range rpf.Revisions {
+            r := pf.Revisions[i]
+            if len(rev.Added) != len(r.Added) || len(rev.Modified) != len(r.Modified) ||
+               len(rev.Deleted) != len(r.Deleted) {
+                t.Errorf( "Revisions %d, revision %d: got changes %v %v %v, expected %v %v %v", n, i,
+                          rev.Added, rev.Modified, rev.Deleted, r.Added, r.Modified, r.Deleted )
+            }
+        }
+    }
+}
+
+func TestWriteIncrementalFreedObject( t *testing.T ) {
+    data := newTestRevisions( t )
+    pf, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    deleted := pf.Revisions[2].Deleted
+    if len(deleted) != 1 {
+        t.Fatalf( "Deleted: got %v, expected 1 page", deleted )
+    }
+    found := false
+    for _, e := range pf.Revisions[2].entries {
+        if e.id == deleted[0].id {
+            found = true
+            if e.inUse || e.gen != deleted[0].gen + 1 {
+                t.Errorf( "Freed object %d: got %+v, expected a free entry at generation %d",
+                          e.id, e, deleted[0].gen + 1 )
+            }
+        }
+    }
+    if ! found {
+        t.Errorf( "Freed object %d has no XREF entry", deleted[0].id )
+    }
+    if _, ok := pf.ObjById[deleted[0].id]; ok {
+        t.Errorf( "Freed object %d is defined", deleted[0].id )
+    }
+
+    // deleting an object created after parsing does not free it
+    obj := pf.NewObject( String("created") )
+    pf.deleteObject( obj )
+    var b bytes.Buffer
+    if n, err := pf.WriteIncrementalTo( &b ); err != nil || n != 0 {
+        t.Errorf( "Update without changes: wrote %d bytes, error %v", n, err )
+    }
+
+    // nothing to update in a new or rebuilt document
+    if _, err = newTestDocument( t, 1 ).WriteIncrementalTo( &b ); err == nil {
+        t.Errorf( "Update of a new document: no error" )
+    }
+}
+
+func TestWriteIncrementalEncrypted( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    args := &WriteArgs{ Encryption: &EncryptionArgs{ Method: ENCRYPT_AES_128,
+                        UserPassword: "user", OwnerPassword: "owner" } }
+    data := writeTestDocument( t, pf, args )
+
+    parsed, err := ParseBytes( data, &ParseArgs{ UserPassword: "user" } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    setTestTitle( t, parsed, "Updated" )
+    var update bytes.Buffer
+    if _, err = parsed.WriteIncrementalTo( &update ); err != nil {
+        t.Fatal( err )
+    }
+    if bytes.Contains( update.Bytes(), []byte("Updated") ) {
+        t.Errorf( "Update is not encrypted" )
+    }
+    if ! bytes.Contains( update.Bytes(), []byte("/Encrypt") ) {
+        t.Errorf( "Update trailer has no Encrypt entry" )
+    }
+    data = append( data, update.Bytes()... )
+    for _, pw := range []*ParseArgs{ { UserPassword: "user" }, { OwnerPassword: "owner" } } {
+        updated, err := ParseBytes( data, pw )
+        if err != nil {
+            t.Fatalf( "Parse with %+v: %v", *pw, err )
+        }
+        if updated.NumRevisions( ) != 2 {
+            t.Errorf( "NumRevisions: got %d, expected 2", updated.NumRevisions( ) )
+        }
+        checkTestDocument( t, updated, 1, "Updated" )
+    }
+}
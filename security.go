@@ -0,0 +1,788 @@
+
+package pdf
+
+import (
+    "fmt"
+    "bytes"
+    "errors"
+    "crypto/md5"
+    "crypto/rc4"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/sha256"
+    "crypto/sha512"
+    "crypto/rand"
+    "encoding/binary"
+    "io"
+)
+
+/*
+Standard security handler: an encrypted document has an Encrypt entry in its
+trailer, referring to the encryption dictionary. All strings and streams are
+encrypted, except in the encryption dictionary itself, in XREF streams, in the
+signature Contents and, optionally, in metadata streams. Objects in object
+streams are not encrypted individually, since the object stream is.
+
+The file encryption key is derived from the user or the owner password:
+  - revisions 2 to 4 use MD5 and RC4 (V 1 and 2), with the RC4 or AES-128
+    (AESV2) crypt filters of V 4. Each object is encrypted with its own key,
+    derived from the file key and the object ID and generation.
+  - revisions 5 and 6 use SHA-256 (R 5, deprecated) or the hash of ISO
+    32000-2 (R 6) and AES-256 (AESV3) with the file key for all objects.
+
+Once parsed, an encrypted document is kept decrypted in memory and it is
+written without encryption, unless encryption is requested when writing: a new
+encryption dictionary is then created, with AES-256 (V 5 R 6) by default or
+AES-128 (V 4 R 4) for older readers. Incremental updates of an encrypted
+document are encrypted with the parsed document key.
+*/
+
+// Returned (wrapped) when the document cannot be opened with the passwords
+var ErrIncorrectPassword = errors.New( "incorrect password" )
+
+// crypt filter methods
+const (
+    _CRYPT_NONE = iota  // Identity
+    _CRYPT_RC4          // V2
+    _CRYPT_AESV2        // AES-128
+    _CRYPT_AESV3        // AES-256
+)
+
+var passwordPadding = []byte{
+    0x28, 0xbf, 0x4e, 0x5e, 0x4e, 0x75, 0x8a, 0x41, 0x64, 0x00, 0x4e, 0x56, 0xff, 0xfa, 0x01, 0x08,
+    0x2e, 0x2e, 0x00, 0xb6, 0xd0, 0x68, 0x3e, 0x80, 0x2f, 0x0c, 0xa9, 0xfe, 0x64, 0x53, 0x69, 0x7a }
+
+type securityHandler struct {
+    v, r        int
+    key         []byte          // file encryption key
+    stmMethod   int             // default method for streams
+    strMethod   int             // default method for strings
+    filters     map[string]int  // crypt filter methods by name
+    encryptMetadata bool
+    p           int32           // permission flags
+    owner       bool            // opened with the owner password
+    random      io.Reader       // source of salts and IVs when encrypting
+}
+
+// return the 32 bytes padded password, for revisions 2 to 4
+func padPassword( pw []byte ) []byte {
+    padded := make( []byte, 32 )
+    n := copy( padded, pw )
+    copy( padded[n:], passwordPadding )
+    return padded
+}
+
+func rc4Crypt( key, data []byte ) []byte {
+    c, _ := rc4.NewCipher( key )        // key length is always valid (5 to 16 bytes)
+    out := make( []byte, len(data) )
+    c.XORKeyStream( out, data )
+    return out
+}
+
+// return a copy of key with each byte XORed with x
+func xorKey( key []byte, x byte ) []byte {
+    k := make( []byte, len(key) )
+    for i, b := range key {
+        k[i] = b ^ x
+    }
+    return k
+}
+
+// algorithm 2: file encryption key from the padded user password (R 2 to 4)
+func (sh *securityHandler) computeKey( padded, o, id0 []byte, n int ) []byte {
+    h := md5.New()
+    h.Write( padded )
+    h.Write( o )
+    binary.Write( h, binary.LittleEndian, sh.p )
+    h.Write( id0 )
+    if sh.r >= 4 && ! sh.encryptMetadata {
+        h.Write( []byte{ 0xff, 0xff, 0xff, 0xff } )
+    }
+    sum := h.Sum( nil )
+    if sh.r >= 3 {
+        for i := 0; i < 50; i++ {
+            s := md5.Sum( sum[:n] )
+            sum = s[:]
+        }
+    }
+    return sum[:n]
+}
+
+// algorithms 4 and 5: U value from the file encryption key (R 2 to 4). Only
+// the first 16 bytes are significant with R 3 and 4.
+func (sh *securityHandler) computeU( key, id0 []byte ) []byte {
+    if sh.r == 2 {
+        return rc4Crypt( key, passwordPadding )
+    }
+    h := md5.New()
+    h.Write( passwordPadding )
+    h.Write( id0 )
+    u := rc4Crypt( key, h.Sum( nil ) )
+    for i := 1; i <= 19; i++ {
+        u = rc4Crypt( xorKey( key, byte(i) ), u )
+    }
+    return u
+}
+
+// algorithm 3 (first steps): RC4 key used for O, from the owner password
+func (sh *securityHandler) ownerKey( pw []byte, n int ) []byte {
+    s := md5.Sum( padPassword( pw ) )
+    sum := s[:]
+    if sh.r >= 3 {
+        for i := 0; i < 50; i++ {
+            s = md5.Sum( sum )
+            sum = s[:]
+        }
+    }
+    return sum[:n]
+}
+
+// check the user password, given padded, and return the file key if correct
+func (sh *securityHandler) checkUserPassword( padded, o, u, id0 []byte, n int ) []byte {
+    key := sh.computeKey( padded, o, id0, n )
+    expected := sh.computeU( key, id0 )
+    if sh.r >= 3 {
+        if len(u) < 16 || ! bytes.Equal( expected[:16], u[:16] ) {
+            return nil
+        }
+    } else if ! bytes.Equal( expected, u ) {
+        return nil
+    }
+    return key
+}
+
+// algorithm 7: check the owner password by recovering the padded user
+// password from O, and return the file key if correct
+func (sh *securityHandler) checkOwnerPassword( pw, o, u, id0 []byte, n int ) []byte {
+    key := sh.ownerKey( pw, n )
+    padded := o
+    if sh.r == 2 {
+        padded = rc4Crypt( key, o )
+    } else {
+        for i := 19; i >= 0; i-- {
+            padded = rc4Crypt( xorKey( key, byte(i) ), padded )
+        }
+    }
+    return sh.checkUserPassword( padded, o, u, id0, n )
+}
+
+// hash used with R 5 and 6 (algorithm 2.B for R 6)
+func (sh *securityHandler) hash( pw, salt, udata []byte ) []byte {
+    h := sha256.New()
+    h.Write( pw )
+    h.Write( salt )
+    h.Write( udata )
+    k := h.Sum( nil )
+    if sh.r == 5 {
+        return k
+    }
+    // at least 64 rounds, then until the last byte of e is small enough
+    for i := 0; ; i++ {
+        k1 := make( []byte, 0, 64 * (len(pw) + len(k) + len(udata)) )
+        for j := 0; j < 64; j++ {
+            k1 = append( k1, pw... )
+            k1 = append( k1, k... )
+            k1 = append( k1, udata... )
+        }
+        block, _ := aes.NewCipher( k[:16] )
+        e := make( []byte, len(k1) )
+        cipher.NewCBCEncrypter( block, k[16:32] ).CryptBlocks( e, k1 )
+        var sum int
+        for _, b := range e[:16] {
+            sum += int(b)
+        }
+        switch sum % 3 {
+        case 0:
+            s := sha256.Sum256( e )
+            k = s[:]
+        case 1:
+            s := sha512.Sum384( e )
+            k = s[:]
+        case 2:
+            s := sha512.Sum512( e )
+            k = s[:]
+        }
+        if i >= 63 && int(e[len(e)-1]) <= i - 31 {
+            break
+        }
+    }
+    return k[:32]
+}
+
+// decrypt with AES-256 in CBC mode, without IV and padding (UE and OE)
+func aes256DecryptNoIV( key, data []byte ) []byte {
+    block, _ := aes.NewCipher( key )
+    out := make( []byte, len(data) )
+    cipher.NewCBCDecrypter( block, make( []byte, aes.BlockSize ) ).CryptBlocks( out, data )
+    return out
+}
+
+// check the user or owner password with R 5 and 6, return the file key
+func (sh *securityHandler) checkPasswordR6( pw []byte, owner bool, o, u, oe, ue []byte ) []byte {
+    if len(pw) > 127 {
+        pw = pw[:127]
+    }
+    if owner {
+        if ! bytes.Equal( sh.hash( pw, o[32:40], u[:48] ), o[:32] ) {
+            return nil
+        }
+        return aes256DecryptNoIV( sh.hash( pw, o[40:48], u[:48] ), oe )
+    }
+    if ! bytes.Equal( sh.hash( pw, u[32:40], nil ), u[:32] ) {
+        return nil
+    }
+    return aes256DecryptNoIV( sh.hash( pw, u[40:48], nil ), ue )
+}
+
+// return the byte string value of key in the encryption dictionary
+func getBytes( dic Dictionary, key string ) []byte {
+    switch v := dic.data[key].(type) {
+    case String:
+        return []byte(v)
+    case HexString:
+        return []byte(v)
+    }
+    return nil
+}
+
+// return the crypt filter method given by the CFM entry of a crypt filter
+func getCryptMethod( cf Dictionary ) ( int, error ) {
+    cfm, _ := cf.data["CFM"].(Name)
+    switch cfm {
+    case "", "None":
+        return _CRYPT_NONE, nil
+    case "V2":
+        return _CRYPT_RC4, nil
+    case "AESV2":
+        return _CRYPT_AESV2, nil
+    case "AESV3":
+        return _CRYPT_AESV3, nil
+    }
+    return _CRYPT_NONE, fmt.Errorf( "Unsupported crypt filter method %s\n", cfm )
+}
+
+// create a security handler from the encryption dictionary and the first
+// file ID, and authenticate with the owner password if given or else with
+// the user password (empty by default).
+func newSecurityHandler( dic Dictionary, id0 []byte, userPw, ownerPw string ) ( *securityHandler, error ) {
+    if filter, _ := dic.data["Filter"].(Name); filter != "Standard" {
+        return nil, fmt.Errorf( "Unsupported security handler %s\n", filter )
+    }
+    sh := &securityHandler{ encryptMetadata: true, filters: map[string]int{ "Identity": _CRYPT_NONE },
+                             random: rand.Reader }
+    sh.v = getIntParameter( dic.data, "V", 0 )
+    sh.r = getIntParameter( dic.data, "R", 0 )
+    sh.p = int32(uint32(int64(getIntParameter( dic.data, "P", 0 ))))
+    if em, ok := dic.data["EncryptMetadata"].(Bool); ok {
+        sh.encryptMetadata = bool(em)
+    }
+
+    n := getIntParameter( dic.data, "Length", 40 ) / 8   // key length in bytes
+    switch sh.v {
+    case 1, 2:
+        if sh.v == 1 {
+            n = 5
+        }
+        sh.stmMethod, sh.strMethod = _CRYPT_RC4, _CRYPT_RC4
+    case 4, 5:
+        cfs, _ := dic.data["CF"].(Dictionary)
+        for _, name := range cfs.Keys() {
+            cf, ok := cfs.data[name].(Dictionary)
+            if ! ok {
+                return nil, fmt.Errorf( "Crypt filter %s is not a dictionary\n", name )
+            }
+            method, err := getCryptMethod( cf )
+            if err != nil {
+                return nil, err
+            }
+            sh.filters[name] = method
+            if l := getIntParameter( cf.data, "Length", 0 ); sh.v == 4 && l > 0 {
+                n = l
+                if l >= 40 {    // given in bits instead of bytes
+                    n = l / 8
+                }
+            }
+        }
+        var ok bool
+        stmF, _ := dic.data["StmF"].(Name)
+        strF, _ := dic.data["StrF"].(Name)
+        if stmF == "" { stmF = "Identity" }
+        if strF == "" { strF = "Identity" }
+        if sh.stmMethod, ok = sh.filters[string(stmF)]; ! ok {
+            return nil, fmt.Errorf( "Undefined stream crypt filter %s\n", stmF )
+        }
+        if sh.strMethod, ok = sh.filters[string(strF)]; ! ok {
+            return nil, fmt.Errorf( "Undefined string crypt filter %s\n", strF )
+        }
+    default:
+        return nil, fmt.Errorf( "Unsupported encryption algorithm V %d\n", sh.v )
+    }
+    if sh.v < 5 && (n < 5 || n > 16) {     // V 5 uses a 256-bit file key
+        return nil, fmt.Errorf( "Invalid encryption key length %d\n", n * 8 )
+    }
+
+    o, u := getBytes( dic, "O" ), getBytes( dic, "U" )
+    switch sh.r {
+    case 2, 3, 4:
+        if len(o) < 32 || len(u) < 32 {
+            return nil, fmt.Errorf( "Invalid O or U entry in encryption dictionary\n" )
+        }
+        o, u = o[:32], u[:32]
+        if ownerPw != "" {
+            if sh.key = sh.checkOwnerPassword( []byte(ownerPw), o, u, id0, n ); sh.key != nil {
+                sh.owner = true
+                return sh, nil
+            }
+        }
+        if sh.key = sh.checkUserPassword( padPassword( []byte(userPw) ), o, u, id0, n ); sh.key == nil {
+            return nil, ErrIncorrectPassword
+        }
+    case 5, 6:
+        oe, ue := getBytes( dic, "OE" ), getBytes( dic, "UE" )
+        if len(o) < 48 || len(u) < 48 || len(oe) < 32 || len(ue) < 32 {
+            return nil, fmt.Errorf( "Invalid O, U, OE or UE entry in encryption dictionary\n" )
+        }
+        if ownerPw != "" {
+            if sh.key = sh.checkPasswordR6( []byte(ownerPw), true, o, u, oe[:32], ue[:32] ); sh.key != nil {
+                sh.owner = true
+                return sh, nil
+            }
+        }
+        if sh.key = sh.checkPasswordR6( []byte(userPw), false, o, u, oe[:32], ue[:32] ); sh.key == nil {
+            return nil, ErrIncorrectPassword
+        }
+    default:
+        return nil, fmt.Errorf( "Unsupported standard security handler revision R %d\n", sh.r )
+    }
+    return sh, nil
+}
+
+// algorithm 1: object key, from the file key and the object ID and generation
+func (sh *securityHandler) objectKey( id, gen int64, method int ) []byte {
+    if method == _CRYPT_AESV3 {
+        return sh.key
+    }
+    h := md5.New()
+    h.Write( sh.key )
+    h.Write( []byte{ byte(id), byte(id >> 8), byte(id >> 16), byte(gen), byte(gen >> 8) } )
+    if method == _CRYPT_AESV2 {
+        h.Write( []byte("sAlT") )
+    }
+    n := len(sh.key) + 5
+    if n > 16 {
+        n = 16
+    }
+    return h.Sum( nil )[:n]
+}
+
+// decrypt data from object id, gen with the given method
+func (sh *securityHandler) decrypt( data []byte, id, gen int64, method int ) ( []byte, error ) {
+    if method == _CRYPT_NONE {
+        return data, nil
+    }
+    key := sh.objectKey( id, gen, method )
+    if method == _CRYPT_RC4 {
+        return rc4Crypt( key, data ), nil
+    }
+    if len(data) == 0 {
+        return data, nil
+    }
+    if len(data) < 2 * aes.BlockSize || len(data) % aes.BlockSize != 0 {
+        return nil, fmt.Errorf( "Invalid AES encrypted data length %d\n", len(data) )
+    }
+    block, _ := aes.NewCipher( key )
+    out := make( []byte, len(data) - aes.BlockSize )
+    cipher.NewCBCDecrypter( block, data[:aes.BlockSize] ).CryptBlocks( out, data[aes.BlockSize:] )
+    pad := int(out[len(out)-1])         // PKCS#5 padding
+    if pad == 0 || pad > aes.BlockSize {
+        return nil, fmt.Errorf( "Invalid AES padding\n" )
+    }
+    return out[:len(out)-pad], nil
+}
+
+// return the crypt filter method of a stream: Crypt filter if any (which is
+// removed from the stream filters) or the default stream method
+func (sh *securityHandler) streamMethod( s *Stream ) ( int, error ) {
+    filters, isArray := s.extent.data["Filter"].(Array)
+    if ! isArray {
+        if f, ok := s.extent.data["Filter"].(Name); ok {
+            filters = NewArray( f )
+        }
+    }
+    if len(filters.data) == 0 || filters.data[0] != Name("Crypt") {
+        return sh.stmMethod, nil
+    }
+    var parms Dictionary
+    switch p := s.extent.data["DecodeParms"].(type) {
+    case Dictionary:
+        parms = p
+    case Array:
+        if len(p.data) > 0 {
+            parms, _ = p.data[0].(Dictionary)
+        }
+        if len(p.data) > 1 {
+            s.extent.Set( "DecodeParms", Array{ p.data[1:] } )
+        } else {
+            s.extent.Delete( "DecodeParms" )
+        }
+    }
+    if ! isArray || len(filters.data) == 1 {
+        s.extent.Delete( "Filter" )
+        s.extent.Delete( "DecodeParms" )
+    } else {
+        s.extent.Set( "Filter", Array{ filters.data[1:] } )
+    }
+    name, _ := parms.data["Name"].(Name)
+    if name == "" {
+        name = "Identity"
+    }
+    method, ok := sh.filters[string(name)]
+    if ! ok {
+        return _CRYPT_NONE, fmt.Errorf( "Undefined crypt filter %s\n", name )
+    }
+    return method, nil
+}
+
+// decrypt all strings and streams in value v, from object id, gen
+func (sh *securityHandler) decryptValue( v interface{}, id, gen int64 ) ( interface{}, error ) {
+    switch v := v.(type) {
+    case String:
+        d, err := sh.decrypt( []byte(v), id, gen, sh.strMethod )
+        return String(d), err
+    case HexString:
+        d, err := sh.decrypt( []byte(v), id, gen, sh.strMethod )
+        return HexString(d), err
+    case Array:
+        for i, e := range v.data {
+            d, err := sh.decryptValue( e, id, gen )
+            if err != nil {
+                return v, err
+            }
+            v.data[i] = d
+        }
+        return v, nil
+    case Dictionary:
+        _, isSignature := v.data["ByteRange"]  // signature Contents is not encrypted
+        for _, k := range v.Keys() {
+            if isSignature && k == "Contents" {
+                continue
+            }
+            d, err := sh.decryptValue( v.data[k], id, gen )
+            if err != nil {
+                return v, fmt.Errorf( "/%s: %v", k, err )
+            }
+            v.data[k] = d
+        }
+        return v, nil
+    case Stream:
+        if t := getValueType( v ); t == "XRef" || (t == "Metadata" && ! sh.encryptMetadata) {
+            return v, nil
+        }
+        dic, err := sh.decryptValue( v.extent, id, gen )
+        if err != nil {
+            return v, err
+        }
+        s := Stream{ dic.(Dictionary), v.data }
+        method, err := sh.streamMethod( &s )
+        if err != nil {
+            return v, err
+        }
+        if s.data, err = sh.decrypt( v.data, id, gen, method ); err != nil {
+            return v, err
+        }
+        s.extent.Set( "Length", Number(len(s.data)) )
+        return s, nil
+    }
+    return v, nil
+}
+
+// return the bytes of a file identifier, given as a string
+func fileIdBytes( v interface{} ) []byte {
+    switch v := v.(type) {
+    case HexString:
+        return []byte(v)
+    case String:
+        return []byte(v)
+    }
+    return nil
+}
+
+// decrypt the document objects, if the trailer refers to an encryption
+// dictionary. This must be done before loading compressed objects, since
+// object streams are encrypted, and it must be done only once.
+func (pf *PdfFile) decrypt( fi *fileInput, trailer Dictionary ) error {
+    ref, ok := trailer.data["Encrypt"].(Reference)
+    if ! ok {
+        if _, ok = trailer.data["Encrypt"]; ok {
+            return fmt.Errorf( "Encrypt entry in trailer is not a reference\n" )
+        }
+        return nil
+    }
+    obj, ok := pf.ObjById[ref.id]
+    if ! ok || obj.value == nil {
+        return fmt.Errorf( "Encryption dictionary: %v", &ReferenceError{ ref, ErrDanglingReference } )
+    }
+    dic, ok := obj.value.(Dictionary)
+    if ! ok {
+        return fmt.Errorf( "Encryption dictionary is not a dictionary\n" )
+    }
+    var id0 []byte
+    if ids, ok := trailer.data["ID"].(Array); ok && len(ids.data) > 0 {
+        id0 = fileIdBytes( ids.data[0] )
+    }
+    sh, err := newSecurityHandler( dic, id0, fi.userPassword, fi.ownerPassword )
+    if err != nil {
+        return fmt.Errorf( "Cannot decrypt document: %w", err )
+    }
+    fi.report( SEVERITY_INFO, DIAG_PROGRESS, false,
+               "Decrypting document (V %d R %d, owner %t)\n", sh.v, sh.r, sh.owner )
+    for _, o := range pf.ObjById {
+        if o == obj || o.stream != 0 || o.value == nil {
+            continue
+        }
+        v, err := sh.decryptValue( o.value, o.id, o.gen )
+        if err != nil {
+            return fmt.Errorf( "Cannot decrypt object %d %d: %v", o.id, o.gen, err )
+        }
+        o.value = v
+    }
+    pf.security = sh
+    return nil
+}
+
+// EncryptionMethod gives the algorithm used to encrypt a document
+type EncryptionMethod int
+
+const (
+    ENCRYPT_AES_256 EncryptionMethod = iota // V 5 R 6, PDF 2.0 (default)
+    ENCRYPT_AES_128                         // V 4 R 4, PDF 1.6
+)
+
+// Permissions gives what is allowed when a document is opened with the user
+// password. Everything is allowed with the owner password.
+type Permissions struct {
+    Print           bool    // print the document, possibly at low resolution
+    Modify          bool    // modify the document, except as given below
+    Copy            bool    // copy or extract text and graphics
+    Annotate        bool    // add or modify annotations, fill form fields
+    FillForms       bool    // fill existing form fields, even if Annotate is false
+    Accessibility   bool    // extract text and graphics for accessibility
+    Assemble        bool    // insert, rotate or delete pages, create outlines
+    PrintHighQuality bool   // print at full resolution (with Print)
+}
+
+// P entry value: reserved bits 7, 8 and 13 to 32 are set, bits 1 and 2 are
+// cleared and bits 3 to 6 and 9 to 12 are given by the permissions.
+func (p Permissions) flags( ) int32 {
+    f := uint32(0xfffff0c0)
+    for _, b := range []struct{ allowed bool; bit uint32 }{
+                { p.Print, 1 << 2 }, { p.Modify, 1 << 3 }, { p.Copy, 1 << 4 },
+                { p.Annotate, 1 << 5 }, { p.FillForms, 1 << 8 },
+                { p.Accessibility, 1 << 9 }, { p.Assemble, 1 << 10 },
+                { p.PrintHighQuality, 1 << 11 } } {
+        if b.allowed {
+            f |= b.bit
+        }
+    }
+    return int32(f)
+}
+
+// EncryptionArgs gives the parameters for encrypting a document when writing it
+type EncryptionArgs struct {
+    Method          EncryptionMethod
+    UserPassword    string      // needed to open the document, empty if none
+    OwnerPassword   string      // needed to get all permissions, user password if empty
+    Permissions     Permissions
+}
+
+// encrypt with AES-256 in CBC mode, without IV and padding (UE, OE and Perms)
+func aes256EncryptNoIV( key, data []byte ) []byte {
+    block, _ := aes.NewCipher( key )
+    out := make( []byte, len(data) )
+    cipher.NewCBCEncrypter( block, make( []byte, aes.BlockSize ) ).CryptBlocks( out, data )
+    return out
+}
+
+// create a security handler for encrypting a document with the given first
+// file ID, and return it with the new encryption dictionary. The file key (for
+// AES-256), salts and IVs are read from random.
+func newEncryptionHandler( args *EncryptionArgs, id0 []byte,
+                           random io.Reader ) ( *securityHandler, Dictionary, error ) {
+    sh := &securityHandler{ encryptMetadata: true, p: args.Permissions.flags(), random: random }
+    userPw, ownerPw := []byte(args.UserPassword), []byte(args.OwnerPassword)
+    if len(ownerPw) == 0 {
+        ownerPw = userPw
+    }
+    dic := NewDictionary( )
+    dic.Set( "Filter", Name("Standard") )
+    cf := NewDictionary( )
+    cf.Set( "AuthEvent", Name("DocOpen") )
+
+    switch args.Method {
+    case ENCRYPT_AES_128:
+        sh.v, sh.r = 4, 4
+        sh.stmMethod, sh.strMethod = _CRYPT_AESV2, _CRYPT_AESV2
+        cf.Set( "CFM", Name("AESV2") )
+        cf.Set( "Length", Number(16) )
+        key := sh.ownerKey( ownerPw, 16 )       // algorithm 3
+        o := rc4Crypt( key, padPassword( userPw ) )
+        for i := 1; i <= 19; i++ {
+            o = rc4Crypt( xorKey( key, byte(i) ), o )
+        }
+        sh.key = sh.computeKey( padPassword( userPw ), o, id0, 16 )
+        u := append( sh.computeU( sh.key, id0 ), make( []byte, 16 )... )
+        dic.Set( "V", Number(4) )
+        dic.Set( "R", Number(4) )
+        dic.Set( "Length", Number(128) )
+        dic.Set( "O", HexString(o) )
+        dic.Set( "U", HexString(u) )
+
+    case ENCRYPT_AES_256:
+        sh.v, sh.r = 5, 6
+        sh.stmMethod, sh.strMethod = _CRYPT_AESV3, _CRYPT_AESV3
+        cf.Set( "CFM", Name("AESV3") )
+        cf.Set( "Length", Number(32) )
+        if len(userPw) > 127 { userPw = userPw[:127] }
+        if len(ownerPw) > 127 { ownerPw = ownerPw[:127] }
+        random := make( []byte, 32 + 4 * 8 + 4 )   // key, 4 salts and Perms bytes
+        if _, err := io.ReadFull( sh.random, random ); err != nil {
+            return nil, Dictionary{}, fmt.Errorf( "Cannot generate encryption key: %v", err )
+        }
+        sh.key = random[:32]
+        u := append( sh.hash( userPw, random[32:40], nil ), random[32:48]... )  // algorithm 8
+        ue := aes256EncryptNoIV( sh.hash( userPw, random[40:48], nil ), sh.key )
+        o := append( sh.hash( ownerPw, random[48:56], u ), random[48:64]... )   // algorithm 9
+        oe := aes256EncryptNoIV( sh.hash( ownerPw, random[56:64], u ), sh.key )
+        perms := make( []byte, 16 )                                             // algorithm 10
+        binary.LittleEndian.PutUint32( perms, uint32(sh.p) )
+        copy( perms[4:], []byte{ 0xff, 0xff, 0xff, 0xff, 'T', 'a', 'd', 'b' } )
+        copy( perms[12:], random[64:] )
+        dic.Set( "V", Number(5) )
+        dic.Set( "R", Number(6) )
+        dic.Set( "Length", Number(256) )
+        dic.Set( "O", HexString(o) )
+        dic.Set( "U", HexString(u) )
+        dic.Set( "OE", HexString(oe) )
+        dic.Set( "UE", HexString(ue) )
+        dic.Set( "Perms", HexString( aes256EncryptNoIV( sh.key, perms ) ) )
+
+    default:
+        return nil, Dictionary{}, fmt.Errorf( "Unsupported encryption method %d\n", args.Method )
+    }
+    cfs := NewDictionary( )
+    cfs.Set( "StdCF", cf )
+    dic.Set( "CF", cfs )
+    dic.Set( "StmF", Name("StdCF") )
+    dic.Set( "StrF", Name("StdCF") )
+    dic.Set( "P", Number(sh.p) )
+    return sh, dic, nil
+}
+
+// deterministicReader is a reproducible replacement for crypto/rand, used to
+// encrypt in deterministic mode: it returns the SHA-256 hashes of a secret
+// seed followed by a block counter.
+type deterministicReader struct {
+    seed        []byte
+    counter     uint64
+    block       []byte      // remaining bytes of the current hash
+}
+
+func newDeterministicReader( seed ...[]byte ) *deterministicReader {
+    h := sha256.New()
+    for _, s := range seed {
+        binary.Write( h, binary.BigEndian, uint32(len(s)) )
+        h.Write( s )
+    }
+    return &deterministicReader{ seed: h.Sum( nil ) }
+}
+
+func (r *deterministicReader) Read( p []byte ) ( int, error ) {
+    n := 0
+    for n < len(p) {
+        if len(r.block) == 0 {
+            h := sha256.New()
+            h.Write( r.seed )
+            binary.Write( h, binary.BigEndian, r.counter )
+            r.counter ++
+            r.block = h.Sum( nil )
+        }
+        c := copy( p[n:], r.block )
+        r.block = r.block[c:]
+        n += c
+    }
+    return n, nil
+}
+
+// encrypt data from object id, gen with the given method
+func (sh *securityHandler) encrypt( data []byte, id, gen int64, method int ) ( []byte, error ) {
+    if method == _CRYPT_NONE {
+        return data, nil
+    }
+    key := sh.objectKey( id, gen, method )
+    if method == _CRYPT_RC4 {
+        return rc4Crypt( key, data ), nil
+    }
+    pad := aes.BlockSize - len(data) % aes.BlockSize   // PKCS#5 padding
+    out := make( []byte, aes.BlockSize + len(data) + pad )
+    if _, err := io.ReadFull( sh.random, out[:aes.BlockSize] ); err != nil {
+        return nil, fmt.Errorf( "Cannot generate AES IV: %v", err )
+    }
+    copy( out[aes.BlockSize:], data )
+    for i := len(out) - pad; i < len(out); i++ {
+        out[i] = byte(pad)
+    }
+    block, _ := aes.NewCipher( key )
+    cipher.NewCBCEncrypter( block, out[:aes.BlockSize] ).CryptBlocks( out[aes.BlockSize:], out[aes.BlockSize:] )
+    return out, nil
+}
+
+// return a copy of value v with all strings and streams encrypted for object
+// id, gen. Unlike decryptValue, v is not modified, since the document is kept
+// decrypted in memory.
+func (sh *securityHandler) encryptValue( v interface{}, id, gen int64 ) ( interface{}, error ) {
+    switch v := v.(type) {
+    case String:
+        e, err := sh.encrypt( []byte(v), id, gen, sh.strMethod )
+        return String(e), err
+    case HexString:
+        e, err := sh.encrypt( []byte(v), id, gen, sh.strMethod )
+        return HexString(e), err
+    case Array:
+        a := Array{ make( []interface{}, len(v.data) ) }
+        for i, e := range v.data {
+            var err error
+            if a.data[i], err = sh.encryptValue( e, id, gen ); err != nil {
+                return v, err
+            }
+        }
+        return a, nil
+    case Dictionary:
+        d := Dictionary{ make( []string, 0, len(v.data) ), make( map[string]interface{}, len(v.data) ) }
+        _, isSignature := v.data["ByteRange"]
+        for _, k := range v.Keys() {
+            e := v.data[k]
+            if ! isSignature || k != "Contents" {
+                var err error
+                if e, err = sh.encryptValue( e, id, gen ); err != nil {
+                    return v, err
+                }
+            }
+            d.Set( k, e )
+        }
+        return d, nil
+    case Stream:
+        if t := getValueType( v ); t == "XRef" || (t == "Metadata" && ! sh.encryptMetadata) {
+            return v, nil
+        }
+        dic, err := sh.encryptValue( v.extent, id, gen )
+        if err != nil {
+            return v, err
+        }
+        data, err := sh.encrypt( v.data, id, gen, sh.stmMethod )
+        if err != nil {
+            return v, err
+        }
+        return NewStream( dic.(Dictionary), data ), nil
+    }
+    return v, nil
+}
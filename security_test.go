@@ -0,0 +1,410 @@
+package pdf
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "testing"
+)
+
+/*
+Known answers computed with an independent implementation of the standard
+security handler algorithms, for the user password "user", the owner password
+"owner", P -3904 and the first file identifier MD5("pdf test"). Revision 6
+uses the file key 00 01 ... 1f and the salts 20 21 ... 3f.
+*/
+const (
+    testId0     = "da850e2a92eb341b593f66e2d3d71717"
+
+    testR2O     = "94e8094419662a774442fb072e3d9f19e9d130ec09a4d0061e78fe920f7ab62f"
+    testR2U     = "2592717ad20f91bcc21f9223fb539bce35c44330a16d402837ccc98a4a447d8e"
+    testR2Key   = "9b784b6c9d"
+    testR2Title = "4624965b1b67dae68c5dbe110422"     // "Secret (title)" in object 6 0, RC4
+
+    testR3O     = "0ba3835f88f90388e74e54584125ce142be0de24c6b0d37746e075b891756671"
+    testR3U     = "250afb3fe6192f074b78094e3a4730aa00000000000000000000000000000000"
+    testR3Key   = "ecec8a548db3d11b690e7108b83a3d91"
+    testR3Title = "681475522b297cc0cc26f6a3bffa"
+
+    // "Hello encrypted" in object 4 0, AESV2 with the IV 00 01 ... 0f
+    testR4Data  = "000102030405060708090a0b0c0d0e0f78d846b2f9573b554b73efaab0fc8be6"
+
+    testR6U     = "0883bdd9f6387104b4382dc453dea14d56ec345fc7e06b5dc5e22d4cdb744d7f" +
+                  "202122232425262728292a2b2c2d2e2f"
+    testR6UE    = "0aced4b8d236ce53b71feba657b9267d9a27e4ccc510f93c30e3a198b59a9b25"
+    testR6O     = "641957c838a6af724badd497b43e3b232414ff58c797fd80cb5b3aa706837b6a" +
+                  "303132333435363738393a3b3c3d3e3f"
+    testR6OE    = "e324f0d67ebebc2337de7cce144767b118f16fd0e9f5f64a7a6b5cf657a41a41"
+    testR6Perms = "73647c22c83de8e8325237d061064f6e"
+    testR6Key   = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+    // "Hello encrypted", AESV3 with the IV 64 65 ... 73
+    testR6Data  = "6465666768696a6b6c6d6e6f70717273f0e00734f68fd16a560f81afc3dbfcf2"
+)
+
+func decodeHex( t *testing.T, s string ) []byte {
+    b, err := hex.DecodeString( s )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return b
+}
+
+// return a crypt filter dictionary StdCF with the given method
+func testCryptFilters( cfm string, length int ) Dictionary {
+    cf := NewDictionary( )
+    cf.Set( "CFM", Name(cfm) )
+    cf.Set( "AuthEvent", Name("DocOpen") )
+    cf.Set( "Length", Number(length) )
+    cfs := NewDictionary( )
+    cfs.Set( "StdCF", cf )
+    return cfs
+}
+
+// return the encryption dictionary for the known answers of revision r
+func testEncryptionDictionary( t *testing.T, r int ) Dictionary {
+    dic := NewDictionary( )
+    dic.Set( "Filter", Name("Standard") )
+    switch r {
+    case 2:
+        dic.Set( "V", Number(1) )
+        dic.Set( "O", HexString( decodeHex( t, testR2O ) ) )
+        dic.Set( "U", HexString( decodeHex( t, testR2U ) ) )
+    case 3, 4:
+        dic.Set( "V", Number(2) )
+        dic.Set( "Length", Number(128) )
+        dic.Set( "O", HexString( decodeHex( t, testR3O ) ) )
+        dic.Set( "U", HexString( decodeHex( t, testR3U ) ) )
+        if r == 4 {
+            dic.Set( "V", Number(4) )
+            dic.Set( "CF", testCryptFilters( "AESV2", 16 ) )
+            dic.Set( "StmF", Name("StdCF") )
+            dic.Set( "StrF", Name("StdCF") )
+        }
+    case 6:
+        dic.Set( "V", Number(5) )
+        dic.Set( "Length", Number(256) )
+        dic.Set( "O", HexString( decodeHex( t, testR6O ) ) )
+        dic.Set( "U", HexString( decodeHex( t, testR6U ) ) )
+        dic.Set( "OE", HexString( decodeHex( t, testR6OE ) ) )
+        dic.Set( "UE", HexString( decodeHex( t, testR6UE ) ) )
+        dic.Set( "Perms", HexString( decodeHex( t, testR6Perms ) ) )
+        dic.Set( "CF", testCryptFilters( "AESV3", 32 ) )
+        dic.Set( "StmF", Name("StdCF") )
+        dic.Set( "StrF", Name("StdCF") )
+    }
+    dic.Set( "R", Number(r) )
+    dic.Set( "P", Number(-3904) )
+    return dic
+}
+
+func TestSecurityHandlerKnownAnswers( t *testing.T ) {
+    id0 := decodeHex( t, testId0 )
+    tests := []struct {
+        r           int
+        key         string
+        method      int
+        id          int64
+        encrypted   string
+        plain       string
+    }{
+        { 2, testR2Key, _CRYPT_RC4, 6, testR2Title, "Secret (title)" },
+        { 3, testR3Key, _CRYPT_RC4, 6, testR3Title, "Secret (title)" },
+        { 4, testR3Key, _CRYPT_AESV2, 4, testR4Data, "Hello encrypted" },
+        { 6, testR6Key, _CRYPT_AESV3, 4, testR6Data, "Hello encrypted" },
+    }
+    for _, test := range tests {
+        dic := testEncryptionDictionary( t, test.r )
+        key := decodeHex( t, test.key )
+        for _, pw := range []struct{ user, owner string; isOwner bool }{
+                    { "user", "", false }, { "", "owner", true }, { "user", "wrong", false } } {
+            sh, err := newSecurityHandler( dic, id0, pw.user, pw.owner )
+            if err != nil {
+                t.Errorf( "R %d passwords %q %q: %v", test.r, pw.user, pw.owner, err )
+                continue
+            }
+            if ! bytes.Equal( sh.key, key ) || sh.owner != pw.isOwner {
+                t.Errorf( "R %d passwords %q %q: got key %x owner %v, expected %s %v",
+                          test.r, pw.user, pw.owner, sh.key, sh.owner, test.key, pw.isOwner )
+            }
+            if sh.strMethod != test.method || sh.stmMethod != test.method {
+                t.Errorf( "R %d: got methods %d %d, expected %d", test.r, sh.strMethod, sh.stmMethod, test.method )
+            }
+            plain, err := sh.decrypt( decodeHex( t, test.encrypted ), test.id, 0, test.method )
+            if err != nil || string(plain) != test.plain {
+                t.Errorf( "R %d decrypt: got %q %v, expected %q", test.r, plain, err, test.plain )
+            }
+        }
+        for _, pw := range []string{ "", "wrong", "owner" } {   // owner is not the user password
+            if _, err := newSecurityHandler( dic, id0, pw, "" ); ! errors.Is( err, ErrIncorrectPassword ) {
+                t.Errorf( "R %d user password %q: got %v, expected ErrIncorrectPassword", test.r, pw, err )
+            }
+        }
+    }
+}
+
+func TestSecurityHandlerInvalid( t *testing.T ) {
+    id0 := decodeHex( t, testId0 )
+    dic := testEncryptionDictionary( t, 4 )
+    dic.Set( "StrF", Name("Unknown") )
+    if _, err := newSecurityHandler( dic, id0, "user", "" ); err == nil {
+        t.Errorf( "Undefined string crypt filter: no error" )
+    }
+    dic = testEncryptionDictionary( t, 3 )
+    dic.Set( "U", HexString("short") )
+    if _, err := newSecurityHandler( dic, id0, "user", "" ); err == nil {
+        t.Errorf( "Short U entry: no error" )
+    }
+    dic = testEncryptionDictionary( t, 3 )
+    dic.Set( "Filter", Name("Custom") )
+    if _, err := newSecurityHandler( dic, id0, "user", "" ); err == nil {
+        t.Errorf( "Unknown security handler: no error" )
+    }
+
+    sh, err := newSecurityHandler( testEncryptionDictionary( t, 4 ), id0, "user", "" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    data := decodeHex( t, testR4Data )
+    if _, err = sh.decrypt( data[:len(data)-1], 4, 0, _CRYPT_AESV2 ); err == nil {
+        t.Errorf( "Truncated AES data: no error" )
+    }
+    if _, err = sh.decrypt( data, 5, 0, _CRYPT_AESV2 ); err == nil {    // wrong key, wrong padding
+        t.Errorf( "AES data from another object: no error" )
+    }
+}
+
+// return a document with an Info dictionary whose Title is encrypted, with
+// the R 2 known answers
+func testEncryptedDocument( t *testing.T ) []byte {
+    objs := []string{
+        "<< /Type /Catalog /Pages 5 0 R >>",
+        "<< /Type /Pages /Kids [ ] /Count 0 >>",
+        "<< /Title <" + testR2Title + "> >>",
+    }
+    var b bytes.Buffer
+    b.WriteString( "%PDF-1.4\n" )
+    offsets := make( []int, 0, 4 )
+    for i, o := range objs {
+        offsets = append( offsets, b.Len() )
+        fmt.Fprintf( &b, "%d 0 obj\n%s\nendobj\n", i + 4, o )  // Info is 6 0
+    }
+    offsets = append( offsets, b.Len() )
+    f := newPdfWriter( &b )
+    f.writeObject( 7, 0, testEncryptionDictionary( t, 2 ) )
+    f.flush( )
+    xref := b.Len()
+    b.WriteString( "xref\n0 1\n0000000000 65535 f\r\n4 4\n" )
+    for _, offset := range offsets {
+        fmt.Fprintf( &b, "%010d 00000 n\r\n", offset )
+    }
+    fmt.Fprintf( &b, "trailer\n<< /Size 8 /Root 4 0 R /Info 6 0 R /Encrypt 7 0 R /ID [ <%s> <%s> ] >>\n",
+                 testId0, testId0 )
+    fmt.Fprintf( &b, "startxref\n%d\n%%%%EOF\n", xref )
+    return b.Bytes()
+}
+
+func TestDecryptDocument( t *testing.T ) {
+    data := testEncryptedDocument( t )
+    for _, args := range []*ParseArgs{ { UserPassword: "user" }, { OwnerPassword: "owner" } } {
+        pf, err := ParseBytes( data, args )
+        if err != nil {
+            t.Fatalf( "Parse with %+v: %v", *args, err )
+        }
+        info, err := pf.Resolve( pf.Info )
+        if err != nil {
+            t.Fatal( err )
+        }
+        if n, err := pf.NumPages( ); err != nil || n != 0 {
+            t.Errorf( "NumPages: got %d %v, expected 0", n, err )
+        }
+        title, _ := info.(Dictionary).Get( "Title" )
+        if title != HexString("Secret (title)") {
+            t.Errorf( "Title: got %q, expected the decrypted title", title )
+        }
+        if id0 := fileIdBytes( pf.Id.data[0] ); hex.EncodeToString( id0 ) != testId0 {
+            t.Errorf( "File ID was decrypted: %x", id0 )
+        }
+    }
+    _, err := ParseBytes( data, &ParseArgs{ UserPassword: "wrong" } )
+    if ! errors.Is( err, ErrIncorrectPassword ) {
+        t.Errorf( "Parse with a wrong password: got %v, expected ErrIncorrectPassword", err )
+    }
+}
+
+func TestPermissionFlags( t *testing.T ) {
+    tests := []struct {
+        p       Permissions
+        flags   int32
+    }{
+        { Permissions{ }, -3904 },
+        { Permissions{ Print: true, Copy: true }, -3884 },
+        { Permissions{ true, true, true, true, true, true, true, true }, -4 },
+    }
+    for _, test := range tests {
+        if flags := test.p.flags( ); flags != test.flags {
+            t.Errorf( "Permissions %+v: got %d, expected %d", test.p, flags, test.flags )
+        }
+    }
+}
+
+// the AES-128 known answers with the permissions Print and Copy (P -3884)
+const (
+    testAES128U     = "b9a6cff53f4377d166f8ec14f35d3d0f00000000000000000000000000000000"
+    testAES128Key   = "c93dd9260d3fb14e19586d6ba8cf0127"
+    // "Hello encrypted" in object 4 0, AESV2 with the IV 00 01 ... 0f
+    testAES128Data  = "000102030405060708090a0b0c0d0e0f884b16c1d94bfc0a1b646c6beb5af8d1"
+)
+
+// return the bytes from start to end - 1
+func testBytes( start, end int ) []byte {
+    b := make( []byte, 0, end - start )
+    for i := start; i < end; i++ {
+        b = append( b, byte(i) )
+    }
+    return b
+}
+
+func TestEncryptionHandlerKnownAnswers( t *testing.T ) {
+    id0 := decodeHex( t, testId0 )
+    tests := []struct {
+        args        EncryptionArgs
+        random      *bytes.Reader   // file key and salts if AES-256, then IV
+        entries     map[string]string
+        p           int
+        key         string
+        encrypted   string
+    }{
+        { EncryptionArgs{ ENCRYPT_AES_128, "user", "owner", Permissions{ Print: true, Copy: true } },
+          bytes.NewReader( testBytes( 0, 16 ) ), map[string]string{ "O": testR3O, "U": testAES128U },
+          -3884, testAES128Key, testAES128Data },
+        { EncryptionArgs{ ENCRYPT_AES_256, "user", "owner", Permissions{ } },
+          bytes.NewReader( append( testBytes( 0, 68 ), testBytes( 100, 116 )... ) ),
+          map[string]string{ "O": testR6O, "U": testR6U, "OE": testR6OE, "UE": testR6UE, "Perms": testR6Perms },
+          -3904, testR6Key, testR6Data },
+    }
+    for _, test := range tests {
+        sh, dic, err := newEncryptionHandler( &test.args, id0, test.random )
+        if err != nil {
+            t.Fatal( err )
+        }
+        for k, v := range test.entries {
+            if e := fmt.Sprintf( "%x", getBytes( dic, k ) ); e != v {
+                t.Errorf( "Method %d /%s: got %s, expected %s", test.args.Method, k, e, v )
+            }
+        }
+        if p, _ := dic.Get( "P" ); p != Number(test.p) {
+            t.Errorf( "Method %d /P: got %v, expected %d", test.args.Method, p, test.p )
+        }
+        if k := hex.EncodeToString( sh.key ); k != test.key {
+            t.Errorf( "Method %d key: got %s, expected %s", test.args.Method, k, test.key )
+        }
+        encrypted, err := sh.encrypt( []byte("Hello encrypted"), 4, 0, sh.stmMethod )
+        if err != nil || hex.EncodeToString( encrypted ) != test.encrypted {
+            t.Errorf( "Method %d encrypt: got %x %v, expected %s", test.args.Method, encrypted, err, test.encrypted )
+        }
+
+        // the dictionary can be used for decrypting
+        for _, pw := range []struct{ user, owner string }{ { "user", "" }, { "", "owner" } } {
+            dsh, err := newSecurityHandler( dic, id0, pw.user, pw.owner )
+            if err != nil || ! bytes.Equal( dsh.key, sh.key ) {
+                t.Errorf( "Method %d passwords %q %q: got %v, expected the encryption key",
+                          test.args.Method, pw.user, pw.owner, err )
+            }
+        }
+    }
+}
+
+func TestEncryptionHandlerShortRandom( t *testing.T ) {
+    args := &EncryptionArgs{ Method: ENCRYPT_AES_256 }
+    if _, _, err := newEncryptionHandler( args, nil, bytes.NewReader( testBytes( 0, 10 ) ) ); err == nil {
+        t.Errorf( "AES-256 with a short random source: no error" )
+    }
+    sh, _, err := newEncryptionHandler( &EncryptionArgs{ Method: ENCRYPT_AES_128 }, nil, bytes.NewReader( testBytes( 0, 10 ) ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if _, err = sh.encrypt( []byte("data"), 1, 0, _CRYPT_AESV2 ); err == nil {
+        t.Errorf( "AES IV from a short random source: no error" )
+    }
+}
+
+func TestWriteEncrypted( t *testing.T ) {
+    for _, method := range []EncryptionMethod{ ENCRYPT_AES_128, ENCRYPT_AES_256 } {
+        pf := newTestDocument( t, 2 )
+        args := &WriteArgs{ Deterministic: true, Encryption: &EncryptionArgs{ Method: method,
+                            UserPassword: "user", OwnerPassword: "owner", Permissions: Permissions{ Print: true } } }
+        data := writeTestDocument( t, pf, args )
+        if bytes.Contains( data, []byte("(Test)") ) || bytes.Contains( data, []byte(" re f") ) {
+            t.Errorf( "Method %d: strings or streams are not encrypted", method )
+        }
+        if again := writeTestDocument( t, newTestDocument( t, 2 ), args ); ! bytes.Equal( data, again ) {
+            t.Errorf( "Method %d: deterministic output differs", method )
+        }
+        header := map[EncryptionMethod]string{ ENCRYPT_AES_128: "%PDF-1.7", ENCRYPT_AES_256: "%PDF-2.0" }
+        if ! bytes.HasPrefix( data, []byte(header[method]) ) {
+            t.Errorf( "Method %d header: got %q, expected %s", method, data[:8], header[method] )
+        }
+
+        if _, err := ParseBytes( data, nil ); ! errors.Is( err, ErrIncorrectPassword ) {
+            t.Errorf( "Method %d without password: got %v, expected ErrIncorrectPassword", method, err )
+        }
+        for _, pw := range []*ParseArgs{ { UserPassword: "user" }, { OwnerPassword: "owner" } } {
+            written, err := ParseBytes( data, pw )
+            if err != nil {
+                t.Fatalf( "Method %d parse with %+v: %v", method, *pw, err )
+            }
+            if written.security.owner != (pw.OwnerPassword != "") || written.security.p != args.Encryption.Permissions.flags( ) {
+                t.Errorf( "Method %d parse with %+v: owner %v, P %d", method, *pw,
+                          written.security.owner, written.security.p )
+            }
+            for id, obj := range pf.ObjById {
+                if wo, ok := written.ObjById[id]; ! ok || ! equalValues( obj.value, wo.value ) {
+                    t.Errorf( "Method %d object %d: got %v, expected %v", method, id, wo, obj.value )
+                }
+            }
+            checkTestDocument( t, written, 2, "Test" )
+        }
+    }
+}
+
+func TestWriteDeterministicEncrypted( t *testing.T ) {
+    for _, method := range []EncryptionMethod{ ENCRYPT_AES_128, ENCRYPT_AES_256 } {
+        pf := newTestDocument( t, 1 )
+        args := &WriteArgs{ Deterministic: true, Encryption: &EncryptionArgs{ Method: method,
+                            UserPassword: "user", OwnerPassword: "owner" } }
+        data := writeTestDocument( t, pf, args )
+        if again := writeTestDocument( t, pf, args ); ! bytes.Equal( data, again ) {
+            t.Errorf( "Method %d: second deterministic write differs", method )
+        }
+
+        // the same update of the same document is identical
+        var updates [2][]byte
+        for i := range updates {
+            parsed, err := ParseBytes( data, &ParseArgs{ UserPassword: "user" } )
+            if err != nil {
+                t.Fatal( err )
+            }
+            setTestTitle( t, parsed, "Updated" )
+            var b bytes.Buffer
+            if _, err = parsed.WriteIncremental( &b, &WriteArgs{ Deterministic: true } ); err != nil {
+                t.Fatal( err )
+            }
+            updates[i] = b.Bytes()
+        }
+        if ! bytes.Equal( updates[0], updates[1] ) {
+            t.Errorf( "Method %d: deterministic incremental updates differ", method )
+        }
+        if bytes.Contains( updates[0], []byte("Updated") ) {
+            t.Errorf( "Method %d: update is not encrypted", method )
+        }
+        updated := append( data, updates[0]... )
+        for _, pw := range []*ParseArgs{ { UserPassword: "user" }, { OwnerPassword: "owner" } } {
+            written, err := ParseBytes( updated, pw )
+            if err != nil {
+                t.Fatalf( "Method %d parse with %+v: %v", method, *pw, err )
+            }
+            checkTestDocument( t, written, 1, "Updated" )
+        }
+    }
+}
@@ -4,11 +4,14 @@ package pdf
 import (
     "fmt"
     "os"
+    "bytes"
     "io"
+    "bufio"
     "time"
     "strings"
     "strconv"
     "crypto/md5"
+    "crypto/rand"
 )
 
 // Improved speed by using fmt.Fprintf only when necessary,
@@ -16,179 +19,215 @@ import (
 // too many nested calls to the detriment of modularity in
 // some cases.
 
-func reportSerializeErrorf( format string, a ...interface{} ) {
-    fmt.Fprintf( os.Stderr, "Error serializing pdf file: " )
-    fmt.Fprintf( os.Stderr, format, a... ) 
-    os.Exit(1)
+// pdfWriter keeps track of the current output offset, needed for the XREF
+// table, and of the first write error. Once an error has occured, nothing
+// else is written, so that errors need to be checked only at the end.
+type pdfWriter struct {
+    w           *bufio.Writer
+    out         *countWriter    // what was actually written, behind w
+    pos         int64           // current offset in output
+    err         error           // first write error
+    arrayLevel  int             // for array formatting
+    security    *securityHandler // encrypts the objects written, if not nil
+    encrypt     *PdfObject      // new encryption dictionary, if not nil
+    fileId      Array           // file identifiers written in trailer
 }
 
-func reportSerializeError( err error ) {
-    fmt.Fprintf( os.Stderr, "Error serializing pdf file: %v", err )
-    os.Exit(1)
+type countWriter struct {
+    w           io.Writer
+    n           int64
 }
 
-// TODO: complete the function - currently not used
-func (pdf *PdfFile) makeFileID( f *os.File ) string {
+func (c *countWriter) Write( b []byte ) ( int, error ) {
+    n, err := c.w.Write( b )
+    c.n += int64(n)
+    return n, err
+}
+
+func newPdfWriter( w io.Writer ) *pdfWriter {
+    out := &countWriter{ w: w }
+    return &pdfWriter{ w: bufio.NewWriter( out ), out: out }
+}
+
+func (f *pdfWriter) Write( b []byte ) ( int, error ) {
+    if f.err != nil {
+        return 0, f.err
+    }
+    n, err := f.w.Write( b )
+    f.pos += int64(n)
+    f.err = err
+    return n, err
+}
+
+func (f *pdfWriter) WriteString( s string ) ( int, error ) {
+    if f.err != nil {
+        return 0, f.err
+    }
+    n, err := f.w.WriteString( s )
+    f.pos += int64(n)
+    f.err = err
+    return n, err
+}
+
+func (f *pdfWriter) flush( ) error {
+    if f.err == nil {
+        f.err = f.w.Flush()
+    }
+    return f.err
+}
+
+// write an indirect object, encrypted if needed (but the encryption dictionary
+// itself is never encrypted)
+func (f *pdfWriter) writeObject( id, gen int64, value interface{} ) {
+    if f.security != nil && (f.encrypt == nil || id != f.encrypt.id) && f.err == nil {
+        var err error
+        if value, err = f.security.encryptValue( value, id, gen ); err != nil {
+            f.err = fmt.Errorf( "Cannot encrypt object %d %d: %v", id, gen, err )
+            return
+        }
+    }
+    fmt.Fprintf( f, "%d %d obj\n", id, gen )
+    serializeValue( f, value )
+    f.WriteString( "\nendobj\n" )
+}
+
+// return a new file identifier, as recommended by PDF specifications: the MD5
+// hash of the current time, the file path, the file size and the Info entries.
+// Since identifiers are needed before writing (for encryption), the size is
+// the parsed file size and the number of objects. If deterministic is true,
+// the time and path are replaced by the document objects, so that identical
+// documents get identical identifiers.
+func (pdf *PdfFile) makeFileID( path string, deterministic bool ) HexString {
     h := md5.New()
-    io.WriteString( h, f.Name() )
-    io.WriteString( h, fmt.Sprintf( "%v", time.Now() ) )
+    hf := newPdfWriter( h )
+    if deterministic {
+        for _, obj := range pdf.Objects {
+            fmt.Fprintf( hf, "%d %d obj\n", obj.id, obj.gen )
+            serializeValue( hf, obj.value )
+        }
+    } else {
+        fmt.Fprintf( hf, "%v %s\n", time.Now(), path )
+    }
+    fmt.Fprintf( hf, "%d %d\n", pdf.inputSize, len(pdf.Objects) )
+    if obj, ok := pdf.ObjById[pdf.Info.id]; ok && pdf.Info.id != 0 {
+        serializeValue( hf, obj.value )
+    }
+    hf.flush( )                     // cannot fail with a hash
+    return HexString( h.Sum(nil) )
+}
 
-    // not the complete file but the difference is a small constant
-    pos, err := f.Seek( 0, os.SEEK_CUR )
-    if err != nil {
-        reportSerializeError( err )
+// return the file identifiers to write: the first one is kept if the document
+// has identifiers, the second one is always new.
+func (pdf *PdfFile) outputFileId( path string, deterministic bool ) Array {
+    id := pdf.makeFileID( path, deterministic )
+    if pdf.Id != nil && len(pdf.Id.data) == 2 {
+        return NewArray( pdf.Id.data[0], id )
     }
-    io.WriteString( h, fmt.Sprintf( "%d", pos ) )
-//    if pdf.Info != nil {
-//        for k, v := range pdf.Info {
-//            io.WriteString( h, 
-//        }
-//    }
-    res := fmt.Sprintf( "<%x>", h.Sum(nil) )
-    return res
+    return NewArray( id, id )       // both identical when first written
 }
 
-func (pdf *PdfFile) serializeTrailer( f *os.File, lastId, xrefPos int64 ) {
-    f.WriteString( "trailer\n<<\n" )
-    for _, k := range pdf.Trailer.keys {
+// return the trailer entries to write, in the document trailer order, with
+// the given Size
+func (pdf *PdfFile) outputTrailer( f *pdfWriter, size int64 ) Dictionary {
+    trailer := NewDictionary( )
+    for _, k := range pdf.Trailer.Keys() {
         switch k {
         case "Size":
-            fmt.Fprintf( f, "/Size %d\n", lastId )
+            trailer.Set( k, Number(size) )
+        case "Prev", "XRefStm", "ID":  // ID is last, whether the document had one or not
         case "Root":
-            fmt.Fprintf( f, "/Root %d %d R\n", pdf.Catalog.id, pdf.Catalog.gen )
+            trailer.Set( k, pdf.Catalog )
         case "Info":
-            fmt.Fprintf( f, "/Info %d %d R\n", pdf.Info.id, pdf.Info.gen )
+            trailer.Set( k, pdf.Info )
         case "Encrypt":
-            fmt.Fprintf( f, "/Encrypt %d %d R\n", pdf.Encrypt.id, pdf.Encrypt.gen )
-        case "Id":
-            fmt.Fprintf( f, "/ID [<%X> <%X>]\n",
-                     pdf.Id.data[0].(pdfHexString), pdf.Id.data[1].(pdfHexString) )
+            if pdf.security == nil {
+                trailer.Set( k, pdf.Encrypt )
+            }
         default:
-            fmt.Fprintf( f, "/%s ", k )
-            serializeValue( f, pdf.Trailer.data[k] )
-            f.WriteString( "\n" )
+            trailer.Set( k, pdf.Trailer.data[k] )
         }
     }
-    fmt.Fprintf( f, ">>\nstartxref\n%d\n%%%%EOF\n", xrefPos )
+    trailer.Set( "Size", Number(size) )
+    if f.encrypt != nil {
+        trailer.Set( "Encrypt", Reference{ f.encrypt.id, f.encrypt.gen } )
+    }
+    trailer.Set( "ID", f.fileId )
+    return trailer
 }
 
-func (pdf *PdfFile) serializeXREF( f *os.File ) (last int64, pos int64) {
-    pos, err := f.Seek( 0, os.SEEK_CUR )
-    if err != nil {
-        reportSerializeError( err )
+func (pdf *PdfFile) serializeTrailer( f *pdfWriter, lastId, xrefPos int64 ) {
+    f.WriteString( "trailer\n" )
+    serializeDictionary( f, pdf.outputTrailer( f, lastId ) )
+    fmt.Fprintf( f, "\nstartxref\n%d\n%%%%EOF\n", xrefPos )
+}
+
+// return the highest object ID to write
+func (pdf *PdfFile) lastObjectId( f *pdfWriter ) int64 {
+    var last int64
+    for id := range pdf.ObjById {
+        if id > last { last = id }
     }
-    f.WriteString( "xref\n" )
+    if f.encrypt != nil && f.encrypt.id > last {
+        last = f.encrypt.id
+    }
+    return last
+}
 
-    n := len(pdf.Objects)
-    fmt.Fprintf( f, "%d %d\n", 0, n + 1 ) // including free object 0
-    fmt.Fprintf( f, "%010d %05d f\r\n", 0, 65535 )
-    for id := 1; id <= n; id++ {
-        obj := pdf.ObjById[int64(id)]
-        fmt.Fprintf( f, "%010d %05d n\r\n", obj.start, obj.gen )
+// return the object id as written, nil if it is not written
+func (pdf *PdfFile) writtenObject( f *pdfWriter, id int64 ) *PdfObject {
+    if f.encrypt != nil && id == f.encrypt.id {
+        return f.encrypt
+    }
+    if obj, ok := pdf.ObjById[id]; ok && obj.value != nil && pdf.isWritten( obj ) {
+        return obj
     }
-    return int64(n + 1), pos
+    return nil
 }
 
-func serializeNumber( f *os.File, n float64 ) {
-    // PDF does not use exponents and does not want trailing 0s after the decimal
-    // point either. One solution is to use %g and to de-normalize the exponent,
-    // which allows to keep the maximum precision from the significand, a.k.a
-    // mantissa, adding leading 0s as needed (negative exponents) while making
-    // sure there is no non-significant trailing 0s after a decimal point.
-    res := fmt.Sprintf( "%g", n )
-    end := strings.IndexByte( res, 'e' )
-    if end != -1 {
-        // e.g.  1.234567e-03 =>  0.001234567 
-        // or   -1.234567e-05 => -0.00001234567
-        // or    1.234567e+02 =>  123.4567
-//        fmt.Printf( "%f or %s\n", n, res )
-        start := 0
-        if res[0] == '-' { start = 1 }
-        exp, _ := strconv.Atoi( res[end+1:] )
-        ml := end - start -1 // excluding '.'
-        pt := 1              // in res only, most cases
-        if res[ start+1 ] != '.' {
-            pt = 0
-            ml ++  // fix mantissa length
-        }
-        nZ := - exp
-        dp := 1     // assumes fRes decimal point in most cases
-        if exp > 0 {
-            nZ = exp - ml + 1
-            if pt == 1 || exp >= ml - 1 {
-                dp = 0  // suppress decimal point if not in source or no following digits
-            }
+func (pdf *PdfFile) serializeXREF( f *pdfWriter ) (last int64, pos int64) {
+    pos = f.pos
+    f.WriteString( "xref\n" )
+
+    // object IDs may not be contiguous (e.g. after parsing a XREF stream,
+    // which is not rewritten). Missing IDs are linked in the free list.
+    n := pdf.lastObjectId( f )
+    free := make( []int64, 0 )
+    for id := int64(1); id <= n; id++ {
+        if pdf.writtenObject( f, id ) == nil {
+            free = append( free, id )
         }
-        fRes := make( []byte, start + dp + ml + nZ ) // 1 for '.' in fRes even if pt is 0 in res
-//        fmt.Printf( "Exp: %d, start: %d, end: %d, ml: %d, pt: %d, dp: %d nZ: %d\n", exp, start, end, ml, pt, dp, nZ )
-        if start > 0 { fRes[0] = '-' }
-        if exp < 0 {
-            fRes[start] = '0'
-            fRes[start+1] = '.'
-            next := start + 2
-            for i := -1; i > exp; i-- {
-                fRes[next] = '0'
-                next ++
-            }
-            fRes[next] = res[start]
-            next++
-            start++
-            for i := 1; i < ml; i++ {
-                fRes[next] = res[start+i]
-                next ++
-            }
-//            fmt.Printf("Fixed res: %s\n", fRes )
-            fmt.Fprintf( f, "%s", fRes )
-//            panic("stop\n")
-        } else {
-            fRes[start] = res[start]  // first digit
-            next := start + 1
-            if pt == 1 { next ++ }    // skip following '.' in res
-
-            if dp == 0 { // no decimal point in final result: exp >= ml
-                for i:= 1; i < ml; i++ {  // copy first ml digits
-                    fRes[start+i] = res[next]
-                    next ++
-                }
-                start += ml
-                for i := 0; i < nZ; i++ { // then remaining 0s
-                    fRes[start+i] = '0'
-                    next ++
-                }
-            } else { // decimal point in final result: exp < ml
-                panic( "Unexpected %g case\n" )
-/*
-                for i:= 1; i < exp; i++ { // copy first exp digits of ml
-                    fRes[start+i] = res[next]
-                    next ++
-                }
-                start += exp
-                fRes[start] = '.'         // then decimal point
-                start ++
-                for i := 0; i < ml - exp; i++ { // then remaining ml
-                    fRes[start+i] = res[next]
-                    next ++
-                }
-*/
-            }
-            f.Write( fRes )
+    }
+    free = append( free, 0 )    // last free entry points back to object 0
+
+    fmt.Fprintf( f, "%d %d\n", 0, n + 1 ) // including free object 0
+    fmt.Fprintf( f, "%010d %05d f\r\n", free[0], 65535 )
+    nextFree := 1
+    for id := int64(1); id <= n; id++ {
+        obj := pdf.writtenObject( f, id )
+        if obj == nil {
+            fmt.Fprintf( f, "%010d %05d f\r\n", free[nextFree], 1 )
+            nextFree ++
+            continue
         }
-    } else {
-        f.WriteString( res )
+        fmt.Fprintf( f, "%010d %05d n\r\n", obj.start, obj.gen )
     }
+    return n + 1, pos
+}
 
+func serializeNumber( f *pdfWriter, n float64 ) {
+    // PDF does not use exponents and does not want trailing 0s after the decimal
+    // point either: format without exponent, with the shortest representation
+    // that keeps the full precision, e.g. 0.001234567, 1234567.5 or 1e+21 as
+    // 1000000000000000000000.
+    f.WriteString( strconv.FormatFloat( n, 'f', -1, 64 ) )
 }
 
-func serializeDictionary( f *os.File, keys []string, d map[string]interface{} ) {
+func serializeDictionary( f *pdfWriter, d Dictionary ) {
     f.WriteString( "<<\n" )
 // keeping the same order as in the parsed file
-    for _, k := range keys {
-        v, ok := d[k]
-        if ! ok {
-            panic( fmt.Sprintf( "Dictionary with no value for key %s\n", k ) )
-        }
+    for _, k := range d.Keys() {
         fmt.Fprintf( f, "/%s ", k )
-        serializeValue( f, v )
+        serializeValue( f, d.data[k] )
         f.WriteString( "\n" )
     }
     f.WriteString( ">>" )
@@ -200,11 +239,10 @@ func serializeDictionary( f *os.File, keys []string, d map[string]interface{} )
 // all arrays are limited to 10 items on the same line,
 // if the previous item was an array, skip the possibly following
 // \n separator (if it was the 10th item on the line)
-var arrayLevel int
 
-func serializeArray( f *os.File, data []interface{} ) {
+func serializeArray( f *pdfWriter, data []interface{} ) {
     f.WriteString( "[" )
-    arrayLevel ++
+    f.arrayLevel ++
     previousValueIsArray := false
     for i, d := range data {
         if i > 0 {
@@ -219,86 +257,343 @@ func serializeArray( f *os.File, data []interface{} ) {
             f.WriteString( sep )
         }
         serializeValue( f, d )
-        if _, ok := d.(PdfArray); ok {
+        if _, ok := d.(Array); ok {
             previousValueIsArray = true
         } else {
             previousValueIsArray = false
         }
     }
-    arrayLevel --
+    f.arrayLevel --
 
-    if arrayLevel == 0 {
+    if f.arrayLevel == 0 {
         f.WriteString( "]" )
     } else {
         f.WriteString( "]\n" ) // to check
     }
 }
 
-func serializeValue( f *os.File, v interface{} ) {
+// escape the characters that cannot appear as is in a literal string
+func escapeLiteralString( s string ) string {
+    var sb strings.Builder
+    for i := 0; i < len(s); i++ {
+        switch c := s[i]; c {
+        case '(', ')', '\\':
+            sb.WriteByte( '\\' )
+            sb.WriteByte( c )
+        case '\r':      // would be read as LF
+            sb.WriteString( "\\r" )
+        default:
+            sb.WriteByte( c )
+        }
+    }
+    return sb.String()
+}
+
+func serializeValue( f *pdfWriter, v interface{} ) {
     switch v := v.(type) {
-    case pdfBool:
+    case Bool:
         fmt.Fprintf( f, "%t", bool(v) )
-    case pdfNumber:
+    case Number:
         serializeNumber( f, float64(v) )
-    case pdfString:
+    case String:
         f.WriteString( "(" )
-        f.WriteString( string(v) )
+        f.WriteString( escapeLiteralString( string(v) ) )
         f.WriteString( ")" )
-    case pdfHexString:
+    case HexString:
         fmt.Fprintf( f, "<%X>", string(v) )
-    case pdfName:
+    case Name:
         f.WriteString( "/" )
         f.WriteString( string(v) )
-    case pdfDictionary:
-        serializeDictionary( f, v.keys, v.data )
-    case pdfStream:
-        serializeDictionary( f, v.extent.keys, v.extent.data )
+    case Dictionary:
+        serializeDictionary( f, v )
+    case Stream:
+        serializeDictionary( f, v.extent )
         f.WriteString( "\nstream\r\n" )
         f.Write( v.data )
         f.WriteString( "\r\nendstream" )
-    case PdfArray:
+    case Array:
         serializeArray( f, v.data )
-    case pdfNull:
+    case Null:
         f.WriteString( "null" )
-    case pdfReference:
+    case Reference:
         fmt.Fprintf( f, "%d %d R", v.id, v.gen )
     }
 }
 
-func (pdf *PdfFile) serializeObjects( f *os.File ) {
+// the encryption dictionary of a decrypted document is not written, since
+// the document is written without encryption
+func (pdf *PdfFile) isWritten( obj *PdfObject ) bool {
+    return pdf.security == nil || obj.id != pdf.Encrypt.id
+}
+
+func (pdf *PdfFile) serializeObjects( f *pdfWriter ) {
     for _, obj := range pdf.Objects {
-//        var err error
-        obj.start, _ = f.Seek( 0, os.SEEK_CUR )
-        fmt.Fprintf( f, "%d %d obj\n", obj.id, obj.gen )
-        serializeValue( f, obj.value )
-//        _, err = f.WriteString( "\nendobj\n" )
-//        if err != nil {
-//            reportSerializeError( err )
-//        }
-        f.WriteString( "\nendobj\n" )
+        if ! pdf.isWritten( obj ) {
+            continue
+        }
+        obj.start = f.pos
+        f.writeObject( obj.id, obj.gen, obj.value )
+    }
+    if f.encrypt != nil {
+        f.encrypt.start = f.pos
+        f.writeObject( f.encrypt.id, f.encrypt.gen, f.encrypt.value )
     }
 }
 
-func (pdf *PdfFile) serializeFirstLine( f *os.File ) {
-    _, err := f.WriteString( pdf.Header )
-    if err != nil {
-        reportSerializeError( err )
+func (pdf *PdfFile) serializeFirstLine( f *pdfWriter, header string ) {
+    f.WriteString( header )
+    f.Write( []byte{ 0x0a, 0x25, 0xf6, 0xe4, 0xfc, 0xdf, 0x0a } )
+}
+
+const OBJECT_STREAM_SIZE = 100      // max number of objects in an object stream
+
+// objects that can be stored in an object stream, by PDF specifications
+func (pdf *PdfFile) isCompressible( obj *PdfObject ) bool {
+    if _, ok := obj.value.(Stream); ok {
+        return false
+    }
+    return obj.gen == 0 && obj.id != pdf.Encrypt.id
+}
+
+// return an object stream made of the given objects, and set the objects
+// location in the XREF entries
+func makeObjectStream( sid int64, objs []*PdfObject, entries map[int64]xrefEntry ) Stream {
+    var header, body bytes.Buffer
+    bf := newPdfWriter( &body )
+    for i, obj := range objs {
+        fmt.Fprintf( &header, "%d %d ", obj.id, bf.pos )
+        serializeValue( bf, obj.value )
+        bf.WriteString( "\n" )
+        entries[obj.id] = xrefEntry{ id: obj.id, stream: sid, index: int64(i), inUse: true }
+    }
+    bf.flush( )                     // cannot fail with a bytes.Buffer
+    header.WriteByte( '\n' )
+    first := header.Len()
+    header.Write( body.Bytes() )
+
+    dic := NewDictionary( )
+    dic.Set( "Type", Name("ObjStm") )
+    dic.Set( "N", Number(len(objs)) )
+    dic.Set( "First", Number(first) )
+    dic.Set( "Filter", Name("FlateDecode") )
+    return NewStream( dic, flateEncode( header.Bytes() ) )
+}
+
+// number of bytes needed to store v in a XREF stream field
+func xrefFieldWidth( v int64 ) int {
+    w := 1
+    for v >>= 8; v > 0; v >>= 8 {
+        w++
+    }
+    return w
+}
+
+// write the whole document with a XREF stream instead of a XREF table, and
+// with non-stream objects in object streams if compress is true. New object
+// IDs follow the highest ID in use for the object streams and the XREF stream.
+func (pdf *PdfFile) serializeWithXrefStream( f *pdfWriter, header string, compress bool ) {
+    pdf.serializeFirstLine( f, header )
+
+    lastId := pdf.lastObjectId( f )
+    entries := make( map[int64]xrefEntry, len(pdf.ObjById) )
+    packed := make( []*PdfObject, 0 )
+    for _, obj := range pdf.Objects {
+        if ! pdf.isWritten( obj ) {
+            continue
+        }
+        if compress && pdf.isCompressible( obj ) {
+            packed = append( packed, obj )
+            continue
+        }
+        obj.start = f.pos
+        entries[obj.id] = xrefEntry{ id: obj.id, gen: obj.gen, start: f.pos, inUse: true }
+        f.writeObject( obj.id, obj.gen, obj.value )
+    }
+    if f.encrypt != nil {
+        f.encrypt.start = f.pos
+        entries[f.encrypt.id] = xrefEntry{ id: f.encrypt.id, start: f.pos, inUse: true }
+        f.writeObject( f.encrypt.id, f.encrypt.gen, f.encrypt.value )
+    }
+    for i := 0; i < len(packed); i += OBJECT_STREAM_SIZE {
+        end := i + OBJECT_STREAM_SIZE
+        if end > len(packed) { end = len(packed) }
+        lastId ++
+        stream := makeObjectStream( lastId, packed[i:end], entries )
+        entries[lastId] = xrefEntry{ id: lastId, start: f.pos, inUse: true }
+        f.writeObject( lastId, 0, stream )
+    }
+
+    // the XREF stream is the last object, free entries are linked as usual
+    xrefId := lastId + 1
+    xrefPos := f.pos
+    entries[xrefId] = xrefEntry{ id: xrefId, start: xrefPos, inUse: true }
+    size := xrefId + 1
+    var nextFree int64
+    for id := size - 1; id >= 0; id-- {
+        if _, ok := entries[id]; ! ok {
+            gen := int64(1)
+            if id == 0 { gen = 65535 }
+            entries[id] = xrefEntry{ id: id, gen: gen, start: nextFree }
+            nextFree = id
+        }
+    }
+    var max2, max3 int64
+    for _, e := range entries {
+        f2, f3 := e.start, e.gen
+        if e.stream != 0 {
+            f2, f3 = e.stream, e.index
+        }
+        if f2 > max2 { max2 = f2 }
+        if f3 > max3 { max3 = f3 }
     }
-    _, err = f.Write( []byte{ 0x0a, 0x25, 0xf6, 0xe4, 0xfc, 0xdf, 0x0a } )
+    w := [3]int{ 1, xrefFieldWidth( max2 ), xrefFieldWidth( max3 ) }
+    data := make( []byte, 0, int(size) * (w[0] + w[1] + w[2]) )
+    putField := func( v int64, w int ) {
+        for i := w - 1; i >= 0; i-- {
+            data = append( data, byte(v >> (8 * uint(i))) )
+        }
+    }
+    for id := int64(0); id < size; id++ {
+        e := entries[id]
+        switch {
+        case ! e.inUse:
+            data = append( data, 0 )
+            putField( e.start, w[1] )
+            putField( e.gen, w[2] )
+        case e.stream != 0:
+            data = append( data, 2 )
+            putField( e.stream, w[1] )
+            putField( e.index, w[2] )
+        default:
+            data = append( data, 1 )
+            putField( e.start, w[1] )
+            putField( e.gen, w[2] )
+        }
+    }
+
+    dic := NewDictionary( )
+    dic.Set( "Type", Name("XRef") )
+    trailer := pdf.outputTrailer( f, size )
+    for _, k := range trailer.Keys() {
+        dic.Set( k, trailer.data[k] )
+    }
+    dic.Set( "W", NewArray( Number(w[0]), Number(w[1]), Number(w[2]) ) )
+    dic.Set( "Filter", Name("FlateDecode") )
+    fmt.Fprintf( f, "%d 0 obj\n", xrefId )
+    serializeValue( f, NewStream( dic, flateEncode( data ) ) )
+    fmt.Fprintf( f, "\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefPos )
+}
+
+// WriteArgs gives the optional parameters for Write
+type WriteArgs struct {
+    XrefStream      bool    // write a XREF stream instead of a XREF table (PDF 1.5)
+    ObjectStreams   bool    // store non-stream objects in object streams (implies XrefStream)
+    Encryption      *EncryptionArgs // encrypt the document, if not nil
+    Deterministic   bool    // reproducible output, see Write
+}
+
+// prepare the writer for encrypting the document, with a new encryption
+// dictionary following the highest object ID in use. In deterministic mode,
+// the random values are derived from the passwords and file identifiers.
+func (pdf *PdfFile) setOutputEncryption( f *pdfWriter, args *EncryptionArgs, deterministic bool ) error {
+    id0 := fileIdBytes( f.fileId.data[0] )
+    var random io.Reader = rand.Reader
+    if deterministic {
+        random = newDeterministicReader( []byte(args.UserPassword), []byte(args.OwnerPassword),
+                                         id0, fileIdBytes( f.fileId.data[1] ) )
+    }
+    sh, dic, err := newEncryptionHandler( args, id0, random )
     if err != nil {
-        reportSerializeError( err )
+        return fmt.Errorf( "Cannot encrypt document: %v", err )
     }
+    f.security = sh
+    f.encrypt = &PdfObject{ id: pdf.lastObjectId( f ) + 1, value: dic, stop: -1 }
+    return nil
 }
 
-func (pdf *PdfFile)Serialize( name string ) {
+// Write writes the whole document to w, as a single revision. By default, or
+// if args is nil, objects are written uncompressed, followed by a XREF table
+// and a trailer with new file identifiers (keeping the first one if the
+// document had identifiers, see PdfFile.Id, which is updated once written).
+// With args.XrefStream or args.ObjectStreams, the header version is raised
+// to 1.5 if needed. With args.Encryption, strings and streams are encrypted
+// and the header version is raised to 1.6 (AES-128) or 2.0 (AES-256) if
+// needed.
+//
+// With args.Deterministic, identical documents are written identically: file
+// identifiers are computed from the document objects instead of the time and
+// path, and encryption salts, IVs and AES-256 key are derived from the
+// passwords and identifiers instead of being random (encryption is then only
+// as strong as the passwords). New dictionaries keep their insertion order in
+// any case. See also DocumentArgs.Deterministic.
+//
+// It returns the number of bytes written and the first error, if any.
+func (pdf *PdfFile) Write( w io.Writer, args *WriteArgs ) ( int64, error ) {
+    return pdf.write( w, args, "" )
+}
+
+// write the whole document to w, which is the file path if known
+func (pdf *PdfFile) write( w io.Writer, args *WriteArgs, path string ) ( int64, error ) {
+    if args == nil {
+        args = &WriteArgs{ }
+    }
+    f := newPdfWriter( w )
+    f.fileId = pdf.outputFileId( path, args.Deterministic )
+    version := pdf.Version
+    if (args.XrefStream || args.ObjectStreams) && version < "1.5" {
+        version = "1.5"             // XREF streams appeared in PDF 1.5
+    }
+    if args.Encryption != nil {
+        if err := pdf.setOutputEncryption( f, args.Encryption, args.Deterministic ); err != nil {
+            return 0, err
+        }
+        if args.Encryption.Method == ENCRYPT_AES_128 && version < "1.6" {
+            version = "1.6"
+        } else if args.Encryption.Method == ENCRYPT_AES_256 && version < "2.0" {
+            version = "2.0"
+        }
+    }
+    header := pdf.Header
+    if version != pdf.Version {
+        header = "%PDF-" + version
+    }
+    if args.XrefStream || args.ObjectStreams {
+        pdf.serializeWithXrefStream( f, header, args.ObjectStreams )
+    } else {
+        pdf.serializeFirstLine( f, header )
+        pdf.serializeObjects( f )
+        last, pos := pdf.serializeXREF( f )
+        pdf.serializeTrailer( f, last, pos )
+    }
+    if err := f.flush( ); err != nil {
+        return f.out.n, fmt.Errorf( "Error serializing pdf file: %v", err )
+    }
+    ids := f.fileId
+    pdf.Id = &ids
+    pdf.Trailer.Set( "ID", ids )
+    return f.out.n, nil
+}
+
+// WriteTo writes the whole document to w, as a single revision with a XREF
+// table. It returns the number of bytes written and the first error, if any.
+func (pdf *PdfFile) WriteTo( w io.Writer ) ( int64, error ) {
+    return pdf.Write( w, nil )
+}
+
+// WriteFile writes the whole document in the file name. See Write.
+func (pdf *PdfFile) WriteFile( name string, args *WriteArgs ) error {
     f, err := os.Create( name )
     if err != nil {
-        reportSerializeError( err )
+        return err
+    }
+    _, err = pdf.write( f, args, name )
+    if cErr := f.Close( ); err == nil {
+        err = cErr
     }
-    pdf.serializeFirstLine( f )
-    pdf.serializeObjects( f )
-    last, pos := pdf.serializeXREF( f )
-    pdf.serializeTrailer( f, last, pos )
-    f.Close( )
+    return err
 }
 
+// Serialize writes the whole document in the file name. See WriteTo.
+func (pdf *PdfFile) Serialize( name string ) error {
+    return pdf.WriteFile( name, nil )
+}
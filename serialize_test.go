@@ -0,0 +1,214 @@
+package pdf
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestXrefFieldWidth( t *testing.T ) {
+    tests := []struct {
+        v       int64
+        w       int
+    }{
+        { 0, 1 }, { 1, 1 }, { 255, 1 }, { 256, 2 }, { 65535, 2 }, { 65536, 3 }, { 1 << 32, 5 },
+    }
+    for _, test := range tests {
+        if w := xrefFieldWidth( test.v ); w != test.w {
+            t.Errorf( "xrefFieldWidth( %d ): got %d, expected %d", test.v, w, test.w )
+        }
+    }
+}
+
+func TestMakeObjectStream( t *testing.T ) {
+    objs := []*PdfObject{ { id: 3, value: NewArray( Number(1), Number(2) ) },
+                          { id: 5, value: Name("Five") },
+                          { id: 4, value: String("four") } }
+    entries := make( map[int64]xrefEntry )
+    stream := makeObjectStream( 9, objs, entries )
+
+    data, err := stream.Decode( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    first, _ := stream.extent.data["First"].(Number)
+    if n, _ := stream.extent.data["N"].(Number); n != 3 {
+        t.Errorf( "Object stream N: got %v, expected 3", n )
+    }
+    fi := newMemoryInput( data, nil, false )
+    ids, offsets, err := parseObjectStreamHeader( fi, 3 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for i, obj := range objs {
+        if ids[i] != obj.id {
+            t.Errorf( "Object #%d: got ID %d, expected %d", i, ids[i], obj.id )
+        }
+        fi = newMemoryInput( data[int64(first) + offsets[i]:], nil, false )
+        fi.nextToken( )
+        v, err := getObjectDef( fi, -1 )
+        if err != nil || ! equalValues( v, obj.value ) {
+            t.Errorf( "Object %d: got %v %v, expected %v", obj.id, v, err, obj.value )
+        }
+        e := entries[obj.id]
+        if ! e.inUse || e.stream != 9 || e.index != int64(i) {
+            t.Errorf( "Object %d XREF entry: got %+v, expected in object stream 9 at %d", obj.id, e, i )
+        }
+    }
+}
+
+// check that the written document has the same objects as pf
+func checkWrittenObjects( t *testing.T, pf, written *PdfFile ) {
+    t.Helper( )
+    for id, obj := range pf.ObjById {
+        wo, ok := written.ObjById[id]
+        if ! ok {
+            t.Errorf( "Object %d is missing", id )
+            continue
+        }
+        if ! equalValues( obj.value, wo.value ) {
+            t.Errorf( "Object %d: got %v, expected %v", id, wo.value, obj.value )
+        }
+    }
+}
+
+func TestWriteXrefStream( t *testing.T ) {
+    pf := newTestDocument( t, 3 )
+    pf.Version, pf.Header = "1.4", "%PDF-1.4"
+    data := writeTestDocument( t, pf, &WriteArgs{ XrefStream: true } )
+    if ! bytes.HasPrefix( data, []byte("%PDF-1.5\n") ) {
+        t.Errorf( "Header: got %q, expected version 1.5", data[:9] )
+    }
+    if bytes.Contains( data, []byte("\nxref\n") ) || bytes.Contains( data, []byte("\ntrailer\n") ) {
+        t.Errorf( "Output has a XREF table or a trailer" )
+    }
+
+    written, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if len(written.Revisions) != 1 || ! written.Revisions[0].XrefStream {
+        t.Fatalf( "Revisions: got %v, expected a single XREF stream", written.Revisions )
+    }
+    for _, obj := range written.ObjById {
+        if obj.stream != 0 {
+            t.Errorf( "Object %d is in an object stream", obj.id )
+        }
+        if getValueType( obj.value ) == "XRef" {
+            t.Errorf( "XREF stream %d is a document object", obj.id )
+        }
+    }
+    checkWrittenObjects( t, pf, written )
+    checkTestDocument( t, written, 3, "Test" )
+}
+
+func TestWriteObjectStreams( t *testing.T ) {
+    pf := newTestDocument( t, OBJECT_STREAM_SIZE )   // more compressible objects than a single stream holds
+    data := writeTestDocument( t, pf, &WriteArgs{ ObjectStreams: true } )
+
+    written, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    containers := make( map[int64]bool )
+    for _, obj := range written.ObjById {
+        _, isStream := obj.value.(Stream)
+        if isStream == (obj.stream != 0) {
+            t.Errorf( "Object %d: stream %v, in object stream %d", obj.id, isStream, obj.stream )
+        }
+        if obj.stream != 0 {
+            containers[obj.stream] = true
+        }
+    }
+    if len(containers) != 2 {
+        t.Errorf( "Object streams: got %d, expected 2", len(containers) )
+    }
+    for id := range containers {    // loaded, then dropped
+        if _, ok := written.ObjById[id]; ok {
+            t.Errorf( "Object stream %d is a document object", id )
+        }
+    }
+    checkWrittenObjects( t, pf, written )
+    checkTestDocument( t, written, OBJECT_STREAM_SIZE, "Test" )
+}
+
+func TestWriteXrefStreamFreeEntries( t *testing.T ) {
+    pf := newTestDocument( t, 2 )
+    deleted, err := pf.Page( 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    id := deleted.obj.id
+    if err = pf.DeletePage( 0 ); err != nil {
+        t.Fatal( err )
+    }
+    data := writeTestDocument( t, pf, &WriteArgs{ XrefStream: true } )
+
+    written, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    found := false
+    for _, e := range written.Revisions[0].entries {
+        if e.id == id {
+            found = true
+            if e.inUse || e.gen != 1 {
+                t.Errorf( "Deleted object %d: got %+v, expected a free entry", id, e )
+            }
+        }
+    }
+    if ! found {
+        t.Errorf( "Deleted object %d has no XREF entry", id )
+    }
+    if _, ok := written.ObjById[id]; ok {
+        t.Errorf( "Deleted object %d is defined", id )
+    }
+    checkTestDocument( t, written, 1, "Test" )
+}
+
+func TestSerializeNumber( t *testing.T ) {
+    tests := []struct {
+        n       float64
+        s       string
+    }{
+        { 0, "0" }, { -1, "-1" }, { 0.5, "0.5" }, { 612, "612" }, { 1234567, "1234567" },
+        { 1234567.5, "1234567.5" }, { 12345678.9, "12345678.9" }, { -98765432.125, "-98765432.125" },
+        { 0.001234567, "0.001234567" }, { -0.00001234567, "-0.00001234567" }, { 1e21, "1000000000000000000000" },
+    }
+    for _, test := range tests {
+        var b bytes.Buffer
+        f := newPdfWriter( &b )
+        serializeNumber( f, test.n )
+        f.flush( )
+        if b.String() != test.s {
+            t.Errorf( "serializeNumber( %g ): got %s, expected %s", test.n, b.String(), test.s )
+        }
+    }
+}
+
+func TestWriteLargeNumbers( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    p, err := pf.Page( 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    box := NewArray( Number(-0.000125), Number(0), Number(1234567.5), Number(12345678.9) )
+    dict := p.Dict( ).clone( )
+    dict.Set( "MediaBox", box )
+    p.obj.SetValue( dict )
+    data := writeTestDocument( t, pf, nil )
+    if ! bytes.Contains( data, []byte("1234567.5") ) || ! bytes.Contains( data, []byte("12345678.9") ) {
+        t.Errorf( "Written MediaBox is not formatted without exponent" )
+    }
+
+    written, err := ParseBytes( data, nil )
+    if err != nil {
+        t.Fatal( err )
+    }
+    wp, err := written.Page( 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if v := wp.Dict( ).data["MediaBox"]; ! equalValues( v, box ) {
+        t.Errorf( "MediaBox: got %v, expected %v", v, box )
+    }
+}
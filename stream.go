@@ -3,10 +3,14 @@ package pdf
 
 import (
     "fmt"
+    "io"
+    "math"
+    "bytes"
+    "compress/zlib"
     "github.com/jrm-1535/jpeg"
 )
 
-func checkASCIIHexDecode( data []byte, verbose, fix bool ) ([]byte, error) {
+func checkASCIIHexDecode( data []byte, dc *diagnostics, fix bool ) ([]byte, error) {
     dl := 0         // decoded length
     offset := 0     // encoded offset, used to find the nibble parity
     var val byte    // use to accumulate 2 decoded Hex chars as 1 byte
@@ -22,10 +26,7 @@ decodeLoop:
         default:
             nib := makeNibbleFromHexChar( v )
             if nib == 0xff {
-                if verbose {
-                    fmt.Printf( "Invalid hex character 0x%x at offset %d\n", v, i )
-                }
-                return output[0:dl], fmt.Errorf( "Invalid hex character 0x%x in stream\n", v )
+                return output[0:dl], fmt.Errorf( "Invalid hex character 0x%x at offset %d in stream\n", v, i )
             }
             if offset & 1 == 0 { // first nibble, temporarily stored in val
                 val = nib
@@ -37,29 +38,25 @@ decodeLoop:
 
         }
     }
-    if dl & 1 == 1 {
-        if verbose {
-            fmt.Printf( "Odd number of nibbles, last nibble assumed to be 0\n" )
-        }
+    if offset & 1 == 1 {
+        dc.add( SEVERITY_INFO, DIAG_STREAM_DATA, false, "Odd number of nibbles, last nibble assumed to be 0\n" )
         output[dl] = val << 4
         dl ++
     }
-    if verbose {
-        fmt.Printf( "Decoded length: %d\n", dl )
-    }
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Decoded ASCII hex data length: %d\n", dl )
     return output[0:dl], nil
 }
 
-func checkASCII85Decode( data []byte, verbose, fix bool ) ([]byte, error) {
+func checkASCII85Decode( data []byte, dc *diagnostics, fix bool ) ([]byte, error) {
 
     dl := 0         // decoded length
     gi := 0         // index in a group 0f 5 ASCII-85 chars
     n64 := int64(0) // number resulting from 5 ASCII-85 char (may not fit in 32 bits)
-    offset := 0     // last offset in encoded data when EOD is found
+    offset := -1    // last offset in encoded data when EOD is found
     // pre-allocate an output buffer for an upper bound
     // At worst it is 4 * (len(data)-2) because of the 'z' compression for 0x00000000
     // At best it is 4 * ((len(data)-2) / 5) - if no 'z' character is used
-    output := make( []byte, 4 * (len(data) - 2 ) )
+    output := make( []byte, 4 * len(data) )
 
 decodeLoop:
     for i, v := range data {
@@ -71,26 +68,17 @@ decodeLoop:
             // just skip any 'white-space' character
         case 'z':
             if gi != 0 {
-                if verbose {
-                    fmt.Printf( "ASCII 85 character 'z' in a middle of a group at offset %d\n", i )
-                }
-                return output[0:dl], fmt.Errorf( "ASCII 85 character 'z' in a middle of a group in stream\n" )
+                return output[0:dl], fmt.Errorf( "ASCII 85 character 'z' in a middle of a group at offset %d in stream\n", i )
             }
             dl += 4 // special case for 0x00000000
         default:
             if v < '!' || v > 'u' {
-                if verbose {
-                    fmt.Printf( "Invalid ASCII 85 character 0x%x at offset %d\n", v, i )
-                }
-                return output[0:dl], fmt.Errorf( "Invalid ASCII 85 character 0x%x in stream\n", v )
+                return output[0:dl], fmt.Errorf( "Invalid ASCII 85 character 0x%x at offset %d in stream\n", v, i )
             }
             n64 = n64 * 85 + int64( v - '!' )
             if gi == 4 {    // we are about to process the 5th ASCI 85 char
                 if n64 > 4294967295 {
-                    if verbose {
-                        fmt.Printf( "Invalid ASCII 85 encoding (beyond 2^32 -1) at offset %d\n", i )
-                    }
-                    return output[0:dl], fmt.Errorf( "Invalid ASCII 85 encoding (beyond 2^32 -1) in stream\n" )
+                    return output[0:dl], fmt.Errorf( "Invalid ASCII 85 encoding (beyond 2^32 -1) at offset %d in stream\n", i )
                 }
                 output[dl] = byte(  n64 >> 24 )
                 output[dl+1] = byte( (n64 >> 16) & 0xff )
@@ -104,40 +92,31 @@ decodeLoop:
             }
         }
     }
+    if offset == -1 {
+        return output[0:dl], fmt.Errorf( "Missing ASCII 85 EOD sequence in stream\n" )
+    }
     if len(data) <= offset + 1 || data[offset+1] != '>' {
-        if verbose {
-            fmt.Printf( "Invalid ASCII 85 EOD sequence (~ followed by 0x%x) at offset %d\n",
-                        data[offset+1], offset+1 )
+        return output[0:dl], fmt.Errorf( "Invalid ASCII 85 EOD sequence (~ not followed by >) at offset %d in stream\n",
+                                         offset+1 )
+    }
+    if gi != 0 {
+        if gi == 1 {
+            return output[0:dl], fmt.Errorf( "Invalid ASCII 85 last group at offset %d in stream\n", offset-1 )
         }
-        return output[0:dl], fmt.Errorf( "Invalid ASCII 85 EOD sequence (~ followed by 0x%x) in stream\n",
-                             data[offset+1] )
-    }
-    if verbose {
-        if gi != 0 {
-            if gi == 1 {
-                if verbose {
-                    fmt.Printf( "Invalid ASCII 85 last group at offset %d\n", offset-1 )
-                }
-                return output[0:dl], fmt.Errorf( "Invalid ASCII last group in stream\n" )
-            }
-            // the last goup should be padded with as many 'u' as needed to make 5 chars
-            for i := gi; i < 5; i++ {
-                n64 = n64 * 85 + 84
-            }
-            if n64 > 4294967295 {
-                if verbose {
-                    fmt.Printf( "Invalid ASCII 85 encoding (beyond 2^32 -1) in last group at offset %d\n",
-                                offset-1 )
-                }
-                return output[0:dl], fmt.Errorf( "Invalid ASCII 85 encoding (beyond 2^32 -1) in last group\n" )
-            }
-            for i := 0; i < gi-1; i++ {
-                output[dl] = byte( 0xff & ( n64 >> uint( 24 - i * 8 ) ) )
-                dl++
-            }
+        // the last goup should be padded with as many 'u' as needed to make 5 chars
+        for i := gi; i < 5; i++ {
+            n64 = n64 * 85 + 84
+        }
+        if n64 > 4294967295 {
+            return output[0:dl], fmt.Errorf( "Invalid ASCII 85 encoding (beyond 2^32 -1) in last group at offset %d\n",
+                                             offset-1 )
+        }
+        for i := 0; i < gi-1; i++ {
+            output[dl] = byte( 0xff & ( n64 >> uint( 24 - i * 8 ) ) )
+            dl++
         }
-        fmt.Printf( "Decoded ASCII 85 data length: %d\n", dl )
     }
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Decoded ASCII 85 data length: %d\n", dl )
     return output[0:dl], nil
 }
 
@@ -148,58 +127,331 @@ the following length + 1 (1 to 128) bytes are copied literally during decompress
 If length is in the range 129 to 255, the following single byte is to be copied
 257 − length (2 to 128) times during decompression. A length value of 128 denotes EOD.
 */
-func checkRunLengthDecode( data []byte, verbose, fix bool ) error {
-    dl := 0
+func checkRunLengthDecode( data []byte, dc *diagnostics, fix bool ) ([]byte, error) {
     offset := 0
     maxOffset := len(data) - 1
+    output := make( []byte, 0, 2 * len(data) )
     for {
         if offset > maxOffset {
-            if verbose {
-                fmt.Printf( "Reached the end of stream without end of runlength at offset %d\n",
-                            offset )
-            }
-            return fmt.Errorf( "Reached the end of stream without end of runlength\n" )
+            return output, fmt.Errorf( "Reached the end of stream without end of runlength at offset %d\n", offset )
         }
         rl := int(data[offset])
         if rl < 128 {
-            dl += rl + 1               // actual "decoded" data
             nOffset := offset + rl + 2 // rl offset + 1 to get to the first following byte + actual (rl + 1)
             if nOffset > len(data) { 
-                if verbose {
-                    fmt.Printf( "Invalid runlength encoding (beyond end of stream) at offset %d\n",
-                                offset )
-                }
-                return fmt.Errorf( "Invalid runlength encoding (beyond end of stream)\n" )
+                return output, fmt.Errorf( "Invalid runlength encoding (beyond end of stream) at offset %d\n", offset )
             }
+            output = append( output, data[offset+1:nOffset]... )    // actual "decoded" data
             offset = nOffset
         } else if rl == 128 {
             break
         } else {
-            dl += 257 - rl
+            if offset + 1 > maxOffset {
+                return output, fmt.Errorf( "Invalid runlength encoding (beyond end of stream) at offset %d\n", offset )
+            }
+            for i := 0; i < 257 - rl; i++ {
+                output = append( output, data[offset+1] )
+            }
             offset += 2
         }
     }
-    if verbose {
-        fmt.Printf( "Decoded runlength data length: %d\n", dl )
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Decoded runlength data length: %d\n", len(output) )
+    return output, nil
+}
+
+/*
+LZW codes start with 9 bits and grow up to 12 bits, as the code table fills
+up. Code 256 clears the table and code 257 indicates EOD. With EarlyChange 1
+(by default) the code length increases one code earlier than necessary.
+*/
+func checkLZWDecode( data []byte, parms map[string]interface{}, dc *diagnostics, fix bool ) ([]byte, error) {
+    early := getIntParameter( parms, "EarlyChange", 1 )
+    table := make( [][]byte, 258, 4096 )
+    for i := 0; i < 256; i++ {
+        table[i] = []byte{ byte(i) }
+    }
+    codeLen := 9
+    var bits uint32     // accumulated input bits
+    nBits := 0          // number of valid bits in bits
+    var prev []byte     // previous code sequence
+    output := make( []byte, 0, 2 * len(data) )
+    pos := 0
+
+decodeLoop:
+    for {
+        for nBits < codeLen {
+            if pos >= len(data) {
+                dc.add( SEVERITY_INFO, DIAG_STREAM_DATA, false, "LZW data without EOD code\n" )
+                break decodeLoop
+            }
+            bits = bits << 8 | uint32(data[pos])
+            pos ++
+            nBits += 8
+        }
+        nBits -= codeLen
+        code := int( bits >> uint(nBits) ) & (1 << uint(codeLen) - 1)
+
+        var entry []byte
+        switch {
+        case code == 256:
+            table = table[:258]
+            codeLen = 9
+            prev = nil
+            continue
+        case code == 257:
+            break decodeLoop
+        case code < len(table):
+            entry = table[code]
+        case code == len(table) && prev != nil:   // sequence being defined
+            entry = make( []byte, len(prev) + 1 )
+            copy( entry, prev )
+            entry[len(prev)] = prev[0]
+        default:
+            return output, fmt.Errorf( "Invalid LZW code %d at offset %d in stream\n", code, pos )
+        }
+        output = append( output, entry... )
+        if prev != nil && len(table) < 4096 {
+            next := make( []byte, len(prev) + 1 )
+            copy( next, prev )
+            next[len(prev)] = entry[0]
+            table = append( table, next )
+        }
+        prev = entry
+        if codeLen < 12 && len(table) + early >= 1 << uint(codeLen) {
+            codeLen ++
+        }
+    }
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Decoded LZW data length: %d\n", len(output) )
+    return reversePredictor( output, parms )
+}
+
+// get an optional integer parameter from a DecodeParms dictionary
+func getIntParameter( parms map[string]interface{}, key string, def int ) int {
+    if v, ok := parms[key]; ok {
+        if n, ok := v.(Number); ok {
+            return int(n)
+        }
+    }
+    return def
+}
+
+func paethPredictor( a, b, c byte ) byte {
+    p := int(a) + int(b) - int(c)
+    pa, pb, pc := p - int(a), p - int(b), p - int(c)
+    if pa < 0 { pa = -pa }
+    if pb < 0 { pb = -pb }
+    if pc < 0 { pc = -pc }
+    if pa <= pb && pa <= pc { return a }
+    if pb <= pc { return b }
+    return c
+}
+
+// check the predictor parameters: Colors and Columns must be positive, and
+// BitsPerComponent must be 1, 2, 4, 8 or 16
+func checkPredictorParameters( colors, bpc, columns int ) error {
+    switch bpc {
+    case 1, 2, 4, 8, 16:
+    default:
+        return fmt.Errorf( "Invalid predictor BitsPerComponent %d\n", bpc )
+    }
+    if colors <= 0 || columns <= 0 || colors > math.MaxInt32 / 16 / columns {
+        return fmt.Errorf( "Invalid predictor Colors %d or Columns %d\n", colors, columns )
     }
     return nil
 }
 
-// TODO: add CCITTFaxDecode and FlatDecode
+/*
+PNG predictors (Predictor 10 to 15) prefix each row of Columns samples with
+a filter type byte. Whatever the Predictor value, the filter type is given
+by that byte in each row: 0 None, 1 Sub, 2 Up, 3 Average and 4 Paeth.
+*/
+func reversePNGPredictor( data []byte, colors, bpc, columns int ) ( []byte, error ) {
+    if err := checkPredictorParameters( colors, bpc, columns ); err != nil {
+        return nil, err
+    }
+    bpp := (colors * bpc + 7) / 8           // bytes per complete pixel, at least 1
+    rowLen := (colors * bpc * columns + 7) / 8
+    nRows := len(data) / (rowLen + 1)
+    output := make( []byte, nRows * rowLen )
+    prior := make( []byte, rowLen )         // all 0 before the first row
+
+    for r := 0; r < nRows; r++ {
+        in := data[r * (rowLen+1):(r+1) * (rowLen+1)]
+        row := output[r * rowLen:(r+1) * rowLen]
+        filter := in[0]
+        copy( row, in[1:] )
+        for i := 0; i < rowLen; i++ {
+            var left, upLeft byte
+            if i >= bpp {
+                left = row[i-bpp]
+                upLeft = prior[i-bpp]
+            }
+            switch filter {
+            case 0:
+            case 1: row[i] += left
+            case 2: row[i] += prior[i]
+            case 3: row[i] += byte( (int(left) + int(prior[i])) / 2 )
+            case 4: row[i] += paethPredictor( left, prior[i], upLeft )
+            default:
+                return nil, fmt.Errorf( "Invalid PNG predictor filter type %d in row %d\n", filter, r )
+            }
+        }
+        prior = row
+    }
+    return output, nil
+}
+
+func getSample( row []byte, s, bpc int ) int {
+    bit := s * bpc
+    shift := 8 - bpc - bit % 8
+    return int(row[bit / 8] >> uint(shift)) & (1 << uint(bpc) - 1)
+}
+
+func setSample( row []byte, s, bpc, v int ) {
+    bit := s * bpc
+    shift := uint(8 - bpc - bit % 8)
+    mask := byte(1 << uint(bpc) - 1) << shift
+    row[bit / 8] = (row[bit / 8] &^ mask) | (byte(v) << shift) & mask
+}
+
+/*
+TIFF predictor 2: in each row, each sample but the ones of the first pixel
+is the difference with the same color component in the preceding pixel.
+*/
+func reverseTIFFPredictor( data []byte, colors, bpc, columns int ) ( []byte, error ) {
+    if err := checkPredictorParameters( colors, bpc, columns ); err != nil {
+        return nil, err
+    }
+    rowLen := (colors * bpc * columns + 7) / 8
+    nRows := len(data) / rowLen
+    output := make( []byte, nRows * rowLen )
+    copy( output, data )
+
+    for r := 0; r < nRows; r++ {
+        row := output[r * rowLen:(r+1) * rowLen]
+        switch bpc {
+        case 8:
+            for i := colors; i < rowLen; i++ {
+                row[i] += row[i-colors]
+            }
+        case 16:
+            for i := 2 * colors; i + 1 < rowLen; i += 2 {
+                v := uint16(row[i]) << 8 | uint16(row[i+1])
+                v += uint16(row[i-2*colors]) << 8 | uint16(row[i-2*colors+1])
+                row[i], row[i+1] = byte(v >> 8), byte(v)
+            }
+        default:                            // 1, 2 or 4
+            for i := colors; i < colors * columns; i++ {
+                setSample( row, i, bpc, getSample( row, i, bpc ) + getSample( row, i-colors, bpc ) )
+            }
+        }
+    }
+    return output, nil
+}
+
+// return the size of the predicted rows, including the PNG filter type
+// byte, or 0 if no predictor is used
+func predictorRowSize( parms map[string]interface{} ) ( int, error ) {
+    predictor := getIntParameter( parms, "Predictor", 1 )
+    if predictor < 2 {
+        return 0, nil
+    }
+    colors := getIntParameter( parms, "Colors", 1 )
+    bpc := getIntParameter( parms, "BitsPerComponent", 8 )
+    columns := getIntParameter( parms, "Columns", 1 )
+    if err := checkPredictorParameters( colors, bpc, columns ); err != nil {
+        return 0, err
+    }
+    rowLen := (colors * bpc * columns + 7) / 8
+    if predictor >= 10 {
+        rowLen ++
+    }
+    return rowLen, nil
+}
 
-func checkCCITTFaxDecode( data []byte, parameters map[string]interface{}, verbose, fix bool ) ([]byte, error) {
+// reverse the predictor indicated in the optional parms, if any
+func reversePredictor( data []byte, parms map[string]interface{} ) ( []byte, error ) {
+    if parms == nil {
+        return data, nil
+    }
+    predictor := getIntParameter( parms, "Predictor", 1 )
+    if predictor == 1 {
+        return data, nil
+    }
+    colors := getIntParameter( parms, "Colors", 1 )
+    bpc := getIntParameter( parms, "BitsPerComponent", 8 )
+    columns := getIntParameter( parms, "Columns", 1 )
+    if predictor == 2 {
+        return reverseTIFFPredictor( data, colors, bpc, columns )
+    }
+    if predictor >= 10 && predictor <= 15 {
+        return reversePNGPredictor( data, colors, bpc, columns )
+    }
+    return nil, fmt.Errorf( "Unsupported predictor %d\n", predictor )
+}
+
+/*
+Decompress zlib/deflate data and reverse the optional predictor. If the
+data is truncated or corrupted and fix is true, whatever could be
+decompressed is compressed again and returned as fixed data, so that the
+stream can be repaired. With a predictor, only complete rows are kept.
+*/
+func checkFlateDecode( data []byte, parms map[string]interface{}, dc *diagnostics, fix bool ) ([]byte, []byte, error) {
+    zr, err := zlib.NewReader( bytes.NewReader( data ) )
+    if err != nil {
+        return []byte{}, nil, fmt.Errorf( "Invalid zlib data: %v\n", err )
+    }
+    inflated, err := io.ReadAll( zr )
+    var fixed []byte
+    if err != nil {
+        if ! fix || len(inflated) == 0 {
+            return inflated, nil, fmt.Errorf( "Corrupted zlib data after %d decompressed bytes: %v\n",
+                                              len(inflated), err )
+        }
+        rowSize, perr := predictorRowSize( parms )
+        if perr != nil {
+            return inflated, nil, perr
+        }
+        if rowSize > 0 {
+            inflated = inflated[:len(inflated) - len(inflated) % rowSize]
+        }
+        fixed = flateEncode( inflated )
+        dc.add( SEVERITY_WARNING, DIAG_STREAM_DATA, true,
+                "Corrupted zlib data after %d decompressed bytes: %v, recompressed (len=%d)\n",
+                len(inflated), err, len(fixed) )
+    }
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Decoded flate data length: %d\n", len(inflated) )
+    output, err := reversePredictor( inflated, parms )
+    return output, fixed, err
+}
+
+// decompress zlib/deflate data and reverse the optional predictor
+func flateDecode( data []byte, parms map[string]interface{} ) ( []byte, error ) {
+    output, _, err := checkFlateDecode( data, parms, nil, false )
+    return output, err
+}
+
+// compress data with zlib/deflate, without predictor
+func flateEncode( data []byte ) []byte {
+    var b bytes.Buffer
+    zw := zlib.NewWriter( &b )
+    zw.Write( data )
+    zw.Close()
+    return b.Bytes()
+}
+
+// TODO: add CCITTFaxDecode
+
+func checkCCITTFaxDecode( data []byte, parameters map[string]interface{}, dc *diagnostics, fix bool ) ([]byte, error) {
     return []byte{}, fmt.Errorf( "checking CCITTFaxDecode is not supported yet\n" )
 }
 
 
-func checkDCTDecode( data []byte, verbose, fix bool ) ([]byte, *jpeg.FrameInfo, error) {
+func checkDCTDecode( data []byte, dc *diagnostics, fix bool ) ([]byte, *jpeg.FrameInfo, error) {
 
     // DCTDecode (JPEG) should be the last decoder in any sequence of decoders
     // since the decompressed data is an image to present.
-    if verbose {
-        fmt.Printf( "checkDCTDecode first 4 bytes 0x%x\n", string(data[0:4]) )
-    }
-    var control jpeg.Control = jpeg.Control{ Warn:verbose, TidyUp:fix }
+    var control jpeg.Control = jpeg.Control{ TidyUp:fix }
     jpg, err := jpeg.Parse( data, &control )
     if err != nil {
         return []byte{}, nil, err
@@ -217,115 +469,194 @@ func checkDCTDecode( data []byte, verbose, fix bool ) ([]byte, *jpeg.FrameInfo,
     }
 
     actualL, dataL := jpg.GetActualLengths()
-    if verbose {
-        fmt.Printf( "Actual JPEG length: %d (data length: %d)\n", actualL, dataL )
-    }
+    dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Actual JPEG length: %d (data length: %d)\n", actualL, dataL )
     if fix {    // FIXME: does not work if other decoders are used before checkDCTDecode (unlikely)
         var fixed []byte
         fixed, err = jpg.Generate()
         if err == nil {
             data = fixed
-            fmt.Printf( "Fixing jpeg stream (len=%d)\n", len(data) )
+            dc.add( SEVERITY_WARNING, DIAG_STREAM_DATA, true, "JPEG data regenerated (len=%d)\n", len(data) )
         }
     }
     return data, frameInfo, err
 }
 
-func makeOneElementArray( element interface{} ) *PdfArray {
-    newArray := new( PdfArray )
+func makeOneElementArray( element interface{} ) *Array {
+    newArray := new( Array )
     newArray.data = make( []interface{}, 1 )
     newArray.data[0] = element
     return newArray
 }
 
-func checkStream( stream *pdfStream, verbose, fix bool ) error {
+/*
+Apply in sequence all filters specified in the stream dictionary and return
+the decoded data. If decode is false, the stream is only checked: the JPEG
+data (DCTDecode) is parsed and possibly fixed, and unsupported filters just
+stop checking. If decode is true, the JPEG data is returned unmodified, and
+unsupported filters are reported as errors.
+*/
+func applyFilters( stream *Stream, dc *diagnostics, fix, decode bool ) ( []byte, error ) {
     dic := stream.extent
+    data := stream.data
 
-    if filter, ok := dic.data["Filter"]; ok {
+    filter, ok := dic.data["Filter"]
+    if ! ok {
+        dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "No filter specified\n" )
+        return data, nil
+    }
 // filter may be a simple name or an array of names.
 // to normalise the processing, an array is created for the single name case
-// Similarly, if DecodeParms is defined, it is a simple pdfDictionary if
-// filter is a simple name, or an array of pdfDictionaries otherwise. Again,
+// Similarly, if DecodeParms is defined, it is a simple Dictionary if
+// filter is a simple name, or an array of Dictionaries otherwise. Again,
 //  we normalize those 2 cases by creating an array with the single dictionary.
-        var filters PdfArray
-        var fParams PdfArray
+    var filters Array
+    var fParams Array
 
-        if f, ok := filter.(pdfName); ok {
-            filters = *makeOneElementArray( f )
-            if parms, ok := dic.data["DecodeParms"]; ok {
-                fParams = *makeOneElementArray( parms )
-            }
-        } else {    // filter is an array, so is DecodeParms
-//            filterArray := filter.(PdfArray)
-            filters = filter.(PdfArray)
-            if parms, ok := dic.data["DecodeParms"]; ok {
-//                parmsArray := parms.(PdfArray)
-                fParams = parms.(PdfArray)
+    if f, ok := filter.(Name); ok {
+        filters = *makeOneElementArray( f )
+        if parms, ok := dic.data["DecodeParms"]; ok {
+            fParams = *makeOneElementArray( parms )
+        }
+    } else if fa, ok := filter.(Array); ok { // filter is an array, so is DecodeParms
+        filters = fa
+        if parms, ok := dic.data["DecodeParms"]; ok {
+            if pa, ok := parms.(Array); ok {
+                fParams = pa
             }
         }
+    } else {
+        return data, fmt.Errorf( "Invalid stream filter %v\n", filter )
+    }
 
-        data := stream.data
-        for i, v := range filters.data {
-            if verbose {
-                fmt.Printf( "Stream Filter: %v\n", v.(pdfName) )
-                if fParams.data != nil {
-                    if p, ok := fParams.data[i].(pdfDictionary); ok {
-                        printMapValue( p.data, "    Parameters:\n", "    " )
-//                        fmt.Printf( ">>> Parameters: %v\n", p.data )
-                    } // else if pdfNull, ignore
-                }
+    for i, v := range filters.data {
+        name, ok := v.(Name)
+        if ! ok {
+            return data, fmt.Errorf( "Invalid stream filter %v\n", v )
+        }
+        var parms map[string]interface{}
+        if i < len(fParams.data) {
+            if p, ok := fParams.data[i].(Dictionary); ok {
+                parms = p.data
+            } // else if Null, ignore
+        }
+        if parms != nil {
+            dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Stream filter: %s, parameters: %v\n", name, parms )
+        } else {
+            dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Stream filter: %s\n", name )
+        }
+        var err error
+        switch name {   // abbreviated names are used in inline images
+        case "DCTDecode", "DCT":
+            if decode {     // data is an image to present: passthrough
+                break
             }
-            var err error
-            switch v.(pdfName) {
-            case "DCTDecode":
-                var meta *jpeg.FrameInfo
-                data, meta, err = checkDCTDecode( data, verbose, fix )
-                if err == nil && fix {  // update stream if jpeg could have fixed it
-//                    fmt.Printf( "Meta bpc=%d, w=%d h=%d\n", meta.SampleSize, meta.Width, meta.Height )
-                    stream.data = data
-                    dic.data["BitsPerComponent"] = pdfNumber(meta.SampleSize)
-                    dic.data["Width"] = pdfNumber(meta.Width)
-                    dic.data["Height"] = pdfNumber(meta.Height)
-                    dic.data["Length"] = pdfNumber(len(stream.data))
-                }
-            case "ASCIIHexDecode":
-                data, err = checkASCIIHexDecode( data, verbose, fix )
-            // TODO: no other case is currently supported
+            var meta *jpeg.FrameInfo
+            data, meta, err = checkDCTDecode( data, dc, fix )
+            if err == nil && fix {  // update stream if jpeg could have fixed it
+//                fmt.Printf( "Meta bpc=%d, w=%d h=%d\n", meta.SampleSize, meta.Width, meta.Height )
+                stream.data = data
+                dic.data["BitsPerComponent"] = Number(meta.SampleSize)
+                dic.data["Width"] = Number(meta.Width)
+                dic.data["Height"] = Number(meta.Height)
+                dic.data["Length"] = Number(len(stream.data))
             }
-            if err != nil {
-                return err
+        case "FlateDecode", "Fl":
+            var fixed []byte
+            data, fixed, err = checkFlateDecode( data, parms, dc, fix )
+            if err == nil && fixed != nil && i == 0 {   // repaired stream data
+                stream.data = fixed
+                dic.data["Length"] = Number(len(stream.data))
             }
+        case "LZWDecode", "LZW":
+            data, err = checkLZWDecode( data, parms, dc, fix )
+        case "ASCIIHexDecode", "AHx":
+            data, err = checkASCIIHexDecode( data, dc, fix )
+        case "ASCII85Decode", "A85":
+            data, err = checkASCII85Decode( data, dc, fix )
+        case "RunLengthDecode", "RL":
+            data, err = checkRunLengthDecode( data, dc, fix )
+        default:
+            if decode {
+                return data, fmt.Errorf( "Unsupported stream filter %s\n", name )
+            }
+            dc.add( SEVERITY_WARNING, DIAG_UNSUPPORTED_FILTER, false,
+                    "Stream filter %s is not supported, data is not checked further\n", name )
+            return data, nil
+        }
+        if err != nil {
+            return data, err
         }
-    } else if verbose {
-        fmt.Printf( "No filter specified\n" )
     }
-    return nil
+    return data, nil
 }
 
-func (pf *PdfFile)CheckStreams( verbose, fix bool ) error {
-    for oIndex, _ := range pf.Objects {
-        objPtr := pf.Objects[oIndex]
-        if stream, ok := objPtr.value.(pdfStream); ok {
-            if verbose {
-                fmt.Printf( "Checking pdfStream ID %d, gen %d\n",
-                            pf.Objects[oIndex].id, pf.Objects[oIndex].gen )
-            }
-            err := checkStream( &stream, verbose, fix )
+func checkStream( stream *Stream, dc *diagnostics, fix bool ) error {
+    _, err := applyFilters( stream, dc, fix, false )
+    return err
+}
+
+// return the data of stream, decoded by the whole sequence of filters
+func decodeStream( stream *Stream ) ( []byte, error ) {
+    return applyFilters( stream, nil, false, true )
+}
+
+// DecodeStream returns the decoded data of the stream object id. An error is
+// returned if the object is not a stream or if one of its filters is not
+// supported. Image data compressed with DCTDecode is returned as JPEG data.
+func (pf *PdfFile) DecodeStream( id int64 ) ( []byte, error ) {
+    obj, ok := pf.ObjById[id]
+    if ! ok || obj.value == nil {
+        return nil, fmt.Errorf( "Object %d does not exist\n", id )
+    }
+    stream, ok := obj.value.(Stream)
+    if ! ok {
+        return nil, fmt.Errorf( "Object %d is not a stream\n", id )
+    }
+    data, err := decodeStream( &stream )
+    if err != nil {
+        return nil, fmt.Errorf( "Stream object %d %d: %v", obj.id, obj.gen, err )
+    }
+    return data, nil
+}
+
+// CheckStreams checks the data of all streams against their filters, and
+// fixes them if requested. All streams are checked even if some are invalid.
+// It returns the diagnostics collected during checking, which are appended
+// to pf.Diagnostics, and an error for the first invalid stream if any.
+func (pf *PdfFile)CheckStreams( verbose, fix bool ) ( []Diagnostic, error ) {
+    dc := newDiagnostics( verbose )
+    var first error
+    for _, objPtr := range pf.Objects {
+        if stream, ok := objPtr.value.(Stream); ok {
+            dc.setObject( objPtr.id, objPtr.start )
+            dc.add( SEVERITY_INFO, DIAG_PROGRESS, false, "Checking stream %d %d\n", objPtr.id, objPtr.gen )
+            n := len(dc.list)
+            err := checkStream( &stream, dc, fix )
             if err != nil {
-                return err
+                dc.add( SEVERITY_ERROR, DIAG_STREAM_DATA, false, "%v", err )
+                if first == nil {
+                    first = fmt.Errorf( "Stream object %d %d: %v", objPtr.id, objPtr.gen, err )
+                }
+                continue
+            }
+            for _, d := range dc.list[n:] {
+                if d.Fixed {        // checkStream has modified the stream
+                    objPtr.SetValue( stream )
+                    break
+                }
             }
-            objPtr.value = stream   // checkStream may have modified the stream
         }
     }
-    return nil
+    pf.Diagnostics = append( pf.Diagnostics, dc.list... )
+    return dc.list, first
 }
 
 type StreamArgs struct {
-    Verbose     bool        // verbose checking the embedded streams
+    Verbose     bool        // collect informational diagnostics while checking the embedded streams
     Fix         bool        // fix stream contents after parsing
 }
 
-func (pf *PdfFile)Check( args *StreamArgs ) error {
+// Check checks the document streams. See CheckStreams.
+func (pf *PdfFile)Check( args *StreamArgs ) ( []Diagnostic, error ) {
     return pf.CheckStreams( args.Verbose, args.Fix )
 }
-
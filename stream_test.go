@@ -0,0 +1,376 @@
+package pdf
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+// return a stream with the given filter and decode parameters, given as
+// dictionary entries, and data
+func newTestStream( filter, parms string, data []byte ) Stream {
+    dict := NewDictionary( )
+    if filter != "" {
+        dict.Set( "Filter", Name(filter) )
+    }
+    if parms != "" {
+        fi := newMemoryInput( []byte( "<< " + parms + " >>" ), nil, false )
+        fi.nextToken()
+        d, err := getObjectDef( fi, -1 )
+        if err != nil {
+            panic( err )
+        }
+        dict.Set( "DecodeParms", d )
+    }
+    return NewStream( dict, data )
+}
+
+func TestPNGPredictor( t *testing.T ) {
+    // 2 rows of 3 samples [ 15 20 30 ] [ 10 50 60 ] with the first row
+    // encoded without prediction, and the second row with each filter type
+    first := []byte{ 0, 15, 20, 30 }
+    tests := []struct {
+        name    string
+        row     []byte
+    }{
+        { "none", []byte{ 0, 10, 50, 60 } },
+        { "sub", []byte{ 1, 10, 40, 10 } },
+        { "up", []byte{ 2, 251, 30, 30 } },
+        { "average", []byte{ 3, 3, 35, 20 } },
+        { "paeth", []byte{ 4, 251, 35, 10 } },  // predictors are up, upper left and left
+    }
+    expected := []byte{ 15, 20, 30, 10, 50, 60 }
+    for _, test := range tests {
+        data := append( first[:4:4], test.row... )
+        s := newTestStream( "FlateDecode", "/Predictor 15 /Columns 3", flateEncode( data ) )
+        decoded, err := s.Decode( )
+        if err != nil {
+            t.Errorf( "%s: %v", test.name, err )
+        } else if ! bytes.Equal( decoded, expected ) {
+            t.Errorf( "%s: got %v, expected %v", test.name, decoded, expected )
+        }
+    }
+
+    // multi-byte pixels and sub-byte samples
+    pixels := []struct {
+        parms   string
+        data    []byte
+        decoded []byte
+    }{
+        { "/Predictor 11 /Colors 2 /Columns 2", []byte{ 1, 1, 2, 4, 5 }, []byte{ 1, 2, 5, 7 } },
+        { "/Predictor 12 /Colors 1 /BitsPerComponent 16 /Columns 1",
+          []byte{ 2, 1, 2, 2, 1, 255 }, []byte{ 1, 2, 2, 1 } },
+        { "/Predictor 11 /BitsPerComponent 4 /Columns 4", []byte{ 1, 0x12, 0x22 }, []byte{ 0x12, 0x34 } },
+        { "/Predictor 10 /Columns 2", []byte{ 0, 1, 2, 1, 3 }, []byte{ 1, 2 } },  // incomplete row ignored
+    }
+    for _, p := range pixels {
+        s := newTestStream( "FlateDecode", p.parms, flateEncode( p.data ) )
+        decoded, err := s.Decode( )
+        if err != nil {
+            t.Errorf( "%s: %v", p.parms, err )
+        } else if ! bytes.Equal( decoded, p.decoded ) {
+            t.Errorf( "%s: got %v, expected %v", p.parms, decoded, p.decoded )
+        }
+    }
+}
+
+func TestTIFFPredictor( t *testing.T ) {
+    tests := []struct {
+        parms   string
+        data    []byte
+        decoded []byte
+    }{
+        { "/Predictor 2 /Columns 3", []byte{ 10, 10, 5, 1, 1, 1 }, []byte{ 10, 20, 25, 1, 2, 3 } },
+        { "/Predictor 2 /Colors 2 /Columns 2", []byte{ 10, 20, 1, 2 }, []byte{ 10, 20, 11, 22 } },
+        { "/Predictor 2 /BitsPerComponent 16 /Columns 2",
+          []byte{ 0x01, 0x00, 0x01, 0xff }, []byte{ 0x01, 0x00, 0x02, 0xff } },
+        { "/Predictor 2 /BitsPerComponent 4 /Columns 4", []byte{ 0x12, 0x39 }, []byte{ 0x13, 0x6f } },
+        // 2 colors of 2 bits: samples 1 2 2 3 are 1 2 3 1 (modulo 4)
+        { "/Predictor 2 /Colors 2 /BitsPerComponent 2 /Columns 2", []byte{ 0x6b }, []byte{ 0x6d } },
+    }
+    for _, test := range tests {
+        s := newTestStream( "FlateDecode", test.parms, flateEncode( test.data ) )
+        decoded, err := s.Decode( )
+        if err != nil {
+            t.Errorf( "%s: %v", test.parms, err )
+        } else if ! bytes.Equal( decoded, test.decoded ) {
+            t.Errorf( "%s: got %v, expected %v", test.parms, decoded, test.decoded )
+        }
+    }
+}
+
+func TestInvalidPredictor( t *testing.T ) {
+    data := flateEncode( []byte{ 0, 1, 2, 3, 4, 5, 6, 7, 8, 9 } )
+    for _, parms := range []string{
+        "/Predictor 12 /Columns 0",
+        "/Predictor 12 /Columns -2",
+        "/Predictor 2 /Colors 0",
+        "/Predictor 12 /BitsPerComponent 3",
+        "/Predictor 2 /BitsPerComponent -8",
+        "/Predictor 12 /Columns 10000000000000000000000",
+        "/Predictor 2 /Colors 1099511627776 /Columns 1099511627776",
+        "/Predictor 5",
+        "/Predictor 12 /Columns 4",     // filter type 5 in the second row
+    } {
+        s := newTestStream( "FlateDecode", parms, data )
+        if decoded, err := s.Decode( ); err == nil {
+            t.Errorf( "%s: no error (%v)", parms, decoded )
+        }
+    }
+}
+
+// return length bytes that do not compress too well
+func testFlateData( length int ) []byte {
+    data := make( []byte, length )
+    for i := range data {
+        data[i] = byte( i * i + i / 7 )
+    }
+    return data
+}
+
+func TestCorruptedFlate( t *testing.T ) {
+    data := testFlateData( 4000 )
+    for _, parms := range []string{ "", "/Predictor 10 /Columns 9" } {
+        pf := newTestDocument( t, 1 )
+        encoded := data
+        if parms != "" {    // rows of 9 bytes with filter type 0
+            var b bytes.Buffer
+            for i := 0; i + 9 <= len(data); i += 9 {
+                b.WriteByte( 0 )
+                b.Write( data[i:i+9] )
+            }
+            encoded = b.Bytes()
+        }
+        truncated := flateEncode( encoded )
+        truncated = truncated[:len(truncated) / 2]
+        obj := pf.NewObject( newTestStream( "FlateDecode", parms, truncated ) )
+        if _, err := pf.DecodeStream( obj.ID( ) ); err == nil {
+            t.Errorf( "%q: truncated data decoded", parms )
+        }
+        if _, err := pf.CheckStreams( false, false ); err == nil {
+            t.Errorf( "%q: no error without fix", parms )
+        }
+        diags, err := pf.CheckStreams( false, true )
+        if err != nil {
+            t.Fatalf( "%q: %v", parms, err )
+        }
+        if n := countCode( diags, DIAG_STREAM_DATA, SEVERITY_WARNING ); n != 1 {
+            t.Errorf( "%q: got %d stream data warnings, expected 1", parms, n )
+        }
+        // the repaired stream has the decompressed data, in complete rows
+        s := obj.Value( ).(Stream)
+        if l, _ := s.Dict( ).Get( "Length" ); l != Number( len(s.Data( )) ) {
+            t.Errorf( "%q: Length %v, expected %d", parms, l, len(s.Data( )) )
+        }
+        decoded, err := s.Decode( )
+        if err != nil {
+            t.Fatalf( "%q: repaired stream: %v", parms, err )
+        }
+        if len(decoded) == 0 || ! bytes.HasPrefix( data, decoded ) {
+            t.Errorf( "%q: repaired data (%d bytes) is not a prefix of the data", parms, len(decoded) )
+        }
+        if parms != "" && len(decoded) % 9 != 0 {
+            t.Errorf( "%q: repaired data length %d is not a multiple of 9", parms, len(decoded) )
+        }
+    }
+    pf := newTestDocument( t, 1 )
+    pf.NewObject( newTestStream( "FlateDecode", "", []byte( "not zlib data" ) ) )
+    if _, err := pf.CheckStreams( false, true ); err == nil {
+        t.Errorf( "Invalid zlib header: no error" )
+    }
+}
+
+// return data compressed with LZW, changing code length early if early is 1
+func encodeLZW( data []byte, early int ) []byte {
+    var out bytes.Buffer
+    var bits uint32
+    nBits, codeLen := 0, 9
+    emit := func( code int ) {
+        bits = bits << uint(codeLen) | uint32(code)
+        nBits += codeLen
+        for nBits >= 8 {
+            nBits -= 8
+            out.WriteByte( byte(bits >> uint(nBits)) )
+        }
+    }
+    table := make( map[string]int )
+    decoderSize := 258      // the decoder table is one entry behind
+    written := 0
+    code := func( s string ) int {
+        if len(s) == 1 {
+            return int(s[0])
+        }
+        return table[s]
+    }
+    output := func( s string ) {
+        emit( code( s ) )
+        if written > 0 {
+            decoderSize ++
+        }
+        written ++
+        if codeLen < 12 && decoderSize + early >= 1 << uint(codeLen) {
+            codeLen ++
+        }
+    }
+    emit( 256 )
+    w := ""
+    for _, c := range data {
+        wc := w + string( []byte{ c } )
+        if _, ok := table[wc]; ok || len(wc) == 1 {
+            w = wc
+            continue
+        }
+        output( w )
+        table[wc] = 258 + len(table)
+        w = wc[len(w):]
+    }
+    if w != "" {
+        output( w )
+    }
+    emit( 257 )
+    if nBits > 0 {
+        out.WriteByte( byte(bits << uint(8 - nBits)) )
+    }
+    return out.Bytes()
+}
+
+func TestLZWDecode( t *testing.T ) {
+    // example from the PDF specification
+    s := newTestStream( "LZWDecode", "", []byte{ 0x80, 0x0b, 0x60, 0x50, 0x22, 0x0c, 0x0c, 0x85, 0x01 } )
+    if decoded, err := s.Decode( ); err != nil || string(decoded) != "-----A---B" {
+        t.Errorf( "Specification example: got %q %v", decoded, err )
+    }
+
+    // long enough for 10 and 11 bit codes
+    data := testFlateData( 6000 )
+    for _, early := range []int{ 0, 1 } {
+        parms := ""
+        if early == 0 {
+            parms = "/EarlyChange 0"
+        }
+        s = newTestStream( "LZW", parms, encodeLZW( data, early ) )
+        if decoded, err := s.Decode( ); err != nil || ! bytes.Equal( decoded, data ) {
+            t.Errorf( "EarlyChange %d: got %d bytes %v, expected %d bytes", early, len(decoded), err, len(data) )
+        }
+    }
+    // with a predictor
+    s = newTestStream( "LZWDecode", "/Predictor 2 /Columns 3", encodeLZW( []byte{ 10, 10, 5 }, 1 ) )
+    if decoded, err := s.Decode( ); err != nil || ! bytes.Equal( decoded, []byte{ 10, 20, 25 } ) {
+        t.Errorf( "LZW with predictor: got %v %v", decoded, err )
+    }
+    // code 300 after clearing the table is invalid
+    s = newTestStream( "LZWDecode", "", []byte{ 0x80, 0x4b, 0x00 } )
+    if decoded, err := s.Decode( ); err == nil {
+        t.Errorf( "Invalid code: no error (%v)", decoded )
+    }
+}
+
+func TestDecodeStream( t *testing.T ) {
+    hello := []byte( "Hello, world" )
+    hex := "48656c6c6f2c20776f726c64"
+    tests := []struct {
+        name    string
+        filter  interface{}
+        parms   interface{}
+        data    []byte
+    }{
+        { "no filter", nil, nil, hello },
+        { "ASCIIHex", Name("ASCIIHexDecode"), nil, []byte( "48 65 6C 6c\n6f2c20776f726c64 >" ) },
+        { "ASCIIHex without EOD", Name("AHx"), nil, []byte( hex ) },
+        { "ASCII85", Name("A85"), nil, []byte( "87cURD_*#TDfTZ)~>" ) },
+        { "RunLength", Name("RunLengthDecode"), nil, []byte( "\x01He\xffl\x07o, world\x80" ) },
+        { "chain", NewArray( Name("AHx"), Name("Fl") ), NewArray( Null{}, Null{} ),
+          []byte( "789cf348cdc9c9d75128cf2fca490100" + "1bd40469>" ) },
+        { "hex and run length", NewArray( Name("ASCIIHexDecode"), Name("RunLengthDecode") ), nil,
+          []byte( "0b" + hex + "80>" ) },
+    }
+    for _, test := range tests {
+        dict := NewDictionary( )
+        if test.filter != nil {
+            dict.Set( "Filter", test.filter )
+        }
+        if test.parms != nil {
+            dict.Set( "DecodeParms", test.parms )
+        }
+        pf := newTestDocument( t, 0 )
+        obj := pf.NewObject( NewStream( dict, test.data ) )
+        decoded, err := pf.DecodeStream( obj.ID( ) )
+        if err != nil {
+            t.Errorf( "%s: %v", test.name, err )
+        } else if ! bytes.Equal( decoded, hello ) {
+            t.Errorf( "%s: got %q, expected %q", test.name, decoded, hello )
+        }
+    }
+}
+
+func TestDecodeStreamErrors( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    if _, err := pf.DecodeStream( 1000 ); err == nil {
+        t.Errorf( "Missing object: no error" )
+    }
+    if _, err := pf.DecodeStream( pf.Catalog.ID( ) ); err == nil {
+        t.Errorf( "Catalog: no error" )
+    }
+
+    // JPEG data is returned as is
+    jpeg := []byte{ 0xff, 0xd8, 0xff, 0xd9 }
+    obj := pf.NewObject( newTestStream( "DCTDecode", "", jpeg ) )
+    if data, err := pf.DecodeStream( obj.ID( ) ); err != nil || ! bytes.Equal( data, jpeg ) {
+        t.Errorf( "DCTDecode: got %v %v, expected %v", data, err, jpeg )
+    }
+
+    for _, test := range []struct {
+        filter  interface{}
+        data    string
+    }{
+        { Name("JBIG2Decode"), "data" },
+        { Name("CCITTFaxDecode"), "data" },
+        { Name("Unknown"), "data" },
+        { Number(1), "data" },
+        { NewArray( Name("AHx"), Number(1) ), "00>" },
+        { Name("ASCIIHexDecode"), "4x>" },
+        { Name("ASCII85Decode"), "87cURD" },         // no EOD
+        { Name("ASCII85Decode"), "87cURD~" },        // ~ without >
+        { Name("ASCII85Decode"), "87z~>" },          // z in a group
+        { Name("ASCII85Decode"), "s8W-\"~>" },       // beyond 2^32 - 1
+        { Name("RunLengthDecode"), "\x05He" },       // beyond data end
+        { Name("RunLengthDecode"), "\x01He" },       // no EOD
+    } {
+        dict := NewDictionary( )
+        dict.Set( "Filter", test.filter )
+        obj := pf.NewObject( NewStream( dict, []byte( test.data ) ) )
+        if data, err := pf.DecodeStream( obj.ID( ) ); err == nil {
+            t.Errorf( "Filter %v %q: no error (%q)", test.filter, test.data, data )
+        }
+    }
+}
+
+func TestCheckStreams( t *testing.T ) {
+    pf := newTestDocument( t, 1 )
+    pf.NewObject( newTestStream( "JBIG2Decode", "", []byte( "data" ) ) )
+    diags, err := pf.Check( &StreamArgs{ Verbose: true } )
+    if err != nil {
+        t.Fatalf( "Unsupported filter: %v", err )
+    }
+    if n := countCode( diags, DIAG_UNSUPPORTED_FILTER, SEVERITY_WARNING ); n != 1 {
+        t.Errorf( "Got %d unsupported filter warnings, expected 1", n )
+    }
+    if n := countCode( diags, DIAG_PROGRESS, SEVERITY_INFO ); n == 0 {
+        t.Errorf( "No progress information in verbose mode" )
+    }
+
+    // all streams are checked, the first error is returned
+    bad := pf.NewObject( newTestStream( "ASCIIHexDecode", "", []byte( "4x>" ) ) )
+    pf.NewObject( newTestStream( "ASCII85Decode", "", []byte( "87cURD" ) ) )
+    diags, err = pf.Check( &StreamArgs{ } )
+    if err == nil || ! strings.Contains( err.Error( ), fmt.Sprintf( "Stream object %d 0", bad.ID( ) ) ) {
+        t.Errorf( "Got error %v, expected an error for object %d", err, bad.ID( ) )
+    }
+    if n := countCode( diags, DIAG_STREAM_DATA, SEVERITY_ERROR ); n != 2 {
+        t.Errorf( "Got %d stream data errors, expected 2", n )
+    }
+    if n := countCode( diags, DIAG_PROGRESS, SEVERITY_INFO ); n != 0 {
+        t.Errorf( "Got %d progress information, expected none", n )
+    }
+}
@@ -0,0 +1,620 @@
+
+package pdf
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+)
+
+/*
+Text extraction: the page content is interpreted to follow the graphics state
+(transformation matrix, saved with q and restored with Q) and the text state
+(font, size, spacing, text matrix and text line matrix) set by the text
+operators, including in form XObjects. Each glyph shown by Tj, TJ, ' and " is
+then known by its text, its origin and its displacement on the page.
+
+Plain text is built in reading order: glyphs following each other on the same
+baseline are gathered in runs, and runs are sorted from top to bottom and
+from left to right on the page as displayed (taking into account the page
+crop box and rotation). Runs on the same baseline are joined in a line. A word
+separator is inserted where the distance between glyphs is larger than a
+fraction of the font size, unless the text already has a space. Text can also
+be given in content order, which is often the order in which it was written.
+*/
+
+const (
+    DEFAULT_WORD_SPACING = 0.2      // in font size units
+    _MAX_FORM_DEPTH = 16            // nested form XObjects
+)
+
+// affine transformation [a b c d e f], mapping x, y to a*x + c*y + e, b*x + d*y + f
+type matrix [6]float64
+
+var identityMatrix = matrix{ 1, 0, 0, 1, 0, 0 }
+
+// return m × n, i.e. the transformation m followed by n
+func (m matrix) multiply( n matrix ) matrix {
+    return matrix{
+        m[0] * n[0] + m[1] * n[2],          m[0] * n[1] + m[1] * n[3],
+        m[2] * n[0] + m[3] * n[2],          m[2] * n[1] + m[3] * n[3],
+        m[4] * n[0] + m[5] * n[2] + n[4],   m[4] * n[1] + m[5] * n[3] + n[5],
+    }
+}
+
+func (m matrix) apply( x, y float64 ) ( float64, float64 ) {
+    return m[0] * x + m[2] * y + m[4], m[1] * x + m[3] * y + m[5]
+}
+
+// return the matrix of 6 numeric operands or values
+func matrixValue( values []interface{} ) ( matrix, bool ) {
+    var m matrix
+    if len(values) != 6 {
+        return m, false
+    }
+    for i, v := range values {
+        n, ok := v.(Number)
+        if ! ok {
+            return m, false
+        }
+        m[i] = float64(n)
+    }
+    return m, true
+}
+
+// Color is a color in a color space, e.g. DeviceRGB with 3 components from 0
+// to 1. For a color space given by a resource (such as ICCBased, Separation
+// or Pattern), Space is the color space family and Components are the
+// numeric operands of the last color operator.
+type Color struct {
+    Space       Name
+    Components  []float64
+}
+
+var blackColor = Color{ "DeviceGray", []float64{ 0 } }
+
+// color spaces set by the device color operators
+var deviceColorSpaces = map[string]Name{
+    "g": "DeviceGray", "G": "DeviceGray", "rg": "DeviceRGB", "RG": "DeviceRGB", "k": "DeviceCMYK", "K": "DeviceCMYK",
+}
+
+// graphics state parameters used for text
+type graphicsState struct {
+    ctm             matrix
+    fill, stroke    Color
+    font            *textFont
+    fontSize        float64
+    charSpacing     float64     // Tc
+    wordSpacing     float64     // Tw
+    scale           float64     // Tz / 100
+    leading         float64     // TL
+    rise            float64     // Ts
+    render          int         // Tr
+}
+
+// a glyph shown by a text operator
+type shownGlyph struct {
+    text        string
+    code        uint32
+    font        *textFont
+    gs          *graphicsState
+    trm         matrix      // text rendering matrix: glyph space (scaled to 1) to user space
+    width       float64     // displacement in text space, for a font size of 1
+}
+
+// text interpreter: it calls show for each glyph shown by the content
+type textInterpreter struct {
+    pf          *PdfFile
+    gs          graphicsState
+    stack       []graphicsState
+    tm, tlm     matrix
+    fonts       map[Reference]*textFont
+    forms       map[Reference]bool      // forms being interpreted
+    show        func( g *shownGlyph )
+}
+
+func newTextInterpreter( pf *PdfFile, ctm matrix, show func( g *shownGlyph ) ) *textInterpreter {
+    ti := &textInterpreter{ pf: pf, fonts: make( map[Reference]*textFont ),
+                            forms: make( map[Reference]bool ), show: show }
+    ti.gs = graphicsState{ ctm: ctm, fill: blackColor, stroke: blackColor, scale: 1 }
+    ti.tm, ti.tlm = identityMatrix, identityMatrix
+    return ti
+}
+
+// return the font resource name in resources
+func (ti *textInterpreter) font( resources Dictionary, name Name ) ( *textFont, error ) {
+    fonts, _ := ti.pf.dictionaryValue( resources.data["Font"] )
+    v, ok := fonts.data[string(name)]
+    if ! ok {
+        return defaultTextFont( ), nil
+    }
+    ref, isRef := v.(Reference)
+    if isRef {
+        if f, ok := ti.fonts[ref]; ok {
+            return f, nil
+        }
+    }
+    f, err := ti.pf.loadFont( v )
+    if err != nil {
+        return nil, fmt.Errorf( "Font /%s: %v", name, err )
+    }
+    if isRef {
+        ti.fonts[ref] = f
+    }
+    return f, nil
+}
+
+// return the initial color in the color space name, either a device color
+// space or a ColorSpace resource
+func (ti *textInterpreter) colorSpace( resources Dictionary, name Name ) Color {
+    switch name {
+    case "DeviceGray", "G":
+        return blackColor
+    case "DeviceRGB", "RGB":
+        return Color{ "DeviceRGB", []float64{ 0, 0, 0 } }
+    case "DeviceCMYK", "CMYK":
+        return Color{ "DeviceCMYK", []float64{ 0, 0, 0, 1 } }
+    case "Pattern":
+        return Color{ Space: name }
+    }
+    spaces, _ := ti.pf.dictionaryValue( resources.data["ColorSpace"] )
+    v, _ := ti.pf.Resolve( spaces.data[string(name)] )
+    if a, ok := v.(Array); ok && len(a.data) > 0 {
+        v, _ = ti.pf.Resolve( a.data[0] )
+    }
+    if family, ok := v.(Name); ok {
+        return Color{ Space: family }
+    }
+    return Color{ Space: name }
+}
+
+// return the numeric operands, or false if they are not all numbers
+func numericOperands( operands []interface{}, n int ) ( []float64, bool ) {
+    if len(operands) != n {
+        return nil, false
+    }
+    values := make( []float64, n )
+    for i, v := range operands {
+        number, ok := v.(Number)
+        if ! ok {
+            return nil, false
+        }
+        values[i] = float64(number)
+    }
+    return values, true
+}
+
+// move to the start of the next line, offset by tx, ty
+func (ti *textInterpreter) moveText( tx, ty float64 ) {
+    ti.tlm = matrix{ 1, 0, 0, 1, tx, ty }.multiply( ti.tlm )
+    ti.tm = ti.tlm
+}
+
+// show the glyphs of string s, updating the text matrix
+func (ti *textInterpreter) showString( s []byte ) {
+    gs := &ti.gs
+    if gs.font == nil {
+        gs.font = defaultTextFont( )
+    }
+    f := gs.font
+    for len(s) > 0 {
+        code, n := f.nextCode( s )
+        w := f.width( code )
+        size := matrix{ gs.fontSize * gs.scale, 0, 0, gs.fontSize, 0, gs.rise }
+        if f.vertical {
+            size[0] = gs.fontSize
+        }
+        state := *gs
+        ti.show( &shownGlyph{ text: f.text( code ), code: code, font: f, gs: &state,
+                              trm: size.multiply( ti.tm ).multiply( gs.ctm ), width: w } )
+        spacing := gs.charSpacing
+        if n == 1 && code == 32 {
+            spacing += gs.wordSpacing
+        }
+        if f.vertical {
+            ti.tm = matrix{ 1, 0, 0, 1, 0, w * gs.fontSize + spacing }.multiply( ti.tm )
+        } else {
+            ti.tm = matrix{ 1, 0, 0, 1, (w * gs.fontSize + spacing) * gs.scale, 0 }.multiply( ti.tm )
+        }
+        s = s[n:]
+    }
+}
+
+// move the text position by a TJ adjustment, in thousandths of text space units
+func (ti *textInterpreter) adjust( n float64 ) {
+    d := -n / 1000 * ti.gs.fontSize
+    if ti.gs.font != nil && ti.gs.font.vertical {
+        ti.tm = matrix{ 1, 0, 0, 1, 0, d }.multiply( ti.tm )
+    } else {
+        ti.tm = matrix{ 1, 0, 0, 1, d * ti.gs.scale, 0 }.multiply( ti.tm )
+    }
+}
+
+// interpret the content operations with the given resources. Operations whose
+// operands are not valid are ignored.
+func (ti *textInterpreter) run( ops []Operation, resources Dictionary ) error {
+    for _, op := range ops {
+        operands := op.Operands
+        switch op.Operator {
+        case "q":
+            ti.stack = append( ti.stack, ti.gs )
+        case "Q":
+            if n := len(ti.stack); n > 0 {
+                ti.gs = ti.stack[n-1]
+                ti.stack = ti.stack[:n-1]
+            }
+        case "cm":
+            if m, ok := matrixValue( operands ); ok {
+                ti.gs.ctm = m.multiply( ti.gs.ctm )
+            }
+        case "g", "G", "rg", "RG", "k", "K":
+            space := deviceColorSpaces[op.Operator]
+            if v, ok := numericOperands( operands, len(ti.colorSpace( resources, space ).Components) ); ok {
+                if op.Operator[0] >= 'a' {
+                    ti.gs.fill = Color{ space, v }
+                } else {
+                    ti.gs.stroke = Color{ space, v }
+                }
+            }
+        case "cs", "CS":
+            if len(operands) == 1 {
+                if name, ok := operands[0].(Name); ok {
+                    if op.Operator == "cs" {
+                        ti.gs.fill = ti.colorSpace( resources, name )
+                    } else {
+                        ti.gs.stroke = ti.colorSpace( resources, name )
+                    }
+                }
+            }
+        case "sc", "scn", "SC", "SCN":
+            c := &ti.gs.fill
+            if op.Operator[0] == 'S' {
+                c = &ti.gs.stroke
+            }
+            components := make( []float64, 0, len(operands) )
+            for _, v := range operands {
+                if n, ok := v.(Number); ok {
+                    components = append( components, float64(n) )
+                }
+            }
+            *c = Color{ c.Space, components }
+        case "BT":
+            ti.tm, ti.tlm = identityMatrix, identityMatrix
+        case "Tc", "Tw", "Tz", "TL", "Ts", "Tr":
+            v, ok := numericOperands( operands, 1 )
+            if ! ok {
+                continue
+            }
+            switch op.Operator {
+            case "Tc": ti.gs.charSpacing = v[0]
+            case "Tw": ti.gs.wordSpacing = v[0]
+            case "Tz": ti.gs.scale = v[0] / 100
+            case "TL": ti.gs.leading = v[0]
+            case "Ts": ti.gs.rise = v[0]
+            case "Tr": ti.gs.render = int(v[0])
+            }
+        case "Tf":
+            if len(operands) != 2 {
+                continue
+            }
+            name, ok1 := operands[0].(Name)
+            size, ok2 := operands[1].(Number)
+            if ! ok1 || ! ok2 {
+                continue
+            }
+            f, err := ti.font( resources, name )
+            if err != nil {
+                return err
+            }
+            ti.gs.font, ti.gs.fontSize = f, float64(size)
+        case "Td", "TD":
+            if v, ok := numericOperands( operands, 2 ); ok {
+                if op.Operator == "TD" {
+                    ti.gs.leading = -v[1]
+                }
+                ti.moveText( v[0], v[1] )
+            }
+        case "Tm":
+            if m, ok := matrixValue( operands ); ok {
+                ti.tm, ti.tlm = m, m
+            }
+        case "T*":
+            ti.moveText( 0, -ti.gs.leading )
+        case "Tj", "'", "\"":
+            if op.Operator == "\"" && len(operands) == 3 {
+                if v, ok := numericOperands( operands[:2], 2 ); ok {
+                    ti.gs.wordSpacing, ti.gs.charSpacing = v[0], v[1]
+                }
+                operands = operands[2:]
+            }
+            if op.Operator != "Tj" {
+                ti.moveText( 0, -ti.gs.leading )
+            }
+            if len(operands) == 1 {
+                if s, ok := cmapString( operands[0] ); ok {
+                    ti.showString( s )
+                }
+            }
+        case "TJ":
+            if len(operands) != 1 {
+                continue
+            }
+            a, _ := operands[0].(Array)
+            for _, e := range a.data {
+                if n, ok := e.(Number); ok {
+                    ti.adjust( float64(n) )
+                } else if s, ok := cmapString( e ); ok {
+                    ti.showString( s )
+                }
+            }
+        case "Do":
+            if len(operands) == 1 {
+                if name, ok := operands[0].(Name); ok {
+                    if err := ti.runForm( resources, name ); err != nil {
+                        return err
+                    }
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// interpret the form XObject name in resources, if it is a form
+func (ti *textInterpreter) runForm( resources Dictionary, name Name ) error {
+    xobjects, _ := ti.pf.dictionaryValue( resources.data["XObject"] )
+    ref, ok := xobjects.data[string(name)].(Reference)
+    if ! ok || ti.forms[ref] || len(ti.forms) >= _MAX_FORM_DEPTH {
+        return nil
+    }
+    v, err := ti.pf.Resolve( ref )
+    if err != nil {
+        return fmt.Errorf( "XObject /%s: %v", name, err )
+    }
+    form, ok := v.(Stream)
+    if ! ok || form.extent.data["Subtype"] != Name("Form") {
+        return nil
+    }
+    data, err := form.Decode( )
+    if err != nil {
+        return fmt.Errorf( "XObject /%s: %v", name, err )
+    }
+    ops, err := ParseContent( data )
+    if err != nil {
+        return fmt.Errorf( "XObject /%s: %v", name, err )
+    }
+    formResources, ok := ti.pf.dictionaryValue( form.extent.data["Resources"] )
+    if ! ok {
+        formResources = resources
+    }
+    // the form is interpreted in its own graphics state and text object
+    saved, tm, tlm := ti.gs, ti.tm, ti.tlm
+    depth := len(ti.stack)
+    if m, ok := ti.pf.arrayValue( form.extent.data["Matrix"] ); ok {
+        if m, ok := matrixValue( m.data ); ok {
+            ti.gs.ctm = m.multiply( ti.gs.ctm )
+        }
+    }
+    ti.forms[ref] = true
+    err = ti.run( ops, formResources )
+    delete( ti.forms, ref )
+    ti.gs, ti.tm, ti.tlm = saved, tm, tlm
+    ti.stack = ti.stack[:depth]
+    return err
+}
+
+// return the matrix transforming the default user space into the page as
+// displayed, with the origin at the top left corner of the crop box, y going
+// down and the page rotation applied
+func (p *Page) displayMatrix( ) ( matrix, error ) {
+    crop, err := p.CropBox( )
+    if err != nil {
+        return identityMatrix, err
+    }
+    rotate, err := p.Rotate( )
+    if err != nil {
+        return identityMatrix, err
+    }
+    w, h := crop.Width(), crop.Height()
+    var m matrix
+    switch rotate {
+    case 0:
+        m = matrix{ 1, 0, 0, -1, 0, h }
+    case 90:
+        m = matrix{ 0, 1, 1, 0, 0, 0 }
+    case 180:
+        m = matrix{ -1, 0, 0, 1, w, 0 }
+    default:
+        m = matrix{ 0, -1, -1, 0, h, w }
+    }
+    return matrix{ 1, 0, 0, 1, -crop.LLx, -crop.LLy }.multiply( m ), nil
+}
+
+// interpret the page content, calling show for each glyph
+func (p *Page) showGlyphs( show func( g *shownGlyph ) ) error {
+    ops, err := p.Content( )
+    if err != nil {
+        return err
+    }
+    resources, err := p.Resources( )
+    if err != nil {
+        return err
+    }
+    ti := newTextInterpreter( p.pf, identityMatrix, show )
+    if err = ti.run( ops, resources ); err != nil {
+        return fmt.Errorf( "Page %d %d: %v", p.obj.id, p.obj.gen, err )
+    }
+    return nil
+}
+
+// TextArgs gives the text extraction options
+type TextArgs struct {
+    LineSeparator   string      // between lines ("\n" by default)
+    WordSeparator   string      // between words that are not separated by a space (" " by default)
+    WordSpacing     float64     // minimum distance between words, in font size units (DEFAULT_WORD_SPACING by default)
+    ContentOrder    bool        // keep text in content order instead of reading order
+}
+
+// glyph origin and displacement in display space
+type glyphPlacement struct {
+    x0, y0, x1, y1      float64     // glyph origin and end of displacement
+    dx, dy              float64     // unit direction
+    size                float64     // font size
+}
+
+// return the glyph placement in display space, and false if the glyph is not
+// visible (null size)
+func placeGlyph( g *shownGlyph, display matrix ) ( glyphPlacement, bool ) {
+    var gp glyphPlacement
+    trm := g.trm.multiply( display )
+    gp.x0, gp.y0 = trm.apply( 0, 0 )
+    gp.x1, gp.y1 = trm.apply( g.width, 0 )
+    if g.font.vertical {
+        gp.x1, gp.y1 = trm.apply( 0, g.width )
+    }
+    ux, uy := trm.apply( 0, 1 )
+    gp.size = math.Hypot( ux - gp.x0, uy - gp.y0 )
+    dx, dy := gp.x1 - gp.x0, gp.y1 - gp.y0
+    if l := math.Hypot( dx, dy ); l > 0 {
+        gp.dx, gp.dy = dx / l, dy / l
+        return gp, true
+    }
+    rx, ry := trm.apply( 1, 0 )       // glyph without width
+    if l := math.Hypot( rx - gp.x0, ry - gp.y0 ); l > 0 {
+        gp.dx, gp.dy = (rx - gp.x0) / l, (ry - gp.y0) / l
+        return gp, true
+    }
+    return gp, false
+}
+
+// glyphs following each other on the same baseline, in display space, from
+// the origin of the first glyph to the end of the last glyph
+type textRun struct {
+    glyphPlacement
+    text                strings.Builder
+}
+
+// return whether the glyph follows the run (or false if r is nil), with its
+// distance from the end of the run along the run direction
+func (r *textRun) follows( gp *glyphPlacement ) ( float64, bool ) {
+    if r == nil || math.Abs( gp.dx - r.dx ) > 0.01 || math.Abs( gp.dy - r.dy ) > 0.01 {
+        return 0, false
+    }
+    vx, vy := gp.x0 - r.x1, gp.y0 - r.y1
+    along, across := vx * r.dx + vy * r.dy, vy * r.dx - vx * r.dy
+    if math.Abs( across ) < 0.5 * gp.size && along > -gp.size && along < 4 * gp.size {
+        return along, true
+    }
+    return along, false
+}
+
+func (r *textRun) horizontal( ) bool {
+    return math.Abs( r.dy ) < 0.01 && r.dx > 0
+}
+
+// add text to s, separated by sep from the previous text unless one of them
+// is white space
+func appendSeparated( s *strings.Builder, text, sep string ) {
+    if s.Len() > 0 && text != "" {
+        last := s.String()[s.Len()-1]
+        if last != ' ' && last != '\t' && text[0] != ' ' && text[0] != '\t' {
+            s.WriteString( sep )
+        }
+    }
+    s.WriteString( text )
+}
+
+// Text returns the page text, in reading order unless args.ContentOrder is
+// true. If args is nil, default values are used.
+func (p *Page) Text( args *TextArgs ) ( string, error ) {
+    if args == nil {
+        args = &TextArgs{}
+    }
+    lineSep, wordSep, spacing := args.LineSeparator, args.WordSeparator, args.WordSpacing
+    if lineSep == "" { lineSep = "\n" }
+    if wordSep == "" { wordSep = " " }
+    if spacing <= 0 { spacing = DEFAULT_WORD_SPACING }
+
+    display, err := p.displayMatrix( )
+    if err != nil {
+        return "", err
+    }
+    runs := make( []*textRun, 0, 64 )
+    var run *textRun
+    err = p.showGlyphs( func( g *shownGlyph ) {
+        gp, visible := placeGlyph( g, display )
+        if ! visible {
+            return
+        }
+        if along, ok := run.follows( &gp ); ok {
+            if along > spacing * gp.size {
+                appendSeparated( &run.text, g.text, wordSep )
+            } else {
+                run.text.WriteString( g.text )
+            }
+            run.x1, run.y1 = gp.x1, gp.y1
+            return
+        }
+        run = &textRun{ glyphPlacement: gp }
+        run.text.WriteString( g.text )
+        runs = append( runs, run )
+    } )
+    if err != nil {
+        return "", err
+    }
+    if ! args.ContentOrder {
+        sort.SliceStable( runs, func( i, j int ) bool {
+            if runs[i].y0 != runs[j].y0 {
+                return runs[i].y0 < runs[j].y0
+            }
+            return runs[i].x0 < runs[j].x0
+        } )
+    }
+
+    // runs are joined in a line if they are horizontal and on the same baseline
+    var text strings.Builder
+    for i := 0; i < len(runs); {
+        line := runs[i:i+1]
+        j := i + 1
+        if runs[i].horizontal() {
+            for j < len(runs) && runs[j].horizontal() &&
+                math.Abs( runs[j].y0 - runs[i].y0 ) < 0.5 * math.Min( runs[i].size, runs[j].size ) {
+                j++
+            }
+            line = runs[i:j]
+            if ! args.ContentOrder {
+                sort.SliceStable( line, func( a, b int ) bool { return line[a].x0 < line[b].x0 } )
+            }
+        }
+        if i > 0 {
+            text.WriteString( lineSep )
+        }
+        var l strings.Builder
+        for k, r := range line {
+            if k > 0 && r.x0 - line[k-1].x1 <= spacing * r.size {
+                l.WriteString( r.text.String() )
+            } else {
+                appendSeparated( &l, r.text.String(), wordSep )
+            }
+        }
+        text.WriteString( l.String() )
+        i = j
+    }
+    return text.String(), nil
+}
+
+// Text returns the text of each page. See Page.Text.
+func (pf *PdfFile) Text( args *TextArgs ) ( []string, error ) {
+    pages, err := pf.Pages( )
+    if err != nil {
+        return nil, err
+    }
+    texts := make( []string, len(pages) )
+    for i, p := range pages {
+        if texts[i], err = p.Text( args ); err != nil {
+            return texts, err
+        }
+    }
+    return texts, nil
+}
@@ -0,0 +1,164 @@
+package pdf
+
+import (
+    "math"
+    "testing"
+)
+
+// return a new letter page, with the given rotation, whose content is made by
+// build with a Helvetica font
+func newTextTestPage( t *testing.T, rotate int, build func( pf *PdfFile, cb *ContentBuilder, font Reference ) ) *Page {
+    pf, err := NewDocument( &DocumentArgs{ Deterministic: true } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    dict := NewDictionary( )
+    dict.Set( "MediaBox", NewArray( Number(0), Number(0), Number(612), Number(792) ) )
+    if rotate != 0 {
+        dict.Set( "Rotate", Number(rotate) )
+    }
+    p, err := pf.AppendPage( dict )
+    if err != nil {
+        t.Fatal( err )
+    }
+    font, err := pf.NewStandardFont( "Helvetica" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    cb, err := p.NewContentBuilder( )
+    if err != nil {
+        t.Fatal( err )
+    }
+    build( pf, cb, font )
+    if err = p.AddContent( cb ); err != nil {
+        t.Fatal( err )
+    }
+    return p
+}
+
+func checkPageText( t *testing.T, what string, p *Page, args *TextArgs, expected string ) {
+    text, err := p.Text( args )
+    if err != nil {
+        t.Errorf( "%s: %v", what, err )
+    } else if text != expected {
+        t.Errorf( "%s: got %q, expected %q", what, text, expected )
+    }
+}
+
+func TestTextHorizontalScaling( t *testing.T ) {
+    // "ab" is 13.344 wide in Helvetica 12, Td is not scaled by Tz
+    for _, test := range []struct{ scale, tx float64; expected string }{
+        { 200, 26.688, "abcd" }, { 200, 13.344, "abcd" }, { 50, 13.344, "ab cd" }, { 50, 6.672, "abcd" },
+    } {
+        p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+            cb.BeginText( )
+            cb.SetFont( font, 12 )
+            cb.Op( "Tz", Number(test.scale) )
+            cb.MoveText( 72, 700 )
+            cb.ShowText( "ab" )
+            cb.MoveText( test.tx, 0 )
+            cb.ShowText( "cd" )
+            cb.EndText( )
+        } )
+        checkPageText( t, "Tz", p, nil, test.expected )
+    }
+}
+
+func TestTextKerning( t *testing.T ) {
+    p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 72, 700 )
+        // a word gap is larger than 0.2 font size, i.e. an adjustment below -200
+        cb.ShowTextAdjusted( "ab", -100, "cd", -300, "ef", 200, "gh", -5000, "ij" )
+        cb.EndText( )
+    } )
+    checkPageText( t, "TJ", p, nil, "abcd efgh ij" )
+    checkPageText( t, "TJ with WordSeparator", p, &TextArgs{ WordSeparator: "_" }, "abcd_efgh_ij" )
+    checkPageText( t, "TJ with WordSpacing", p, &TextArgs{ WordSpacing: 0.5 }, "abcdefgh ij" )
+}
+
+func TestTextForms( t *testing.T ) {
+    p := newTextTestPage( t, 0, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        inner := NewContentBuilder( )
+        inner.BeginText( )
+        inner.SetFont( font, 10 )
+        inner.ShowText( "inner" )
+        inner.EndText( )
+        innerForm := pf.NewFormXObject( inner, Rectangle{ 0, 0, 100, 20 } )
+
+        // the outer form moves the inner form down, without changing the
+        // graphics state of the page
+        outer := NewContentBuilder( )
+        outer.BeginText( )
+        outer.SetFont( font, 10 )
+        outer.ShowText( "outer" )
+        outer.EndText( )
+        outer.Transform( 1, 0, 0, 1, 0, -50 )
+        outer.DrawXObject( innerForm )
+        outerForm := pf.NewFormXObject( outer, Rectangle{ 0, -50, 100, 20 } )
+
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 72, 700 )
+        cb.ShowText( "top" )
+        cb.EndText( )
+        cb.Translate( 72, 600 )
+        cb.DrawXObject( outerForm )
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 100, -50 )
+        cb.ShowText( "right" )
+        cb.EndText( )
+    } )
+    checkPageText( t, "Nested forms", p, nil, "top\nouter\ninner right" )
+}
+
+func TestTextRotatedPage( t *testing.T ) {
+    // on a page rotated by 90, lines go up the page and follow each other to
+    // the right, "first" is 19.332 wide in Helvetica 12
+    p := newTextTestPage( t, 90, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.SetTextMatrix( 0, 1, -1, 0, 120, 72 )
+        cb.ShowText( "second line" )
+        cb.SetTextMatrix( 0, 1, -1, 0, 100, 72 )
+        cb.ShowText( "first" )
+        cb.SetTextMatrix( 0, 1, -1, 0, 100, 72 + 19.332 + 6 )
+        cb.ShowText( "line" )
+        cb.EndText( )
+        cb.BeginText( )
+        cb.SetFont( font, 12 )
+        cb.MoveText( 300, 500 )       // going down the displayed page
+        cb.ShowText( "down" )
+        cb.EndText( )
+    } )
+    checkPageText( t, "Reading order", p, nil, "first line\nsecond line\ndown" )
+    checkPageText( t, "Content order", p, &TextArgs{ ContentOrder: true, LineSeparator: " | " },
+                   "second line | first line | down" )
+    checkPageText( t, "WordSpacing", p, &TextArgs{ WordSpacing: 1 }, "firstline\nsecond line\ndown" )
+}
+
+func TestDisplayMatrix( t *testing.T ) {
+    // crop box lower left corner and upper right corner, as displayed
+    for _, test := range []struct{ rotate int; ll, ur [2]float64 }{
+        { 0, [2]float64{ 0, 200 }, [2]float64{ 100, 0 } },
+        { 90, [2]float64{ 0, 0 }, [2]float64{ 200, 100 } },
+        { 180, [2]float64{ 100, 0 }, [2]float64{ 0, 200 } },
+        { 270, [2]float64{ 200, 100 }, [2]float64{ 0, 0 } },
+    } {
+        p := newTextTestPage( t, test.rotate, func( pf *PdfFile, cb *ContentBuilder, font Reference ) {} )
+        dict := p.obj.value.(Dictionary)
+        dict.Set( "CropBox", NewArray( Number(10), Number(20), Number(110), Number(220) ) )
+        m, err := p.displayMatrix( )
+        if err != nil {
+            t.Fatal( err )
+        }
+        for _, c := range []struct{ x, y float64; expected [2]float64 }{ { 10, 20, test.ll }, { 110, 220, test.ur } } {
+            x, y := m.apply( c.x, c.y )
+            if math.Abs( x - c.expected[0] ) > 1e-9 || math.Abs( y - c.expected[1] ) > 1e-9 {
+                t.Errorf( "Rotate %d: %g %g displayed at %g %g, expected %v", test.rotate, c.x, c.y, x, y, c.expected )
+            }
+        }
+    }
+}